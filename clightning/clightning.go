@@ -119,6 +119,7 @@ func NewClightningClient(ctx context.Context) (*ClightningClient, <-chan interfa
 		return nil, nil, err
 	}
 	cl.Plugin.SubscribeConnect(cl.OnConnect)
+	cl.Plugin.SubscribeDisconnect(cl.OnDisconnect)
 
 	cl.glightning = glightning.NewLightning()
 
@@ -214,6 +215,11 @@ func (cl *ClightningClient) SendMessage(peerId string, message []byte, messageTy
 	return nil
 }
 
+// IsPeerConnected returns true if the peer is connected to the cln node.
+func (cl *ClightningClient) IsPeerConnected(peerId string) (bool, error) {
+	return cl.isPeerConnected(peerId), nil
+}
+
 // OnCustomMsg is the hook that c-lightning calls
 func (cl *ClightningClient) OnCustomMsg(event *glightning.CustomMsgReceivedEvent) (*glightning.CustomMsgReceivedResponse, error) {
 	typeString := event.Payload[:4]
@@ -260,6 +266,15 @@ func (cl *ClightningClient) DecodePayreq(payreq string) (paymentHash string, amo
 	return res.PaymentHash, res.MilliSatoshis, nil
 }
 
+// IsInvoiceExpired returns true if the expiry of a Bolt11 Invoice passed
+func (cl *ClightningClient) IsInvoiceExpired(payreq string) (bool, error) {
+	res, err := cl.glightning.DecodeBolt11(payreq)
+	if err != nil {
+		return false, err
+	}
+	return uint64(time.Now().Unix()) > res.CreatedAt+res.Expiry, nil
+}
+
 // PayInvoice tries to pay a Bolt11 Invoice
 func (cl *ClightningClient) PayInvoice(payreq string) (preimage string, err error) {
 	res, err := cl.glightning.Pay(&glightning.PayRequest{Bolt11: payreq})
@@ -424,19 +439,30 @@ func (cl *ClightningClient) onInit(plugin *glightning.Plugin, options map[string
 
 // OnConnect is called after the connect event. The
 // handler sends out a poll to the peer it connected
-// to.
+// to and reconciles the active swaps with the peer.
 func (cl *ClightningClient) OnConnect(connectEvent *glightning.ConnectEvent) {
 	go func() {
 		for {
 			time.Sleep(10 * time.Second)
 			if cl.pollService != nil {
 				cl.pollService.RequestPoll(connectEvent.PeerId)
+				if cl.swaps != nil {
+					cl.swaps.OnPeerConnected(connectEvent.PeerId)
+				}
 				return
 			}
 		}
 	}()
 }
 
+// OnDisconnect is called after the disconnect event. The
+// handler starts the disconnect grace period of the peer.
+func (cl *ClightningClient) OnDisconnect(disconnectEvent *glightning.DisconnectEvent) {
+	if cl.swaps != nil {
+		cl.swaps.OnPeerDisconnected(disconnectEvent.PeerId)
+	}
+}
+
 // RegisterMethods registeres rpc methods to c-lightning
 func (cl *ClightningClient) RegisterMethods() error {
 	swapIn := glightning.NewRpcMethod(&SwapIn{
@@ -55,6 +55,12 @@ func (cl *ClightningClient) BroadcastOpeningTx(unpreparedTxHex string) (txId, tx
 	return sendRes.TxId, sendRes.SignedTx, nil
 }
 
+// RebroadcastOpeningTx sends the signed opening transaction to bitcoind
+// again.
+func (cl *ClightningClient) RebroadcastOpeningTx(txHex string) (string, error) {
+	return cl.gbitcoin.SendRawTx(txHex)
+}
+
 func (cl *ClightningClient) CreatePreimageSpendingTransaction(swapParams *swap.OpeningParams, claimParams *swap.ClaimParams) (txId, txHex string, err error) {
 
 	_, vout, err := cl.bitcoinChain.GetVoutAndVerify(claimParams.OpeningTxHex, swapParams)
@@ -200,6 +206,11 @@ func (cl *ClightningClient) GetRefundFee() (uint64, error) {
 	return cl.bitcoinChain.GetFee(250)
 }
 
+// GetSpendingTxFee returns the fee that the spending transaction paid.
+func (cl *ClightningClient) GetSpendingTxFee(openingTxHex, spendingTxHex string) (uint64, error) {
+	return cl.bitcoinChain.GetSpendingTxFee(openingTxHex, spendingTxHex)
+}
+
 func (cl *ClightningClient) GetOnchainBalance() (uint64, error) {
 	funds, err := cl.glightning.ListFunds()
 	if err != nil {
@@ -213,6 +224,11 @@ func (cl *ClightningClient) GetOnchainBalance() (uint64, error) {
 	return totalBalance, nil
 }
 
+// GetFeeRate returns the estimated fee rate in sat/vb of the bitcoin chain.
+func (cl *ClightningClient) GetFeeRate() (float64, error) {
+	return cl.bitcoinChain.GetFeeRate()
+}
+
 // GetFlatSwapOutFee returns an estimated size for the opening transaction. This
 // can be used to calculate the amount of the fee invoice and should cover most
 // but not all cases. For an explanation of the estimation see comments of the
@@ -275,6 +275,7 @@ func run() error {
 	if err != nil {
 		return err
 	}
+	defer swapService.Stop()
 
 	pollStore, err := poll.NewStore(swapDb)
 	if err != nil {
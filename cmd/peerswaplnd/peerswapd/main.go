@@ -314,6 +314,7 @@ func run() error {
 	if err != nil {
 		return err
 	}
+	defer swapService.Stop()
 
 	// Try to upgrade version if needed
 	versionService, err := version.NewVersionService(swapDb)
@@ -344,6 +345,18 @@ func run() error {
 		return err
 	}
 
+	// Reconcile the active swaps with a peer that comes online.
+	err = peerListener.AddHandler(lnrpc.PeerEvent_PEER_ONLINE, swapService.OnPeerConnected)
+	if err != nil {
+		return err
+	}
+
+	// Start the disconnect grace period of a peer that goes offline.
+	err = peerListener.AddHandler(lnrpc.PeerEvent_PEER_OFFLINE, swapService.OnPeerDisconnected)
+	if err != nil {
+		return err
+	}
+
 	// Start internal lnd listener.
 	lnd.StartListening()
 
@@ -12,6 +12,7 @@ require (
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/lightningnetwork/lnd v0.14.1-beta
 	github.com/onsi/gomega v1.5.0 // indirect
+	github.com/prometheus/client_golang v1.11.0
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli v1.22.2-0.20191024042601-850de854cda0
 	github.com/vulpemventures/go-elements v0.3.7
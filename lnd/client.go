@@ -72,6 +72,17 @@ func NewClient(
 	}, nil
 }
 
+// GetNodeId returns the pubkey of the lnd node.
+func (l *Client) GetNodeId() string {
+	return l.pubkey
+}
+
+// IsUnavailableError returns true if the error was returned because lnd can
+// not be reached.
+func (l *Client) IsUnavailableError(err error) bool {
+	return IsUnavailableError(err)
+}
+
 func (l *Client) StartListening() error {
 	return l.messageListener.Start()
 }
@@ -88,6 +99,15 @@ func (l *Client) DecodePayreq(payreq string) (paymentHash string, amountMsat uin
 	return decoded.PaymentHash, uint64(decoded.NumMsat), nil
 }
 
+// IsInvoiceExpired returns true if the expiry of the invoice passed.
+func (l *Client) IsInvoiceExpired(payreq string) (bool, error) {
+	decoded, err := l.lndClient.DecodePayReq(l.ctx, &lnrpc.PayReqString{PayReq: payreq})
+	if err != nil {
+		return false, err
+	}
+	return time.Now().Unix() > decoded.Timestamp+decoded.Expiry, nil
+}
+
 func (l *Client) PayInvoice(payreq string) (preImage string, err error) {
 	payres, err := l.lndClient.SendPaymentSync(l.ctx, &lnrpc.SendRequest{PaymentRequest: payreq})
 	if err != nil {
@@ -249,6 +269,20 @@ func (l *Client) SendMessage(peerId string, message []byte, messageType int) err
 	return nil
 }
 
+// IsPeerConnected returns true if the peer is connected to the lnd node.
+func (l *Client) IsPeerConnected(peerId string) (bool, error) {
+	res, err := l.lndClient.ListPeers(l.ctx, &lnrpc.ListPeersRequest{})
+	if err != nil {
+		return false, err
+	}
+	for _, peer := range res.Peers {
+		if peer.PubKey == peerId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (l *Client) AddMessageHandler(f func(peerId string, msgType string, payload []byte) error) {
 	l.messageListener.AddMessageHandler(f)
 }
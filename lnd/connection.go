@@ -2,6 +2,7 @@ package lnd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -46,3 +47,13 @@ func IsContextError(err error) bool {
 	code := status.Code(err)
 	return code == codes.DeadlineExceeded || code == codes.Canceled
 }
+
+// IsUnavailableError returns true if the error or an error that it wraps is of
+// grpc error type Unavailable, which indicates that lnd can not be reached.
+func IsUnavailableError(err error) bool {
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return se.GRPCStatus().Code() == codes.Unavailable
+	}
+	return false
+}
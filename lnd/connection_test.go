@@ -2,6 +2,8 @@ package lnd
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"sync"
@@ -9,7 +11,9 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
 	testgrpc "google.golang.org/grpc/test/grpc_testing"
 	testpb "google.golang.org/grpc/test/grpc_testing"
 )
@@ -306,3 +310,21 @@ func (t *testServer) sendStreamingOutput() {
 func (t *testServer) stopStreamingOutput() {
 	t.stop <- true
 }
+
+func TestIsUnavailableError(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	for _, tc := range []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{errors.New("unavailable"), false},
+		{status.Error(codes.Unknown, "unknown"), false},
+		{unavailable, true},
+		{fmt.Errorf("could not pay: %w", unavailable), true},
+	} {
+		if got := IsUnavailableError(tc.err); got != tc.expected {
+			t.Errorf("IsUnavailableError(%v) = %v, expected %v", tc.err, got, tc.expected)
+		}
+	}
+}
@@ -82,6 +82,41 @@ func (l *Client) BroadcastOpeningTx(unpreparedTxHex string) (txId, txHex string,
 	return openingTx.TxHash().String(), unpreparedTxHex, nil
 }
 
+// RebroadcastOpeningTx publishes the signed opening transaction again.
+func (l *Client) RebroadcastOpeningTx(txHex string) (string, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return "", err
+	}
+	openingTx := wire.NewMsgTx(2)
+	err = openingTx.Deserialize(bytes.NewReader(txBytes))
+	if err != nil {
+		return "", err
+	}
+
+	_, err = l.walletClient.PublishTransaction(l.ctx, &walletrpc.Transaction{TxHex: txBytes})
+	if err != nil {
+		return "", err
+	}
+	return openingTx.TxHash().String(), nil
+}
+
+// BumpClaimTx lets the sweeper of lnd spend the output of the claim
+// transaction with a child that pays for the claim transaction.
+func (l *Client) BumpClaimTx(claimTxId string) (string, error) {
+	_, err := l.walletClient.BumpFee(l.ctx, &walletrpc.BumpFeeRequest{
+		Outpoint: &lnrpc.OutPoint{
+			TxidStr:     claimTxId,
+			OutputIndex: 0,
+		},
+		TargetConf: 2,
+	})
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
 func (l *Client) CreatePreimageSpendingTransaction(swapParams *swap.OpeningParams, claimParams *swap.ClaimParams) (string, string, error) {
 	_, vout, err := l.bitcoinOnChain.GetVoutAndVerify(claimParams.OpeningTxHex, swapParams)
 	if err != nil {
@@ -232,6 +267,16 @@ func (l *Client) GetRefundFee() (uint64, error) {
 	return l.bitcoinOnChain.GetFee(250)
 }
 
+// GetSpendingTxFee returns the fee that the spending transaction paid.
+func (l *Client) GetSpendingTxFee(openingTxHex, spendingTxHex string) (uint64, error) {
+	return l.bitcoinOnChain.GetSpendingTxFee(openingTxHex, spendingTxHex)
+}
+
+// GetFeeRate returns the estimated fee rate in sat/vb of the bitcoin chain.
+func (l *Client) GetFeeRate() (float64, error) {
+	return l.bitcoinOnChain.GetFeeRate()
+}
+
 // GetFlatSwapOutFee returns an estimated size for the opening transaction. This
 // can be used to calculate the amount of the fee invoice and should cover most
 // but not all cases. For an explanation of the estimation see comments of the
@@ -39,6 +39,7 @@ type TxWatcher struct {
 
 	confirmationCallback func(swapId, txHex string) error
 	csvPassedCallback    func(swapId string) error
+	reorgCallback        func(swapId string) error
 
 	confirmationWatchers map[string]bool
 	waitForCsvWatchers   map[string]bool
@@ -85,12 +86,16 @@ func (t *TxWatcher) Stop() error {
 	return nil
 }
 
+// addTxWatcher registers a confirmation notification for the tx. The stream is
+// kept open after the confirmation, as lnd sends a reorg event if the
+// confirmation is reorged out of the chain and closes the stream once the
+// confirmation is deep enough.
 func (t *TxWatcher) addTxWatcher(ctx context.Context, swapId string, txId string, numConfs, heightHint uint32, script []byte) (
-	chan confirmationEvent, chan error, error) {
+	chan confirmationEvent, chan struct{}, chan error, error) {
 
 	txIdHash, err := chainhash.NewHashFromStr(txId)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	stream, err := t.chainrpcClient.RegisterConfirmationsNtfn(
@@ -103,10 +108,11 @@ func (t *TxWatcher) addTxWatcher(ctx context.Context, swapId string, txId string
 		},
 	)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	confChan := make(chan confirmationEvent, 1)
+	reorgChan := make(chan struct{}, 1)
 	errChan := make(chan error, 1)
 
 	t.wg.Add(1)
@@ -117,6 +123,7 @@ func (t *TxWatcher) addTxWatcher(ctx context.Context, swapId string, txId string
 			res, err := stream.Recv()
 			if err == io.EOF {
 				// EOF means the stream was closed and returned.
+				errChan <- err
 				return
 			} else if err != nil {
 				errChan <- err
@@ -125,18 +132,23 @@ func (t *TxWatcher) addTxWatcher(ctx context.Context, swapId string, txId string
 
 			switch event := res.Event.(type) {
 			case *chainrpc.ConfEvent_Conf:
-				confChan <- confirmationEvent{
+				select {
+				case confChan <- confirmationEvent{
 					swapId:      swapId,
 					rawTx:       event.Conf.RawTx,
 					blockHeight: event.Conf.BlockHeight,
+				}:
+				case <-ctx.Done():
+					return
 				}
-				return
 
 			case *chainrpc.ConfEvent_Reorg:
-				// Not supported yet, we can continue as we return on conf and
-				// should never get an reorg event in return.
 				log.Debugf("[TxWatcher] Swap: %s: Got an reorg event", swapId)
-				continue
+				select {
+				case reorgChan <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
 
 			default:
 				errChan <- fmt.Errorf("event has unexpected types")
@@ -145,7 +157,7 @@ func (t *TxWatcher) addTxWatcher(ctx context.Context, swapId string, txId string
 		}
 	}()
 
-	return confChan, errChan, nil
+	return confChan, reorgChan, errChan, nil
 }
 
 // AddWaitForConfirmationTx subscribes to the lnd onchain tx watcher and calls
@@ -165,10 +177,11 @@ func (t *TxWatcher) AddWaitForConfirmationTx(swapId string, txId string, _ uint3
 		t.targetConfs,
 	)
 	t.confirmationWatchers[swapId] = true
+	targetConfs := t.targetConfs
 	t.Unlock()
 
 	ctx, cancel := context.WithCancel(t.ctx)
-	confChan, errChan, err := t.addTxWatcher(ctx, swapId, txId, t.targetConfs, heightHint, script)
+	confChan, reorgChan, errChan, err := t.addTxWatcher(ctx, swapId, txId, targetConfs, heightHint, script)
 	if err != nil {
 		// TODO: Add error return to somehow handle error in swap. Else this
 		// could lead to stale swaps that might not resolve.
@@ -180,17 +193,38 @@ func (t *TxWatcher) AddWaitForConfirmationTx(swapId string, txId string, _ uint3
 	t.wg.Add(1)
 	go func() {
 		defer t.wg.Done()
+		// The watcher is removed before the reorg callback is called, as
+		// the swap adds the tx to the watcher again.
+		confirmed, reorged := false, false
 		defer func() {
+			if reorged {
+				return
+			}
 			t.Lock()
 			delete(t.confirmationWatchers, swapId)
 			t.Unlock()
 		}()
 		defer cancel()
-		defer close(errChan)
-		defer close(confChan)
 
 		for {
 			select {
+			case <-reorgChan:
+				if !confirmed {
+					continue
+				}
+				t.Lock()
+				delete(t.confirmationWatchers, swapId)
+				callback := t.reorgCallback
+				t.Unlock()
+				reorged = true
+
+				log.Infof("[TxWatcher] Wait for confirmation on swap %s: Confirmation of tx %s was reorged", swapId, txId)
+				if callback == nil {
+					log.Infof("[TxWatcher] Wait for confirmation on swap %s: reorgCallback is nil", swapId)
+					return
+				}
+				_ = callback(swapId)
+				return
 			case conf := <-confChan:
 				// This tx watcher can also be used on recovery. This can lead to
 				// the situation that the tx is confirmed but also already too close
@@ -226,8 +260,12 @@ func (t *TxWatcher) AddWaitForConfirmationTx(swapId string, txId string, _ uint3
 					log.Infof("[TxWatcher] Wait for confirmation on swap %s: confirmationCallback is nil", swapId)
 					return
 				}
-				_ = t.confirmationCallback(swapId, hex.EncodeToString(conf.rawTx))
-				return
+				if err := t.confirmationCallback(swapId, hex.EncodeToString(conf.rawTx)); err != nil {
+					return
+				}
+				confirmed = true
+				// Keep listening for a reorg of the confirmation until
+				// lnd closes the stream.
 			case err := <-errChan:
 				if err == io.EOF {
 					log.Infof("[TxWatcher] Wait for confirmation on swap %s: Stream closed by server: %s", swapId)
@@ -272,7 +310,7 @@ func (t *TxWatcher) AddWaitForCsvTx(swapId string, txId string, vout uint32, hei
 	// for a tx to be reorganized out of the chain.
 	// This means that we have to count the blocks after this by our self.
 	// TODO: Ask lnd why we can not listen longer?
-	confChan, errChan, err := t.addTxWatcher(ctx, swapId, txId, 144, heightHint, script)
+	confChan, reorgChan, errChan, err := t.addTxWatcher(ctx, swapId, txId, 144, heightHint, script)
 	if err != nil {
 		// TODO: Add error return to somehow handle error in swap. Else this
 		// could lead to stale swaps that might not resolve.
@@ -290,11 +328,13 @@ func (t *TxWatcher) AddWaitForCsvTx(swapId string, txId string, vout uint32, hei
 			t.Unlock()
 		}()
 		defer cancel()
-		defer close(errChan)
-		defer close(confChan)
 
 		for {
 			select {
+			case <-reorgChan:
+				// lnd sends the confirmation again once the tx is
+				// confirmed 144 times.
+				continue
 			case conf := <-confChan:
 				// We get to this point if the tx has been confirmed 144 times
 				// as lnd does not allow to track for more confirmations.
@@ -365,6 +405,15 @@ func (t *TxWatcher) AddWaitForCsvTx(swapId string, txId string, vout uint32, hei
 	}()
 }
 
+// SetRequiredConfirmations sets the number of confirmations after which a
+// transaction is reported as confirmed. It applies to transactions that are
+// added to the watcher afterwards.
+func (t *TxWatcher) SetRequiredConfirmations(confs uint32) {
+	t.Lock()
+	defer t.Unlock()
+	t.targetConfs = confs
+}
+
 // AddConfirmationCallback adds a callback to the watcher that will be called in
 // the case that an active "wait for confirmation" watcher reached the
 // confirmation limit for a swap.
@@ -383,6 +432,15 @@ func (t *TxWatcher) AddCsvCallback(cb func(swapId string) error) {
 	t.csvPassedCallback = cb
 }
 
+// AddReorgCallback adds a callback to the watcher that will be called in the
+// case that a confirmation that was reported to the confirmation callback was
+// reorged out of the chain.
+func (t *TxWatcher) AddReorgCallback(cb func(swapId string) error) {
+	t.Lock()
+	defer t.Unlock()
+	t.reorgCallback = cb
+}
+
 // GetBlockHeight returns the current best block from the GetInfo call. Beware
 // that this hight is the best block from the nodes view.
 func (t *TxWatcher) GetBlockHeight() (uint32, error) {
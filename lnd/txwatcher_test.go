@@ -13,6 +13,7 @@ import (
 	"github.com/elementsproject/peerswap/test"
 	"github.com/elementsproject/peerswap/testframework"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/chainrpc"
 	"google.golang.org/grpc"
 )
 
@@ -706,3 +707,96 @@ func txwatcherNodeSetup(t *testing.T, dir string) (bitcoind *testframework.Bitco
 
 	return bitcoind, lnd, cc, nil
 }
+
+func TestTxWatcher_AddWaitForConfirmationTx_Reorg(t *testing.T) {
+	t.Parallel()
+
+	notifier := &reorgChainNotifier{events: make(chan *chainrpc.ConfEvent)}
+	ctx, cancel := context.WithCancel(context.Background())
+	txwatcher := &TxWatcher{
+		ctx:                  ctx,
+		cancel:               cancel,
+		lnrpcClient:          &blockHeightLightningClient{height: 101},
+		chainrpcClient:       notifier,
+		targetConfs:          testTargetConf,
+		targetCsv:            testCsvLimit,
+		confirmationWatchers: make(map[string]bool),
+		waitForCsvWatchers:   make(map[string]bool),
+	}
+	defer txwatcher.Stop()
+
+	confirmed := make(chan string, 1)
+	reorged := make(chan string, 1)
+	txwatcher.AddConfirmationCallback(func(swapId, txHex string) error {
+		confirmed <- txHex
+		return nil
+	})
+	txwatcher.AddReorgCallback(func(swapId string) error {
+		reorged <- swapId
+		return nil
+	})
+
+	txid := "0000000000000000000000000000000000000000000000000000000000000001"
+	txwatcher.AddWaitForConfirmationTx("reorg", txid, 0, 100, nil)
+
+	notifier.events <- &chainrpc.ConfEvent{Event: &chainrpc.ConfEvent_Conf{Conf: &chainrpc.ConfDetails{RawTx: []byte{1}, BlockHeight: 100}}}
+	select {
+	case txHex := <-confirmed:
+		if txHex != "01" {
+			t.Fatalf("Expected tx hex 01, got %s", txHex)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Confirmation callback was not called")
+	}
+
+	notifier.events <- &chainrpc.ConfEvent{Event: &chainrpc.ConfEvent_Reorg{Reorg: &chainrpc.Reorg{}}}
+	select {
+	case swapId := <-reorged:
+		if swapId != "reorg" {
+			t.Fatalf("Expected swap id reorg, got %s", swapId)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Reorg callback was not called")
+	}
+
+	// The watcher is removed so that the tx can be watched again.
+	txwatcher.Lock()
+	defer txwatcher.Unlock()
+	if _, ok := txwatcher.confirmationWatchers["reorg"]; ok {
+		t.Fatalf("Expected confirmation watcher to be removed")
+	}
+}
+
+// reorgChainNotifier sends the events to the confirmation streams.
+type reorgChainNotifier struct {
+	chainrpc.ChainNotifierClient
+	events chan *chainrpc.ConfEvent
+}
+
+func (n *reorgChainNotifier) RegisterConfirmationsNtfn(ctx context.Context, in *chainrpc.ConfRequest, opts ...grpc.CallOption) (chainrpc.ChainNotifier_RegisterConfirmationsNtfnClient, error) {
+	return &reorgConfStream{ctx: ctx, events: n.events}, nil
+}
+
+type reorgConfStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	events chan *chainrpc.ConfEvent
+}
+
+func (s *reorgConfStream) Recv() (*chainrpc.ConfEvent, error) {
+	select {
+	case event := <-s.events:
+		return event, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+type blockHeightLightningClient struct {
+	lnrpc.LightningClient
+	height uint32
+}
+
+func (c *blockHeightLightningClient) GetInfo(ctx context.Context, in *lnrpc.GetInfoRequest, opts ...grpc.CallOption) (*lnrpc.GetInfoResponse, error) {
+	return &lnrpc.GetInfoResponse{BlockHeight: c.height}, nil
+}
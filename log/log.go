@@ -33,6 +33,26 @@ func Debugf(format string, v ...interface{}) {
 	}
 }
 
+// Warnf logs a warning. The PeerswapLogger has no dedicated warning level, so
+// the line is prefixed and logged on info level.
+func Warnf(format string, v ...interface{}) {
+	if logger != nil {
+		logger.Infof("[WARN] "+format, v...)
+	} else {
+		log.Printf("[WARN] "+format, v...)
+	}
+}
+
+// Errorf logs an error. The PeerswapLogger has no dedicated error level, so
+// the line is prefixed and logged on info level.
+func Errorf(format string, v ...interface{}) {
+	if logger != nil {
+		logger.Infof("[ERROR] "+format, v...)
+	} else {
+		log.Printf("[ERROR] "+format, v...)
+	}
+}
+
 type logType int
 
 const (
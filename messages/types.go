@@ -35,6 +35,12 @@ const (
 	MESSAGETYPE_POLL
 	_
 	MESSAGETYPE_REQUEST_POLL
+	_
+	MESSAGETYPE_SWAPREUNION
+	_
+	MESSAGETYPE_PING
+	_
+	MESSAGETYPE_PONG
 	UPPER_MESSAGE_BOUND
 )
 
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
@@ -139,6 +140,48 @@ func (b *BitcoinOnChain) TxIdFromHex(txHex string) (string, error) {
 	return msgTx.TxHash().String(), nil
 }
 
+// GetSpendingTxFee returns the fee that the spending transaction paid. All
+// inputs of the spending transaction must spend outputs of the opening
+// transaction.
+func (b *BitcoinOnChain) GetSpendingTxFee(openingTxHex, spendingTxHex string) (uint64, error) {
+	openingTxBytes, err := hex.DecodeString(openingTxHex)
+	if err != nil {
+		return 0, err
+	}
+	openingTx := wire.NewMsgTx(2)
+	err = openingTx.Deserialize(bytes.NewReader(openingTxBytes))
+	if err != nil {
+		return 0, err
+	}
+
+	spendingTxBytes, err := hex.DecodeString(spendingTxHex)
+	if err != nil {
+		return 0, err
+	}
+	spendingTx := wire.NewMsgTx(2)
+	err = spendingTx.Deserialize(bytes.NewReader(spendingTxBytes))
+	if err != nil {
+		return 0, err
+	}
+
+	openingTxHash := openingTx.TxHash()
+	var inputSats, outputSats int64
+	for _, in := range spendingTx.TxIn {
+		prevOut := in.PreviousOutPoint
+		if prevOut.Hash != openingTxHash || int(prevOut.Index) >= len(openingTx.TxOut) {
+			return 0, fmt.Errorf("input %s does not spend the opening transaction", prevOut)
+		}
+		inputSats += openingTx.TxOut[prevOut.Index].Value
+	}
+	for _, out := range spendingTx.TxOut {
+		outputSats += out.Value
+	}
+	if outputSats > inputSats {
+		return 0, fmt.Errorf("outputs of %d sat exceed the inputs of %d sat", outputSats, inputSats)
+	}
+	return uint64(inputSats - outputSats), nil
+}
+
 func (b *BitcoinOnChain) GetVoutAndVerify(txHex string, params *swap.OpeningParams) (bool, uint32, error) {
 	msgTx := wire.NewMsgTx(2)
 
@@ -297,6 +340,21 @@ func (b *BitcoinOnChain) GetFeeSatsFromTx(psbtString, txHex string) (uint64, err
 // fetches the fee estimation from the Estimator in sat/kw and converts the
 // returned fee estimation into sat/vb. The return value is in sat.
 func (b *BitcoinOnChain) GetFee(txSize int64) (uint64, error) {
+	satPerVb, err := b.GetFeeRate()
+	if err != nil {
+		return 0, err
+	}
+
+	// assume largest witness
+	fee := uint64(satPerVb * float64(txSize))
+	log.Debugf("Using a fee rate of %.2f sat/vb for a total fee of %d", satPerVb, fee)
+	return fee, nil
+}
+
+// GetFeeRate returns the estimated fee rate in sat/vb. It fetches the fee
+// estimation from the Estimator in sat/kw and falls back to the fallback fee
+// rate if the estimation fails. The fee rate is at least the fee floor.
+func (b *BitcoinOnChain) GetFeeRate() (float64, error) {
 	// EstimateFeePerKw returns an btcutil.Amount that is in sat/kw.
 	satPerKw, err := b.estimator.EstimateFeePerKW(BitcoinFeeTargetBlocks)
 	switch {
@@ -320,10 +378,5 @@ func (b *BitcoinOnChain) GetFee(txSize int64) (uint64, error) {
 	// below 1.0 sat/vb if we set the fallback fee above 250 sat/kw. We can set
 	// this fallback fee in the fee estimator.
 	satPerKb := satPerKw * witnessScaleFactor
-	satPerVb := float64(satPerKb) / 1000
-
-	// assume largest witness
-	fee := uint64(satPerVb * float64(txSize))
-	log.Debugf("Using a fee rate of %.2f sat/vb for a total fee of %d", satPerVb, fee)
-	return fee, nil
+	return float64(satPerKb) / 1000, nil
 }
@@ -1,9 +1,12 @@
 package onchain
 
 import (
+	"bytes"
+	"encoding/hex"
 	"testing"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/stretchr/testify/require"
 )
@@ -77,6 +80,37 @@ func TestBitcoinOnChain_GetFee_UseFloorFeeRate(t *testing.T) {
 	)
 }
 
+func TestBitcoinOnChain_GetSpendingTxFee(t *testing.T) {
+	btcOnChain := NewBitcoinOnChain(&EstimatorMock{}, btcutil.Amount(300), &chaincfg.Params{})
+
+	txHex := func(tx *wire.MsgTx) string {
+		var buf bytes.Buffer
+		require.NoError(t, tx.Serialize(&buf))
+		return hex.EncodeToString(buf.Bytes())
+	}
+
+	openingTx := wire.NewMsgTx(2)
+	openingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 0}, nil, nil))
+	openingTx.AddTxOut(wire.NewTxOut(50000, []byte{0x00}))
+	openingTx.AddTxOut(wire.NewTxOut(100000, []byte{0x00}))
+	openingTxHash := openingTx.TxHash()
+
+	spendingTx := wire.NewMsgTx(2)
+	spendingTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&openingTxHash, 1), nil, nil))
+	spendingTx.AddTxOut(wire.NewTxOut(99750, []byte{0x00}))
+
+	fee, err := btcOnChain.GetSpendingTxFee(txHex(openingTx), txHex(spendingTx))
+	require.NoError(t, err)
+	require.Equal(t, uint64(250), fee)
+
+	// A transaction that spends another transaction is rejected.
+	otherTx := wire.NewMsgTx(2)
+	otherTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	otherTx.AddTxOut(wire.NewTxOut(99750, []byte{0x00}))
+	_, err = btcOnChain.GetSpendingTxFee(txHex(openingTx), txHex(otherTx))
+	require.Error(t, err)
+}
+
 type EstimatorMock struct {
 	EstimateFeePerKWCalled int
 	EstimateFeePerKWReturn btcutil.Amount
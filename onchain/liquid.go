@@ -111,6 +111,12 @@ func (l *LiquidOnChain) BroadcastOpeningTx(unpreparedTxHex string) (string, stri
 	return txId, txHex, nil
 }
 
+// RebroadcastOpeningTx sends the signed opening transaction to elementsd
+// again.
+func (l *LiquidOnChain) RebroadcastOpeningTx(txHex string) (string, error) {
+	return l.elements.SendRawTx(txHex)
+}
+
 func (l *LiquidOnChain) CreatePreimageSpendingTransaction(swapParams *swap.OpeningParams, claimParams *swap.ClaimParams) (string, string, error) {
 	newAddr, err := l.liquidWallet.GetAddress()
 	if err != nil {
@@ -414,6 +420,21 @@ func (l *LiquidOnChain) TxIdFromHex(txHex string) (string, error) {
 	return openingTx.TxHash().String(), nil
 }
 
+// GetSpendingTxFee returns the fee that the spending transaction paid, which
+// is the value of its explicit fee output.
+func (l *LiquidOnChain) GetSpendingTxFee(openingTxHex, spendingTxHex string) (uint64, error) {
+	spendingTx, err := transaction.NewTxFromHex(spendingTxHex)
+	if err != nil {
+		return 0, err
+	}
+	for _, out := range spendingTx.Outputs {
+		if len(out.Script) == 0 {
+			return elementsutil.ElementsToSatoshiValue(out.Value)
+		}
+	}
+	return 0, errors.New("spending transaction has no fee output")
+}
+
 func (l *LiquidOnChain) ValidateTx(openingParams *swap.OpeningParams, txHex string) (bool, error) {
 	redeemScript, err := ParamsToTxScript(openingParams, LiquidCsv)
 	if err != nil {
@@ -550,6 +571,19 @@ func (l *LiquidOnChain) Blech32ToScript(blech32Addr string) ([]byte, error) {
 }
 
 func (l *LiquidOnChain) getFee(txSize int) (uint64, error) {
+	satPerByte, err := l.GetFeeRate()
+	if err != nil {
+		return 0, err
+	}
+	// assume largest witness
+	fee := satPerByte * float64(txSize)
+
+	return uint64(fee), nil
+}
+
+// GetFeeRate returns the estimated fee rate in sat/vb. The fee rate is at
+// least 0.1 sat/vb.
+func (l *LiquidOnChain) GetFeeRate() (float64, error) {
 	feeRes, err := l.elements.EstimateFee(LiquidTargetBlocks, "ECONOMICAL")
 	if err != nil {
 		return 0, err
@@ -562,10 +596,7 @@ func (l *LiquidOnChain) getFee(txSize int) (uint64, error) {
 		//todo sane default sat per byte
 		satPerByte = 0.1
 	}
-	// assume largest witness
-	fee := satPerByte * float64(txSize)
-
-	return uint64(fee), nil
+	return satPerByte, nil
 }
 
 func (l *LiquidOnChain) GetRefundFee() (uint64, error) {
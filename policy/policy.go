@@ -143,6 +143,13 @@ func (p *Policy) NewSwapsAllowed() bool {
 	return p.AllowNewSwaps
 }
 
+// GetPeerAllowlist returns a copy of the allowlisted peers.
+func (p *Policy) GetPeerAllowlist() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]string{}, p.PeerAllowlist...)
+}
+
 // IsPeerAllowed returns if a peer or node is part of
 // the allowlist.
 func (p *Policy) IsPeerAllowed(peer string) bool {
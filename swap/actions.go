@@ -9,8 +9,6 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/elementsproject/peerswap/log"
-
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/elementsproject/peerswap/isdev"
 	"github.com/elementsproject/peerswap/lightning"
@@ -23,8 +21,9 @@ type CheckRequestWrapperAction struct {
 
 func (a CheckRequestWrapperAction) Execute(services *SwapServices, swap *SwapData) EventType {
 	if !services.policy.NewSwapsAllowed() {
-		swap.LastErr = errors.New("swaps are disabled")
+		swap.LastErr = ErrSwapsDisabled
 		swap.CancelMessage = "swaps are disabled"
+		swap.CancelReason = CancelReasonSwapsDisabled
 		services.requestedSwapsStore.Add(swap.PeerNodeId, RequestedSwap{
 			Asset:           swap.GetChain(),
 			AmountSat:       swap.GetAmount(),
@@ -34,9 +33,22 @@ func (a CheckRequestWrapperAction) Execute(services *SwapServices, swap *SwapDat
 		return swap.HandleError(errors.New(swap.CancelMessage))
 	}
 
+	if !services.isAssetAllowed(swap.GetChain()) {
+		swap.CancelMessage = WrongAssetError(swap.GetChain()).Error()
+		swap.CancelReason = CancelReasonUnsupportedAsset
+		services.requestedSwapsStore.Add(swap.PeerNodeId, RequestedSwap{
+			Asset:           swap.GetChain(),
+			AmountSat:       swap.GetAmount(),
+			Type:            swap.GetType(),
+			RejectionReason: swap.CancelMessage,
+		})
+		return swap.HandleError(WrongAssetError(swap.GetChain()))
+	}
+
 	if swap.GetChain() == l_btc_chain && !services.liquidEnabled {
 		swap.LastErr = errors.New("lbtc swaps are not supported")
 		swap.CancelMessage = "lbtc swaps are not supported"
+		swap.CancelReason = CancelReasonUnsupportedAsset
 		services.requestedSwapsStore.Add(swap.PeerNodeId, RequestedSwap{
 			Asset:           swap.GetChain(),
 			AmountSat:       swap.GetAmount(),
@@ -49,6 +61,7 @@ func (a CheckRequestWrapperAction) Execute(services *SwapServices, swap *SwapDat
 	if swap.GetChain() == btc_chain && !services.bitcoinEnabled {
 		swap.LastErr = errors.New("btc swaps are not supported")
 		swap.CancelMessage = "btc swaps are not supported"
+		swap.CancelReason = CancelReasonUnsupportedAsset
 		services.requestedSwapsStore.Add(swap.PeerNodeId, RequestedSwap{
 			Asset:           swap.GetChain(),
 			AmountSat:       swap.GetAmount(),
@@ -58,8 +71,9 @@ func (a CheckRequestWrapperAction) Execute(services *SwapServices, swap *SwapDat
 		return swap.HandleError(errors.New(swap.CancelMessage))
 	}
 
-	if swap.GetProtocolVersion() != PEERSWAP_PROTOCOL_VERSION {
-		swap.CancelMessage = "incompatible peerswap version"
+	if !services.isProtocolVersionAccepted(swap.GetProtocolVersion()) {
+		swap.CancelMessage = ProtocolVersionError(swap.GetProtocolVersion()).Error()
+		swap.CancelReason = CancelReasonProtocolVersion
 		services.requestedSwapsStore.Add(swap.PeerNodeId, RequestedSwap{
 			Asset:           swap.GetChain(),
 			AmountSat:       swap.GetAmount(),
@@ -71,6 +85,7 @@ func (a CheckRequestWrapperAction) Execute(services *SwapServices, swap *SwapDat
 
 	if swap.GetAmount()*1000 < services.policy.GetMinSwapAmountMsat() {
 		swap.CancelMessage = ErrMinimumSwapSize(services.policy.GetMinSwapAmountMsat()).Error()
+		swap.CancelReason = CancelReasonInvalidAmount
 		services.requestedSwapsStore.Add(swap.PeerNodeId, RequestedSwap{
 			Asset:           swap.GetChain(),
 			AmountSat:       swap.GetAmount(),
@@ -80,6 +95,18 @@ func (a CheckRequestWrapperAction) Execute(services *SwapServices, swap *SwapDat
 		return swap.HandleError(errors.New(swap.CancelMessage))
 	}
 
+	if err := services.checkPeerSwapAmount(swap.PeerNodeId, swap.GetAmount()); err != nil {
+		swap.CancelMessage = err.Error()
+		swap.CancelReason = CancelReasonInvalidAmount
+		services.requestedSwapsStore.Add(swap.PeerNodeId, RequestedSwap{
+			Asset:           swap.GetChain(),
+			AmountSat:       swap.GetAmount(),
+			Type:            swap.GetType(),
+			RejectionReason: swap.CancelMessage,
+		})
+		return swap.HandleError(err)
+	}
+
 	_, wallet, _, err := services.getOnChainServices(swap.GetChain())
 	if err != nil {
 		return swap.HandleError(err)
@@ -87,6 +114,7 @@ func (a CheckRequestWrapperAction) Execute(services *SwapServices, swap *SwapDat
 
 	if swap.GetAsset() != "" && swap.GetAsset() != wallet.GetAsset() {
 		swap.CancelMessage = fmt.Sprintf("invalid liquid asset %s", swap.GetAsset())
+		swap.CancelReason = CancelReasonUnsupportedAsset
 		services.requestedSwapsStore.Add(swap.PeerNodeId, RequestedSwap{
 			Asset:           swap.GetChain(),
 			AmountSat:       swap.GetAmount(),
@@ -98,6 +126,7 @@ func (a CheckRequestWrapperAction) Execute(services *SwapServices, swap *SwapDat
 
 	if swap.GetNetwork() != "" && swap.GetNetwork() != wallet.GetNetwork() {
 		swap.CancelMessage = fmt.Sprintf("invalid bitcoin network %s", swap.GetNetwork())
+		swap.CancelReason = CancelReasonUnsupportedAsset
 		services.requestedSwapsStore.Add(swap.PeerNodeId, RequestedSwap{
 			Asset:           swap.GetChain(),
 			AmountSat:       swap.GetAmount(),
@@ -109,6 +138,7 @@ func (a CheckRequestWrapperAction) Execute(services *SwapServices, swap *SwapDat
 
 	if !services.policy.IsPeerAllowed(swap.PeerNodeId) {
 		swap.CancelMessage = fmt.Sprintf("peer %s not allowed to request swaps", swap.PeerNodeId)
+		swap.CancelReason = CancelReasonPeerNotAllowed
 		services.requestedSwapsStore.Add(swap.PeerNodeId, RequestedSwap{
 			Asset:           swap.GetChain(),
 			AmountSat:       swap.GetAmount(),
@@ -120,6 +150,7 @@ func (a CheckRequestWrapperAction) Execute(services *SwapServices, swap *SwapDat
 
 	if services.policy.IsPeerSuspicious(swap.PeerNodeId) {
 		swap.CancelMessage = fmt.Sprintf("peer %s not allowed to request swaps", swap.PeerNodeId)
+		swap.CancelReason = CancelReasonPeerNotAllowed
 		services.requestedSwapsStore.Add(swap.PeerNodeId, RequestedSwap{
 			Asset:           swap.GetChain(),
 			AmountSat:       swap.GetAmount(),
@@ -142,8 +173,12 @@ func (s *SwapInReceiverInitAction) Execute(services *SwapServices, swap *SwapDat
 		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
 		SwapId:          swap.GetId(),
 		Pubkey:          hex.EncodeToString(swap.GetPrivkey().PubKey().SerializeCompressed()),
-		// todo: set premium
-		Premium: 0,
+		Premium:         services.getPremium(swap.PeerNodeId),
+		Nonce:           swap.GetNonce(),
+	}
+	if len(swap.SwapInRequest.ChainOptions) > 0 {
+		agreementMessage.Asset = swap.GetAsset()
+		agreementMessage.Network = swap.GetNetwork()
 	}
 	swap.SwapInAgreement = agreementMessage
 
@@ -154,10 +189,6 @@ func (s *SwapInReceiverInitAction) Execute(services *SwapServices, swap *SwapDat
 	swap.NextMessage = nextMessage
 	swap.NextMessageType = nextMessageType
 
-	toCtx, cancel := context.WithCancel(context.Background())
-	swap.toCancel = cancel
-	services.toService.addNewTimeOut(toCtx, 10*time.Minute, swap.GetId().String())
-
 	return Event_ActionSucceeded
 }
 
@@ -183,9 +214,12 @@ func (s *ClaimSwapTransactionWithPreimageAction) Execute(services *SwapServices,
 	}
 
 	if swap.ClaimTxId == "" {
+		if err := swap.flushPending(); err != nil {
+			return swap.HandleError(err)
+		}
 		txId, _, err := wallet.CreatePreimageSpendingTransaction(swap.GetOpeningParams(), swap.GetClaimParams())
 		if err != nil {
-			log.Infof("Error claiming tx with preimage %v", err)
+			newSwapLogger(services.logger, swap.GetId().String()).Infof("Error claiming tx with preimage %v", err)
 			return Event_OnRetry
 		}
 		swap.ClaimTxId = txId
@@ -215,10 +249,12 @@ func (c *CreateAndBroadcastOpeningTransaction) Execute(services *SwapServices, s
 
 	// Construct memo
 	memo := fmt.Sprintf("peerswap %s %s %s %s", swap.GetChain(), INVOICE_CLAIM, swap.GetScidInBoltFormat(), swap.GetId())
-	payreq, err := services.lightning.GetPayreq((swap.GetAmount())*1000, preimage.String(), swap.GetId().String(), memo, INVOICE_CLAIM, swap.GetInvoiceExpiry())
+	claimAmount := swap.GetClaimInvoiceAmount()
+	payreq, err := services.lightning.GetPayreq(claimAmount*1000, preimage.String(), swap.GetId().String(), memo, INVOICE_CLAIM, services.claimInvoiceExpiry(swap))
 	if err != nil {
 		return swap.HandleError(err)
 	}
+	swap.Cost.setClaimInvoice(claimAmount)
 
 	var blindingKey *btcec.PrivateKey
 	var blindingKeyHex string
@@ -228,7 +264,7 @@ func (c *CreateAndBroadcastOpeningTransaction) Execute(services *SwapServices, s
 	}
 
 	// Create the opening transaction
-	txHex, _, vout, err := wallet.CreateOpeningTransaction(&OpeningParams{
+	txHex, openingTxFee, vout, err := wallet.CreateOpeningTransaction(&OpeningParams{
 		TakerPubkey:      swap.GetTakerPubkey(),
 		MakerPubkey:      swap.GetMakerPubkey(),
 		ClaimPaymentHash: preimage.Hash().String(),
@@ -239,6 +275,9 @@ func (c *CreateAndBroadcastOpeningTransaction) Execute(services *SwapServices, s
 		return swap.HandleError(err)
 	}
 
+	if err := swap.flushPending(); err != nil {
+		return swap.HandleError(err)
+	}
 	txId, txHex, err := wallet.BroadcastOpeningTx(txHex)
 	if err != nil {
 		// todo: idempotent states
@@ -251,6 +290,7 @@ func (c *CreateAndBroadcastOpeningTransaction) Execute(services *SwapServices, s
 	swap.StartingBlockHeight = startingHeight
 
 	swap.OpeningTxHex = txHex
+	swap.Cost.setOpeningTxFee(openingTxFee)
 
 	message := &OpeningTxBroadcastedMessage{
 		SwapId:      swap.GetId(),
@@ -273,6 +313,53 @@ func (c *CreateAndBroadcastOpeningTransaction) Execute(services *SwapServices, s
 	return Event_ActionSucceeded
 }
 
+// CheckPremiumWrapperAction cancels the swap if the premium that the swap
+// partner asked for in the agreement exceeds our maximum premium.
+type CheckPremiumWrapperAction struct {
+	next Action
+}
+
+func (a CheckPremiumWrapperAction) Execute(services *SwapServices, swap *SwapData) EventType {
+	premium := swap.GetPremium()
+	if premium > services.maxPremiumSat {
+		swap.CancelMessage = fmt.Sprintf("premium of %d sat exceeds the maximum premium of %d sat", premium, services.maxPremiumSat)
+		swap.CancelReason = CancelReasonFeeTooHigh
+		return swap.HandleError(errors.New(swap.CancelMessage))
+	}
+	if premium > 0 && premium >= swap.GetAmount() {
+		swap.CancelMessage = fmt.Sprintf("premium of %d sat exceeds the swap amount", premium)
+		swap.CancelReason = CancelReasonFeeTooHigh
+		return swap.HandleError(errors.New(swap.CancelMessage))
+	}
+
+	// Call next Action
+	return a.next.Execute(services, swap)
+}
+
+// CheckSwapCostWrapperAction cancels a swap in if the premium and the
+// estimated fee of the opening transaction that we pay exceed our maximum
+// cost.
+type CheckSwapCostWrapperAction struct {
+	next Action
+}
+
+func (a CheckSwapCostWrapperAction) Execute(services *SwapServices, swap *SwapData) EventType {
+	if services.maxCostPPM == 0 {
+		return a.next.Execute(services, swap)
+	}
+	openingTxFee, err := services.feeEstimator.EstimateOpeningTxFee(swap.GetChain(), swap.GetAmount())
+	if err != nil {
+		return swap.HandleError(err)
+	}
+	if err := services.checkSwapCost(swap.GetAmount(), swap.GetPremium()+openingTxFee); err != nil {
+		swap.CancelReason = CancelReasonFeeTooHigh
+		return swap.HandleError(err)
+	}
+
+	// Call next Action
+	return a.next.Execute(services, swap)
+}
+
 type StopSendMessageWithRetryWrapperAction struct {
 	next Action
 }
@@ -362,7 +449,7 @@ func (c *CreateSwapOutFromRequestAction) Execute(services *SwapServices, swap *S
 	}
 
 	// todo replace with premium estimation https://github.com/elementsproject/peerswap/issues/109
-	openingFee, err := wallet.GetFlatSwapOutFee()
+	openingFee, err := services.feeEstimator.EstimateOpeningTxFee(swap.GetChain(), swap.GetAmount())
 	if err != nil {
 		swap.LastErr = err
 		return swap.HandleError(err)
@@ -378,7 +465,11 @@ func (c *CreateSwapOutFromRequestAction) Execute(services *SwapServices, swap *S
 	safetynet := uint64(20000)
 
 	if walletBalance < swap.GetAmount()+openingFee+safetynet {
-		return swap.HandleError(errors.New("insufficient walletbalance"))
+		// Do not leak the wallet balance to the peer.
+		swap.CancelMessage = "insufficient funds"
+		swap.CancelReason = CancelReasonInsufficientFunds
+		return swap.HandleError(fmt.Errorf("insufficient walletbalance: %d sat available, %d sat required",
+			walletBalance, swap.GetAmount()+openingFee+safetynet))
 	}
 
 	// Construct memo
@@ -389,16 +480,29 @@ func (c *CreateSwapOutFromRequestAction) Execute(services *SwapServices, swap *S
 	if err != nil {
 		return swap.HandleError(err)
 	}
-	feeInvoice, err := services.lightning.GetPayreq(openingFee*1000, feepreimage.String(), swap.GetId().String(), memo, INVOICE_FEE, 600)
+	// The premium is payed together with the opening fee.
+	premium := services.getPremium(swap.PeerNodeId)
+	feeInvoice, err := services.lightning.GetPayreq((openingFee+premium)*1000, feepreimage.String(), swap.GetId().String(), memo, INVOICE_FEE, 600)
 	if err != nil {
 		return swap.HandleError(err)
 	}
+	swap.Cost.setFeeInvoice(openingFee + premium)
 
 	message := &SwapOutAgreementMessage{
 		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
 		SwapId:          swap.GetId(),
 		Pubkey:          hex.EncodeToString(swap.GetPrivkey().PubKey().SerializeCompressed()),
 		Payreq:          feeInvoice,
+		Premium:         premium,
+		FeeBreakdown: &FeeBreakdown{
+			OpeningTxFee: openingFee,
+			Premium:      premium,
+		},
+		Nonce: swap.GetNonce(),
+	}
+	if len(swap.SwapOutRequest.ChainOptions) > 0 {
+		message.Asset = swap.GetAsset()
+		message.Network = swap.GetNetwork()
 	}
 	swap.SwapOutAgreement = message
 
@@ -409,10 +513,6 @@ func (c *CreateSwapOutFromRequestAction) Execute(services *SwapServices, swap *S
 	swap.NextMessage = nextMessage
 	swap.NextMessageType = nextMessageType
 
-	toCtx, cancel := context.WithCancel(context.Background())
-	swap.toCancel = cancel
-	services.toService.addNewTimeOut(toCtx, 10*time.Minute, swap.GetId().String())
-
 	return Event_ActionSucceeded
 }
 
@@ -427,12 +527,20 @@ func (c *ClaimSwapTransactionWithCsv) Execute(services *SwapServices, swap *Swap
 	}
 
 	if swap.ClaimTxId == "" {
-		txId, _, err := wallet.CreateCsvSpendingTransaction(swap.GetOpeningParams(), swap.GetClaimParams())
+		if err := swap.flushPending(); err != nil {
+			return swap.HandleError(err)
+		}
+		txId, txHex, err := wallet.CreateCsvSpendingTransaction(swap.GetOpeningParams(), swap.GetClaimParams())
 		if err != nil {
 			swap.HandleError(err)
 			return Event_OnRetry
 		}
 		swap.ClaimTxId = txId
+		if getter, ok := wallet.(SpendingTxFeeGetter); ok {
+			if fee, err := getter.GetSpendingTxFee(swap.OpeningTxHex, txHex); err == nil {
+				swap.Cost.setRefundTxFee(fee)
+			}
+		}
 	}
 
 	return Event_ActionSucceeded
@@ -454,11 +562,19 @@ func (c *ClaimSwapTransactionCoop) Execute(services *SwapServices, swap *SwapDat
 	takerKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), takerKeyBytes)
 
 	if swap.ClaimTxId == "" {
-		txId, _, err := wallet.CreateCoopSpendingTransaction(swap.GetOpeningParams(), swap.GetClaimParams(), takerKey)
+		if err := swap.flushPending(); err != nil {
+			return swap.HandleError(err)
+		}
+		txId, txHex, err := wallet.CreateCoopSpendingTransaction(swap.GetOpeningParams(), swap.GetClaimParams(), takerKey)
 		if err != nil {
 			return swap.HandleError(err)
 		}
 		swap.ClaimTxId = txId
+		if getter, ok := wallet.(SpendingTxFeeGetter); ok {
+			if fee, err := getter.GetSpendingTxFee(swap.OpeningTxHex, txHex); err == nil {
+				swap.Cost.setRefundTxFee(fee)
+			}
+		}
 	}
 
 	return Event_ActionSucceeded
@@ -469,19 +585,29 @@ type SendCancelAction struct{}
 
 func (s *SendCancelAction) Execute(services *SwapServices, swap *SwapData) EventType {
 	if swap.LastErr != nil {
-		log.Debugf("[FSM] Canceling because of %s", swap.LastErr.Error())
+		newSwapLogger(services.logger, swap.GetId().String()).Debugf("[FSM] Canceling because of %s", swap.LastErr.Error())
 	}
-	messenger := services.messenger
 
+	reason := swap.CancelReason
+	if reason == "" {
+		reason = CancelReasonOther
+	}
+	if swap.CancelCategory == "" {
+		swap.CancelCategory = reason.category()
+	}
 	msgBytes, msgType, err := MarshalPeerswapMessage(&CancelMessage{
 		SwapId:  swap.GetId(),
+		Reason:  reason,
 		Message: swap.CancelMessage,
 	})
 	if err != nil {
 		return swap.HandleError(err)
 	}
 
-	err = messenger.SendMessage(swap.PeerNodeId, msgBytes, msgType)
+	if err := swap.flushPending(); err != nil {
+		return swap.HandleError(err)
+	}
+	err = services.sendMessage(swap.PeerNodeId, msgBytes, msgType)
 	if err != nil {
 		return swap.HandleError(err)
 	}
@@ -497,6 +623,7 @@ func (s *TakerSendPrivkeyAction) Execute(services *SwapServices, swap *SwapData)
 		SwapId:  swap.GetId(),
 		Message: swap.CancelMessage,
 		Privkey: privkeystring,
+		Nonce:   swap.GetNonce(),
 	})
 	if err != nil {
 		return swap.HandleError(err)
@@ -520,10 +647,6 @@ func (a *CreateSwapRequestAction) Execute(services *SwapServices, swap *SwapData
 	swap.NextMessage = nextMessage
 	swap.NextMessageType = nextMessageType
 
-	toCtx, cancel := context.WithCancel(context.Background())
-	swap.toCancel = cancel
-	services.toService.addNewTimeOut(toCtx, 10*time.Minute, swap.GetId().String())
-
 	return Event_ActionSucceeded
 }
 
@@ -534,7 +657,10 @@ func (s *SendMessageAction) Execute(services *SwapServices, swap *SwapData) Even
 		return swap.HandleError(errors.New("swap.NextMessage is nil"))
 	}
 
-	err := services.messenger.SendMessage(swap.PeerNodeId, swap.NextMessage, swap.NextMessageType)
+	if err := swap.flushPending(); err != nil {
+		return swap.HandleError(err)
+	}
+	err := services.sendMessage(swap.PeerNodeId, swap.NextMessage, swap.NextMessageType)
 	if err != nil {
 		return swap.HandleError(err)
 	}
@@ -550,6 +676,9 @@ func (s *SendMessageWithRetryAction) Execute(services *SwapServices, swap *SwapD
 
 	// Send message repeated as we really want the message to be received at some point!
 	rm := messages.NewRedundantMessenger(services.messenger, 10*time.Second)
+	if err := swap.flushPending(); err != nil {
+		return swap.HandleError(err)
+	}
 	err := services.messengerManager.AddSender(swap.GetId().String(), rm)
 	if err != nil {
 		return swap.HandleError(err)
@@ -563,11 +692,6 @@ func (s *SendMessageWithRetryAction) Execute(services *SwapServices, swap *SwapD
 type PayFeeInvoiceAction struct{}
 
 func (r *PayFeeInvoiceAction) Execute(services *SwapServices, swap *SwapData) EventType {
-	_, wallet, _, err := services.getOnChainServices(swap.GetChain())
-	if err != nil {
-		return swap.HandleError(err)
-	}
-
 	ll := services.lightning
 	// policy := services.policy
 	_, msatAmt, err := ll.DecodePayreq(swap.SwapOutAgreement.Payreq)
@@ -576,24 +700,52 @@ func (r *PayFeeInvoiceAction) Execute(services *SwapServices, swap *SwapData) Ev
 	}
 	swap.OpeningTxFee = msatAmt / 1000
 
-	expectedFee, err := wallet.GetFlatSwapOutFee()
+	expectedFee, err := services.feeEstimator.EstimateOpeningTxFee(swap.GetChain(), swap.GetAmount())
 	if err != nil {
 		swap.LastErr = err
 		return swap.HandleError(err)
 	}
 
-	maxExpected := uint64((float64(expectedFee) * 3))
+	maxExpectedOpeningTxFee := uint64((float64(expectedFee) * 3))
+
+	if breakdown := swap.SwapOutAgreement.FeeBreakdown; breakdown != nil {
+		// Check the components of the fee invoice on their own. The
+		// premium was checked against our maximum premium already.
+		if breakdown.OpeningTxFee+breakdown.Premium != swap.OpeningTxFee {
+			swap.CancelReason = CancelReasonFeeTooHigh
+			return swap.HandleError(fmt.Errorf("fee invoice of %d sat does not match the fee breakdown of %d sat opening tx fee and %d sat premium",
+				swap.OpeningTxFee, breakdown.OpeningTxFee, breakdown.Premium))
+		}
+		if breakdown.OpeningTxFee > maxExpectedOpeningTxFee {
+			swap.CancelReason = CancelReasonFeeTooHigh
+			return swap.HandleError(fmt.Errorf("opening tx fee of %d sat exceeds the max expected opening tx fee of %d sat",
+				breakdown.OpeningTxFee, maxExpectedOpeningTxFee))
+		}
+	}
+
+	maxExpected := maxExpectedOpeningTxFee + swap.GetPremium()
 
 	// if the fee invoice is larger than what we would expect, don't pay
 	if swap.OpeningTxFee > maxExpected {
+		swap.CancelReason = CancelReasonFeeTooHigh
 		return swap.HandleError(errors.New(fmt.Sprintf("Fee is too damn high. Max expected: %v Received %v", maxExpected, swap.OpeningTxFee)))
 	}
 
+	// the fee invoice is the total cost of the swap out
+	if err := services.checkSwapCost(swap.GetAmount(), swap.OpeningTxFee); err != nil {
+		swap.CancelReason = CancelReasonFeeTooHigh
+		return swap.HandleError(err)
+	}
+
+	if err := swap.flushPending(); err != nil {
+		return swap.HandleError(err)
+	}
 	preimage, err := ll.PayInvoiceViaChannel(swap.SwapOutAgreement.Payreq, swap.GetScid())
 	if err != nil {
 		return swap.HandleError(err)
 	}
 	swap.FeePreimage = preimage
+	swap.Cost.setFeeInvoice(msatAmt / 1000)
 	return Event_ActionSucceeded
 }
 
@@ -627,18 +779,20 @@ func (t *AwaitTxConfirmationAction) Execute(services *SwapServices, swap *SwapDa
 		return swap.HandleError(err)
 	}
 
-	if msatAmount != swap.GetAmount()*1000 {
-		return swap.HandleError(fmt.Errorf("invoice amount does not equal swap amount, invoice: %v, swap %v", swap.OpeningTxBroadcasted.Payreq, swap.GetAmount()))
+	claimAmount := swap.GetClaimInvoiceAmount()
+	if msatAmount != claimAmount*1000 {
+		return swap.HandleError(fmt.Errorf("invoice amount does not equal swap amount, invoice: %v, swap %v", swap.OpeningTxBroadcasted.Payreq, claimAmount))
 	}
 
 	swap.ClaimPaymentHash = phash
+	swap.Cost.setClaimInvoice(claimAmount)
 
 	wantScript, err := wallet.GetOutputScript(swap.GetOpeningParams())
 	if err != nil {
 		return swap.HandleError(err)
 	}
 	txWatcher.AddWaitForConfirmationTx(swap.GetId().String(), swap.OpeningTxBroadcasted.TxId, swap.OpeningTxBroadcasted.ScriptOut, swap.StartingBlockHeight, wantScript)
-	log.Debugf("Await confirmation for tx with id: %s on swap %s", swap.OpeningTxBroadcasted.TxId, swap.GetId().String())
+	newSwapLogger(services.logger, swap.GetId().String()).Debugf("Await confirmation for tx with id: %s", swap.OpeningTxBroadcasted.TxId)
 	return NoOp
 }
 
@@ -669,7 +823,7 @@ func (p *ValidateTxAndPayClaimInvoiceAction) Execute(services *SwapServices, swa
 		if prtStr := os.Getenv("PAYMENT_RETRY_TIME"); prtStr != "" {
 			prtInt, err := strconv.Atoi(prtStr)
 			if err != nil {
-				log.Debugf("could not read from PAYMENT_RETRY_TIME")
+				newSwapLogger(services.logger, swap.GetId().String()).Debugf("could not read from PAYMENT_RETRY_TIME")
 			} else {
 				retryTime = time.Duration(prtInt) * time.Second
 			}
@@ -677,6 +831,9 @@ func (p *ValidateTxAndPayClaimInvoiceAction) Execute(services *SwapServices, swa
 		interval = 1 * time.Second
 	}
 
+	if err := swap.flushPending(); err != nil {
+		return swap.HandleError(err)
+	}
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -691,7 +848,7 @@ func (p *ValidateTxAndPayClaimInvoiceAction) Execute(services *SwapServices, swa
 		case <-ticker.C:
 			preimage, err = lc.RebalancePayment(swap.OpeningTxBroadcasted.Payreq, swap.GetScid())
 			if err != nil {
-				log.Infof("error trying to pay invoice: %v, retry...", err)
+				newSwapLogger(services.logger, swap.GetId().String()).Infof("error trying to pay invoice: %v, retry...", err)
 				// Another round!
 				continue
 			}
@@ -0,0 +1,154 @@
+package swap
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// circuitBreaker stops the acceptance of new swaps if too many of the recent
+// swaps failed, e.g. because the wallet is misconfigured.
+type circuitBreaker struct {
+	sync.Mutex
+	window    int
+	threshold float64
+	cooldown  time.Duration
+
+	// failures holds the results of the last finished swaps, true if the
+	// swap failed.
+	failures []bool
+	open     bool
+	openedAt time.Time
+}
+
+// SetCircuitBreaker enables the circuit breaker. If at least the threshold
+// share of the last window finished swaps failed, the breaker opens and new
+// swaps are neither started nor accepted for the cooldown, like while the
+// service is paused. The breaker closes again after the cooldown or once a
+// swap that was active while it was open succeeds. A swap fails if it does
+// not end with the claim of the preimage. A window of 0 disables the circuit
+// breaker, which is the default.
+func (s *SwapServices) SetCircuitBreaker(window int, threshold float64, cooldown time.Duration) error {
+	if window < 0 {
+		return fmt.Errorf("circuit breaker window must not be negative, got %d", window)
+	}
+	if window == 0 {
+		s.circuitBreaker = nil
+		return nil
+	}
+	if threshold <= 0 || threshold > 1 {
+		return fmt.Errorf("circuit breaker threshold must be in (0, 1], got %v", threshold)
+	}
+	if cooldown <= 0 {
+		return fmt.Errorf("circuit breaker cooldown must be positive, got %v", cooldown)
+	}
+	s.circuitBreaker = &circuitBreaker{
+		window:    window,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+	return nil
+}
+
+// record adds the result of a finished swap. It returns whether the breaker
+// was closed and whether it was opened by the result.
+func (b *circuitBreaker) record(failed bool, now time.Time) (closed, opened bool) {
+	b.Lock()
+	defer b.Unlock()
+	closed = b.closeAfterCooldown(now)
+	if b.open {
+		// A swap that succeeds while the breaker is open shows that swaps
+		// work again.
+		if !failed {
+			b.reset()
+			return true, false
+		}
+		return closed, false
+	}
+
+	b.failures = append(b.failures, failed)
+	if len(b.failures) > b.window {
+		b.failures = b.failures[1:]
+	}
+	if len(b.failures) < b.window {
+		return closed, false
+	}
+	var n int
+	for _, failed := range b.failures {
+		if failed {
+			n++
+		}
+	}
+	if float64(n)/float64(b.window) < b.threshold {
+		return closed, false
+	}
+	b.open = true
+	b.openedAt = now
+	return closed, true
+}
+
+// check returns whether the breaker is open and until when, and whether it
+// was closed because the cooldown passed.
+func (b *circuitBreaker) check(now time.Time) (open bool, until time.Time, closed bool) {
+	b.Lock()
+	defer b.Unlock()
+	closed = b.closeAfterCooldown(now)
+	return b.open, b.openedAt.Add(b.cooldown), closed
+}
+
+// closeAfterCooldown closes the breaker if it is open for the cooldown and
+// returns true if it was closed.
+func (b *circuitBreaker) closeAfterCooldown(now time.Time) bool {
+	if !b.open || now.Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.reset()
+	return true
+}
+
+// reset closes the breaker and forgets the results of the swaps.
+func (b *circuitBreaker) reset() {
+	b.open = false
+	b.openedAt = time.Time{}
+	b.failures = nil
+}
+
+// recordSwapResult adds the result of the finished swap to the circuit
+// breaker. Abandoned swaps are not counted.
+func (s *SwapService) recordSwapResult(swap *SwapStateMachine) {
+	breaker := s.swapServices.circuitBreaker
+	if breaker == nil || swap.Current == State_SwapAbandoned {
+		return
+	}
+	closed, opened := breaker.record(swap.Current != State_ClaimedPreimage, s.swapServices.now())
+	if closed {
+		s.onCircuitBreakerClosed()
+	}
+	if opened {
+		s.swapServices.logger.Warnf("[SwapService] Circuit breaker opened after too many failed swaps, not accepting new swaps for %v", breaker.cooldown)
+		s.swapServices.publishSwapEvent(SwapEvent{Kind: SwapEventCircuitBreakerOpened})
+	}
+}
+
+// checkCircuitBreaker returns ErrCircuitBreakerOpen if the circuit breaker is
+// open.
+func (s *SwapService) checkCircuitBreaker() error {
+	breaker := s.swapServices.circuitBreaker
+	if breaker == nil {
+		return nil
+	}
+	open, until, closed := breaker.check(s.swapServices.now())
+	if closed {
+		s.onCircuitBreakerClosed()
+	}
+	if open {
+		return fmt.Errorf("%w until %s", ErrCircuitBreakerOpen, until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// onCircuitBreakerClosed reports that the circuit breaker closed.
+func (s *SwapService) onCircuitBreakerClosed() {
+	s.swapServices.logger.Infof("[SwapService] Circuit breaker closed, accepting new swaps again")
+	s.swapServices.publishSwapEvent(SwapEvent{Kind: SwapEventCircuitBreakerClosed})
+}
@@ -0,0 +1,105 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// breakerEvents returns the circuit breaker events that were published so
+// far.
+func breakerEvents(events <-chan SwapEvent) []SwapEventKind {
+	var kinds []SwapEventKind
+	for _, event := range drainEvents(events, func(event SwapEvent) bool {
+		return event.Kind == SwapEventCircuitBreakerOpened || event.Kind == SwapEventCircuitBreakerClosed
+	}) {
+		kinds = append(kinds, event.Kind)
+	}
+	return kinds
+}
+
+func Test_CircuitBreaker(t *testing.T) {
+	initiator, peer, pubkey, _, channelId := getTestParams()
+
+	now := time.Now()
+	service, messenger := getRecordingTestSetup(initiator)
+	service.swapServices.clock = func() time.Time { return now }
+	assert.Error(t, service.swapServices.SetCircuitBreaker(-1, 0.5, time.Hour))
+	assert.Error(t, service.swapServices.SetCircuitBreaker(3, 0, time.Hour))
+	assert.Error(t, service.swapServices.SetCircuitBreaker(3, 1.5, time.Hour))
+	assert.Error(t, service.swapServices.SetCircuitBreaker(3, 0.5, 0))
+	require.NoError(t, service.swapServices.SetCircuitBreaker(3, 0.6, time.Hour))
+
+	events, unsubscribe := service.Subscribe()
+	defer unsubscribe()
+
+	// finish removes a swap that finished in the state.
+	finish := func(state StateType) {
+		swap := newSwapOutSenderFSM(service.swapServices, initiator, peer)
+		swap.Current = state
+		service.AddActiveSwap(swap.SwapId.String(), swap)
+		service.RemoveActiveSwap(swap.SwapId.String())
+	}
+	// Every swap out uses another channel, so that the swaps do not block
+	// each other.
+	var channels int
+	swapOut := func() error {
+		channels++
+		_, err := service.SwapOut(peer, btc_chain, fmt.Sprintf("100x1x%d", channels), initiator, 100000)
+		return err
+	}
+
+	// The breaker does not open before the window is full.
+	finish(State_SwapCanceled)
+	finish(State_ClaimedCsv)
+	assert.NoError(t, swapOut())
+	assert.Empty(t, breakerEvents(events))
+
+	// Trip: the third failure of the last three swaps opens the breaker.
+	assert.NoError(t, service.checkCircuitBreaker())
+	finish(State_ClaimedCoop)
+	assert.Equal(t, []SwapEventKind{SwapEventCircuitBreakerOpened}, breakerEvents(events))
+
+	assert.ErrorIs(t, swapOut(), ErrCircuitBreakerOpen)
+	swapId := NewSwapId()
+	err := service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            channelId,
+		Amount:          100000,
+		Pubkey:          pubkey,
+	})
+	assert.ErrorIs(t, err, ErrCircuitBreakerOpen)
+	msg := lastCancelMessage(t, messenger)
+	assert.Equal(t, swapId, msg.SwapId)
+	assert.Equal(t, CancelReasonUnavailable, msg.Reason)
+	assert.ErrorIs(t, service.HealthCheck(context.Background()), ErrCircuitBreakerOpen)
+
+	// Hold: the breaker stays open during the cooldown, also if more swaps
+	// fail.
+	now = now.Add(59 * time.Minute)
+	finish(State_SwapCanceled)
+	assert.ErrorIs(t, swapOut(), ErrCircuitBreakerOpen)
+	assert.Empty(t, breakerEvents(events))
+
+	// Reset after the cooldown.
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, swapOut())
+	assert.Equal(t, []SwapEventKind{SwapEventCircuitBreakerClosed}, breakerEvents(events))
+	assert.False(t, errors.Is(service.HealthCheck(context.Background()), ErrCircuitBreakerOpen))
+
+	// Reset by a swap that succeeds while the breaker is open.
+	finish(State_SwapCanceled)
+	finish(State_SwapCanceled)
+	finish(State_SwapCanceled)
+	assert.ErrorIs(t, swapOut(), ErrCircuitBreakerOpen)
+	finish(State_ClaimedPreimage)
+	assert.NoError(t, swapOut())
+	assert.Equal(t, []SwapEventKind{SwapEventCircuitBreakerOpened, SwapEventCircuitBreakerClosed}, breakerEvents(events))
+}
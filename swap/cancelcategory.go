@@ -0,0 +1,58 @@
+package swap
+
+// CancelCategory is the machine readable category of the cancellation of a
+// swap. Unlike the CancelReason it also tells whether we or the peer
+// canceled the swap, so that failures can be grouped.
+type CancelCategory string
+
+const (
+	CancelCategoryPeerCancelled     CancelCategory = "peer_cancelled"
+	CancelCategoryTimeout           CancelCategory = "timeout"
+	CancelCategoryInvalidMessage    CancelCategory = "invalid_message"
+	CancelCategoryPolicyReject      CancelCategory = "policy_reject"
+	CancelCategoryInsufficientFunds CancelCategory = "insufficient_funds"
+	CancelCategoryFeeTooHigh        CancelCategory = "fee_too_high"
+	CancelCategoryOperatorCancelled CancelCategory = "operator_cancelled"
+	// CancelCategoryOther is used for the cancellations that fit no other
+	// category, e.g. internal errors.
+	CancelCategoryOther CancelCategory = "other"
+)
+
+// category returns the category of a cancellation by us for the reason.
+func (r CancelReason) category() CancelCategory {
+	switch r {
+	case CancelReasonSwapsDisabled, CancelReasonUnsupportedAsset, CancelReasonProtocolVersion,
+		CancelReasonInvalidAmount, CancelReasonPeerNotAllowed, CancelReasonRateLimited,
+		CancelReasonSwapLimit, CancelReasonDuplicateSwapId, CancelReasonCrossingSwap,
+		CancelReasonUnavailable:
+		return CancelCategoryPolicyReject
+	case CancelReasonFeeTooHigh:
+		return CancelCategoryFeeTooHigh
+	case CancelReasonInsufficientFunds:
+		return CancelCategoryInsufficientFunds
+	case CancelReasonInvalidMessage:
+		return CancelCategoryInvalidMessage
+	case CancelReasonTimeout, CancelReasonExpired:
+		return CancelCategoryTimeout
+	case CancelReasonOperator:
+		return CancelCategoryOperatorCancelled
+	default:
+		return CancelCategoryOther
+	}
+}
+
+// GetCancelCategory returns the category of the cancellation of the swap or
+// an empty category if the swap was not canceled. The category of swaps that
+// were stored before it was persisted is derived from their cancel messages.
+func (s *SwapData) GetCancelCategory() CancelCategory {
+	if s.CancelCategory != "" {
+		return s.CancelCategory
+	}
+	if s.Cancel != nil {
+		return CancelCategoryPeerCancelled
+	}
+	if s.CancelReason != "" {
+		return s.CancelReason.category()
+	}
+	return ""
+}
@@ -0,0 +1,293 @@
+package swap
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lastCancelMessage returns the last cancel message that was sent.
+func lastCancelMessage(t *testing.T, messenger *recordingMessenger) *CancelMessage {
+	messenger.Lock()
+	defer messenger.Unlock()
+	for i := len(messenger.sent) - 1; i >= 0; i-- {
+		if messenger.sent[i].msgType != int(messages.MESSAGETYPE_CANCELED) {
+			continue
+		}
+		var msg *CancelMessage
+		require.NoError(t, json.Unmarshal(messenger.sent[i].payload, &msg))
+		return msg
+	}
+	require.Fail(t, "no cancel message was sent")
+	return nil
+}
+
+func Test_CancelReason_RequestRejected(t *testing.T) {
+	initiator, peer, pubkey, _, _ := getTestParams()
+	_, otherPeer, _, _, _ := getTestParams()
+
+	type request func(swapId *SwapId, protocolVersion uint8, channelId string) error
+
+	for _, tc := range []struct {
+		name            string
+		setup           func(t *testing.T, service *SwapService, swapId *SwapId, request request)
+		protocolVersion uint8
+		reason          CancelReason
+	}{
+		{
+			name: "peer not on allowlist",
+			setup: func(t *testing.T, service *SwapService, swapId *SwapId, request request) {
+				setFilePolicy(t, service)
+				require.NoError(t, service.ReloadAllowlist([]string{otherPeer}))
+			},
+			reason: CancelReasonPeerNotAllowed,
+		},
+		{
+			name: "rate limited",
+			setup: func(t *testing.T, service *SwapService, swapId *SwapId, request request) {
+				require.NoError(t, service.swapServices.SetRequestRateLimit(1, time.Hour))
+				require.NoError(t, request(NewSwapId(), PEERSWAP_PROTOCOL_VERSION, "100x1x2"))
+			},
+			reason: CancelReasonRateLimited,
+		},
+		{
+			name:            "protocol version",
+			protocolVersion: PEERSWAP_PROTOCOL_VERSION + 1,
+			reason:          CancelReasonProtocolVersion,
+		},
+		{
+			name: "swap limit",
+			setup: func(t *testing.T, service *SwapService, swapId *SwapId, request request) {
+				require.NoError(t, service.swapServices.SetMaxActiveSwapsPerPeer(1))
+				require.NoError(t, request(NewSwapId(), PEERSWAP_PROTOCOL_VERSION, "100x1x2"))
+			},
+			reason: CancelReasonSwapLimit,
+		},
+		{
+			name: "fee ceiling",
+			setup: func(t *testing.T, service *SwapService, swapId *SwapId, request request) {
+				service.swapServices.bitcoinWallet = &feeRateWallet{dummyChain: service.swapServices.bitcoinWallet.(*dummyChain), feeRate: 50}
+				require.NoError(t, service.swapServices.SetMaxOpeningTxFeeRate(btc_chain, 20))
+			},
+			reason: CancelReasonFeeTooHigh,
+		},
+		{
+			name: "duplicate swap id",
+			setup: func(t *testing.T, service *SwapService, swapId *SwapId, request request) {
+				stored := newSwapOutReceiverFSM(swapId, service.swapServices, peer)
+				service.swapServices.swapStore.(*dummyStore).dataMap[swapId.String()] = stored
+			},
+			reason: CancelReasonDuplicateSwapId,
+		},
+		{
+			name: "swaps disabled",
+			setup: func(t *testing.T, service *SwapService, swapId *SwapId, request request) {
+				service.swapServices.policy.(*dummyPolicy).newSwapsAllowedReturn = false
+			},
+			reason: CancelReasonSwapsDisabled,
+		},
+		{
+			name: "chain disabled",
+			setup: func(t *testing.T, service *SwapService, swapId *SwapId, request request) {
+				service.swapServices.bitcoinEnabled = false
+			},
+			reason: CancelReasonUnsupportedAsset,
+		},
+		{
+			name: "amount too small",
+			setup: func(t *testing.T, service *SwapService, swapId *SwapId, request request) {
+				service.swapServices.policy.(*dummyPolicy).getMinSwapAmountMsatReturn = 200000 * 1000
+			},
+			reason: CancelReasonInvalidAmount,
+		},
+		{
+			name: "peer suspicious",
+			setup: func(t *testing.T, service *SwapService, swapId *SwapId, request request) {
+				service.swapServices.policy.(*dummyPolicy).isPeerSuspiciousReturn = true
+			},
+			reason: CancelReasonPeerNotAllowed,
+		},
+		{
+			name: "insufficient balance",
+			setup: func(t *testing.T, service *SwapService, swapId *SwapId, request request) {
+				service.swapServices.bitcoinWallet.(*dummyChain).SetBalance(0)
+			},
+			reason: CancelReasonInsufficientFunds,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service, messenger := getRecordingTestSetup(initiator)
+			require.NoError(t, service.swapServices.SetRequestRateLimit(0, time.Minute))
+
+			request := func(swapId *SwapId, protocolVersion uint8, channelId string) error {
+				return service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+					ProtocolVersion: protocolVersion,
+					SwapId:          swapId,
+					Network:         "mainnet",
+					Scid:            channelId,
+					Amount:          100000,
+					Pubkey:          pubkey,
+				})
+			}
+
+			swapId := NewSwapId()
+			if tc.setup != nil {
+				tc.setup(t, service, swapId, request)
+			}
+			protocolVersion := tc.protocolVersion
+			if protocolVersion == 0 {
+				protocolVersion = PEERSWAP_PROTOCOL_VERSION
+			}
+			// The error depends on whether the request is rejected before
+			// or by the statemachine, the cancel message is the same.
+			_ = request(swapId, protocolVersion, "100x1x1")
+
+			msg := lastCancelMessage(t, messenger)
+			assert.Equal(t, swapId, msg.SwapId)
+			assert.Equal(t, tc.reason, msg.Reason)
+			assert.NotEmpty(t, msg.Message)
+		})
+	}
+}
+
+func Test_CancelReason_Sender(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+
+	t.Run("operator", func(t *testing.T) {
+		service, messenger := getRecordingTestSetup(initiator)
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		require.NoError(t, service.CancelSwap(swap.SwapId.String(), ""))
+
+		msg := lastCancelMessage(t, messenger)
+		assert.Equal(t, CancelReasonOperator, msg.Reason)
+		assert.Equal(t, "canceled by operator", msg.Message)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		service, messenger := getRecordingTestSetup(initiator)
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		service.createTimeoutCallback(swap.SwapId.String())()
+
+		require.Equal(t, State_SwapCanceled, swap.Current)
+		msg := lastCancelMessage(t, messenger)
+		assert.Equal(t, CancelReasonTimeout, msg.Reason)
+		assert.Equal(t, "swap timed out", msg.Message)
+	})
+
+	t.Run("received reason", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		require.NoError(t, service.OnCancelReceived(swap.SwapId, &CancelMessage{
+			SwapId:  swap.SwapId,
+			Reason:  CancelReasonInsufficientFunds,
+			Message: "insufficient funds",
+		}))
+
+		got, err := service.GetSwap(swap.SwapId.String())
+		require.NoError(t, err)
+		assert.Equal(t, CancelReasonInsufficientFunds, got.Data.GetCancelReason())
+		assert.Equal(t, "insufficient funds", got.Data.GetCancelMessage())
+	})
+}
+
+func Test_CancelCategory(t *testing.T) {
+	initiator, peer, pubkey, _, channelId := getTestParams()
+
+	assertCategory := func(t *testing.T, service *SwapService, swapId *SwapId, category CancelCategory) {
+		got, err := service.GetSwap(swapId.String())
+		require.NoError(t, err)
+		assert.Equal(t, category, got.Data.GetCancelCategory())
+		assert.Equal(t, string(category), newExportedSwap(got).CancelCategory)
+	}
+
+	t.Run("operator", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		require.NoError(t, service.CancelSwap(swap.SwapId.String(), ""))
+		assertCategory(t, service, swap.SwapId, CancelCategoryOperatorCancelled)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		service.createTimeoutCallback(swap.SwapId.String())()
+		assertCategory(t, service, swap.SwapId, CancelCategoryTimeout)
+	})
+
+	t.Run("peer cancelled", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		require.NoError(t, service.OnCancelReceived(swap.SwapId, &CancelMessage{
+			SwapId:  swap.SwapId,
+			Reason:  CancelReasonFeeTooHigh,
+			Message: "fee too high",
+		}))
+		assertCategory(t, service, swap.SwapId, CancelCategoryPeerCancelled)
+	})
+
+	t.Run("invalid message", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		_ = service.OnSwapOutAgreementReceived(&SwapOutAgreementMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swap.SwapId,
+			Pubkey:          getRandom33ByteHexString(),
+			Payreq:          "invoice",
+			Nonce:           getRandom32ByteHexString(),
+		})
+		assertCategory(t, service, swap.SwapId, CancelCategoryInvalidMessage)
+	})
+
+	for _, tc := range []struct {
+		name     string
+		setup    func(service *SwapService)
+		category CancelCategory
+	}{
+		{
+			name: "policy reject",
+			setup: func(service *SwapService) {
+				service.swapServices.policy.(*dummyPolicy).isPeerSuspiciousReturn = true
+			},
+			category: CancelCategoryPolicyReject,
+		},
+		{
+			name: "insufficient funds",
+			setup: func(service *SwapService) {
+				service.swapServices.bitcoinWallet.(*dummyChain).SetBalance(0)
+			},
+			category: CancelCategoryInsufficientFunds,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service, _ := getRecordingTestSetup(initiator)
+			tc.setup(service)
+			swapId := NewSwapId()
+			_ = service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          swapId,
+				Network:         "mainnet",
+				Scid:            channelId,
+				Amount:          100000,
+				Pubkey:          pubkey,
+			})
+			assertCategory(t, service, swapId, tc.category)
+		})
+	}
+
+	// The category of swaps that were stored before it was persisted is
+	// derived from the cancel messages.
+	assert.Equal(t, CancelCategoryFeeTooHigh, (&SwapData{CancelReason: CancelReasonFeeTooHigh}).GetCancelCategory())
+	assert.Equal(t, CancelCategoryPeerCancelled, (&SwapData{Cancel: &CancelMessage{}}).GetCancelCategory())
+	assert.Equal(t, CancelCategory(""), (&SwapData{}).GetCancelCategory())
+}
@@ -0,0 +1,113 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SwapOutChains starts a new swap out process on the first of the chains that
+// the peer supports. The chains are given in the order of preference, the
+// peer selects the chain and confirms it in the agreement. A single chain
+// behaves like SwapOutContext.
+func (s *SwapService) SwapOutChains(ctx context.Context, peer string, chains []string, channelId string, initiator string, amtSat uint64) (*SwapStateMachine, error) {
+	return s.swapOut(ctx, "", peer, chains, channelId, initiator, amtSat)
+}
+
+// SwapInChains starts a new swap in process on the first of the chains that
+// the peer supports. The chains are given in the order of preference, the
+// peer selects the chain and confirms it in the agreement. A single chain
+// behaves like SwapInContext.
+func (s *SwapService) SwapInChains(ctx context.Context, peer string, chains []string, channelId string, initiator string, amtSat uint64) (*SwapStateMachine, error) {
+	return s.swapIn(ctx, "", peer, chains, channelId, initiator, amtSat)
+}
+
+// checkChainsEnabled checks that the chains of a new swap are enabled and
+// are not given twice.
+func (s *SwapServices) checkChainsEnabled(chains []string) error {
+	if len(chains) == 0 {
+		return errors.New("no chain given")
+	}
+	seen := make(map[string]struct{}, len(chains))
+	for _, chain := range chains {
+		if _, ok := seen[chain]; ok {
+			return fmt.Errorf("chain %s is given twice", chain)
+		}
+		seen[chain] = struct{}{}
+		if err := s.checkChainEnabled(chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getChainOptions returns the chain options of a swap request for the chains.
+func (s *SwapService) getChainOptions(ctx context.Context, chains []string) ([]ChainOption, error) {
+	options := make([]ChainOption, 0, len(chains))
+	for _, chain := range chains {
+		bitcoinNetwork, elementsAsset, err := s.getChainParams(ctx, chain)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, ChainOption{Asset: elementsAsset, Network: bitcoinNetwork})
+	}
+	return options, nil
+}
+
+// selectChainOption returns the first of the chain options of a swap request
+// that is enabled and matches the asset or network of our wallet.
+func (s *SwapServices) selectChainOption(options []ChainOption) (ChainOption, error) {
+	chains := make([]string, 0, len(options))
+	for _, option := range options {
+		chain := option.chain()
+		chains = append(chains, chain)
+		if chain == "" || s.checkChainEnabled(chain) != nil {
+			continue
+		}
+		_, wallet, _, err := s.getOnChainServices(chain)
+		if err != nil {
+			continue
+		}
+		if chain == l_btc_chain && option.Asset == wallet.GetAsset() {
+			return option, nil
+		}
+		if chain == btc_chain && option.Network == wallet.GetNetwork() {
+			return option, nil
+		}
+	}
+	return ChainOption{}, NoCommonChainError(chains)
+}
+
+// SetChainEnabled enables or disables new swaps on the chain at runtime, e.g.
+// while the backend of the chain is down. While a chain is disabled, swaps on
+// the chain are neither started nor accepted, the active swaps on the chain
+// continue. A chain that was not enabled when the services were created can
+// not be enabled.
+func (s *SwapService) SetChainEnabled(chain string, enabled bool) error {
+	services := s.swapServices
+	switch chain {
+	case btc_chain:
+		if enabled && !services.bitcoinEnabled {
+			return fmt.Errorf("chain %s is not configured", chain)
+		}
+	case l_btc_chain:
+		if enabled && !services.liquidEnabled {
+			return fmt.Errorf("chain %s is not configured", chain)
+		}
+	default:
+		return fmt.Errorf("unknown chain %s", chain)
+	}
+
+	services.chainsMutex.Lock()
+	defer services.chainsMutex.Unlock()
+	if enabled {
+		delete(services.disabledChains, chain)
+	} else {
+		if services.disabledChains == nil {
+			services.disabledChains = map[string]struct{}{}
+		}
+		services.disabledChains[chain] = struct{}{}
+	}
+	services.logger.Infof("[SwapService] Set swaps on chain %s enabled: %v", chain, enabled)
+	return nil
+}
@@ -0,0 +1,251 @@
+package swap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assetWallet is a dummyChain with a fixed liquid asset.
+type assetWallet struct {
+	*dummyChain
+	asset string
+}
+
+func (a *assetWallet) GetAsset() string {
+	return a.asset
+}
+
+// networkWallet is a dummyChain on a fixed bitcoin network.
+type networkWallet struct {
+	*dummyChain
+	network string
+}
+
+func (n *networkWallet) GetNetwork() string {
+	return n.network
+}
+
+// getChainOptionsTestSetup returns two connected swap services that support
+// both chains with the same liquid asset.
+func getChainOptionsTestSetup(t *testing.T) (alice, bob *SwapService, aliceMsgChan, bobMsgChan chan messages.MessageType) {
+	// The asset id is validated as 33 bytes.
+	initiator, peer, asset, _, _ := getTestParams()
+
+	alice = getTestSetup(initiator)
+	bob = getTestSetup(peer)
+	for _, service := range []*SwapService{alice, bob} {
+		service.swapServices.liquidWallet = &assetWallet{dummyChain: service.swapServices.liquidWallet.(*dummyChain), asset: asset}
+	}
+
+	aliceMessenger := alice.swapServices.messenger.(*ConnectedMessenger)
+	bobMessenger := bob.swapServices.messenger.(*ConnectedMessenger)
+	aliceMessenger.other = bobMessenger
+	bobMessenger.other = aliceMessenger
+	aliceMessenger.msgReceivedChan = make(chan messages.MessageType, 10)
+	bobMessenger.msgReceivedChan = make(chan messages.MessageType, 10)
+
+	for _, service := range []*SwapService{alice, bob} {
+		require.NoError(t, service.Start())
+		service.swapServices.toService = &timeOutDummy{}
+	}
+	return alice, bob, aliceMessenger.msgReceivedChan, bobMessenger.msgReceivedChan
+}
+
+func Test_SwapOutChains(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		chains        []string
+		liquidEnabled bool
+		chain         string
+	}{
+		{
+			name:          "liquid preferred",
+			chains:        []string{l_btc_chain, btc_chain},
+			liquidEnabled: true,
+			chain:         l_btc_chain,
+		},
+		{
+			name:          "bitcoin preferred",
+			chains:        []string{btc_chain, l_btc_chain},
+			liquidEnabled: true,
+			chain:         btc_chain,
+		},
+		{
+			name:   "first mutually supported",
+			chains: []string{l_btc_chain, btc_chain},
+			chain:  btc_chain,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			alice, bob, aliceMsgChan, bobMsgChan := getChainOptionsTestSetup(t)
+			bob.swapServices.liquidEnabled = tc.liquidEnabled
+			peer := bob.swapServices.messenger.(*ConnectedMessenger).thisPeerId
+			initiator := alice.swapServices.messenger.(*ConnectedMessenger).thisPeerId
+
+			aliceSwap, err := alice.SwapOutChains(context.Background(), peer, tc.chains, "100x2x3", initiator, 100000)
+			require.NoError(t, err)
+			require.Len(t, aliceSwap.Data.SwapOutRequest.ChainOptions, 2)
+
+			assert.Equal(t, messages.MESSAGETYPE_SWAPOUTREQUEST, <-bobMsgChan)
+			assert.Equal(t, messages.MESSAGETYPE_SWAPOUTAGREEMENT, <-aliceMsgChan)
+
+			bobSwap, err := bob.GetSwap(aliceSwap.SwapId.String())
+			require.NoError(t, err)
+			assert.Equal(t, tc.chain, bobSwap.Data.GetChain())
+			assert.Equal(t, tc.chain, getChain(bobSwap.Data.SwapOutAgreement.Asset, bobSwap.Data.SwapOutAgreement.Network))
+			aliceSwap.mutex.Lock()
+			defer aliceSwap.mutex.Unlock()
+			assert.Equal(t, tc.chain, aliceSwap.Data.GetChain())
+		})
+	}
+}
+
+func Test_SwapOutChains_NoOverlap(t *testing.T) {
+	alice, bob, aliceMsgChan, bobMsgChan := getChainOptionsTestSetup(t)
+	bob.swapServices.liquidEnabled = false
+	bob.swapServices.bitcoinWallet = &networkWallet{dummyChain: bob.swapServices.bitcoinWallet.(*dummyChain), network: "testnet"}
+	peer := bob.swapServices.messenger.(*ConnectedMessenger).thisPeerId
+	initiator := alice.swapServices.messenger.(*ConnectedMessenger).thisPeerId
+
+	aliceSwap, err := alice.SwapOutChains(context.Background(), peer, []string{l_btc_chain, btc_chain}, "100x2x3", initiator, 100000)
+	require.NoError(t, err)
+
+	assert.Equal(t, messages.MESSAGETYPE_SWAPOUTREQUEST, <-bobMsgChan)
+	assert.Equal(t, messages.MESSAGETYPE_CANCELED, <-aliceMsgChan)
+
+	aliceSwap.mutex.Lock()
+	defer aliceSwap.mutex.Unlock()
+	assert.Equal(t, State_SwapCanceled, aliceSwap.Current)
+	assert.Equal(t, CancelReasonUnsupportedAsset, aliceSwap.Data.GetCancelReason())
+	_, err = bob.GetActiveSwap(aliceSwap.SwapId.String())
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+}
+
+func Test_SwapInChains(t *testing.T) {
+	alice, bob, aliceMsgChan, bobMsgChan := getChainOptionsTestSetup(t)
+	bob.swapServices.liquidEnabled = false
+	peer := bob.swapServices.messenger.(*ConnectedMessenger).thisPeerId
+	initiator := alice.swapServices.messenger.(*ConnectedMessenger).thisPeerId
+
+	aliceSwap, err := alice.SwapInChains(context.Background(), peer, []string{l_btc_chain, btc_chain}, "100x2x3", initiator, 100000)
+	require.NoError(t, err)
+
+	assert.Equal(t, messages.MESSAGETYPE_SWAPINREQUEST, <-bobMsgChan)
+	assert.Equal(t, messages.MESSAGETYPE_SWAPINAGREEMENT, <-aliceMsgChan)
+
+	bobSwap, err := bob.GetSwap(aliceSwap.SwapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, btc_chain, bobSwap.Data.GetChain())
+	aliceSwap.mutex.Lock()
+	defer aliceSwap.mutex.Unlock()
+	assert.Equal(t, btc_chain, aliceSwap.Data.GetChain())
+}
+
+func Test_SwapOutChains_SingleChain(t *testing.T) {
+	alice, bob, aliceMsgChan, bobMsgChan := getChainOptionsTestSetup(t)
+	peer := bob.swapServices.messenger.(*ConnectedMessenger).thisPeerId
+	initiator := alice.swapServices.messenger.(*ConnectedMessenger).thisPeerId
+
+	aliceSwap, err := alice.SwapOutChains(context.Background(), peer, []string{btc_chain}, "100x2x3", initiator, 100000)
+	require.NoError(t, err)
+	assert.Nil(t, aliceSwap.Data.SwapOutRequest.ChainOptions)
+
+	assert.Equal(t, messages.MESSAGETYPE_SWAPOUTREQUEST, <-bobMsgChan)
+	assert.Equal(t, messages.MESSAGETYPE_SWAPOUTAGREEMENT, <-aliceMsgChan)
+
+	bobSwap, err := bob.GetSwap(aliceSwap.SwapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, btc_chain, bobSwap.Data.GetChain())
+	assert.Empty(t, bobSwap.Data.SwapOutAgreement.Network)
+
+	_, err = alice.SwapOutChains(context.Background(), peer, []string{btc_chain, btc_chain}, "100x2x4", initiator, 100000)
+	assert.Error(t, err)
+	_, err = alice.SwapOutChains(context.Background(), peer, nil, "100x2x4", initiator, 100000)
+	assert.Error(t, err)
+}
+
+func Test_ValidateSelectedChain(t *testing.T) {
+	asset := getRandom32ByteHexString()
+	options := []ChainOption{{Asset: asset}, {Network: "mainnet"}}
+
+	assert.NoError(t, validateSelectedChain("", "", options))
+	assert.NoError(t, validateSelectedChain("", "mainnet", options))
+	assert.NoError(t, validateSelectedChain(asset, "", options))
+	assert.Error(t, validateSelectedChain("", "testnet", options))
+	assert.Error(t, validateSelectedChain("", "mainnet", nil))
+}
+
+func Test_SetChainEnabled(t *testing.T) {
+	initiator, peer, asset, _, _ := getTestParams()
+	_, otherPeer, pubkey, _, _ := getTestParams()
+
+	for _, chain := range []string{btc_chain, l_btc_chain} {
+		t.Run(chain, func(t *testing.T) {
+			service, messenger := getRecordingTestSetup(initiator)
+			service.swapServices.liquidWallet = &assetWallet{dummyChain: service.swapServices.liquidWallet.(*dummyChain), asset: asset}
+
+			active, err := service.SwapOut(peer, chain, "100x1x1", initiator, 100000)
+			require.NoError(t, err)
+
+			require.NoError(t, service.SetChainEnabled(chain, false))
+
+			// New swaps on the chain are neither started nor accepted.
+			_, err = service.SwapOut(peer, chain, "100x1x2", initiator, 100000)
+			assert.ErrorIs(t, err, ErrChainNotSupported)
+			_, err = service.SwapIn(peer, chain, "100x1x2", initiator, 100000)
+			assert.ErrorIs(t, err, ErrChainNotSupported)
+
+			request := &SwapOutRequestMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          NewSwapId(),
+				Scid:            "100x1x3",
+				Amount:          100000,
+				Pubkey:          pubkey,
+			}
+			if chain == l_btc_chain {
+				request.Asset = asset
+			} else {
+				request.Network = "mainnet"
+			}
+			err = service.OnSwapOutRequestReceived(request.SwapId, otherPeer, request)
+			assert.ErrorIs(t, err, ErrChainNotSupported)
+			msg := lastCancelMessage(t, messenger)
+			assert.Equal(t, request.SwapId, msg.SwapId)
+			assert.Equal(t, CancelReasonUnsupportedAsset, msg.Reason)
+
+			// Swaps on the other chain are not affected.
+			other := btc_chain
+			if chain == btc_chain {
+				other = l_btc_chain
+			}
+			_, err = service.SwapOut(peer, other, "100x1x4", initiator, 100000)
+			assert.NoError(t, err)
+
+			// The active swap on the chain continues.
+			require.NoError(t, service.OnSwapOutAgreementReceived(&SwapOutAgreementMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          active.SwapId,
+				Pubkey:          pubkey,
+				Payreq:          "fee",
+				Nonce:           active.Data.GetNonce(),
+			}))
+			assert.Equal(t, State_SwapOutSender_AwaitTxBroadcastedMessage, active.Current)
+
+			require.NoError(t, service.SetChainEnabled(chain, true))
+			_, err = service.SwapOut(peer, chain, "100x1x2", initiator, 100000)
+			assert.NoError(t, err)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		service := getTestSetup(initiator)
+		assert.Error(t, service.SetChainEnabled("doge", false))
+		service.swapServices.liquidEnabled = false
+		assert.Error(t, service.SetChainEnabled(l_btc_chain, true))
+		assert.NoError(t, service.SetChainEnabled(l_btc_chain, false))
+	})
+}
@@ -0,0 +1,36 @@
+package swap
+
+import "errors"
+
+// resolveCrossingSwap resolves a swap that we requested from the peer on the
+// channel while the peer requested the swap with swapId from us. Both sides
+// decide the same way: the swap with the lower swap id wins. If our swap
+// wins, CrossingSwapError is returned so that the request of the peer is
+// rejected. Otherwise our swap is canceled, so that the request of the peer
+// can take the channel. Swaps that already committed funds are not crossing
+// swaps, they are left to the channel check.
+func (s *SwapService) resolveCrossingSwap(swapId *SwapId, peerId string, channelId string) error {
+	for _, swap := range s.activeSwapsOnChannel(channelId) {
+		swap.mutex.Lock()
+		crossing := swap.Role == SWAPROLE_SENDER && swap.Data != nil && swap.Data.PeerNodeId == peerId &&
+			swap.EventIsValid(Event_OnOperatorCancel)
+		swap.mutex.Unlock()
+		if !crossing {
+			continue
+		}
+
+		if swap.SwapId.String() < swapId.String() {
+			swap.logger().Infof("[SwapService] Rejecting crossing swap %s of peer %s", swapId.String(), peerId)
+			return CrossingSwapError{ChannelId: channelId, SwapId: swap.SwapId.String()}
+		}
+
+		swap.logger().Infof("[SwapService] Canceling swap for crossing swap %s of peer %s", swapId.String(), peerId)
+		err := s.cancelSwap(swap.SwapId.String(), CancelReasonCrossingSwap, CrossingSwapError{ChannelId: channelId, SwapId: swapId.String()}.Error())
+		if err != nil && !errors.Is(err, ErrSwapDoesNotExist) {
+			// The channel check rejects the request if the swap
+			// is still active.
+			swap.logger().Warnf("[SwapService] Could not cancel swap for crossing swap: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package swap
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func Test_ResolveCrossingSwap(t *testing.T) {
+	initiator, peer, pubkey, _, channelId := getTestParams()
+	lowest, err := ParseSwapIdFromString(strings.Repeat("00", 32))
+	require.NoError(t, err)
+	highest, err := ParseSwapIdFromString(strings.Repeat("ff", 32))
+	require.NoError(t, err)
+
+	newService := func() (*SwapService, *recordingMessenger, *SwapStateMachine) {
+		service, messenger := getRecordingTestSetup(initiator)
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		return service, messenger, swap
+	}
+	request := func(service *SwapService, swapId *SwapId) error {
+		return service.OnSwapInRequestReceived(swapId, peer, &SwapInRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            channelId,
+			Amount:          100000,
+			Pubkey:          pubkey,
+		})
+	}
+
+	t.Run("our swap wins", func(t *testing.T) {
+		service, messenger, swap := newService()
+		err := request(service, highest)
+		assert.ErrorAs(t, err, &CrossingSwapError{})
+
+		msg := lastCancelMessage(t, messenger)
+		assert.Equal(t, highest, msg.SwapId)
+		assert.Equal(t, CancelReasonCrossingSwap, msg.Reason)
+		assert.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Current)
+		_, err = service.GetActiveSwap(highest.String())
+		assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+	})
+
+	t.Run("peer swap wins", func(t *testing.T) {
+		service, messenger, swap := newService()
+		require.NoError(t, request(service, lowest))
+
+		assert.Equal(t, State_SwapCanceled, swap.Current)
+		assert.Equal(t, CancelReasonCrossingSwap, swap.Data.GetCancelReason())
+		msg := lastCancelMessage(t, messenger)
+		assert.Equal(t, swap.SwapId, msg.SwapId)
+		assert.Equal(t, CancelReasonCrossingSwap, msg.Reason)
+		_, err = service.GetActiveSwap(lowest.String())
+		assert.NoError(t, err)
+	})
+
+	t.Run("other peer", func(t *testing.T) {
+		service, _, swap := newService()
+		_, otherPeer, _, _, _ := getTestParams()
+		err := service.OnSwapInRequestReceived(lowest, otherPeer, &SwapInRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          lowest,
+			Network:         "mainnet",
+			Scid:            channelId,
+			Amount:          100000,
+			Pubkey:          pubkey,
+		})
+		assert.ErrorAs(t, err, &ActiveSwapOnChannelError{})
+		assert.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Current)
+	})
+}
+
+func Test_CrossingSwaps(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+
+	// Both sides store swaps concurrently, which the bbolt store supports.
+	newService := func(name string) *SwapService {
+		db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		store, err := NewBboltStore(db)
+		require.NoError(t, err)
+
+		service := getTestSetup(name)
+		service.swapServices.swapStore = store
+		return service
+	}
+	alice := newService(initiator)
+	bob := newService(peer)
+	aliceMessenger := alice.swapServices.messenger.(*ConnectedMessenger)
+	bobMessenger := bob.swapServices.messenger.(*ConnectedMessenger)
+	aliceMessenger.other = bobMessenger
+	bobMessenger.other = aliceMessenger
+	aliceMessenger.msgReceivedChan = make(chan messages.MessageType, 20)
+	bobMessenger.msgReceivedChan = make(chan messages.MessageType, 20)
+	for _, service := range []*SwapService{alice, bob} {
+		require.NoError(t, service.Start())
+		service.swapServices.toService = &timeOutDummy{}
+	}
+
+	// Alice requests a swap out while Bob requests a swap in on the same
+	// channel.
+	var aliceSwap, bobSwap *SwapStateMachine
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		var err error
+		aliceSwap, err = alice.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		assert.NoError(t, err)
+	}()
+	go func() {
+		defer wg.Done()
+		var err error
+		bobSwap, err = bob.SwapIn(initiator, btc_chain, channelId, peer, 100000)
+		assert.NoError(t, err)
+	}()
+	wg.Wait()
+	require.NotNil(t, aliceSwap)
+	require.NotNil(t, bobSwap)
+
+	winner, loser := aliceSwap, bobSwap
+	winnerService, loserService := alice, bob
+	if bobSwap.SwapId.String() < aliceSwap.SwapId.String() {
+		winner, loser = bobSwap, aliceSwap
+		winnerService, loserService = bob, alice
+	}
+
+	// The swap with the lower swap id goes on, the other one is canceled on
+	// both sides.
+	assert.Eventually(t, func() bool {
+		_, err := winnerService.GetActiveSwap(loser.SwapId.String())
+		if err == nil {
+			return false
+		}
+		_, err = loserService.GetActiveSwap(loser.SwapId.String())
+		if err == nil {
+			return false
+		}
+		_, err = loserService.GetActiveSwap(winner.SwapId.String())
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	loser.mutex.Lock()
+	assert.Equal(t, State_SwapCanceled, loser.Current)
+	assert.Equal(t, CancelReasonCrossingSwap, loser.Data.GetCancelReason())
+	loser.mutex.Unlock()
+
+	winner.mutex.Lock()
+	assert.NotEqual(t, State_SwapCanceled, winner.Current)
+	winner.mutex.Unlock()
+}
@@ -0,0 +1,19 @@
+//go:build dev
+// +build dev
+
+package swap
+
+// forceConfirm reports the confirmation of the transaction of the swap as if
+// the txwatcher had seen it. It lets the integration tests advance a swap
+// past the confirmation wait without a chain and only exists in dev builds.
+func (s *SwapService) forceConfirm(swapId, txHex string) error {
+	s.swapServices.logger.Infof("[SwapService] Forcing the confirmation of the transaction of swap %s", swapId)
+	return s.OnTxConfirmed(swapId, txHex)
+}
+
+// forceCsvPassed reports that the csv of the opening transaction of the swap
+// passed as if the txwatcher had seen it. It only exists in dev builds.
+func (s *SwapService) forceCsvPassed(swapId string) error {
+	s.swapServices.logger.Infof("[SwapService] Forcing the passed csv of swap %s", swapId)
+	return s.OnCsvPassed(swapId)
+}
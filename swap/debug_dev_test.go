@@ -0,0 +1,70 @@
+//go:build dev
+// +build dev
+
+package swap
+
+import (
+	"testing"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startForcedSwapOut starts a swap-out between two services over the
+// in-memory transport and returns it once the opening transaction was
+// broadcasted.
+func startForcedSwapOut(t *testing.T) (alice, bob *SwapService, aliceSwap, bobSwap *SwapStateMachine) {
+	amount := uint64(100000)
+	initiator, peer, _, _, channelId := getTestParams()
+
+	transport := newMemoryTransport()
+	t.Cleanup(transport.close)
+
+	alice = getTestSetup(initiator)
+	bob = getTestSetup(peer)
+	aliceMessenger := transport.connect(initiator)
+	bobMessenger := transport.connect(peer)
+	alice.swapServices.messenger = aliceMessenger
+	bob.swapServices.messenger = bobMessenger
+
+	require.NoError(t, alice.Start())
+	require.NoError(t, bob.Start())
+	t.Cleanup(func() {
+		alice.Stop()
+		bob.Stop()
+	})
+
+	aliceSwap, err := alice.SwapOut(peer, btc_chain, channelId, initiator, amount)
+	require.NoError(t, err)
+	bobMessenger.awaitMessage(t, messages.MESSAGETYPE_SWAPOUTREQUEST)
+	bobSwap, err = bob.GetActiveSwap(aliceSwap.SwapId.String())
+	require.NoError(t, err)
+	aliceMessenger.awaitMessage(t, messages.MESSAGETYPE_SWAPOUTAGREEMENT)
+
+	bob.swapServices.lightning.(*dummyLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_FEE)
+	aliceMessenger.awaitMessage(t, messages.MESSAGETYPE_OPENINGTXBROADCASTED)
+	return alice, bob, aliceSwap, bobSwap
+}
+
+func Test_ForceConfirm(t *testing.T) {
+	alice, bob, aliceSwap, bobSwap := startForcedSwapOut(t)
+	assert.Equal(t, State_SwapOutSender_AwaitTxConfirmation, aliceSwap.Current)
+
+	require.NoError(t, alice.forceConfirm(aliceSwap.SwapId.String(), aliceSwap.Data.OpeningTxHex))
+	assert.Equal(t, State_ClaimedPreimage, aliceSwap.Current)
+
+	bob.swapServices.lightning.(*dummyLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_CLAIM)
+	assert.Equal(t, State_ClaimedPreimage, bobSwap.Current)
+}
+
+func Test_ForceCsvPassed(t *testing.T) {
+	_, bob, _, bobSwap := startForcedSwapOut(t)
+	assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, bobSwap.Current)
+
+	require.NoError(t, bob.forceCsvPassed(bobSwap.SwapId.String()))
+	assert.Equal(t, State_ClaimedCsv, bobSwap.Current)
+
+	_, err := bob.GetActiveSwap(bobSwap.SwapId.String())
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+}
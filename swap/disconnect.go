@@ -0,0 +1,83 @@
+package swap
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetDisconnectGracePeriod sets how long the swaps with a peer that
+// disconnected are kept before they are canceled. If the peer reconnects
+// within the grace period the swaps continue, otherwise the swaps that did
+// not commit any funds yet are canceled. Swaps that committed funds are left
+// to their timeouts. A grace period of 0 keeps the swaps until their state
+// times out, which is the default.
+func (s *SwapServices) SetDisconnectGracePeriod(period time.Duration) error {
+	if period < 0 {
+		return fmt.Errorf("disconnect grace period must not be negative, got %v", period)
+	}
+	s.disconnectGracePeriod = period
+	return nil
+}
+
+// OnPeerDisconnected starts the grace period of the peer. It is called by the
+// messenger when the connection to the peer is lost.
+func (s *SwapService) OnPeerDisconnected(peerId string) {
+	period := s.swapServices.disconnectGracePeriod
+	if period == 0 {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.stopped {
+		return
+	}
+	if _, ok := s.disconnectTimers[peerId]; ok {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(period, func() {
+		s.Lock()
+		if s.disconnectTimers[peerId] != timer {
+			// The peer reconnected in the meantime.
+			s.Unlock()
+			return
+		}
+		delete(s.disconnectTimers, peerId)
+		s.Unlock()
+		s.cancelDisconnectedSwaps(peerId, period)
+	})
+	s.disconnectTimers[peerId] = timer
+}
+
+// stopDisconnectTimer stops the grace period of a peer that reconnected.
+func (s *SwapService) stopDisconnectTimer(peerId string) {
+	s.Lock()
+	defer s.Unlock()
+	if timer, ok := s.disconnectTimers[peerId]; ok {
+		timer.Stop()
+		delete(s.disconnectTimers, peerId)
+	}
+}
+
+// cancelDisconnectedSwaps cancels the active swaps with the peer that did not
+// commit any funds yet.
+func (s *SwapService) cancelDisconnectedSwaps(peerId string, period time.Duration) {
+	for _, swap := range s.GetActiveSwaps() {
+		swap.mutex.Lock()
+		skip := swap.Data == nil || swap.Data.PeerNodeId != peerId ||
+			swap.Data.OpeningTxHex != "" || swap.Data.GetOpeningTxId() != ""
+		swap.mutex.Unlock()
+		if skip {
+			continue
+		}
+
+		swapId := swap.SwapId.String()
+		reason := fmt.Sprintf("peer did not reconnect within %v", period)
+		if err := s.cancelSwap(swapId, CancelReasonTimeout, reason); err != nil {
+			s.swapServices.logger.Infof("[SwapService] Could not cancel swap %s after the peer disconnected: %v", swapId, err)
+			continue
+		}
+		s.swapServices.logger.Infof("[SwapService] Canceled swap %s: %s", swapId, reason)
+	}
+}
@@ -0,0 +1,62 @@
+package swap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DisconnectGracePeriod(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+
+	newService := func(t *testing.T) (*SwapService, *recordingMessenger, *SwapStateMachine) {
+		service, messenger := getRecordingTestSetup(initiator)
+		require.NoError(t, service.swapServices.SetDisconnectGracePeriod(20*time.Millisecond))
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		require.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Current)
+		return service, messenger, swap
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.Error(t, getTestSetup(initiator).swapServices.SetDisconnectGracePeriod(-time.Second))
+	})
+
+	t.Run("reconnect within", func(t *testing.T) {
+		service, _, swap := newService(t)
+		service.OnPeerDisconnected(peer)
+		service.OnPeerConnected(peer)
+
+		time.Sleep(50 * time.Millisecond)
+		_, err := service.GetActiveSwap(swap.SwapId.String())
+		assert.NoError(t, err)
+		assert.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Current)
+	})
+
+	t.Run("timeout exceeds", func(t *testing.T) {
+		service, messenger, swap := newService(t)
+		service.OnPeerDisconnected(peer)
+
+		assert.Eventually(t, func() bool {
+			_, err := service.GetActiveSwap(swap.SwapId.String())
+			return err != nil
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, State_SwapCanceled, swap.Current)
+		msg := lastCancelMessage(t, messenger)
+		assert.Equal(t, CancelReasonTimeout, msg.Reason)
+	})
+
+	t.Run("committed swap is kept", func(t *testing.T) {
+		service, _, swap := newService(t)
+		swap.mutex.Lock()
+		swap.Data.OpeningTxHex = "txhex"
+		swap.mutex.Unlock()
+		service.OnPeerDisconnected(peer)
+
+		time.Sleep(50 * time.Millisecond)
+		_, err := service.GetActiveSwap(swap.SwapId.String())
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,122 @@
+package swap
+
+import "sync"
+
+// swapEventBufferSize is the number of events that are buffered for a
+// subscriber before new events are dropped.
+const swapEventBufferSize = 64
+
+type SwapEventKind string
+
+const (
+	// SwapEventAdded is published when a swap is added to the active swaps.
+	SwapEventAdded SwapEventKind = "added"
+	// SwapEventTransition is published when a swap transitions to a new state.
+	SwapEventTransition SwapEventKind = "transition"
+	// SwapEventRemoved is published when a swap is removed from the active
+	// swaps.
+	SwapEventRemoved SwapEventKind = "removed"
+	// SwapEventPeerUnresponsive is published when the swap partner did not
+	// answer a keepalive ping within the keepalive window.
+	SwapEventPeerUnresponsive SwapEventKind = "peer_unresponsive"
+	// SwapEventPeerResponsive is published when an unresponsive swap partner
+	// answers a keepalive ping again.
+	SwapEventPeerResponsive SwapEventKind = "peer_responsive"
+	// SwapEventOpeningTxSeen is published when the opening transaction of a
+	// swap is first seen unconfirmed in the mempool.
+	SwapEventOpeningTxSeen SwapEventKind = "opening_tx_seen"
+	// SwapEventSwapExpired is published when a swap that already committed
+	// funds exceeds the max swap age.
+	SwapEventSwapExpired SwapEventKind = "swap_expired"
+	// SwapEventOpeningTxReorged is published when the confirmation of the
+	// opening transaction of a swap was reorged out of the chain.
+	SwapEventOpeningTxReorged SwapEventKind = "opening_tx_reorged"
+	// SwapEventCircuitBreakerOpened is published when the circuit breaker
+	// opens and new swaps are no longer accepted. It is not tied to a swap.
+	SwapEventCircuitBreakerOpened SwapEventKind = "circuit_breaker_opened"
+	// SwapEventCircuitBreakerClosed is published when the circuit breaker
+	// closes and new swaps are accepted again. It is not tied to a swap.
+	SwapEventCircuitBreakerClosed SwapEventKind = "circuit_breaker_closed"
+)
+
+// SwapEvent describes a change in the lifecycle of a swap.
+type SwapEvent struct {
+	Kind       SwapEventKind
+	SwapId     string
+	OldState   StateType
+	NewState   StateType
+	Type       SwapType
+	Role       SwapRole
+	PeerNodeId string
+}
+
+// newSwapEvent returns a SwapEvent for the swap.
+func newSwapEvent(kind SwapEventKind, swap *SwapStateMachine, oldState, newState StateType) SwapEvent {
+	var peerNodeId string
+	if swap.Data != nil {
+		peerNodeId = swap.Data.PeerNodeId
+	}
+	return SwapEvent{
+		Kind:       kind,
+		SwapId:     swap.SwapId.String(),
+		OldState:   oldState,
+		NewState:   newState,
+		Type:       swap.Type,
+		Role:       swap.Role,
+		PeerNodeId: peerNodeId,
+	}
+}
+
+// swapEventBroker distributes swap events to its subscribers.
+type swapEventBroker struct {
+	sync.Mutex
+	nextId      int
+	subscribers map[int]chan SwapEvent
+}
+
+func newSwapEventBroker() *swapEventBroker {
+	return &swapEventBroker{
+		subscribers: map[int]chan SwapEvent{},
+	}
+}
+
+// subscribe registers a new subscriber and returns its channel and a function
+// that unsubscribes and closes the channel.
+func (b *swapEventBroker) subscribe() (<-chan SwapEvent, func()) {
+	b.Lock()
+	defer b.Unlock()
+	id := b.nextId
+	b.nextId++
+	ch := make(chan SwapEvent, swapEventBufferSize)
+	b.subscribers[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.Lock()
+			defer b.Unlock()
+			delete(b.subscribers, id)
+			close(ch)
+		})
+	}
+}
+
+// publish sends the event to all subscribers. The event is dropped for a
+// subscriber whose buffer is full so that a slow consumer never blocks the
+// swap processing.
+func (b *swapEventBroker) publish(logger Logger, event SwapEvent) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	for id, ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			if logger != nil {
+				logger.Warnf("[SwapService] Dropped %s event of swap %s for slow subscriber %d", event.Kind, event.SwapId, id)
+			}
+		}
+	}
+}
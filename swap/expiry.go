@@ -0,0 +1,100 @@
+package swap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SetMaxSwapAge enables the expiry of active swaps that are older than
+// maxAge, checked every interval. The age of a swap is independent of the
+// state timeouts, it is counted from the creation of the swap. An expired
+// swap that did not commit any funds yet is canceled, an expired swap that
+// committed funds is reported once with a SwapEventSwapExpired event. An
+// interval of 0 disables the expiry, which is the default.
+func (s *SwapServices) SetMaxSwapAge(maxAge, interval time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("swap age check interval must not be negative, got %v", interval)
+	}
+	if interval > 0 && maxAge <= 0 {
+		return fmt.Errorf("max swap age must be positive, got %v", maxAge)
+	}
+	s.maxSwapAge = maxAge
+	s.swapAgeCheckInterval = interval
+	return nil
+}
+
+// runSwapExpiry expires old swaps every interval until the service is
+// stopped.
+func (s *SwapService) runSwapExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.isStopped() {
+			return
+		}
+		s.ExpireOldSwaps()
+	}
+}
+
+// ExpireOldSwaps cancels the active swaps that are older than the max swap
+// age and did not commit any funds yet. The older swaps that committed funds
+// can not be canceled, they are reported once with a SwapEventSwapExpired
+// event so that the node operator can look into them.
+func (s *SwapService) ExpireOldSwaps() {
+	if s.isStopped() || s.swapServices.maxSwapAge <= 0 {
+		return
+	}
+	now := time.Now()
+	swaps := s.GetActiveSwaps()
+
+	var expired []*SwapStateMachine
+	s.Lock()
+	active := make(map[string]struct{}, len(swaps))
+	for _, swap := range swaps {
+		swapId := swap.SwapId.String()
+		active[swapId] = struct{}{}
+		if now.Sub(time.Unix(swap.Data.CreatedAt, 0)) > s.swapServices.maxSwapAge {
+			expired = append(expired, swap)
+		}
+	}
+	// Forget the swaps that are no longer active.
+	for swapId := range s.expiredSwaps {
+		if _, ok := active[swapId]; !ok {
+			delete(s.expiredSwaps, swapId)
+		}
+	}
+	s.Unlock()
+
+	for _, swap := range expired {
+		s.expireSwap(swap)
+	}
+}
+
+// expireSwap cancels the expired swap if it did not commit any funds yet, and
+// reports it otherwise.
+func (s *SwapService) expireSwap(swap *SwapStateMachine) {
+	swapId := swap.SwapId.String()
+	if swap.EventIsValid(Event_OnOperatorCancel) {
+		err := s.cancelSwap(swapId, CancelReasonExpired, fmt.Sprintf("swap exceeded the max swap age of %v", s.swapServices.maxSwapAge))
+		if err == nil || errors.Is(err, ErrSwapDoesNotExist) {
+			return
+		}
+		if !errors.Is(err, ErrSwapNotCancelable) {
+			swap.logger().Warnf("[SwapService] Could not cancel expired swap: %v", err)
+			return
+		}
+		// The swap committed funds in the meantime.
+	}
+
+	s.Lock()
+	_, reported := s.expiredSwaps[swapId]
+	s.expiredSwaps[swapId] = struct{}{}
+	s.Unlock()
+	if reported {
+		return
+	}
+
+	swap.logger().Warnf("[SwapService] Swap exceeded the max swap age of %v in state %s", s.swapServices.maxSwapAge, swap.Current)
+	s.swapServices.publishSwapEvent(newSwapEvent(SwapEventSwapExpired, swap, swap.Current, swap.Current))
+}
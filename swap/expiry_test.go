@@ -0,0 +1,84 @@
+package swap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expiredEvents returns the ids of the swaps that were reported as expired so
+// far.
+func expiredEvents(events <-chan SwapEvent) []string {
+	var swapIds []string
+	for _, event := range drainEvents(events, func(event SwapEvent) bool {
+		return event.Kind == SwapEventSwapExpired
+	}) {
+		swapIds = append(swapIds, event.SwapId)
+	}
+	return swapIds
+}
+
+func Test_ExpireOldSwaps(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	service, messenger := getRecordingTestSetup(initiator)
+	assert.Error(t, service.swapServices.SetMaxSwapAge(time.Hour, -time.Second))
+	assert.Error(t, service.swapServices.SetMaxSwapAge(0, time.Second))
+	require.NoError(t, service.swapServices.SetMaxSwapAge(time.Hour, 0))
+
+	events, unsubscribe := service.Subscribe()
+	defer unsubscribe()
+
+	young, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+
+	old, err := service.SwapOut(peer, btc_chain, "100x2x4", initiator, 100000)
+	require.NoError(t, err)
+	old.Data.CreatedAt = time.Now().Add(-2 * time.Hour).Unix()
+
+	// The opening transaction of the committed swap awaits its confirmation.
+	committed := newSwapOutSenderFSM(service.swapServices, initiator, peer)
+	committed.Current = State_SwapOutSender_AwaitTxConfirmation
+	committed.Data.CreatedAt = time.Now().Add(-2 * time.Hour).Unix()
+	service.AddActiveSwap(committed.SwapId.String(), committed)
+
+	sent := len(messenger.sent)
+	service.ExpireOldSwaps()
+
+	// The young swap is untouched.
+	assert.Equal(t, State_SwapOutSender_AwaitAgreement, young.Current)
+	_, err = service.GetActiveSwap(young.SwapId.String())
+	assert.NoError(t, err)
+
+	// The old swap is canceled and the peer is told.
+	assert.Equal(t, State_SwapCanceled, old.Current)
+	assert.Contains(t, old.Data.GetCancelMessage(), "max swap age")
+	_, err = service.GetActiveSwap(old.SwapId.String())
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+	require.Len(t, messenger.sent, sent+1)
+	assert.Equal(t, int(messages.MESSAGETYPE_CANCELED), messenger.sent[sent].msgType)
+
+	// The committed swap is reported, but not canceled.
+	assert.Equal(t, []string{committed.SwapId.String()}, expiredEvents(events))
+	assert.Equal(t, State_SwapOutSender_AwaitTxConfirmation, committed.Current)
+	_, err = service.GetActiveSwap(committed.SwapId.String())
+	assert.NoError(t, err)
+
+	// The committed swap is only reported once.
+	service.ExpireOldSwaps()
+	assert.Empty(t, expiredEvents(events))
+}
+
+func Test_ExpireOldSwaps_Disabled(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	service, _ := getRecordingTestSetup(initiator)
+
+	swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	swap.Data.CreatedAt = time.Now().Add(-24 * 365 * time.Hour).Unix()
+
+	service.ExpireOldSwaps()
+	assert.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Current)
+}
@@ -0,0 +1,83 @@
+package swap
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ExportedSwap is the exported record of a stored swap. It does not contain
+// any secrets of the swap like the private key or the preimages.
+type ExportedSwap struct {
+	SwapId          string    `json:"swap_id"`
+	Type            string    `json:"type"`
+	Role            string    `json:"role"`
+	State           StateType `json:"state"`
+	PreviousState   StateType `json:"previous_state"`
+	Chain           string    `json:"chain"`
+	ChannelId       string    `json:"channel_id"`
+	PeerNodeId      string    `json:"peer_node_id"`
+	InitiatorNodeId string    `json:"initiator_node_id"`
+	AmountSat       uint64    `json:"amount_sat"`
+	PremiumSat      uint64    `json:"premium_sat"`
+	OpeningTxFeeSat uint64    `json:"opening_tx_fee_sat"`
+	OpeningTxId     string    `json:"opening_tx_id"`
+	ClaimTxId       string    `json:"claim_tx_id"`
+	CancelMessage   string    `json:"cancel_message"`
+	CancelReason    string    `json:"cancel_reason"`
+	CancelCategory  string    `json:"cancel_category"`
+	CreatedAt       int64     `json:"created_at"`
+	UpdatedAt       int64     `json:"updated_at"`
+}
+
+func newExportedSwap(swap *SwapStateMachine) *ExportedSwap {
+	exported := &ExportedSwap{
+		SwapId:        swap.SwapId.String(),
+		Type:          swap.Type.String(),
+		Role:          swap.Role.String(),
+		State:         swap.Current,
+		PreviousState: swap.Previous,
+	}
+	if swap.Data != nil {
+		exported.Chain = swap.Data.GetChain()
+		exported.ChannelId = swap.Data.GetScid()
+		exported.PeerNodeId = swap.Data.PeerNodeId
+		exported.InitiatorNodeId = swap.Data.InitiatorNodeId
+		exported.AmountSat = swap.Data.GetAmount()
+		exported.PremiumSat = swap.Data.GetPremium()
+		exported.OpeningTxFeeSat = swap.Data.OpeningTxFee
+		exported.OpeningTxId = swap.Data.GetOpeningTxId()
+		exported.ClaimTxId = swap.Data.ClaimTxId
+		exported.CancelMessage = swap.Data.GetCancelMessage()
+		exported.CancelReason = string(swap.Data.GetCancelReason())
+		exported.CancelCategory = string(swap.Data.GetCancelCategory())
+		exported.CreatedAt = swap.Data.CreatedAt
+		exported.UpdatedAt = swap.Data.UpdatedAt
+	}
+	return exported
+}
+
+// ExportSwaps writes all stored swaps to w as newline-delimited JSON, one
+// ExportedSwap per line. If the store can iterate the swaps, every swap is
+// written as soon as it is read, so that the swaps are not all loaded into
+// memory.
+func (s *SwapService) ExportSwaps(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encode := func(swap *SwapStateMachine) error {
+		return encoder.Encode(newExportedSwap(swap))
+	}
+
+	if store, ok := s.swapServices.swapStore.(IteratingStore); ok {
+		return store.ForEach(encode)
+	}
+
+	swaps, err := s.swapServices.swapStore.ListAll()
+	if err != nil {
+		return err
+	}
+	for _, swap := range swaps {
+		if err := encode(swap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,8 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
-
-	"github.com/elementsproject/peerswap/log"
+	"time"
 )
 
 // ErrEventRejected is the error returned when the state machine cannot process
@@ -61,6 +60,46 @@ type Store interface {
 	ListAllByPeer(peer string) ([]*SwapStateMachine, error)
 }
 
+// FilteringStore is implemented by stores that filter the swaps while they
+// are read, so that swaps that do not match are not kept in memory.
+type FilteringStore interface {
+	ListWhere(predicate func(swap *SwapStateMachine) bool) ([]*SwapStateMachine, error)
+}
+
+// IteratingStore is implemented by stores that hand out the swaps one by one
+// while they are read, so that the swaps are not all kept in memory. An error
+// returned by fn stops the iteration and is returned.
+type IteratingStore interface {
+	ForEach(fn func(swap *SwapStateMachine) error) error
+}
+
+// DeletingStore is implemented by stores that can delete swaps.
+type DeletingStore interface {
+	DeleteById(id string) error
+}
+
+// ProcessedPaymentStore is implemented by stores that record the invoice
+// payments that were processed, so that a payment that is reported again is
+// not processed twice, also after a restart.
+type ProcessedPaymentStore interface {
+	IsPaymentProcessed(paymentId string) (bool, error)
+	SetPaymentProcessed(paymentId string) error
+}
+
+// FailedSwapCostStore is implemented by stores that keep the on-chain cost of
+// the failed swaps apart from the swaps, so that the cost is not lost when
+// the swaps are pruned.
+type FailedSwapCostStore interface {
+	SetFailedSwapCost(swapId string, cost *FailedSwapCost) error
+	ListFailedSwapCosts() (map[string]*FailedSwapCost, error)
+}
+
+// FailedSwapCost is the on-chain cost of a failed swap with a peer.
+type FailedSwapCost struct {
+	PeerNodeId string `json:"peer_node_id"`
+	CostSat    uint64 `json:"cost_sat"`
+}
+
 // States represents a mapping of states and their implementations.
 type States map[StateType]State
 
@@ -96,6 +135,15 @@ type SwapStateMachine struct {
 	retries int
 
 	failures int
+
+	// lightningUnavailableSince is the time the lightning client was first
+	// found unavailable by the actions of the swap.
+	lightningUnavailableSince time.Time
+
+	// persistedAt is the time the swap was last stored, dirty is set if a
+	// write of the swap was deferred to the end of the persist window.
+	persistedAt time.Time
+	dirty       bool
 }
 
 // getNextState returns the next state for the event given the machine's current
@@ -132,7 +180,41 @@ func (s *SwapStateMachine) EventIsValid(event EventType) bool {
 func (s *SwapStateMachine) SendEvent(event EventType, eventCtx EventContext) (bool, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	return s.sendEvent(event, eventCtx)
+}
+
+// sendDeferredEvent sends the event again that was deferred in the state,
+// with the event context it was sent with. ErrEventRejected is returned if the
+// swap left the state in the meantime.
+func (s *SwapStateMachine) sendDeferredEvent(state StateType, event EventType, eventCtx EventContext) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.Current != state {
+		return false, ErrEventRejected
+	}
+	if eventCtx != nil {
+		eventCtx = &deferredEventContext{eventCtx}
+	}
+	return s.sendEvent(event, eventCtx)
+}
+
+// deferredEventContext is the context of a deferred event that is sent again.
+// The context was already applied to the swap data when the event was
+// deferred, the data it applied is kept.
+type deferredEventContext struct {
+	EventContext
+}
+
+func (d *deferredEventContext) ApplyToSwapData(data *SwapData) error {
+	err := d.EventContext.ApplyToSwapData(data)
+	if errors.Is(err, AlreadyExistsError) {
+		return nil
+	}
+	return err
+}
 
+// sendEvent sends an event to the state machine, the mutex must be held.
+func (s *SwapStateMachine) sendEvent(event EventType, eventCtx EventContext) (bool, error) {
 	if event == Event_Done {
 		return true, nil
 	}
@@ -142,11 +224,17 @@ func (s *SwapStateMachine) SendEvent(event EventType, eventCtx EventContext) (bo
 	if eventCtx != nil {
 		err = eventCtx.Validate(s.Data)
 		if err != nil {
+			// Relock before the deferred unlock, also if the event
+			// panics.
 			s.mutex.Unlock()
-			log.Infof("Message validation error: %v on msg %v", err, eventCtx)
-			res, err := s.SendEvent(Event_OnInvalid_Message, nil)
-			s.mutex.Lock()
-			return res, err
+			defer s.mutex.Lock()
+			s.logger().Infof("Message validation error: %v on msg %v", err, eventCtx)
+			if s.Data.CancelMessage == "" {
+				s.Data.CancelReason = CancelReasonInvalidMessage
+				s.Data.CancelCategory = CancelCategoryInvalidMessage
+				s.Data.CancelMessage = fmt.Sprintf("invalid message: %v", err)
+			}
+			return s.SendEvent(Event_OnInvalid_Message, nil)
 		}
 		err = eventCtx.ApplyToSwapData(s.Data)
 		if err != nil {
@@ -157,14 +245,18 @@ func (s *SwapStateMachine) SendEvent(event EventType, eventCtx EventContext) (bo
 		}
 	}
 
-	err = s.swapServices.swapStore.UpdateData(s)
+	s.Data.flush = s.flush
+	err = s.persist()
 	if err != nil {
 		return false, err
 	}
 
+	// The event context only belongs to the event that was sent, not to
+	// the events returned by the actions.
+	deferredCtx := eventCtx
 	for {
 		// Determine the next state for the event given the machine's current state.
-		log.Debugf("[FSM] event:id: %s, %s on %s", s.SwapId.String(), event, s.Current)
+		s.logger().Debugf("[FSM] event %s on %s", event, s.Current)
 		nextState, err := s.getNextState(event)
 		if err != nil {
 			return false, ErrEventRejected
@@ -178,9 +270,14 @@ func (s *SwapStateMachine) SendEvent(event EventType, eventCtx EventContext) (bo
 		}
 
 		// Transition over to the next state.
+		from, previous, cancelMessage := s.Current, s.Previous, s.Data.CancelMessage
+		updatedAt, transitions := s.Data.UpdatedAt, s.Data.Transitions
 		s.Previous = s.Current
 		s.Current = nextState
 		s.Data.SetState(s.Current)
+		s.Data.UpdatedAt = time.Now().Unix()
+		s.recordTransition(event)
+		s.swapServices.startStateTimeout(s.Data)
 
 		// Print Swap information
 		s.logSwapInfo()
@@ -188,13 +285,35 @@ func (s *SwapStateMachine) SendEvent(event EventType, eventCtx EventContext) (bo
 		// Execute the next state's action and loop over again if the event returned
 		// is not a no-op.
 		nextEvent := state.Action.Execute(s.swapServices, s.Data)
-		err = s.swapServices.swapStore.UpdateData(s)
+		if nextEvent == Event_ActionFailed && s.deferLightningUnavailable() {
+			// Roll back to the state before the event, so that the
+			// event can be sent again once the lightning client is
+			// available.
+			s.Current, s.Previous = from, previous
+			s.Data.SetState(s.Current)
+			s.Data.CancelMessage = cancelMessage
+			s.Data.UpdatedAt, s.Data.Transitions = updatedAt, transitions
+			s.swapServices.startStateTimeout(s.Data)
+			if err := s.write(); err != nil {
+				return false, err
+			}
+			return false, &eventDeferredError{State: from, Event: event, EventCtx: deferredCtx, Err: s.Data.LastErr}
+		}
+		s.lightningUnavailableSince = time.Time{}
+		deferredCtx = nil
+		// The transition is only published once it is not rolled back.
+		s.swapServices.publishSwapEvent(newSwapEvent(SwapEventTransition, s, s.Previous, s.Current))
+
+		err = s.persist()
 		if err != nil {
 			return false, err
 		}
 
 		switch nextEvent {
 		case Event_Done:
+			if err := s.flush(); err != nil {
+				return false, err
+			}
 			return true, nil
 		case NoOp:
 			return false, nil
@@ -206,7 +325,7 @@ func (s *SwapStateMachine) SendEvent(event EventType, eventCtx EventContext) (bo
 			}
 		case Event_ActionFailed:
 			if s.Data.LastErr != nil {
-				log.Infof("[FSM] Action failure %v", s.Data.LastErr)
+				s.logger().Infof("[FSM] Action failure %v", s.Data.LastErr)
 			}
 		}
 
@@ -214,9 +333,65 @@ func (s *SwapStateMachine) SendEvent(event EventType, eventCtx EventContext) (bo
 	}
 }
 
+// deferLightningUnavailable returns true if the last action failed because
+// the lightning client is unavailable and the retry window is not used up.
+func (s *SwapStateMachine) deferLightningUnavailable() bool {
+	window := s.swapServices.lightningRetryWindow
+	if window == 0 || !s.swapServices.isLightningUnavailable(s.Data.LastErr) {
+		return false
+	}
+	if s.lightningUnavailableSince.IsZero() {
+		s.lightningUnavailableSince = time.Now()
+	}
+	return time.Since(s.lightningUnavailableSince) < window
+}
+
+// snapshot returns a copy of the swap that can be read without holding the
+// lock of the swap. The mutex must be held.
+func (s *SwapStateMachine) snapshot() *SwapStateMachine {
+	snapshot := &SwapStateMachine{
+		SwapId:       s.SwapId,
+		Type:         s.Type,
+		Role:         s.Role,
+		Previous:     s.Previous,
+		Current:      s.Current,
+		States:       s.States,
+		swapServices: s.swapServices,
+	}
+	if s.Data != nil {
+		data := *s.Data
+		data.Transitions = append([]StateTransition(nil), s.Data.Transitions...)
+		data.toCancel = nil
+		data.flush = nil
+		snapshot.Data = &data
+	}
+	return snapshot
+}
+
+// recordTransition adds the last transition to the history of the swap. The
+// time spent in the state that was left is derived from the timestamp of the
+// transition that entered it, so it is only observed if that transition is
+// still in the history.
+func (s *SwapStateMachine) recordTransition(event EventType) {
+	transition := StateTransition{
+		Event: event,
+		From:  s.Previous,
+		To:    s.Current,
+		Time:  s.swapServices.now(),
+	}
+	if event == Event_ActionFailed && s.Data.LastErr != nil {
+		transition.Err = s.Data.LastErr.Error()
+	}
+	if n := len(s.Data.Transitions); n > 0 && s.Data.Transitions[n-1].To == transition.From {
+		entered := s.Data.Transitions[n-1].Time
+		s.swapServices.metrics.stateLeft(s, transition.From, transition.Time.Sub(entered))
+	}
+	s.Data.addTransition(transition, s.swapServices.maxTransitions)
+}
+
 // Recover tries to continue from the current state, by doing the associated Action
 func (s *SwapStateMachine) Recover() (bool, error) {
-	log.Infof("[Swap:%s]: Recovering from state %s", s.SwapId.String(), s.Current)
+	s.logger().Infof("Recovering from state %s", s.Current)
 	state, ok := s.States[s.Current]
 	if !ok {
 		return false, fmt.Errorf("unknown state: %s for swap %s", s.Current, s.SwapId.String())
@@ -230,8 +405,12 @@ func (s *SwapStateMachine) Recover() (bool, error) {
 		return s.SendEvent(Event_ActionFailed, nil)
 	}
 
+	// Restart the timeout of the state as pending timeouts are lost on
+	// shutdown.
+	s.swapServices.startStateTimeout(s.Data)
+
 	nextEvent := state.Action.Execute(s.swapServices, s.Data)
-	err := s.swapServices.swapStore.UpdateData(s)
+	err := s.write()
 	if err != nil {
 		return false, err
 	}
@@ -241,7 +420,8 @@ func (s *SwapStateMachine) Recover() (bool, error) {
 	return s.SendEvent(nextEvent, nil)
 }
 
-// IsFinished returns true if the swap is already finished
+// IsFinished returns true if the swap is already finished, i.e. it is in one
+// of the terminal states.
 func (s *SwapStateMachine) IsFinished() bool {
 	switch s.Current {
 	case State_ClaimedCsv:
@@ -252,6 +432,8 @@ func (s *SwapStateMachine) IsFinished() bool {
 		return true
 	case State_ClaimedCoop:
 		return true
+	case State_SwapAbandoned:
+		return true
 	}
 	return false
 }
@@ -325,6 +507,15 @@ func (s *SwapStateMachine) printFeeInvoiceInfo() {
 }
 
 func (s *SwapStateMachine) Infof(format string, v ...interface{}) {
-	idString := fmt.Sprintf("%s", s.SwapId.String())
-	log.Infof("[Swap:"+idString+"] "+format, v...)
+	s.logger().Infof(format, v...)
+}
+
+// logger returns the logger of the swap services that prefixes the log lines
+// with the swap id.
+func (s *SwapStateMachine) logger() Logger {
+	var logger Logger
+	if s.swapServices != nil {
+		logger = s.swapServices.logger
+	}
+	return newSwapLogger(logger, s.SwapId.String())
 }
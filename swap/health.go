@@ -0,0 +1,148 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HealthChecker is implemented by dependencies of the swap service that can
+// check that they are reachable and working, e.g. that a store is writable.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// DependencyError is the error of the health check of a dependency.
+type DependencyError struct {
+	Dependency string
+	Err        error
+}
+
+func (e DependencyError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Dependency, e.Err)
+}
+
+func (e DependencyError) Unwrap() error {
+	return e.Err
+}
+
+// HealthError lists the dependencies of the swap service that are down.
+type HealthError struct {
+	Errors []DependencyError
+}
+
+func (e *HealthError) Error() string {
+	var failures []string
+	for _, err := range e.Errors {
+		failures = append(failures, err.Error())
+	}
+	return fmt.Sprintf("swap service is unhealthy: %s", strings.Join(failures, "; "))
+}
+
+// Is reports whether the error of any dependency matches the target.
+func (e *HealthError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// healthProbe checks a single dependency of the swap service.
+type healthProbe struct {
+	dependency string
+	check      func() error
+}
+
+// HealthCheck checks that the swap service is started and its circuit breaker
+// is closed, and probes the wallets of the enabled chains, the store and the
+// messenger. The probes run concurrently, a probe that does not return before
+// the context is done fails with the context error. A *HealthError that lists
+// every dependency that is down is returned.
+func (s *SwapService) HealthCheck(ctx context.Context) error {
+	healthErr := &HealthError{}
+
+	s.RLock()
+	started, stopped := s.started, s.stopped
+	s.RUnlock()
+	if stopped {
+		healthErr.Errors = append(healthErr.Errors, DependencyError{Dependency: "service", Err: ErrServiceStopped})
+	} else if !started {
+		healthErr.Errors = append(healthErr.Errors, DependencyError{Dependency: "service", Err: errors.New("callbacks are not registered, service is not started")})
+	}
+
+	if err := s.checkCircuitBreaker(); err != nil {
+		healthErr.Errors = append(healthErr.Errors, DependencyError{Dependency: "circuit breaker", Err: err})
+	}
+
+	var probes []healthProbe
+	if s.BitcoinEnabled {
+		probes = append(probes, healthProbe{"bitcoin wallet", func() error {
+			if s.swapServices.bitcoinWallet.GetNetwork() == "" {
+				return errors.New("no network")
+			}
+			return nil
+		}})
+	}
+	if s.LiquidEnabled {
+		probes = append(probes, healthProbe{"liquid wallet", func() error {
+			if s.swapServices.liquidWallet.GetAsset() == "" {
+				return errors.New("no asset")
+			}
+			return nil
+		}})
+	}
+	probes = append(probes, healthProbe{"store", func() error {
+		return s.checkStoreHealth(ctx)
+	}})
+	if checker, ok := s.swapServices.messenger.(HealthChecker); ok {
+		probes = append(probes, healthProbe{"messenger", func() error {
+			return checker.HealthCheck(ctx)
+		}})
+	}
+
+	results := make([]chan error, len(probes))
+	for i, probe := range probes {
+		results[i] = make(chan error, 1)
+		go func(check func() error, res chan<- error) {
+			res <- check()
+		}(probe.check, results[i])
+	}
+	for i, probe := range probes {
+		// Prefer the result of a probe that returned before the context
+		// was done.
+		var err error
+		select {
+		case err = <-results[i]:
+		default:
+			select {
+			case err = <-results[i]:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		if err != nil {
+			healthErr.Errors = append(healthErr.Errors, DependencyError{Dependency: probe.dependency, Err: err})
+		}
+	}
+
+	if len(healthErr.Errors) > 0 {
+		return healthErr
+	}
+	return nil
+}
+
+// checkStoreHealth uses the health check of the store if the store
+// implements HealthChecker and reads a swap that does not exist otherwise.
+func (s *SwapService) checkStoreHealth(ctx context.Context) error {
+	if checker, ok := s.swapServices.swapStore.(HealthChecker); ok {
+		return checker.HealthCheck(ctx)
+	}
+	_, err := s.swapServices.swapStore.GetData(NewSwapId().String())
+	if err == ErrDataNotAvailable {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,180 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+// failingStore is a dummyStore whose reads fail.
+type failingStore struct {
+	*dummyStore
+}
+
+func (f *failingStore) GetData(id string) (*SwapStateMachine, error) {
+	return nil, errors.New("disk on fire")
+}
+
+// healthMessenger is a recordingMessenger with a health check.
+type healthMessenger struct {
+	recordingMessenger
+	err error
+}
+
+func (h *healthMessenger) HealthCheck(ctx context.Context) error {
+	return h.err
+}
+
+func Test_HealthCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		setup        func(t *testing.T, service *SwapService)
+		dependencies []string
+	}{
+		{
+			name:  "healthy",
+			setup: func(t *testing.T, service *SwapService) {},
+		},
+		{
+			name: "healthy bbolt store",
+			setup: func(t *testing.T, service *SwapService) {
+				db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+				require.NoError(t, err)
+				t.Cleanup(func() { db.Close() })
+				store, err := NewBboltStore(db)
+				require.NoError(t, err)
+				service.swapServices.swapStore = store
+			},
+		},
+		{
+			name: "read-only bbolt store",
+			setup: func(t *testing.T, service *SwapService) {
+				path := filepath.Join(t.TempDir(), "swaps")
+				db, err := bbolt.Open(path, 0700, nil)
+				require.NoError(t, err)
+				_, err = NewBboltStore(db)
+				require.NoError(t, err)
+				require.NoError(t, db.Close())
+
+				db, err = bbolt.Open(path, 0700, &bbolt.Options{ReadOnly: true})
+				require.NoError(t, err)
+				t.Cleanup(func() { db.Close() })
+				service.swapServices.swapStore = &bboltStore{db: db}
+			},
+			dependencies: []string{"store"},
+		},
+		{
+			name: "failing store",
+			setup: func(t *testing.T, service *SwapService) {
+				service.swapServices.swapStore = &failingStore{service.swapServices.swapStore.(*dummyStore)}
+			},
+			dependencies: []string{"store"},
+		},
+		{
+			name: "bitcoin wallet without network",
+			setup: func(t *testing.T, service *SwapService) {
+				service.swapServices.bitcoinWallet = &fixedNetworkWallet{dummyChain: service.swapServices.bitcoinWallet.(*dummyChain)}
+			},
+			dependencies: []string{"bitcoin wallet"},
+		},
+		{
+			name: "liquid wallet without asset",
+			setup: func(t *testing.T, service *SwapService) {
+				service.swapServices.liquidWallet = &fixedAssetWallet{dummyChain: service.swapServices.liquidWallet.(*dummyChain)}
+			},
+			dependencies: []string{"liquid wallet"},
+		},
+		{
+			name: "disabled wallet is not probed",
+			setup: func(t *testing.T, service *SwapService) {
+				service.swapServices.liquidWallet = &fixedAssetWallet{dummyChain: service.swapServices.liquidWallet.(*dummyChain)}
+				service.LiquidEnabled = false
+			},
+		},
+		{
+			name: "unreachable wallet",
+			setup: func(t *testing.T, service *SwapService) {
+				unblock := make(chan struct{})
+				t.Cleanup(func() { close(unblock) })
+				service.swapServices.bitcoinWallet = &blockingWallet{dummyChain: service.swapServices.bitcoinWallet.(*dummyChain), unblock: unblock}
+			},
+			dependencies: []string{"bitcoin wallet"},
+		},
+		{
+			name: "healthy messenger",
+			setup: func(t *testing.T, service *SwapService) {
+				service.swapServices.messenger = &healthMessenger{}
+			},
+		},
+		{
+			name: "unhealthy messenger",
+			setup: func(t *testing.T, service *SwapService) {
+				service.swapServices.messenger = &healthMessenger{err: errors.New("not connected")}
+			},
+			dependencies: []string{"messenger"},
+		},
+		{
+			name: "everything down",
+			setup: func(t *testing.T, service *SwapService) {
+				service.swapServices.swapStore = &failingStore{service.swapServices.swapStore.(*dummyStore)}
+				service.swapServices.bitcoinWallet = &fixedNetworkWallet{dummyChain: service.swapServices.bitcoinWallet.(*dummyChain)}
+				service.swapServices.liquidWallet = &fixedAssetWallet{dummyChain: service.swapServices.liquidWallet.(*dummyChain)}
+				service.swapServices.messenger = &healthMessenger{err: errors.New("not connected")}
+			},
+			dependencies: []string{"bitcoin wallet", "liquid wallet", "store", "messenger"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := getTestSetup(aliceId)
+			tc.setup(t, service)
+			require.NoError(t, service.Start())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := service.HealthCheck(ctx)
+			if len(tc.dependencies) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			var healthErr *HealthError
+			require.True(t, errors.As(err, &healthErr), "expected a HealthError, got %v", err)
+			var dependencies []string
+			for _, depErr := range healthErr.Errors {
+				dependencies = append(dependencies, depErr.Dependency)
+				assert.Contains(t, err.Error(), depErr.Error())
+			}
+			assert.Equal(t, tc.dependencies, dependencies)
+		})
+	}
+}
+
+func Test_HealthCheck_Service(t *testing.T) {
+	service := getTestSetup(aliceId)
+
+	err := service.HealthCheck(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service is not started")
+
+	require.NoError(t, service.Start())
+	assert.NoError(t, service.HealthCheck(context.Background()))
+
+	require.NoError(t, service.Stop())
+	assert.ErrorIs(t, service.HealthCheck(context.Background()), ErrServiceStopped)
+}
+
+// fixedNetworkWallet is a dummyChain that returns a fixed bitcoin network.
+type fixedNetworkWallet struct {
+	*dummyChain
+	network string
+}
+
+func (w *fixedNetworkWallet) GetNetwork() string {
+	return w.network
+}
@@ -0,0 +1,87 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SwapOutIdempotent starts a new swap out process unless a swap with the
+// idempotency key is active or was updated within the idempotency key ttl, in
+// which case that swap is returned. This prevents a retried call from starting
+// a second swap. An empty key always starts a new swap.
+func (s *SwapService) SwapOutIdempotent(ctx context.Context, idempotencyKey string, peer string, chain string, channelId string, initiator string, amtSat uint64) (*SwapStateMachine, error) {
+	if idempotencyKey == "" {
+		return s.SwapOutContext(ctx, peer, chain, channelId, initiator, amtSat)
+	}
+
+	s.idempotencyMutex.Lock()
+	defer s.idempotencyMutex.Unlock()
+
+	swap, err := s.findIdempotentSwap(idempotencyKey, SWAPTYPE_OUT, peer, channelId, amtSat)
+	if err != nil || swap != nil {
+		return swap, err
+	}
+	return s.swapOut(ctx, idempotencyKey, peer, []string{chain}, channelId, initiator, amtSat)
+}
+
+// SwapInIdempotent starts a new swap in process unless a swap with the
+// idempotency key is active or was updated within the idempotency key ttl, in
+// which case that swap is returned. An empty key always starts a new swap.
+func (s *SwapService) SwapInIdempotent(ctx context.Context, idempotencyKey string, peer string, chain string, channelId string, initiator string, amtSat uint64) (*SwapStateMachine, error) {
+	if idempotencyKey == "" {
+		return s.SwapInContext(ctx, peer, chain, channelId, initiator, amtSat)
+	}
+
+	s.idempotencyMutex.Lock()
+	defer s.idempotencyMutex.Unlock()
+
+	swap, err := s.findIdempotentSwap(idempotencyKey, SWAPTYPE_IN, peer, channelId, amtSat)
+	if err != nil || swap != nil {
+		return swap, err
+	}
+	return s.swapIn(ctx, idempotencyKey, peer, []string{chain}, channelId, initiator, amtSat)
+}
+
+// findIdempotentSwap returns the active swap with the idempotency key or else
+// the most recently updated swap with the key within the ttl. Nil is returned
+// if there is no such swap. ErrIdempotencyKeyReused is returned if the swap
+// was started with other parameters.
+func (s *SwapService) findIdempotentSwap(idempotencyKey string, swapType SwapType, peer string, channelId string, amtSat uint64) (*SwapStateMachine, error) {
+	var found *SwapStateMachine
+	for _, swap := range s.GetActiveSwaps() {
+		if swap.Data != nil && swap.Data.IdempotencyKey == idempotencyKey {
+			found = swap
+			break
+		}
+	}
+
+	if found == nil && s.swapServices.idempotencyKeyTTL > 0 {
+		ttl := s.swapServices.idempotencyKeyTTL
+		swaps, err := s.ListSwapsWhere(func(swap *SwapStateMachine) bool {
+			return swap.Data != nil && swap.Data.IdempotencyKey == idempotencyKey &&
+				time.Since(time.Unix(swap.Data.UpdatedAt, 0)) < ttl
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, swap := range swaps {
+			if found == nil || swap.Data.UpdatedAt > found.Data.UpdatedAt {
+				found = swap
+			}
+		}
+	}
+
+	if found == nil {
+		return nil, nil
+	}
+	found.mutex.Lock()
+	matches := found.Type == swapType && found.Data.PeerNodeId == peer &&
+		found.Data.GetScid() == channelId && found.Data.GetAmount() == amtSat
+	found.mutex.Unlock()
+	if !matches {
+		return nil, fmt.Errorf("%w: %q of swap %s", ErrIdempotencyKeyReused, idempotencyKey, found.SwapId.String())
+	}
+	s.swapServices.logger.Infof("[SwapService] Returning swap %s of idempotency key %q", found.SwapId.String(), idempotencyKey)
+	return found, nil
+}
@@ -0,0 +1,112 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func idempotencyTestSetup(t *testing.T, initiator string) *SwapService {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service, _ := getRecordingTestSetup(initiator)
+	service.swapServices.swapStore = store
+	return service
+}
+
+func Test_SwapOutIdempotent(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	ctx := context.Background()
+
+	t.Run("same key", func(t *testing.T) {
+		service := idempotencyTestSetup(t, initiator)
+		first, err := service.SwapOutIdempotent(ctx, "key", peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		second, err := service.SwapOutIdempotent(ctx, "key", peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+
+		assert.Equal(t, first.SwapId, second.SwapId)
+		assert.Equal(t, "key", first.Data.IdempotencyKey)
+		assert.Len(t, service.GetActiveSwaps(), 1)
+		assert.Len(t, service.swapServices.messenger.(*recordingMessenger).sent, 1)
+
+		// The key returns the swap after it was finished.
+		require.NoError(t, service.CancelSwap(first.SwapId.String(), ""))
+		third, err := service.SwapOutIdempotent(ctx, "key", peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		assert.Equal(t, first.SwapId, third.SwapId)
+		assert.Equal(t, State_SwapCanceled, third.Current)
+		assert.Empty(t, service.GetActiveSwaps())
+	})
+
+	t.Run("different keys", func(t *testing.T) {
+		service := idempotencyTestSetup(t, initiator)
+		first, err := service.SwapOutIdempotent(ctx, "key1", peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		second, err := service.SwapOutIdempotent(ctx, "key2", peer, btc_chain, "100x1x1", initiator, 100000)
+		require.NoError(t, err)
+
+		assert.NotEqual(t, first.SwapId, second.SwapId)
+		assert.Len(t, service.GetActiveSwaps(), 2)
+	})
+
+	t.Run("key reused for another swap", func(t *testing.T) {
+		service := idempotencyTestSetup(t, initiator)
+		_, err := service.SwapOutIdempotent(ctx, "key", peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		_, err = service.SwapOutIdempotent(ctx, "key", peer, btc_chain, channelId, initiator, 200000)
+		assert.True(t, errors.Is(err, ErrIdempotencyKeyReused))
+		_, err = service.SwapInIdempotent(ctx, "key", peer, btc_chain, channelId, initiator, 100000)
+		assert.True(t, errors.Is(err, ErrIdempotencyKeyReused))
+		assert.Len(t, service.GetActiveSwaps(), 1)
+	})
+
+	t.Run("expired key", func(t *testing.T) {
+		service := idempotencyTestSetup(t, initiator)
+		require.NoError(t, service.swapServices.SetIdempotencyKeyTTL(time.Nanosecond))
+		first, err := service.SwapOutIdempotent(ctx, "key", peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+
+		// An active swap is returned regardless of the ttl.
+		second, err := service.SwapOutIdempotent(ctx, "key", peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		assert.Equal(t, first.SwapId, second.SwapId)
+
+		require.NoError(t, service.CancelSwap(first.SwapId.String(), ""))
+		third, err := service.SwapOutIdempotent(ctx, "key", peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		assert.NotEqual(t, first.SwapId, third.SwapId)
+		assert.Len(t, service.GetActiveSwaps(), 1)
+	})
+}
+
+func Test_SwapInIdempotent(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	ctx := context.Background()
+	service := idempotencyTestSetup(t, initiator)
+
+	first, err := service.SwapInIdempotent(ctx, "key", peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	second, err := service.SwapInIdempotent(ctx, "key", peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	assert.Equal(t, first.SwapId, second.SwapId)
+
+	// An empty key always starts a new swap.
+	third, err := service.SwapInIdempotent(ctx, "", peer, btc_chain, "100x1x1", initiator, 100000)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.SwapId, third.SwapId)
+	assert.Empty(t, third.Data.IdempotencyKey)
+	assert.Len(t, service.GetActiveSwaps(), 2)
+
+	assert.Error(t, service.swapServices.SetIdempotencyKeyTTL(-time.Second))
+}
@@ -0,0 +1,105 @@
+package swap
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetInvoiceExpiry sets the expiry of the claim invoices that we create and
+// enables the check for expired claim invoices every interval. An expiry of 0
+// keeps the default expiry of the chain of the swap. A swap whose claim
+// invoice expired before the swap partner paid it can not be claimed with the
+// preimage anymore, it waits for the csv to refund the opening transaction.
+// The check requires a lightning client that implements
+// InvoiceExpiryChecker. An interval of 0 disables the check, which is the
+// default.
+func (s *SwapServices) SetInvoiceExpiry(expiry, interval time.Duration) error {
+	if expiry < 0 {
+		return fmt.Errorf("invoice expiry must not be negative, got %v", expiry)
+	}
+	if expiry > 0 && expiry < time.Second {
+		return fmt.Errorf("invoice expiry must be at least 1s, got %v", expiry)
+	}
+	if interval < 0 {
+		return fmt.Errorf("invoice check interval must not be negative, got %v", interval)
+	}
+	s.invoiceExpiry = expiry
+	s.invoiceCheckInterval = interval
+	return nil
+}
+
+// claimInvoiceExpiry returns the expiry in seconds of the claim invoice of
+// the swap.
+func (s *SwapServices) claimInvoiceExpiry(swap *SwapData) uint64 {
+	if s.invoiceExpiry > 0 {
+		return uint64(s.invoiceExpiry / time.Second)
+	}
+	return swap.GetInvoiceExpiry()
+}
+
+// runInvoiceExpiryCheck checks the claim invoices every interval until the
+// service is stopped.
+func (s *SwapService) runInvoiceExpiryCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.isStopped() {
+			return
+		}
+		s.CheckExpiredInvoices()
+	}
+}
+
+// CheckExpiredInvoices sends the ClaimInvoiceExpired event to the active
+// swaps that wait for the payment of a claim invoice that expired. Nothing is
+// checked if the lightning client does not implement InvoiceExpiryChecker.
+func (s *SwapService) CheckExpiredInvoices() {
+	checker, ok := s.swapServices.lightning.(InvoiceExpiryChecker)
+	if !ok || s.isStopped() {
+		return
+	}
+
+	for _, swap := range s.GetActiveSwaps() {
+		swap.mutex.Lock()
+		var payreq string
+		if swap.EventIsValid(Event_OnClaimInvoiceExpired) && swap.Data.OpeningTxBroadcasted != nil {
+			payreq = swap.Data.OpeningTxBroadcasted.Payreq
+		}
+		swap.mutex.Unlock()
+		if payreq == "" {
+			continue
+		}
+
+		expired, err := checker.IsInvoiceExpired(payreq)
+		if err != nil {
+			swap.logger().Warnf("[SwapService] Could not check the expiry of the claim invoice: %v", err)
+			continue
+		}
+		if !expired {
+			continue
+		}
+
+		swap.logger().Infof("[SwapService] Claim invoice expired, waiting for csv")
+		if err := s.OnClaimInvoiceExpired(swap.SwapId); err != nil {
+			swap.logger().Warnf("[SwapService] Could not handle the expired claim invoice: %v", err)
+		}
+	}
+}
+
+// OnClaimInvoiceExpired sends the ClaimInvoiceExpired event to the
+// corresponding swap state machine.
+func (s *SwapService) OnClaimInvoiceExpired(swapId *SwapId) error {
+	swap, err := s.GetActiveSwap(swapId.String())
+	if err != nil {
+		return err
+	}
+
+	done, err := s.sendEvent(swap, Event_OnClaimInvoiceExpired, nil)
+	if err != nil {
+		return err
+	}
+	if done {
+		s.RemoveActiveSwap(swap.SwapId.String())
+	}
+	return nil
+}
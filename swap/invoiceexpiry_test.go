@@ -0,0 +1,86 @@
+package swap
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expiringLightningClient reports the invoices in expired as expired.
+type expiringLightningClient struct {
+	dummyLightningClient
+	expired map[string]bool
+	err     error
+}
+
+func (e *expiringLightningClient) IsInvoiceExpired(payreq string) (bool, error) {
+	return e.expired[payreq], e.err
+}
+
+func Test_CheckExpiredInvoices(t *testing.T) {
+	initiator, peer, _, _, _ := getTestParams()
+
+	newService := func(t *testing.T) (*SwapService, *expiringLightningClient) {
+		service, _ := getRecordingTestSetup(peer)
+		lc := &expiringLightningClient{expired: map[string]bool{}}
+		service.swapServices.lightning = lc
+		return service, lc
+	}
+	// awaitPayment adds a swap that awaits the payment of the claim invoice
+	// payreq.
+	awaitPayment := func(service *SwapService, payreq string) *SwapStateMachine {
+		swapId := NewSwapId()
+		swap := newSwapOutReceiverFSM(swapId, service.swapServices, initiator)
+		swap.Current = State_SwapOutReceiver_AwaitClaimInvoicePayment
+		swap.Data.SwapOutRequest = &SwapOutRequestMessage{SwapId: swapId, Network: "mainnet", Amount: 100000}
+		swap.Data.OpeningTxBroadcasted = &OpeningTxBroadcastedMessage{SwapId: swapId, Payreq: payreq}
+		service.AddActiveSwap(swapId.String(), swap)
+		return swap
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		service, lc := newService(t)
+		expired := awaitPayment(service, "expired")
+		pending := awaitPayment(service, "pending")
+		lc.expired["expired"] = true
+
+		service.CheckExpiredInvoices()
+		assert.Equal(t, State_WaitCsv, expired.Current)
+		assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, pending.Current)
+	})
+
+	t.Run("check fails", func(t *testing.T) {
+		service, lc := newService(t)
+		swap := awaitPayment(service, "expired")
+		lc.expired["expired"] = true
+		lc.err = errors.New("lightning unavailable")
+
+		service.CheckExpiredInvoices()
+		assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, swap.Current)
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		service, _ := newService(t)
+		service.swapServices.lightning = &dummyLightningClient{}
+		swap := awaitPayment(service, "expired")
+
+		service.CheckExpiredInvoices()
+		assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, swap.Current)
+	})
+}
+
+func Test_SetInvoiceExpiry(t *testing.T) {
+	services := getTestSetup(aliceId).swapServices
+	assert.Error(t, services.SetInvoiceExpiry(-time.Second, 0))
+	assert.Error(t, services.SetInvoiceExpiry(time.Millisecond, 0))
+	assert.Error(t, services.SetInvoiceExpiry(time.Hour, -time.Second))
+
+	btcSwap := &SwapData{SwapOutRequest: &SwapOutRequestMessage{Asset: "", Network: "mainnet"}}
+	assert.Equal(t, btcSwap.GetInvoiceExpiry(), services.claimInvoiceExpiry(btcSwap))
+
+	require.NoError(t, services.SetInvoiceExpiry(2*time.Hour, time.Minute))
+	assert.Equal(t, uint64(7200), services.claimInvoiceExpiry(btcSwap))
+}
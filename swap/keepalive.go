@@ -0,0 +1,115 @@
+package swap
+
+import "time"
+
+// keepaliveState holds the keepalive state of an active swap.
+type keepaliveState struct {
+	// awaitingSince is the time the oldest unanswered ping was sent. It is
+	// zero if no ping is outstanding.
+	awaitingSince time.Time
+	// unresponsive is set once the peer was reported as unresponsive.
+	unresponsive bool
+}
+
+// runKeepalives sends keepalive pings every interval until the service is
+// stopped.
+func (s *SwapService) runKeepalives(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.isStopped() {
+			return
+		}
+		s.SendKeepalives()
+	}
+}
+
+// SendKeepalives sends a ping to the partner of every active swap. A partner
+// that did not answer an earlier ping within the keepalive window is
+// reported once with a SwapEventPeerUnresponsive event.
+func (s *SwapService) SendKeepalives() {
+	if s.isStopped() {
+		return
+	}
+	now := time.Now()
+	swaps := s.GetActiveSwaps()
+
+	var unresponsive []*SwapStateMachine
+	s.Lock()
+	active := make(map[string]struct{}, len(swaps))
+	for _, swap := range swaps {
+		swapId := swap.SwapId.String()
+		active[swapId] = struct{}{}
+		state, ok := s.keepalives[swapId]
+		if !ok {
+			state = &keepaliveState{}
+			s.keepalives[swapId] = state
+		}
+		if state.awaitingSince.IsZero() {
+			state.awaitingSince = now
+		} else if !state.unresponsive && now.Sub(state.awaitingSince) >= s.swapServices.keepaliveWindow {
+			state.unresponsive = true
+			unresponsive = append(unresponsive, swap)
+		}
+	}
+	// Forget the swaps that are no longer active.
+	for swapId := range s.keepalives {
+		if _, ok := active[swapId]; !ok {
+			delete(s.keepalives, swapId)
+		}
+	}
+	s.Unlock()
+
+	for _, swap := range unresponsive {
+		s.swapServices.logger.Warnf("[SwapService] Peer %s of swap %s is unresponsive", swap.Data.PeerNodeId, swap.SwapId.String())
+		s.swapServices.publishSwapEvent(newSwapEvent(SwapEventPeerUnresponsive, swap, swap.Current, swap.Current))
+	}
+
+	for _, swap := range swaps {
+		s.sendKeepaliveMessage(swap.Data.PeerNodeId, &PingMessage{SwapId: swap.SwapId})
+	}
+}
+
+// OnPingReceived answers the ping of the swap partner.
+func (s *SwapService) OnPingReceived(peerId string, msg *PingMessage) error {
+	return s.sendKeepaliveMessage(peerId, &PongMessage{SwapId: msg.SwapId})
+}
+
+// OnPongReceived marks the partner of the swap as responsive. A
+// SwapEventPeerResponsive event is published if the partner was reported as
+// unresponsive before.
+func (s *SwapService) OnPongReceived(msg *PongMessage) {
+	swapId := msg.SwapId.String()
+	s.Lock()
+	state, ok := s.keepalives[swapId]
+	wasUnresponsive := ok && state.unresponsive
+	if ok {
+		state.awaitingSince = time.Time{}
+		state.unresponsive = false
+	}
+	s.Unlock()
+
+	if !wasUnresponsive {
+		return
+	}
+	swap, err := s.GetActiveSwap(swapId)
+	if err != nil {
+		return
+	}
+	s.swapServices.logger.Infof("[SwapService] Peer %s of swap %s is responsive again", swap.Data.PeerNodeId, swapId)
+	s.swapServices.publishSwapEvent(newSwapEvent(SwapEventPeerResponsive, swap, swap.Current, swap.Current))
+}
+
+// sendKeepaliveMessage sends a ping or pong message once, a lost keepalive
+// message is covered by the next ping.
+func (s *SwapService) sendKeepaliveMessage(peerId string, msg PeerMessage) error {
+	msgBytes, msgType, err := MarshalPeerswapMessage(msg)
+	if err != nil {
+		return err
+	}
+	if err := s.swapServices.messenger.SendMessage(peerId, msgBytes, msgType); err != nil {
+		s.swapServices.logger.Debugf("[SwapService] Could not send keepalive message to %s: %v", peerId, err)
+		return err
+	}
+	return nil
+}
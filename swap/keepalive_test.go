@@ -0,0 +1,104 @@
+package swap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keepaliveEvents returns the keepalive events that were published so far.
+func keepaliveEvents(events <-chan SwapEvent) []SwapEventKind {
+	var kinds []SwapEventKind
+	for _, event := range drainEvents(events, func(event SwapEvent) bool {
+		return event.Kind == SwapEventPeerUnresponsive || event.Kind == SwapEventPeerResponsive
+	}) {
+		kinds = append(kinds, event.Kind)
+	}
+	return kinds
+}
+
+func Test_Keepalive_Responsive(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	alice, bob, aliceMsgChan, bobMsgChan := splitTestSetup(t, initiator, peer)
+	require.NoError(t, alice.swapServices.SetKeepalive(time.Hour, 20*time.Millisecond))
+
+	_, err := alice.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	aliceSwap, _ := negotiateSwapOut(t, alice, bob, aliceMsgChan, bobMsgChan)
+
+	events, unsubscribe := alice.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i < 3; i++ {
+		alice.SendKeepalives()
+		require.Equal(t, messages.MESSAGETYPE_PING, <-bobMsgChan)
+		require.Equal(t, messages.MESSAGETYPE_PONG, <-aliceMsgChan)
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	assert.Empty(t, keepaliveEvents(events))
+	assert.Equal(t, State_SwapOutSender_AwaitTxBroadcastedMessage, aliceSwap.Current)
+}
+
+func Test_Keepalive_Unresponsive(t *testing.T) {
+	service, messenger := getRecordingTestSetup(aliceId)
+	assert.Error(t, service.swapServices.SetKeepalive(-time.Second, time.Second))
+	assert.Error(t, service.swapServices.SetKeepalive(time.Second, 0))
+	require.NoError(t, service.swapServices.SetKeepalive(time.Hour, 20*time.Millisecond))
+
+	swap, err := service.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+	require.NoError(t, err)
+
+	events, unsubscribe := service.Subscribe()
+	defer unsubscribe()
+
+	// The peer does not answer the ping within the window.
+	sent := len(messenger.sent)
+	service.SendKeepalives()
+	time.Sleep(30 * time.Millisecond)
+	service.SendKeepalives()
+	assert.Equal(t, []SwapEventKind{SwapEventPeerUnresponsive}, keepaliveEvents(events))
+
+	// The peer is only reported once.
+	service.SendKeepalives()
+	assert.Empty(t, keepaliveEvents(events))
+
+	require.Len(t, messenger.sent, sent+3)
+	for _, msg := range messenger.sent[sent:] {
+		assert.Equal(t, int(messages.MESSAGETYPE_PING), msg.msgType)
+		assert.Equal(t, bobId, msg.peerId)
+	}
+
+	// The swap is not canceled.
+	assert.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Current)
+	_, err = service.GetActiveSwap(swap.SwapId.String())
+	assert.NoError(t, err)
+
+	// The peer answers again.
+	service.OnPongReceived(&PongMessage{SwapId: swap.SwapId})
+	assert.Equal(t, []SwapEventKind{SwapEventPeerResponsive}, keepaliveEvents(events))
+}
+
+func Test_Keepalive_AnswerPing(t *testing.T) {
+	service, messenger := getRecordingTestSetup(aliceId)
+
+	swap, err := service.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+	require.NoError(t, err)
+	sent := len(messenger.sent)
+
+	payload, msgType, err := MarshalPeerswapMessage(&PingMessage{SwapId: swap.SwapId})
+	require.NoError(t, err)
+	msgTypeString := messages.MessageTypeToHexString(messages.MessageType(msgType))
+
+	// Pings of other peers are not answered.
+	assert.Error(t, service.OnMessageReceived(malloryId, msgTypeString, payload))
+	require.Len(t, messenger.sent, sent)
+
+	require.NoError(t, service.OnMessageReceived(bobId, msgTypeString, payload))
+	require.Len(t, messenger.sent, sent+1)
+	assert.Equal(t, int(messages.MESSAGETYPE_PONG), messenger.sent[sent].msgType)
+	assert.Equal(t, bobId, messenger.sent[sent].peerId)
+}
@@ -0,0 +1,83 @@
+package swap
+
+import (
+	"encoding/json"
+
+	"github.com/elementsproject/peerswap/log"
+)
+
+// Logger is a leveled logger that can be injected into the SwapServices to
+// route the log output of the swap service.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// defaultLogger passes all log lines to the peerswap log package, which
+// falls back to the standard library logger if no logger was set.
+type defaultLogger struct{}
+
+func (defaultLogger) Debugf(format string, v ...interface{}) {
+	log.Debugf(format, v...)
+}
+
+func (defaultLogger) Infof(format string, v ...interface{}) {
+	log.Infof(format, v...)
+}
+
+func (defaultLogger) Warnf(format string, v ...interface{}) {
+	log.Warnf(format, v...)
+}
+
+func (defaultLogger) Errorf(format string, v ...interface{}) {
+	log.Errorf(format, v...)
+}
+
+// swapLogger prefixes all log lines with the id of a swap, so that the log
+// lines of a single swap can be told apart from those of concurrent swaps.
+type swapLogger struct {
+	logger Logger
+	prefix string
+}
+
+// newSwapLogger returns a logger that prefixes the log lines with the swap id
+// and passes them to logger, or to the default logger if logger is nil.
+func newSwapLogger(logger Logger, swapId string) Logger {
+	if logger == nil {
+		logger = defaultLogger{}
+	}
+	return &swapLogger{
+		logger: logger,
+		prefix: "[Swap:" + swapId + "] ",
+	}
+}
+
+func (l *swapLogger) Debugf(format string, v ...interface{}) {
+	l.logger.Debugf(l.prefix+format, v...)
+}
+
+func (l *swapLogger) Infof(format string, v ...interface{}) {
+	l.logger.Infof(l.prefix+format, v...)
+}
+
+func (l *swapLogger) Warnf(format string, v ...interface{}) {
+	l.logger.Warnf(l.prefix+format, v...)
+}
+
+func (l *swapLogger) Errorf(format string, v ...interface{}) {
+	l.logger.Errorf(l.prefix+format, v...)
+}
+
+// messageLogger returns a swapLogger for the swap of the peer message payload,
+// or logger if the payload does not belong to a swap.
+func messageLogger(logger Logger, payload []byte) Logger {
+	var msg struct {
+		SwapId *SwapId `json:"swap_id"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.SwapId == nil {
+		return logger
+	}
+	return newSwapLogger(logger, msg.SwapId.String())
+}
@@ -0,0 +1,87 @@
+package swap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_OnTxSeenInMempool checks that a swap records when its opening tx is
+// seen in the mempool without advancing, and still advances once the tx is
+// confirmed.
+func Test_OnTxSeenInMempool(t *testing.T) {
+	amount := uint64(100000)
+	initiator, peer, _, _, channelId := getTestParams()
+
+	transport := newMemoryTransport()
+	t.Cleanup(transport.close)
+
+	aliceSwapService := getTestSetup(initiator)
+	bobSwapService := getTestSetup(peer)
+	aliceMessenger := transport.connect(initiator)
+	bobMessenger := transport.connect(peer)
+	aliceSwapService.swapServices.messenger = aliceMessenger
+	bobSwapService.swapServices.messenger = bobMessenger
+
+	now := time.Unix(1700000000, 0)
+	aliceSwapService.swapServices.clock = func() time.Time { return now }
+
+	require.NoError(t, aliceSwapService.Start())
+	require.NoError(t, bobSwapService.Start())
+	t.Cleanup(func() {
+		aliceSwapService.Stop()
+		bobSwapService.Stop()
+	})
+
+	aliceSwap, err := aliceSwapService.SwapOut(peer, btc_chain, channelId, initiator, amount)
+	require.NoError(t, err)
+	bobMessenger.awaitMessage(t, messages.MESSAGETYPE_SWAPOUTREQUEST)
+	bobSwap, err := bobSwapService.GetActiveSwap(aliceSwap.SwapId.String())
+	require.NoError(t, err)
+	aliceMessenger.awaitMessage(t, messages.MESSAGETYPE_SWAPOUTAGREEMENT)
+	bobSwapService.swapServices.lightning.(*dummyLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_FEE)
+	aliceMessenger.awaitMessage(t, messages.MESSAGETYPE_OPENINGTXBROADCASTED)
+	require.Equal(t, State_SwapOutSender_AwaitTxConfirmation, aliceSwap.Current)
+
+	events, unsubscribe := aliceSwapService.Subscribe()
+	defer unsubscribe()
+
+	swapId := aliceSwap.SwapId.String()
+	txId := aliceSwap.Data.GetOpeningTxId()
+	watcher := aliceSwapService.swapServices.bitcoinTxWatcher.(*dummyChain)
+	require.NotNil(t, watcher.mempoolFunc)
+
+	// A tx that is not the opening tx is not recorded.
+	assert.Error(t, watcher.mempoolFunc(swapId, getRandom32ByteHexString()))
+	assert.Zero(t, aliceSwap.Data.OpeningTxSeenAt)
+
+	require.NoError(t, watcher.mempoolFunc(swapId, txId))
+	assert.Equal(t, State_SwapOutSender_AwaitTxConfirmation, aliceSwap.Current)
+	assert.Equal(t, now.Unix(), aliceSwap.Data.OpeningTxSeenAt)
+	stored, err := aliceSwapService.swapServices.swapStore.GetData(swapId)
+	require.NoError(t, err)
+	assert.Equal(t, now.Unix(), stored.Data.OpeningTxSeenAt)
+
+	event := <-events
+	assert.Equal(t, SwapEventOpeningTxSeen, event.Kind)
+	assert.Equal(t, swapId, event.SwapId)
+	assert.Equal(t, State_SwapOutSender_AwaitTxConfirmation, event.NewState)
+
+	// Later sightings do not change the time.
+	now = now.Add(time.Minute)
+	require.NoError(t, watcher.mempoolFunc(swapId, txId))
+	assert.Equal(t, now.Add(-time.Minute).Unix(), aliceSwap.Data.OpeningTxSeenAt)
+
+	require.NoError(t, watcher.txConfirmedFunc(swapId, aliceSwap.Data.OpeningTxHex))
+	assert.Equal(t, State_ClaimedPreimage, aliceSwap.Current)
+	assert.Equal(t, now.Add(-time.Minute).Unix(), aliceSwap.Data.OpeningTxSeenAt)
+
+	// Both the sighting and the confirmation are stored.
+	stored, err = aliceSwapService.GetSwap(swapId)
+	require.NoError(t, err)
+	assert.Equal(t, State_ClaimedPreimage, stored.Current)
+	assert.Equal(t, now.Add(-time.Minute).Unix(), stored.Data.OpeningTxSeenAt)
+}
@@ -0,0 +1,166 @@
+package swap
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryTransport connects memoryMessengers in-process. Every messenger
+// delivers the incoming messages in order from its own goroutine, so that
+// the handlers may send messages back while they handle a message.
+type memoryTransport struct {
+	sync.Mutex
+	messengers map[string]*memoryMessenger
+}
+
+func newMemoryTransport() *memoryTransport {
+	return &memoryTransport{messengers: map[string]*memoryMessenger{}}
+}
+
+// connect returns the messenger of the peer on the transport.
+func (t *memoryTransport) connect(peerId string) *memoryMessenger {
+	t.Lock()
+	defer t.Unlock()
+	m := &memoryMessenger{
+		transport: t,
+		peerId:    peerId,
+		inbox:     make(chan memoryMessage, 64),
+		received:  make(chan messages.MessageType, 64),
+		done:      make(chan struct{}),
+	}
+	t.messengers[peerId] = m
+	return m
+}
+
+func (t *memoryTransport) get(peerId string) (*memoryMessenger, bool) {
+	t.Lock()
+	defer t.Unlock()
+	m, ok := t.messengers[peerId]
+	return m, ok
+}
+
+// close stops the delivery of the messages of all messengers.
+func (t *memoryTransport) close() {
+	t.Lock()
+	defer t.Unlock()
+	for _, m := range t.messengers {
+		close(m.done)
+	}
+	t.messengers = map[string]*memoryMessenger{}
+}
+
+type memoryMessage struct {
+	from    string
+	msgType int
+	payload []byte
+}
+
+// memoryMessenger implements the Messenger contract on top of a
+// memoryTransport. The types of the handled messages are sent to received.
+type memoryMessenger struct {
+	transport *memoryTransport
+	peerId    string
+	inbox     chan memoryMessage
+	received  chan messages.MessageType
+	done      chan struct{}
+}
+
+func (m *memoryMessenger) SendMessage(peerId string, msg []byte, msgType int) error {
+	to, ok := m.transport.get(peerId)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrPeerNotConnected, peerId)
+	}
+	payload := make([]byte, len(msg))
+	copy(payload, msg)
+	select {
+	case to.inbox <- memoryMessage{from: m.peerId, msgType: msgType, payload: payload}:
+		return nil
+	case <-to.done:
+		return fmt.Errorf("%w: %s", ErrPeerNotConnected, peerId)
+	}
+}
+
+func (m *memoryMessenger) AddMessageHandler(f func(peerId string, msgType string, payload []byte) error) {
+	go func() {
+		for {
+			select {
+			case msg := <-m.inbox:
+				msgType := messages.MessageTypeToHexString(messages.MessageType(msg.msgType))
+				if err := f(msg.from, msgType, msg.payload); err != nil {
+					continue
+				}
+				m.received <- messages.MessageType(msg.msgType)
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+func (m *memoryMessenger) IsPeerConnected(peerId string) (bool, error) {
+	_, ok := m.transport.get(peerId)
+	return ok, nil
+}
+
+// awaitMessage waits until the messenger handled a message of the type.
+func (m *memoryMessenger) awaitMessage(t *testing.T, msgType messages.MessageType) {
+	t.Helper()
+	select {
+	case received := <-m.received:
+		require.Equal(t, msgType, received)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not receive message %s", m.peerId, messages.MessageTypeToHexString(msgType))
+	}
+}
+
+// Test_SwapOut_MemoryTransport runs a swap-out between two services that
+// exchange their messages over the in-memory transport.
+func Test_SwapOut_MemoryTransport(t *testing.T) {
+	amount := uint64(100000)
+	initiator, peer, _, _, channelId := getTestParams()
+
+	transport := newMemoryTransport()
+	t.Cleanup(transport.close)
+
+	aliceSwapService := getTestSetup(initiator)
+	bobSwapService := getTestSetup(peer)
+	aliceMessenger := transport.connect(initiator)
+	bobMessenger := transport.connect(peer)
+	aliceSwapService.swapServices.messenger = aliceMessenger
+	bobSwapService.swapServices.messenger = bobMessenger
+
+	require.NoError(t, aliceSwapService.Start())
+	require.NoError(t, bobSwapService.Start())
+	t.Cleanup(func() {
+		aliceSwapService.Stop()
+		bobSwapService.Stop()
+	})
+
+	aliceSwap, err := aliceSwapService.SwapOut(peer, btc_chain, channelId, initiator, amount)
+	require.NoError(t, err)
+
+	bobMessenger.awaitMessage(t, messages.MESSAGETYPE_SWAPOUTREQUEST)
+	bobSwap, err := bobSwapService.GetActiveSwap(aliceSwap.SwapId.String())
+	require.NoError(t, err)
+
+	aliceMessenger.awaitMessage(t, messages.MESSAGETYPE_SWAPOUTAGREEMENT)
+	assert.Equal(t, State_SwapOutSender_AwaitTxBroadcastedMessage, aliceSwap.Current)
+	assert.Equal(t, State_SwapOutReceiver_AwaitFeeInvoicePayment, bobSwap.Current)
+
+	bobSwapService.swapServices.lightning.(*dummyLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_FEE)
+	assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, bobSwap.Current)
+
+	aliceMessenger.awaitMessage(t, messages.MESSAGETYPE_OPENINGTXBROADCASTED)
+	err = aliceSwapService.swapServices.liquidTxWatcher.(*dummyChain).txConfirmedFunc(aliceSwap.SwapId.String(), aliceSwap.Data.OpeningTxHex)
+	require.NoError(t, err)
+	assert.Equal(t, State_ClaimedPreimage, aliceSwap.Current)
+
+	bobSwapService.swapServices.lightning.(*dummyLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_CLAIM)
+	assert.Equal(t, State_ClaimedPreimage, bobSwap.Current)
+}
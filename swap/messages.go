@@ -1,13 +1,16 @@
 package swap
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/elementsproject/peerswap/messages"
 )
 
@@ -17,12 +20,32 @@ var (
 	InvalidNetworkError    = errors.New("Invalid network")
 	InvalidScidError       = errors.New("Invalid Scid")
 	AssetOrNetworkSetError = errors.New("Either asset or network must be set")
+	MissingFieldError      = errors.New("Missing required field")
+	NilMessageError        = errors.New("Message is nil")
+	NonceMismatchError     = errors.New("Nonce does not match the swap")
 )
 
 func NewInvalidLengthError(paramName string, expected, actual int) error {
 	return fmt.Errorf("Param %s is of invalid length expected: %v, actual %v", paramName, expected, actual)
 }
 
+func NewMissingFieldError(paramName string, msgType messages.MessageType) error {
+	return fmt.Errorf("%w %s in message of type %d", MissingFieldError, paramName, msgType)
+}
+
+// requiredField is a field of a message that must be set before the message
+// is sent to the peer.
+type requiredField struct {
+	name string
+	set  bool
+}
+
+// requiredFieldsMessage is implemented by messages that have mandatory
+// fields.
+type requiredFieldsMessage interface {
+	requiredFields() []requiredField
+}
+
 type SwapInRequestMessage struct {
 	// ProtocolVersion is the version of the PeerSwap peer protocol the sending
 	// node uses.
@@ -45,17 +68,43 @@ type SwapInRequestMessage struct {
 	// Amount is The amount in Sats that is asked for.
 	Amount uint64 `json:"amount"`
 	Pubkey string `json:"pubkey"`
+	// ChainOptions lists the on-chain networks that the sender accepts in
+	// the order of its preference. The swap partner selects the first one it
+	// supports and confirms it in the agreement. If set, Asset and Network
+	// hold the first option, so that peers that do not support chain options
+	// use the preferred chain.
+	ChainOptions []ChainOption `json:"chain_options,omitempty"`
+	// Nonce is a random 32 byte string that binds the agreement and the
+	// coop close message to the swap, so that they can not be replayed
+	// against another swap. It is not set by peers that do not support it.
+	Nonce string `json:"nonce,omitempty"`
 }
 
 func (s SwapInRequestMessage) MessageType() messages.MessageType {
 	return messages.MESSAGETYPE_SWAPINREQUEST
 }
 
+func (s SwapInRequestMessage) requiredFields() []requiredField {
+	return []requiredField{
+		{"protocol_version", s.ProtocolVersion != 0},
+		{"swap_id", s.SwapId != nil},
+		{"scid", s.Scid != ""},
+		{"amount", s.Amount != 0},
+		{"pubkey", s.Pubkey != ""},
+	}
+}
+
 func (s SwapInRequestMessage) Validate(swap *SwapData) error {
-	err := validateHexString("pubkey", s.Pubkey, 33)
+	err := validatePubkey("pubkey", s.Pubkey)
 	if err != nil {
 		return err
 	}
+	if s.Nonce != "" {
+		err = validateHexString("nonce", s.Nonce, 32)
+		if err != nil {
+			return err
+		}
+	}
 	err = validateAssetAndNetwork(s.Asset, s.Network)
 	if err != nil {
 		return err
@@ -76,6 +125,10 @@ func (s SwapInRequestMessage) Validate(swap *SwapData) error {
 	if err != nil {
 		return err
 	}
+	err = validateChainOptions(s.Asset, s.Network, s.ChainOptions)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -92,11 +145,12 @@ func validateScid(scid string) error {
 	if len(parts) != 3 {
 		return InvalidScidError
 	}
-	_, err := strconv.Atoi(parts[0])
-	_, err = strconv.Atoi(parts[1])
-	_, err = strconv.Atoi(parts[2])
-	if err != nil {
-		return InvalidScidError
+	// The block height and the transaction index are encoded in 3 bytes,
+	// the output index in 2 bytes.
+	for i, bitSize := range []int{24, 24, 16} {
+		if _, err := strconv.ParseUint(parts[i], 10, bitSize); err != nil {
+			return InvalidScidError
+		}
 	}
 	return nil
 }
@@ -119,6 +173,55 @@ func validateAssetAndNetwork(asset string, network string) error {
 	return nil
 }
 
+// ChainOption is an on-chain network that can be used for a swap.
+type ChainOption struct {
+	// Asset is the asset id of the liquid bitcoin asset, it is left blank for
+	// bitcoin.
+	Asset string `json:"asset"`
+	// Network is the bitcoin network, it is left blank for liquid.
+	Network string `json:"network"`
+}
+
+func (o ChainOption) chain() string {
+	return getChain(o.Asset, o.Network)
+}
+
+// validateChainOptions checks the chain options of a request. The asset and
+// network of the request must be one of the options.
+func validateChainOptions(asset, network string, options []ChainOption) error {
+	if len(options) == 0 {
+		return nil
+	}
+	var found bool
+	for _, option := range options {
+		if err := validateAssetAndNetwork(option.Asset, option.Network); err != nil {
+			return err
+		}
+		if option.Asset == asset && option.Network == network {
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("asset and network are not one of the chain options")
+	}
+	return nil
+}
+
+// validateSelectedChain checks that the chain that the agreement selected was
+// offered in the chain options of the request.
+func validateSelectedChain(asset, network string, options []ChainOption) error {
+	if asset == "" && network == "" {
+		return nil
+	}
+	selected := ChainOption{Asset: asset, Network: network}
+	for _, option := range options {
+		if option == selected {
+			return nil
+		}
+	}
+	return fmt.Errorf("agreement selected chain %s that was not offered", selected.chain())
+}
+
 func validateNetwork(network string) error {
 	switch network {
 	case "mainnet":
@@ -145,6 +248,51 @@ func validateHexString(paramName, hexString string, expectedLength int) error {
 	return nil
 }
 
+// validatePubkey returns an error if the hex string is not a 33 byte
+// compressed secp256k1 public key.
+func validatePubkey(paramName, hexString string) error {
+	err := validateHexString(paramName, hexString, 33)
+	if err != nil {
+		return err
+	}
+	data, _ := hex.DecodeString(hexString)
+	if data[0] != 0x02 && data[0] != 0x03 {
+		return fmt.Errorf("Param %s is not a compressed public key", paramName)
+	}
+	if _, err := btcec.ParsePubKey(data, btcec.S256()); err != nil {
+		return fmt.Errorf("Param %s is not a valid public key: %v", paramName, err)
+	}
+	return nil
+}
+
+// validateNonce returns NonceMismatchError if the request of the swap set a
+// nonce that differs from the nonce of the message. A message without a nonce
+// is accepted, as peers that do not know the nonce do not echo it, unless the
+// agreement of the swap already echoed the nonce.
+func validateNonce(nonce string, swap *SwapData) error {
+	expected := swap.GetNonce()
+	if expected == "" {
+		return nil
+	}
+	if nonce == "" {
+		if swap.getAgreementNonce() == expected {
+			return NonceMismatchError
+		}
+		return nil
+	}
+	if nonce != expected {
+		return NonceMismatchError
+	}
+	return nil
+}
+
+// newNonce returns a random 32 byte nonce for a swap request.
+func newNonce() string {
+	var nonce [32]byte
+	rand.Read(nonce[:])
+	return hex.EncodeToString(nonce[:])
+}
+
 func (s SwapInRequestMessage) ApplyToSwapData(swap *SwapData) error {
 	if swap.SwapInRequest != nil {
 		return AlreadyExistsError
@@ -169,13 +317,30 @@ type SwapInAgreementMessage struct {
 	// Premium is a compensation in Sats that the swap partner wants to be payed
 	// in order to participate in the swap.
 	Premium uint64 `json:"premium"`
+	// Asset and Network confirm the chain that was selected from the chain
+	// options of the request. They are left blank if the request had no
+	// chain options.
+	Asset   string `json:"asset,omitempty"`
+	Network string `json:"network,omitempty"`
+	// Nonce is the nonce of the request.
+	Nonce string `json:"nonce,omitempty"`
 }
 
 func (s SwapInAgreementMessage) Validate(swap *SwapData) error {
-	err := validateHexString("pubkey", s.Pubkey, 33)
+	err := validatePubkey("pubkey", s.Pubkey)
+	if err != nil {
+		return err
+	}
+	err = validateNonce(s.Nonce, swap)
 	if err != nil {
 		return err
 	}
+	if swap.SwapInRequest != nil {
+		err = validateSelectedChain(s.Asset, s.Network, swap.SwapInRequest.ChainOptions)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -184,11 +349,22 @@ func (s SwapInAgreementMessage) MessageType() messages.MessageType {
 	return messages.MESSAGETYPE_SWAPINAGREEMENT
 }
 
+func (s SwapInAgreementMessage) requiredFields() []requiredField {
+	return []requiredField{
+		{"protocol_version", s.ProtocolVersion != 0},
+		{"swap_id", s.SwapId != nil},
+		{"pubkey", s.Pubkey != ""},
+	}
+}
+
 func (s SwapInAgreementMessage) ApplyToSwapData(swap *SwapData) error {
 	if swap.SwapInAgreement != nil {
 		return AlreadyExistsError
 	}
 	swap.SwapInAgreement = &s
+	if swap.SwapInRequest != nil && (s.Asset != "" || s.Network != "") {
+		swap.SwapInRequest.Asset, swap.SwapInRequest.Network = s.Asset, s.Network
+	}
 	return nil
 }
 
@@ -217,13 +393,29 @@ type SwapOutRequestMessage struct {
 	// Pubkey is a 33 byte compressed public key used for the spending paths in
 	// the opening_transaction.
 	Pubkey string `json:"pubkey"`
+	// ChainOptions lists the on-chain networks that the sender accepts in
+	// the order of its preference. The swap partner selects the first one it
+	// supports and confirms it in the agreement. If set, Asset and Network
+	// hold the first option, so that peers that do not support chain options
+	// use the preferred chain.
+	ChainOptions []ChainOption `json:"chain_options,omitempty"`
+	// Nonce is a random 32 byte string that binds the agreement and the
+	// coop close message to the swap, so that they can not be replayed
+	// against another swap. It is not set by peers that do not support it.
+	Nonce string `json:"nonce,omitempty"`
 }
 
 func (s SwapOutRequestMessage) Validate(swap *SwapData) error {
-	err := validateHexString("pubkey", s.Pubkey, 33)
+	err := validatePubkey("pubkey", s.Pubkey)
 	if err != nil {
 		return err
 	}
+	if s.Nonce != "" {
+		err = validateHexString("nonce", s.Nonce, 32)
+		if err != nil {
+			return err
+		}
+	}
 	err = validateAssetAndNetwork(s.Asset, s.Network)
 	if err != nil {
 		return err
@@ -232,6 +424,10 @@ func (s SwapOutRequestMessage) Validate(swap *SwapData) error {
 	if err != nil {
 		return err
 	}
+	err = validateChainOptions(s.Asset, s.Network, s.ChainOptions)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -239,6 +435,16 @@ func (s SwapOutRequestMessage) MessageType() messages.MessageType {
 	return messages.MESSAGETYPE_SWAPOUTREQUEST
 }
 
+func (s SwapOutRequestMessage) requiredFields() []requiredField {
+	return []requiredField{
+		{"protocol_version", s.ProtocolVersion != 0},
+		{"swap_id", s.SwapId != nil},
+		{"scid", s.Scid != ""},
+		{"amount", s.Amount != 0},
+		{"pubkey", s.Pubkey != ""},
+	}
+}
+
 func (s SwapOutRequestMessage) ApplyToSwapData(swap *SwapData) error {
 	if swap.SwapOutRequest != nil {
 		return AlreadyExistsError
@@ -261,15 +467,50 @@ type SwapOutAgreementMessage struct {
 	// the opening_transaction.
 	Pubkey string `json:"pubkey"`
 	// Payreq is a BOLT#11 invoice with an amount that covers the fee expenses
-	// for the on-chain transactions.
+	// for the on-chain transactions and the premium.
 	Payreq string
+	// Premium is a compensation in Sats that the swap partner wants to be payed
+	// in order to participate in the swap. It is part of the Payreq amount.
+	Premium uint64 `json:"premium"`
+	// FeeBreakdown splits the Payreq amount into its components. It is not
+	// set by peers that do not support it.
+	FeeBreakdown *FeeBreakdown `json:"fee_breakdown,omitempty"`
+	// Asset and Network confirm the chain that was selected from the chain
+	// options of the request. They are left blank if the request had no
+	// chain options.
+	Asset   string `json:"asset,omitempty"`
+	Network string `json:"network,omitempty"`
+	// Nonce is the nonce of the request.
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// FeeBreakdown lists the components of the amount of the fee invoice of a
+// swap out, so that the taker can check each component on its own.
+type FeeBreakdown struct {
+	// OpeningTxFee is the on-chain fee in Sats of the opening transaction.
+	OpeningTxFee uint64 `json:"opening_tx_fee"`
+	// Premium is the premium in Sats, it equals the Premium of the agreement.
+	Premium uint64 `json:"premium"`
 }
 
 func (s SwapOutAgreementMessage) Validate(swap *SwapData) error {
-	err := validateHexString("pubkey", s.Pubkey, 33)
+	err := validatePubkey("pubkey", s.Pubkey)
 	if err != nil {
 		return err
 	}
+	err = validateNonce(s.Nonce, swap)
+	if err != nil {
+		return err
+	}
+	if s.FeeBreakdown != nil && s.FeeBreakdown.Premium != s.Premium {
+		return fmt.Errorf("premium of the fee breakdown %d does not match the premium %d", s.FeeBreakdown.Premium, s.Premium)
+	}
+	if swap.SwapOutRequest != nil {
+		err = validateSelectedChain(s.Asset, s.Network, swap.SwapOutRequest.ChainOptions)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -277,11 +518,23 @@ func (s SwapOutAgreementMessage) MessageType() messages.MessageType {
 	return messages.MESSAGETYPE_SWAPOUTAGREEMENT
 }
 
+func (s SwapOutAgreementMessage) requiredFields() []requiredField {
+	return []requiredField{
+		{"protocol_version", s.ProtocolVersion != 0},
+		{"swap_id", s.SwapId != nil},
+		{"pubkey", s.Pubkey != ""},
+		{"payreq", s.Payreq != ""},
+	}
+}
+
 func (s SwapOutAgreementMessage) ApplyToSwapData(swap *SwapData) error {
 	if swap.SwapOutAgreement != nil {
 		return AlreadyExistsError
 	}
 	swap.SwapOutAgreement = &s
+	if swap.SwapOutRequest != nil && (s.Asset != "" || s.Network != "") {
+		swap.SwapOutRequest.Asset, swap.SwapOutRequest.Network = s.Asset, s.Network
+	}
 	return nil
 }
 
@@ -324,6 +577,14 @@ func (t OpeningTxBroadcastedMessage) MessageType() messages.MessageType {
 	return messages.MESSAGETYPE_OPENINGTXBROADCASTED
 }
 
+func (t OpeningTxBroadcastedMessage) requiredFields() []requiredField {
+	return []requiredField{
+		{"swap_id", t.SwapId != nil},
+		{"payreq", t.Payreq != ""},
+		{"tx_id", t.TxId != ""},
+	}
+}
+
 func (m OpeningTxBroadcastedMessage) ApplyToSwapData(swap *SwapData) error {
 	if swap.OpeningTxBroadcasted != nil {
 		return AlreadyExistsError
@@ -332,11 +593,40 @@ func (m OpeningTxBroadcastedMessage) ApplyToSwapData(swap *SwapData) error {
 	return nil
 }
 
+// CancelReason is the reason code of a CancelMessage.
+type CancelReason string
+
+const (
+	CancelReasonSwapsDisabled     CancelReason = "swaps_disabled"
+	CancelReasonUnsupportedAsset  CancelReason = "unsupported_asset"
+	CancelReasonProtocolVersion   CancelReason = "unsupported_protocol_version"
+	CancelReasonInvalidAmount     CancelReason = "invalid_amount"
+	CancelReasonPeerNotAllowed    CancelReason = "peer_not_allowed"
+	CancelReasonRateLimited       CancelReason = "rate_limited"
+	CancelReasonSwapLimit         CancelReason = "swap_limit"
+	CancelReasonDuplicateSwapId   CancelReason = "duplicate_swap_id"
+	CancelReasonFeeTooHigh        CancelReason = "fee_too_high"
+	CancelReasonInsufficientFunds CancelReason = "insufficient_funds"
+	CancelReasonInvalidMessage    CancelReason = "invalid_message"
+	CancelReasonTimeout           CancelReason = "timeout"
+	CancelReasonOperator          CancelReason = "operator"
+	CancelReasonExpired           CancelReason = "expired"
+	CancelReasonCrossingSwap      CancelReason = "crossing_swap"
+	CancelReasonInternalError     CancelReason = "internal_error"
+	CancelReasonUnavailable       CancelReason = "temporarily_unavailable"
+	// CancelReasonOther is sent if no other reason applies, the message
+	// holds the details.
+	CancelReasonOther CancelReason = "other"
+)
+
 // CancelMessage is the message sent by a peer if he wants to / has to cancel
 // the swap
 type CancelMessage struct {
 	// SwapId is the unique identifier of the swap.
 	SwapId *SwapId `json:"swap_id"`
+	// Reason is the reason code of the cancellation. It is not set by peers
+	// that do not support it.
+	Reason CancelReason `json:"reason,omitempty"`
 	// Message is a hint to why the swap was canceled.
 	Message string `json:"message"`
 }
@@ -345,12 +635,19 @@ func (e CancelMessage) MessageType() messages.MessageType {
 	return messages.MESSAGETYPE_CANCELED
 }
 
+func (e CancelMessage) requiredFields() []requiredField {
+	return []requiredField{
+		{"swap_id", e.SwapId != nil},
+	}
+}
+
 func (s CancelMessage) Validate(swap *SwapData) error {
 	return nil
 }
 
 func (m CancelMessage) ApplyToSwapData(swap *SwapData) error {
 	swap.Cancel = &m
+	swap.CancelCategory = CancelCategoryPeerCancelled
 	return nil
 }
 
@@ -363,18 +660,27 @@ type CoopCloseMessage struct {
 	Message string `json:"message"`
 	// privkey is the private key to the pubkey that is used to build the opening_transaction.
 	Privkey string `json:"privkey"`
+	// Nonce is the nonce of the request.
+	Nonce string `json:"nonce,omitempty"`
 }
 
 func (c CoopCloseMessage) MessageType() messages.MessageType {
 	return messages.MESSAGETYPE_COOPCLOSE
 }
 
+func (c CoopCloseMessage) requiredFields() []requiredField {
+	return []requiredField{
+		{"swap_id", c.SwapId != nil},
+		{"privkey", c.Privkey != ""},
+	}
+}
+
 func (s CoopCloseMessage) Validate(swap *SwapData) error {
 	err := validateHexString("privkey", s.Privkey, 32)
 	if err != nil {
 		return err
 	}
-	return nil
+	return validateNonce(s.Nonce, swap)
 }
 
 func (m CoopCloseMessage) ApplyToSwapData(swap *SwapData) error {
@@ -385,7 +691,81 @@ func (m CoopCloseMessage) ApplyToSwapData(swap *SwapData) error {
 	return nil
 }
 
+// SwapReunionMessage is sent for every active swap with a peer when the peer
+// reconnects. It carries the state of the swap on the side of the sender so
+// that the swap partners can detect and reconcile a divergence.
+type SwapReunionMessage struct {
+	// SwapId is the unique identifier of the swap.
+	SwapId *SwapId `json:"swap_id"`
+	// State is the current state of the swap of the sender.
+	State StateType `json:"state"`
+	// OpeningTxId is the id of the opening transaction that the sender
+	// knows of, if any.
+	OpeningTxId string `json:"opening_tx_id"`
+}
+
+func (m SwapReunionMessage) MessageType() messages.MessageType {
+	return messages.MESSAGETYPE_SWAPREUNION
+}
+
+func (m SwapReunionMessage) requiredFields() []requiredField {
+	return []requiredField{
+		{"swap_id", m.SwapId != nil},
+		{"state", m.State != ""},
+	}
+}
+
+// PingMessage is sent to the swap partner to probe whether it is still
+// responsive. The partner answers with a PongMessage.
+type PingMessage struct {
+	// SwapId is the unique identifier of the swap.
+	SwapId *SwapId `json:"swap_id"`
+}
+
+func (m PingMessage) MessageType() messages.MessageType {
+	return messages.MESSAGETYPE_PING
+}
+
+func (m PingMessage) requiredFields() []requiredField {
+	return []requiredField{
+		{"swap_id", m.SwapId != nil},
+	}
+}
+
+// PongMessage answers a PingMessage.
+type PongMessage struct {
+	// SwapId is the unique identifier of the swap.
+	SwapId *SwapId `json:"swap_id"`
+}
+
+func (m PongMessage) MessageType() messages.MessageType {
+	return messages.MESSAGETYPE_PONG
+}
+
+func (m PongMessage) requiredFields() []requiredField {
+	return []requiredField{
+		{"swap_id", m.SwapId != nil},
+	}
+}
+
+// MarshalPeerswapMessage marshals the message for the peer. It returns an
+// error if a required field of the message is not set, as the peer would
+// reject the message anyway.
 func MarshalPeerswapMessage(msg PeerMessage) ([]byte, int, error) {
+	if msg == nil {
+		return nil, 0, NilMessageError
+	}
+	if v := reflect.ValueOf(msg); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, 0, NilMessageError
+	}
+	if m, ok := msg.(requiredFieldsMessage); ok {
+		for _, field := range m.requiredFields() {
+			if !field.set {
+				return nil, 0, NewMissingFieldError(field.name, msg.MessageType())
+			}
+		}
+	}
+
 	msgBytes, err := json.Marshal(msg)
 	if err != nil {
 		return nil, 0, err
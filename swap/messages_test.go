@@ -0,0 +1,197 @@
+package swap
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MarshalPeerswapMessage_RequiredFields(t *testing.T) {
+	swapId := NewSwapId()
+	pubkey := "02" + "11111111111111111111111111111111" + "11111111111111111111111111111111"
+
+	for _, tc := range []struct {
+		name    string
+		message PeerMessage
+		// missing returns a copy of the message without the field.
+		missing map[string]func() PeerMessage
+	}{
+		{
+			name:    "swap in request",
+			message: &SwapInRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Network: "regtest", Scid: "100x2x3", Amount: 100000, Pubkey: pubkey},
+			missing: map[string]func() PeerMessage{
+				"protocol_version": func() PeerMessage {
+					return &SwapInRequestMessage{SwapId: swapId, Network: "regtest", Scid: "100x2x3", Amount: 100000, Pubkey: pubkey}
+				},
+				"swap_id": func() PeerMessage {
+					return &SwapInRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, Network: "regtest", Scid: "100x2x3", Amount: 100000, Pubkey: pubkey}
+				},
+				"scid": func() PeerMessage {
+					return &SwapInRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Network: "regtest", Amount: 100000, Pubkey: pubkey}
+				},
+				"amount": func() PeerMessage {
+					return &SwapInRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Network: "regtest", Scid: "100x2x3", Pubkey: pubkey}
+				},
+				"pubkey": func() PeerMessage {
+					return &SwapInRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Network: "regtest", Scid: "100x2x3", Amount: 100000}
+				},
+			},
+		},
+		{
+			name:    "swap in agreement",
+			message: &SwapInAgreementMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Pubkey: pubkey},
+			missing: map[string]func() PeerMessage{
+				"protocol_version": func() PeerMessage { return &SwapInAgreementMessage{SwapId: swapId, Pubkey: pubkey} },
+				"swap_id": func() PeerMessage {
+					return &SwapInAgreementMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, Pubkey: pubkey}
+				},
+				"pubkey": func() PeerMessage {
+					return &SwapInAgreementMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId}
+				},
+			},
+		},
+		{
+			name:    "swap out request",
+			message: &SwapOutRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Network: "regtest", Scid: "100x2x3", Amount: 100000, Pubkey: pubkey},
+			missing: map[string]func() PeerMessage{
+				"protocol_version": func() PeerMessage {
+					return &SwapOutRequestMessage{SwapId: swapId, Network: "regtest", Scid: "100x2x3", Amount: 100000, Pubkey: pubkey}
+				},
+				"swap_id": func() PeerMessage {
+					return &SwapOutRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, Network: "regtest", Scid: "100x2x3", Amount: 100000, Pubkey: pubkey}
+				},
+				"scid": func() PeerMessage {
+					return &SwapOutRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Network: "regtest", Amount: 100000, Pubkey: pubkey}
+				},
+				"amount": func() PeerMessage {
+					return &SwapOutRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Network: "regtest", Scid: "100x2x3", Pubkey: pubkey}
+				},
+				"pubkey": func() PeerMessage {
+					return &SwapOutRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Network: "regtest", Scid: "100x2x3", Amount: 100000}
+				},
+			},
+		},
+		{
+			name:    "swap out agreement",
+			message: &SwapOutAgreementMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Pubkey: pubkey, Payreq: "fee"},
+			missing: map[string]func() PeerMessage{
+				"protocol_version": func() PeerMessage { return &SwapOutAgreementMessage{SwapId: swapId, Pubkey: pubkey, Payreq: "fee"} },
+				"swap_id": func() PeerMessage {
+					return &SwapOutAgreementMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, Pubkey: pubkey, Payreq: "fee"}
+				},
+				"pubkey": func() PeerMessage {
+					return &SwapOutAgreementMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Payreq: "fee"}
+				},
+				"payreq": func() PeerMessage {
+					return &SwapOutAgreementMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Pubkey: pubkey}
+				},
+			},
+		},
+		{
+			name:    "opening tx broadcasted",
+			message: &OpeningTxBroadcastedMessage{SwapId: swapId, Payreq: "claim", TxId: "txid"},
+			missing: map[string]func() PeerMessage{
+				"swap_id": func() PeerMessage { return &OpeningTxBroadcastedMessage{Payreq: "claim", TxId: "txid"} },
+				"payreq":  func() PeerMessage { return &OpeningTxBroadcastedMessage{SwapId: swapId, TxId: "txid"} },
+				"tx_id":   func() PeerMessage { return &OpeningTxBroadcastedMessage{SwapId: swapId, Payreq: "claim"} },
+			},
+		},
+		{
+			name:    "cancel",
+			message: &CancelMessage{SwapId: swapId},
+			missing: map[string]func() PeerMessage{
+				"swap_id": func() PeerMessage { return &CancelMessage{Message: "canceled"} },
+			},
+		},
+		{
+			name:    "coop close",
+			message: &CoopCloseMessage{SwapId: swapId, Privkey: "privkey"},
+			missing: map[string]func() PeerMessage{
+				"swap_id": func() PeerMessage { return &CoopCloseMessage{Privkey: "privkey"} },
+				"privkey": func() PeerMessage { return &CoopCloseMessage{SwapId: swapId} },
+			},
+		},
+		{
+			name:    "swap reunion",
+			message: &SwapReunionMessage{SwapId: swapId, State: State_SwapCanceled},
+			missing: map[string]func() PeerMessage{
+				"swap_id": func() PeerMessage { return &SwapReunionMessage{State: State_SwapCanceled} },
+				"state":   func() PeerMessage { return &SwapReunionMessage{SwapId: swapId} },
+			},
+		},
+		{
+			name:    "ping",
+			message: &PingMessage{SwapId: swapId},
+			missing: map[string]func() PeerMessage{
+				"swap_id": func() PeerMessage { return &PingMessage{} },
+			},
+		},
+		{
+			name:    "pong",
+			message: &PongMessage{SwapId: swapId},
+			missing: map[string]func() PeerMessage{
+				"swap_id": func() PeerMessage { return &PongMessage{} },
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msgBytes, msgType, err := MarshalPeerswapMessage(tc.message)
+			require.NoError(t, err)
+			assert.Equal(t, int(tc.message.MessageType()), msgType)
+			expected, err := json.Marshal(tc.message)
+			require.NoError(t, err)
+			assert.Equal(t, expected, msgBytes)
+
+			for field, message := range tc.missing {
+				_, _, err := MarshalPeerswapMessage(message())
+				assert.ErrorIs(t, err, MissingFieldError, field)
+				assert.Contains(t, err.Error(), field)
+			}
+		})
+	}
+}
+
+func Test_MarshalPeerswapMessage_Nil(t *testing.T) {
+	_, _, err := MarshalPeerswapMessage(nil)
+	assert.ErrorIs(t, err, NilMessageError)
+
+	var msg *CancelMessage
+	_, _, err = MarshalPeerswapMessage(msg)
+	assert.ErrorIs(t, err, NilMessageError)
+}
+
+func Test_ValidatePubkey(t *testing.T) {
+	valid := getRandom33ByteHexString()
+	for _, tc := range []struct {
+		name   string
+		pubkey string
+		valid  bool
+	}{
+		{name: "valid", pubkey: valid, valid: true},
+		{name: "not hex", pubkey: "zz" + valid[2:]},
+		{name: "too short", pubkey: valid[:64]},
+		{name: "too long", pubkey: valid + "00"},
+		{name: "uncompressed prefix", pubkey: "04" + valid[2:]},
+		{name: "not on the curve", pubkey: "02" + strings.Repeat("00", 32)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			messages := []interface{ Validate(*SwapData) error }{
+				&SwapInRequestMessage{Network: "mainnet", Scid: "100x2x3", Pubkey: tc.pubkey},
+				&SwapInAgreementMessage{Pubkey: tc.pubkey},
+				&SwapOutRequestMessage{Network: "mainnet", Scid: "100x2x3", Pubkey: tc.pubkey},
+				&SwapOutAgreementMessage{Pubkey: tc.pubkey},
+			}
+			for _, msg := range messages {
+				err := msg.Validate(&SwapData{})
+				if tc.valid {
+					assert.NoError(t, err, "%T", msg)
+				} else {
+					assert.Error(t, err, "%T", msg)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package swap
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "peerswap"
+
+// swapMetrics holds the prometheus metrics of the swap service. A nil
+// swapMetrics does not record anything.
+type swapMetrics struct {
+	started     *prometheus.CounterVec
+	completed   *prometheus.CounterVec
+	canceled    *prometheus.CounterVec
+	timedOut    *prometheus.CounterVec
+	activeSwaps prometheus.Gauge
+
+	stateDuration *prometheus.HistogramVec
+
+	unknownMessages *prometheus.CounterVec
+	orphanPayments  *prometheus.CounterVec
+
+	oversizedPayloads prometheus.Counter
+}
+
+// newSwapMetrics creates the swap metrics and registers them with the
+// registerer.
+func newSwapMetrics(registerer prometheus.Registerer) (*swapMetrics, error) {
+	labels := []string{"type", "role", "chain"}
+	m := &swapMetrics{
+		started: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "swaps_started_total",
+			Help:      "Number of swaps that were started.",
+		}, labels),
+		completed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "swaps_completed_total",
+			Help:      "Number of swaps that were claimed.",
+		}, labels),
+		canceled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "swaps_canceled_total",
+			Help:      "Number of swaps that were canceled.",
+		}, labels),
+		timedOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "swaps_timed_out_total",
+			Help:      "Number of swaps that timed out.",
+		}, labels),
+		activeSwaps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_swaps",
+			Help:      "Number of swaps that are currently active.",
+		}),
+		stateDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "swap_state_duration_seconds",
+			Help:      "Time that swaps spent in a state before leaving it.",
+			Buckets:   []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 7200, 21600, 86400},
+		}, []string{"state", "type", "chain"}),
+		unknownMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "unknown_messages_total",
+			Help:      "Number of received peer messages of an unknown type, by whether the type is in the peerswap message range.",
+		}, []string{"range"}),
+		orphanPayments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "orphan_payments_total",
+			Help:      "Number of invoice payments for swaps that are not active.",
+		}, []string{"invoice_type"}),
+		oversizedPayloads: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "oversized_payloads_total",
+			Help:      "Number of received peer messages that exceeded the maximum message size.",
+		}),
+	}
+
+	collectors := []prometheus.Collector{m.started, m.completed, m.canceled, m.timedOut, m.activeSwaps, m.stateDuration, m.unknownMessages, m.orphanPayments, m.oversizedPayloads}
+	for _, c := range collectors {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// swapLabels returns the label values for the swap.
+func swapLabels(swap *SwapStateMachine) prometheus.Labels {
+	var chain string
+	if swap.Data != nil {
+		chain = swap.Data.GetChain()
+	}
+	return prometheus.Labels{
+		"type":  swap.Type.String(),
+		"role":  swap.Role.String(),
+		"chain": chain,
+	}
+}
+
+func (m *swapMetrics) swapStarted(swap *SwapStateMachine) {
+	if m == nil {
+		return
+	}
+	m.started.With(swapLabels(swap)).Inc()
+}
+
+func (m *swapMetrics) swapTimedOut(swap *SwapStateMachine) {
+	if m == nil {
+		return
+	}
+	m.timedOut.With(swapLabels(swap)).Inc()
+}
+
+// swapFinished counts the swap as completed or canceled depending on its
+// final state.
+func (m *swapMetrics) swapFinished(swap *SwapStateMachine) {
+	if m == nil {
+		return
+	}
+	switch swap.Current {
+	case State_ClaimedPreimage, State_ClaimedCoop, State_ClaimedCsv:
+		m.completed.With(swapLabels(swap)).Inc()
+	case State_SwapCanceled, State_SwapAbandoned:
+		m.canceled.With(swapLabels(swap)).Inc()
+	}
+}
+
+func (m *swapMetrics) setActiveSwaps(n int) {
+	if m == nil {
+		return
+	}
+	m.activeSwaps.Set(float64(n))
+}
+
+// stateLeft observes the time that the swap spent in the state it just left.
+func (m *swapMetrics) stateLeft(swap *SwapStateMachine, state StateType, d time.Duration) {
+	if m == nil {
+		return
+	}
+	labels := swapLabels(swap)
+	m.stateDuration.With(prometheus.Labels{
+		"state": string(state),
+		"type":  labels["type"],
+		"chain": labels["chain"],
+	}).Observe(d.Seconds())
+}
+
+func (m *swapMetrics) unknownMessageReceived(inRange bool) {
+	if m == nil {
+		return
+	}
+	if inRange {
+		m.unknownMessages.WithLabelValues("in_range").Inc()
+	} else {
+		m.unknownMessages.WithLabelValues("out_of_range").Inc()
+	}
+}
+
+func (m *swapMetrics) orphanPaymentReceived(invoiceType InvoiceType) {
+	if m == nil {
+		return
+	}
+	m.orphanPayments.WithLabelValues(invoiceType.String()).Inc()
+}
+
+func (m *swapMetrics) oversizedPayloadReceived() {
+	if m == nil {
+		return
+	}
+	m.oversizedPayloads.Inc()
+}
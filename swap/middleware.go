@@ -0,0 +1,37 @@
+package swap
+
+// HandlerFunc handles a peer message of the hex encoded message type.
+type HandlerFunc func(peerId, msgType string, payload []byte) error
+
+// MessageMiddleware is called for every peer message with the next handler
+// of the chain. It can observe the message, pass a transformed message to
+// next or short-circuit the handling by not calling next.
+type MessageMiddleware func(peerId, msgType string, payload []byte, next HandlerFunc) error
+
+// AddMessageMiddleware registers a middleware around the handling of peer
+// messages in OnMessageReceived. Middlewares are called in the order they
+// are registered, the last one calls the built-in handling. Messages of
+// unknown types pass the middlewares too, so that a middleware can handle
+// experimental message types.
+func (s *SwapService) AddMessageMiddleware(fn func(peerId, msgType string, payload []byte, next HandlerFunc) error) {
+	s.Lock()
+	defer s.Unlock()
+	s.messageMiddlewares = append(s.messageMiddlewares, fn)
+}
+
+// messageHandler returns the built-in message handling wrapped in the
+// message middlewares.
+func (s *SwapService) messageHandler() HandlerFunc {
+	s.RLock()
+	middlewares := append([]MessageMiddleware{}, s.messageMiddlewares...)
+	s.RUnlock()
+
+	handler := HandlerFunc(s.handleMessage)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		middleware, next := middlewares[i], handler
+		handler = func(peerId, msgType string, payload []byte) error {
+			return middleware(peerId, msgType, payload, next)
+		}
+	}
+	return handler
+}
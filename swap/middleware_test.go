@@ -0,0 +1,96 @@
+package swap
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MessageMiddleware(t *testing.T) {
+	initiator, peer, pubkey, _, _ := getTestParams()
+	_, blockedPeer, _, _, _ := getTestParams()
+
+	service, messenger := getRecordingTestSetup(initiator)
+
+	var order []string
+	errBlocked := errors.New("peer is blocked")
+	service.AddMessageMiddleware(func(peerId, msgType string, payload []byte, next HandlerFunc) error {
+		order = append(order, "block")
+		if peerId == blockedPeer {
+			return errBlocked
+		}
+		return next(peerId, msgType, payload)
+	})
+	counts := map[string]int{}
+	service.AddMessageMiddleware(func(peerId, msgType string, payload []byte, next HandlerFunc) error {
+		order = append(order, "count")
+		counts[msgType]++
+		return next(peerId, msgType, payload)
+	})
+
+	request := func(peerId string, scid string) (*SwapId, error) {
+		swapId := NewSwapId()
+		payload, err := json.Marshal(&SwapOutRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            scid,
+			Amount:          100000,
+			Pubkey:          pubkey,
+		})
+		require.NoError(t, err)
+		return swapId, service.OnMessageReceived(peerId, messages.MessageTypeToHexString(messages.MESSAGETYPE_SWAPOUTREQUEST), payload)
+	}
+	requestType := messages.MessageTypeToHexString(messages.MESSAGETYPE_SWAPOUTREQUEST)
+
+	// The request of the blocked peer does not reach the other middleware
+	// and the built-in handling.
+	swapId, err := request(blockedPeer, "100x1x1")
+	assert.ErrorIs(t, err, errBlocked)
+	assert.Equal(t, []string{"block"}, order)
+	assert.Equal(t, 0, counts[requestType])
+	_, err = service.GetActiveSwap(swapId.String())
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+	assert.Empty(t, messenger.sent)
+
+	order = nil
+	swapId, err = request(peer, "100x1x2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"block", "count"}, order)
+	assert.Equal(t, 1, counts[requestType])
+	_, err = service.GetActiveSwap(swapId.String())
+	assert.NoError(t, err)
+
+	// Messages of unknown types pass the middlewares too.
+	require.NoError(t, service.OnMessageReceived(peer, "ffff", []byte("{}")))
+	assert.Equal(t, 1, counts["ffff"])
+}
+
+func Test_MessageMiddleware_PayloadTooLarge(t *testing.T) {
+	service := getTestSetup(aliceId)
+	require.NoError(t, service.swapServices.SetMetricsRegisterer(prometheus.NewRegistry()))
+	require.NoError(t, service.swapServices.SetMaxMessageSize(16))
+
+	// The middleware short-circuits every message, oversized payloads are
+	// rejected before they reach it.
+	var calls int
+	service.AddMessageMiddleware(func(peerId, msgType string, payload []byte, next HandlerFunc) error {
+		calls++
+		return nil
+	})
+
+	msgType := messages.MessageTypeToHexString(messages.MESSAGETYPE_POLL)
+	err := service.OnMessageReceived(bobId, msgType, make([]byte, 20))
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, float64(1), testutil.ToFloat64(service.swapServices.metrics.oversizedPayloads))
+
+	require.NoError(t, service.OnMessageReceived(bobId, msgType, make([]byte, 16)))
+	assert.Equal(t, 1, calls)
+}
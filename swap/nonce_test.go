@@ -0,0 +1,81 @@
+package swap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NonceReplay(t *testing.T) {
+	initiator, peer, pubkey, _, _ := getTestParams()
+
+	service, messenger := getRecordingTestSetup(initiator)
+
+	swapA, err := service.SwapOut(peer, btc_chain, "100x1x1", initiator, 100000)
+	require.NoError(t, err)
+	swapB, err := service.SwapOut(peer, btc_chain, "100x1x2", initiator, 100000)
+	require.NoError(t, err)
+	require.NotEmpty(t, swapA.Data.GetNonce())
+	assert.NotEqual(t, swapA.Data.GetNonce(), swapB.Data.GetNonce())
+
+	agreementA := SwapOutAgreementMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapA.SwapId,
+		Pubkey:          pubkey,
+		Payreq:          "fee",
+		Nonce:           swapA.Data.GetNonce(),
+	}
+
+	// The agreement of swap A is replayed against swap B.
+	replayed := agreementA
+	replayed.SwapId = swapB.SwapId
+	require.NoError(t, service.OnSwapOutAgreementReceived(&replayed))
+	assert.Equal(t, State_SwapCanceled, swapB.Current)
+	assert.Nil(t, swapB.Data.SwapOutAgreement)
+	msg := lastCancelMessage(t, messenger)
+	assert.Equal(t, swapB.SwapId, msg.SwapId)
+	assert.Equal(t, CancelReasonInvalidMessage, msg.Reason)
+
+	// Swap A accepts its own agreement.
+	assert.Equal(t, State_SwapOutSender_AwaitAgreement, swapA.Current)
+	require.NoError(t, service.OnSwapOutAgreementReceived(&agreementA))
+	assert.Equal(t, State_SwapOutSender_AwaitTxBroadcastedMessage, swapA.Current)
+}
+
+func Test_ValidateNonce(t *testing.T) {
+	nonceA, nonceB := newNonce(), newNonce()
+	privkey := getRandom32ByteHexString()
+	swapB := &SwapData{SwapInRequest: &SwapInRequestMessage{Nonce: nonceB}}
+
+	// A coop close message of swap A is rejected by swap B.
+	assert.ErrorIs(t, CoopCloseMessage{Privkey: privkey, Nonce: nonceA}.Validate(swapB), NonceMismatchError)
+	assert.NoError(t, CoopCloseMessage{Privkey: privkey, Nonce: nonceB}.Validate(swapB))
+
+	// Peers that do not know the nonce do not echo it.
+	assert.NoError(t, CoopCloseMessage{Privkey: privkey}.Validate(swapB))
+
+	// The nonce is missing once the agreement echoed it.
+	swapB.SwapInAgreement = &SwapInAgreementMessage{Nonce: nonceB}
+	assert.ErrorIs(t, CoopCloseMessage{Privkey: privkey}.Validate(swapB), NonceMismatchError)
+	assert.NoError(t, CoopCloseMessage{Privkey: privkey, Nonce: nonceB}.Validate(swapB))
+	swapOut := &SwapData{
+		SwapOutRequest:   &SwapOutRequestMessage{Nonce: nonceB},
+		SwapOutAgreement: &SwapOutAgreementMessage{Nonce: nonceB},
+	}
+	assert.ErrorIs(t, CoopCloseMessage{Privkey: privkey}.Validate(swapOut), NonceMismatchError)
+
+	// An agreement that did not echo the nonce does not require it.
+	swapOut.SwapOutAgreement.Nonce = ""
+	assert.NoError(t, CoopCloseMessage{Privkey: privkey}.Validate(swapOut))
+
+	// Swaps with peers that do not send a nonce are not checked.
+	legacy := &SwapData{SwapInRequest: &SwapInRequestMessage{}}
+	assert.NoError(t, CoopCloseMessage{Privkey: privkey, Nonce: nonceA}.Validate(legacy))
+
+	// A request nonce must be 32 bytes.
+	request := SwapOutRequestMessage{Network: "mainnet", Scid: "100x2x3", Pubkey: getRandom33ByteHexString(), Nonce: "abcd"}
+	assert.Error(t, request.Validate(&SwapData{}))
+	request.Nonce = nonceA
+	assert.NoError(t, request.Validate(&SwapData{}))
+}
@@ -0,0 +1,70 @@
+package swap
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+// claimedEvents returns the number of swaps that were claimed so far.
+func claimedEvents(events <-chan SwapEvent) int {
+	return len(drainEvents(events, func(event SwapEvent) bool {
+		return event.Kind == SwapEventTransition && event.NewState == State_ClaimedPreimage
+	}))
+}
+
+func Test_OnPayment_ProcessedOnce(t *testing.T) {
+	_, peer, _, _, _ := getTestParams()
+	path := filepath.Join(t.TempDir(), "swaps")
+	swapId := NewSwapId()
+
+	// newService returns a service on the store at path with an active swap
+	// that awaits the payment of the claim invoice.
+	newService := func() (*SwapService, *bbolt.DB, *SwapStateMachine) {
+		db, err := bbolt.Open(path, 0700, nil)
+		require.NoError(t, err)
+		store, err := NewBboltStore(db)
+		require.NoError(t, err)
+
+		service, _ := getRecordingTestSetup(peer)
+		service.swapServices.swapStore = store
+
+		swap := newSwapOutReceiverFSM(swapId, service.swapServices, peer)
+		swap.Current = State_SwapOutReceiver_AwaitClaimInvoicePayment
+		service.AddActiveSwap(swapId.String(), swap)
+		return service, db, swap
+	}
+
+	service, db, swap := newService()
+	events, unsubscribe := service.Subscribe()
+	service.OnPayment(swapId.String(), INVOICE_CLAIM)
+	assert.Equal(t, 1, claimedEvents(events))
+	assert.Equal(t, State_ClaimedPreimage, swap.Current)
+
+	// The replayed payment is skipped, even if the swap awaits the payment.
+	swap = newSwapOutReceiverFSM(swapId, service.swapServices, peer)
+	swap.Current = State_SwapOutReceiver_AwaitClaimInvoicePayment
+	service.AddActiveSwap(swapId.String(), swap)
+	service.OnPayment(swapId.String(), INVOICE_CLAIM)
+	assert.Equal(t, 0, claimedEvents(events))
+	assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, swap.Current)
+	unsubscribe()
+	require.NoError(t, db.Close())
+
+	// The processed payment survives a restart.
+	service, db, swap = newService()
+	defer db.Close()
+	events, unsubscribe = service.Subscribe()
+	defer unsubscribe()
+	service.OnPayment(swapId.String(), INVOICE_CLAIM)
+	assert.Equal(t, 0, claimedEvents(events))
+	assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, swap.Current)
+
+	// The fee invoice of the swap is a different payment.
+	processed, err := service.swapServices.swapStore.(ProcessedPaymentStore).IsPaymentProcessed("fee_" + swapId.String())
+	require.NoError(t, err)
+	assert.False(t, processed)
+}
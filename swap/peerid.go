@@ -0,0 +1,54 @@
+package swap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// peerIdLength is the length of the hex encoded compressed pubkey of a node.
+const peerIdLength = 66
+
+// InvalidPeerIdError is returned if a peer id is not the hex encoded pubkey
+// of a node.
+type InvalidPeerIdError string
+
+func (e InvalidPeerIdError) Error() string {
+	return fmt.Sprintf("peer id %q is not a hex encoded node pubkey", string(e))
+}
+
+func (e InvalidPeerIdError) Is(target error) bool {
+	return target == ErrInvalidPeerId
+}
+
+// SetCheckPeerIds sets whether the peer ids of new swaps and received
+// messages must be hex encoded node pubkeys. The check is enabled by
+// default, the peer ids are lowercased either way.
+func (s *SwapServices) SetCheckPeerIds(check bool) {
+	s.checkPeerIds = check
+}
+
+// canonicalPeerId returns the lowercase form of the peer id. Every peer id
+// that is stored for a later lookup is canonicalized, so that it matches the
+// normalized peer ids of swaps and messages.
+func canonicalPeerId(peerId string) string {
+	return strings.ToLower(strings.TrimSpace(peerId))
+}
+
+// normalizePeerId returns the lowercase form of the peer id, so that the
+// different representations of a node pubkey compare equal. It returns
+// InvalidPeerIdError if the check is enabled and the peer id is not a hex
+// encoded node pubkey.
+func (s *SwapServices) normalizePeerId(peerId string) (string, error) {
+	normalized := canonicalPeerId(peerId)
+	if !s.checkPeerIds {
+		return normalized, nil
+	}
+	if len(normalized) != peerIdLength {
+		return "", InvalidPeerIdError(peerId)
+	}
+	if _, err := hex.DecodeString(normalized); err != nil {
+		return "", InvalidPeerIdError(peerId)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,88 @@
+package swap
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NormalizePeerId(t *testing.T) {
+	peerId := getRandom33ByteHexString()
+	services := getTestSetup(aliceId).swapServices
+	services.SetCheckPeerIds(true)
+
+	for _, id := range []string{peerId, strings.ToUpper(peerId), " " + peerId + "\n"} {
+		normalized, err := services.normalizePeerId(id)
+		require.NoError(t, err)
+		assert.Equal(t, peerId, normalized)
+	}
+
+	for _, id := range []string{"", "bob", peerId[2:], peerId + "00", "zz" + peerId[2:]} {
+		_, err := services.normalizePeerId(id)
+		assert.ErrorIs(t, err, ErrInvalidPeerId, id)
+	}
+
+	services.SetCheckPeerIds(false)
+	normalized, err := services.normalizePeerId("Bob")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", normalized)
+}
+
+// Test_PeerIdCasing checks that differently cased representations of the
+// pubkey of the peer are treated as the same peer.
+func Test_PeerIdCasing(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	upperPeer := strings.ToUpper(peer)
+	mixedPeer := peer[:33] + strings.ToUpper(peer[33:])
+
+	service := getTestSetup(initiator)
+	service.swapServices.SetCheckPeerIds(true)
+	service.swapServices.messenger = &recordingMessenger{}
+	require.NoError(t, service.Start())
+	service.swapServices.toService = &timeOutDummy{}
+
+	swap, err := service.SwapOut(upperPeer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	assert.Equal(t, peer, swap.Data.PeerNodeId)
+
+	msgType := messages.MessageTypeToHexString(messages.MESSAGETYPE_CANCELED)
+	msgBytes, err := json.Marshal(&CancelMessage{SwapId: swap.SwapId, Message: "canceled"})
+	require.NoError(t, err)
+
+	err = service.OnMessageReceived("bob", msgType, msgBytes)
+	assert.ErrorIs(t, err, ErrInvalidPeerId)
+
+	err = service.OnMessageReceived(getRandom33ByteHexString(), msgType, msgBytes)
+	assert.Error(t, err)
+	assert.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Current)
+
+	require.NoError(t, service.OnMessageReceived(mixedPeer, msgType, msgBytes))
+	assert.Equal(t, State_SwapCanceled, swap.Current)
+}
+
+// Test_PeerIdCasing_Settings checks that peer ids that are configured in a
+// different casing match the normalized peer id of a request.
+func Test_PeerIdCasing_Settings(t *testing.T) {
+	_, peer, _, _, _ := getTestParams()
+	upperPeer := strings.ToUpper(peer)
+	service := getTestSetup(aliceId)
+	setFilePolicy(t, service)
+
+	require.NoError(t, service.ReloadAllowlist([]string{upperPeer}))
+	assert.True(t, service.isPeerOnAllowlist(peer))
+
+	require.NoError(t, service.ReloadBlocklist([]string{upperPeer}))
+	assert.True(t, service.isPeerBlocked(peer))
+
+	service.swapServices.SetPeerPremium(upperPeer, 42)
+	assert.Equal(t, uint64(42), service.swapServices.getPremium(peer))
+
+	require.NoError(t, service.swapServices.SetPeerPolicy(upperPeer, PeerPolicy{PremiumSat: 7}))
+	assert.Equal(t, uint64(7), service.swapServices.getPremium(peer))
+	service.swapServices.RemovePeerPolicy(upperPeer)
+	assert.Equal(t, uint64(42), service.swapServices.getPremium(peer))
+}
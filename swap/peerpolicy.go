@@ -0,0 +1,102 @@
+package swap
+
+import (
+	"fmt"
+	"time"
+)
+
+// PeerPolicy is the request policy for a single peer, e.g. a trusted node of
+// the same operator. It replaces the swap amount limits, the premium and the
+// request rate limit of the services for the swaps that the peer requests.
+// Peers without a policy are handled with the settings of the services.
+type PeerPolicy struct {
+	// MinSwapAmountSat and MaxSwapAmountSat limit the amount of a swap. A
+	// limit of 0 disables the check.
+	MinSwapAmountSat uint64
+	MaxSwapAmountSat uint64
+	// PremiumSat is the premium that is asked for in the agreement.
+	PremiumSat uint64
+	// RequestRateLimit is the number of swap requests the peer may send per
+	// RequestRateInterval. A limit of 0 disables the rate limiting.
+	RequestRateLimit    int
+	RequestRateInterval time.Duration
+}
+
+// peerPolicy is a PeerPolicy with the rate limiter of the peer.
+type peerPolicy struct {
+	PeerPolicy
+	rateLimiter *peerRateLimiter
+}
+
+// SetPeerPolicy sets the request policy for the peer. It takes precedence
+// over the swap amount limits, the premium and the request rate limit of the
+// services, including a premium set with SetPeerPremium.
+func (s *SwapServices) SetPeerPolicy(peerId string, policy PeerPolicy) error {
+	peerId = canonicalPeerId(peerId)
+	if err := validateHexString("peer", peerId, 33); err != nil {
+		return err
+	}
+	if policy.MaxSwapAmountSat != 0 && policy.MinSwapAmountSat > policy.MaxSwapAmountSat {
+		return fmt.Errorf("minimum swap amount %d sat is greater than maximum swap amount %d sat", policy.MinSwapAmountSat, policy.MaxSwapAmountSat)
+	}
+	if policy.RequestRateLimit < 0 {
+		return fmt.Errorf("request rate limit must not be negative, got %d", policy.RequestRateLimit)
+	}
+
+	var rateLimiter *peerRateLimiter
+	if policy.RequestRateLimit > 0 {
+		if policy.RequestRateInterval <= 0 {
+			return fmt.Errorf("request rate interval must be positive, got %v", policy.RequestRateInterval)
+		}
+		rateLimiter = newPeerRateLimiter(policy.RequestRateLimit, policy.RequestRateInterval)
+	}
+
+	s.peerSettingsMutex.Lock()
+	defer s.peerSettingsMutex.Unlock()
+	if s.peerPolicies == nil {
+		s.peerPolicies = map[string]*peerPolicy{}
+	}
+	s.peerPolicies[peerId] = &peerPolicy{PeerPolicy: policy, rateLimiter: rateLimiter}
+	return nil
+}
+
+// RemovePeerPolicy removes the request policy of the peer, so that the peer is
+// handled with the settings of the services again.
+func (s *SwapServices) RemovePeerPolicy(peerId string) {
+	s.peerSettingsMutex.Lock()
+	defer s.peerSettingsMutex.Unlock()
+	delete(s.peerPolicies, canonicalPeerId(peerId))
+}
+
+// allowRequest consumes a token of the request rate limit that applies to the
+// peer and returns false if the peer sends too many requests.
+func (s *SwapServices) allowRequest(peerId string) bool {
+	if policy, ok := s.getPeerPolicy(peerId); ok {
+		return policy.rateLimiter.allow(peerId)
+	}
+	return s.requestRateLimiter.allow(peerId)
+}
+
+// getPeerPolicy returns the request policy of the peer, if it has one.
+func (s *SwapServices) getPeerPolicy(peerId string) (*peerPolicy, bool) {
+	s.peerSettingsMutex.RLock()
+	defer s.peerSettingsMutex.RUnlock()
+	policy, ok := s.peerPolicies[peerId]
+	return policy, ok
+}
+
+// checkPeerSwapAmount returns an error if the amount of a swap requested by
+// the peer is out of the swap amount limits that apply to the peer.
+func (s *SwapServices) checkPeerSwapAmount(peerId string, amtSat uint64) error {
+	policy, ok := s.getPeerPolicy(peerId)
+	if !ok {
+		return s.checkSwapAmount(amtSat)
+	}
+	if policy.MinSwapAmountSat != 0 && amtSat < policy.MinSwapAmountSat {
+		return ErrMinimumSwapSize(policy.MinSwapAmountSat * 1000)
+	}
+	if policy.MaxSwapAmountSat != 0 && amtSat > policy.MaxSwapAmountSat {
+		return ErrMaximumSwapSize(policy.MaxSwapAmountSat * 1000)
+	}
+	return nil
+}
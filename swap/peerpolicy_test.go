@@ -0,0 +1,153 @@
+package swap
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lastAgreementMessage returns the last swap out agreement that was sent or
+// nil if none was sent.
+func lastAgreementMessage(t *testing.T, messenger *recordingMessenger) *SwapOutAgreementMessage {
+	messenger.Lock()
+	defer messenger.Unlock()
+	for i := len(messenger.sent) - 1; i >= 0; i-- {
+		if messenger.sent[i].msgType != int(messages.MESSAGETYPE_SWAPOUTAGREEMENT) {
+			continue
+		}
+		var msg *SwapOutAgreementMessage
+		require.NoError(t, json.Unmarshal(messenger.sent[i].payload, &msg))
+		return msg
+	}
+	return nil
+}
+
+func Test_PeerPolicy(t *testing.T) {
+	initiator, _, pubkey, _, _ := getTestParams()
+	_, normalPeer, _, _, _ := getTestParams()
+	_, trustedPeer, _, _, _ := getTestParams()
+
+	// Every request uses another channel, so that the swaps do not block each
+	// other.
+	var channels int
+	request := func(service *SwapService, peerId string, amount uint64) (*SwapId, error) {
+		channels++
+		swapId := NewSwapId()
+		return swapId, service.OnSwapOutRequestReceived(swapId, peerId, &SwapOutRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            fmt.Sprintf("100x1x%d", channels),
+			Amount:          amount,
+			Pubkey:          pubkey,
+		})
+	}
+
+	t.Run("swap amount", func(t *testing.T) {
+		service, messenger := getRecordingTestSetup(initiator)
+		require.NoError(t, service.swapServices.SetSwapAmountLimits(0, 50000))
+		require.NoError(t, service.swapServices.SetPeerPolicy(trustedPeer, PeerPolicy{MaxSwapAmountSat: 200000}))
+
+		swapId, _ := request(service, normalPeer, 100000)
+		msg := lastCancelMessage(t, messenger)
+		assert.Equal(t, swapId, msg.SwapId)
+		assert.Equal(t, CancelReasonInvalidAmount, msg.Reason)
+
+		swapId, err := request(service, trustedPeer, 100000)
+		require.NoError(t, err)
+		agreement := lastAgreementMessage(t, messenger)
+		require.NotNil(t, agreement)
+		assert.Equal(t, swapId, agreement.SwapId)
+
+		// The limit of the policy applies to the trusted peer.
+		swapId, _ = request(service, trustedPeer, 300000)
+		msg = lastCancelMessage(t, messenger)
+		assert.Equal(t, swapId, msg.SwapId)
+		assert.Equal(t, CancelReasonInvalidAmount, msg.Reason)
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		service, messenger := getRecordingTestSetup(initiator)
+		require.NoError(t, service.swapServices.SetRequestRateLimit(1, time.Hour))
+		require.NoError(t, service.swapServices.SetPeerPolicy(trustedPeer, PeerPolicy{}))
+
+		_, err := request(service, normalPeer, 100000)
+		require.NoError(t, err)
+		swapId, _ := request(service, normalPeer, 100000)
+		msg := lastCancelMessage(t, messenger)
+		assert.Equal(t, swapId, msg.SwapId)
+		assert.Equal(t, CancelReasonRateLimited, msg.Reason)
+
+		for i := 0; i < 3; i++ {
+			swapId, err := request(service, trustedPeer, 100000)
+			require.NoError(t, err)
+			assert.Equal(t, swapId, lastAgreementMessage(t, messenger).SwapId)
+		}
+
+		// Without the policy the trusted peer is rate limited again.
+		service.swapServices.RemovePeerPolicy(trustedPeer)
+		_, err = request(service, trustedPeer, 100000)
+		require.NoError(t, err)
+		swapId, _ = request(service, trustedPeer, 100000)
+		assert.Equal(t, CancelReasonRateLimited, lastCancelMessage(t, messenger).Reason)
+		assert.Equal(t, swapId, lastCancelMessage(t, messenger).SwapId)
+	})
+
+	t.Run("premium", func(t *testing.T) {
+		service, messenger := getRecordingTestSetup(initiator)
+		service.swapServices.SetDefaultPremium(1000)
+		service.swapServices.SetPeerPremium(trustedPeer, 500)
+		require.NoError(t, service.swapServices.SetPeerPolicy(trustedPeer, PeerPolicy{}))
+
+		_, err := request(service, normalPeer, 100000)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1000), lastAgreementMessage(t, messenger).Premium)
+
+		_, err = request(service, trustedPeer, 100000)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), lastAgreementMessage(t, messenger).Premium)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		services := getTestSetup(initiator).swapServices
+		assert.Error(t, services.SetPeerPolicy("peer", PeerPolicy{}))
+		assert.Error(t, services.SetPeerPolicy(trustedPeer, PeerPolicy{MinSwapAmountSat: 2, MaxSwapAmountSat: 1}))
+		assert.Error(t, services.SetPeerPolicy(trustedPeer, PeerPolicy{RequestRateLimit: -1}))
+		assert.Error(t, services.SetPeerPolicy(trustedPeer, PeerPolicy{RequestRateLimit: 1}))
+		assert.Empty(t, services.peerPolicies)
+	})
+}
+
+// Test_PeerPolicy_Concurrent changes the peer settings while they are read by
+// the request handlers. Run with -race.
+func Test_PeerPolicy_Concurrent(t *testing.T) {
+	_, peer, _, _, _ := getTestParams()
+	services := getTestSetup(aliceId).swapServices
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, services.SetPeerPolicy(peer, PeerPolicy{PremiumSat: uint64(i)}))
+			services.SetPeerPremium(peer, uint64(i))
+			services.SetDefaultPremium(uint64(i))
+			services.RemovePeerPolicy(peer)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			services.allowRequest(peer)
+			_ = services.checkPeerSwapAmount(peer, 100000)
+			services.getPremium(peer)
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,65 @@
+package swap
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/elementsproject/peerswap/messages"
+)
+
+// pendingMessageType names a message type that a swap stores as its next
+// message and creates the struct that the message decodes into.
+type pendingMessageType struct {
+	name     string
+	newEmpty func() PeerMessage
+}
+
+var pendingMessageTypes = map[messages.MessageType]pendingMessageType{
+	messages.MESSAGETYPE_SWAPINREQUEST: {"swap_in_request", func() PeerMessage {
+		return &SwapInRequestMessage{}
+	}},
+	messages.MESSAGETYPE_SWAPOUTREQUEST: {"swap_out_request", func() PeerMessage {
+		return &SwapOutRequestMessage{}
+	}},
+	messages.MESSAGETYPE_SWAPINAGREEMENT: {"swap_in_agreement", func() PeerMessage {
+		return &SwapInAgreementMessage{}
+	}},
+	messages.MESSAGETYPE_SWAPOUTAGREEMENT: {"swap_out_agreement", func() PeerMessage {
+		return &SwapOutAgreementMessage{}
+	}},
+	messages.MESSAGETYPE_OPENINGTXBROADCASTED: {"opening_tx_broadcasted", func() PeerMessage {
+		return &OpeningTxBroadcastedMessage{}
+	}},
+	messages.MESSAGETYPE_COOPCLOSE: {"coop_close", func() PeerMessage {
+		return &CoopCloseMessage{}
+	}},
+}
+
+// InspectPendingMessage returns the name of the type of the message that the
+// swap sends next, or sent last, and the message decoded into its struct,
+// e.g. *SwapOutAgreementMessage. It helps to debug swaps that are stuck.
+// ErrNoPendingMessage is returned if the swap has no message.
+func (s *SwapService) InspectPendingMessage(swapId string) (msgType string, decoded interface{}, err error) {
+	swap, err := s.getSwap(swapId)
+	if err != nil {
+		return "", nil, err
+	}
+
+	swap.mutex.Lock()
+	payload := swap.Data.NextMessage
+	nextType := messages.MessageType(swap.Data.NextMessageType)
+	swap.mutex.Unlock()
+
+	if len(payload) == 0 {
+		return "", nil, fmt.Errorf("%w: %s", ErrNoPendingMessage, swapId)
+	}
+	pending, ok := pendingMessageTypes[nextType]
+	if !ok {
+		return "", nil, fmt.Errorf("pending message of swap %s has unknown type %s", swapId, messages.MessageTypeToHexString(nextType))
+	}
+	msg := pending.newEmpty()
+	if err := json.Unmarshal(payload, msg); err != nil {
+		return "", nil, fmt.Errorf("could not decode pending %s message of swap %s: %w", pending.name, swapId, err)
+	}
+	return pending.name, msg, nil
+}
@@ -0,0 +1,75 @@
+package swap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_InspectPendingMessage(t *testing.T) {
+	initiator, peer, pubkey, _, channelId := getTestParams()
+	service := getTestSetup(initiator)
+
+	swapId := NewSwapId()
+	nonce := getRandom32ByteHexString()
+	for _, tc := range []struct {
+		msgType string
+		message PeerMessage
+	}{
+		{
+			msgType: "swap_in_request",
+			message: &SwapInRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Network: "mainnet", Scid: channelId, Amount: 100000, Pubkey: pubkey, Nonce: nonce},
+		},
+		{
+			msgType: "swap_out_request",
+			message: &SwapOutRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Network: "mainnet", Scid: channelId, Amount: 100000, Pubkey: pubkey, Nonce: nonce},
+		},
+		{
+			msgType: "swap_in_agreement",
+			message: &SwapInAgreementMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Pubkey: pubkey, Premium: 100, Nonce: nonce},
+		},
+		{
+			msgType: "swap_out_agreement",
+			message: &SwapOutAgreementMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: swapId, Pubkey: pubkey, Payreq: "invoice", Nonce: nonce},
+		},
+		{
+			msgType: "opening_tx_broadcasted",
+			message: &OpeningTxBroadcastedMessage{SwapId: swapId, Payreq: "invoice", TxId: getRandom32ByteHexString()},
+		},
+		{
+			msgType: "coop_close",
+			message: &CoopCloseMessage{SwapId: swapId, Message: "canceled", Privkey: getRandom32ByteHexString(), Nonce: nonce},
+		},
+	} {
+		t.Run(tc.msgType, func(t *testing.T) {
+			payload, msgType, err := MarshalPeerswapMessage(tc.message)
+			require.NoError(t, err)
+
+			swap := newSwapOutSenderFSM(service.swapServices, initiator, peer)
+			swap.Data.NextMessage = payload
+			swap.Data.NextMessageType = msgType
+			service.AddActiveSwap(swap.SwapId.String(), swap)
+			defer service.RemoveActiveSwap(swap.SwapId.String())
+
+			gotType, decoded, err := service.InspectPendingMessage(swap.SwapId.String())
+			require.NoError(t, err)
+			assert.Equal(t, tc.msgType, gotType)
+			assert.Equal(t, tc.message, decoded)
+		})
+	}
+
+	t.Run("no pending message", func(t *testing.T) {
+		swap := newSwapOutSenderFSM(service.swapServices, initiator, peer)
+		service.AddActiveSwap(swap.SwapId.String(), swap)
+		defer service.RemoveActiveSwap(swap.SwapId.String())
+
+		_, _, err := service.InspectPendingMessage(swap.SwapId.String())
+		assert.ErrorIs(t, err, ErrNoPendingMessage)
+	})
+
+	t.Run("unknown swap", func(t *testing.T) {
+		_, _, err := service.InspectPendingMessage(NewSwapId().String())
+		assert.Error(t, err)
+	})
+}
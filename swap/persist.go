@@ -0,0 +1,73 @@
+package swap
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetPersistWindow enables the coalescing of the store writes of a swap. A
+// swap that was stored within the window is not stored again on every
+// transition, the transitions are stored with a single write once the window
+// passed. Pending transitions are always stored before the swap sends a
+// message, pays an invoice or takes an on-chain action, and when the swap
+// finishes. A window of 0 stores every transition, which is the default.
+func (s *SwapServices) SetPersistWindow(window time.Duration) error {
+	if window < 0 {
+		return fmt.Errorf("persist window must not be negative, got %v", window)
+	}
+	s.persistWindow = window
+	return nil
+}
+
+// persist stores the swap, or defers the write to the end of the persist
+// window if the swap was stored within the window. The mutex must be held.
+func (s *SwapStateMachine) persist() error {
+	window := s.swapServices.persistWindow
+	since := time.Since(s.persistedAt)
+	if window == 0 || since >= window {
+		return s.write()
+	}
+	if !s.dirty {
+		s.dirty = true
+		time.AfterFunc(window-since, s.flushDeferred)
+	}
+	return nil
+}
+
+// flush stores the swap if a write was deferred. The mutex must be held.
+func (s *SwapStateMachine) flush() error {
+	if !s.dirty {
+		return nil
+	}
+	return s.write()
+}
+
+// write stores the swap. The mutex must be held.
+func (s *SwapStateMachine) write() error {
+	if err := s.swapServices.swapStore.UpdateData(s); err != nil {
+		return err
+	}
+	s.dirty = false
+	s.persistedAt = time.Now()
+	return nil
+}
+
+// flushDeferred stores the swap at the end of the persist window.
+func (s *SwapStateMachine) flushDeferred() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.flush(); err != nil {
+		s.logger().Warnf("[FSM] Could not store swap: %v", err)
+	}
+}
+
+// flushPending stores the transitions of the swap whose write was deferred.
+// Actions call it before they send a message, pay an invoice or take an
+// on-chain action, so that the stored swap is never behind the swap partner
+// or the chain.
+func (s *SwapData) flushPending() error {
+	if s.flush == nil {
+		return nil
+	}
+	return s.flush()
+}
@@ -0,0 +1,104 @@
+package swap
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore records the states of the swaps it stores.
+type countingStore struct {
+	Store
+	sync.Mutex
+	stored []StateType
+}
+
+func (c *countingStore) UpdateData(swap *SwapStateMachine) error {
+	c.Lock()
+	c.stored = append(c.stored, swap.Current)
+	c.Unlock()
+	return c.Store.UpdateData(swap)
+}
+
+func (c *countingStore) writes() []StateType {
+	c.Lock()
+	defer c.Unlock()
+	return append([]StateType{}, c.stored...)
+}
+
+// storeCheckingMessenger records the last stored state of the swap whenever
+// a message is sent.
+type storeCheckingMessenger struct {
+	recordingMessenger
+	store         *countingStore
+	storedOnSends []StateType
+}
+
+func (m *storeCheckingMessenger) SendMessage(peerId string, message []byte, messageType int) error {
+	writes := m.store.writes()
+	m.storedOnSends = append(m.storedOnSends, writes[len(writes)-1])
+	return m.recordingMessenger.SendMessage(peerId, message, messageType)
+}
+
+func Test_PersistWindow(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+
+	newService := func(t *testing.T, window time.Duration) (*SwapService, *countingStore, *storeCheckingMessenger) {
+		service := getTestSetup(initiator)
+		store := &countingStore{Store: service.swapServices.swapStore}
+		service.swapServices.swapStore = store
+		messenger := &storeCheckingMessenger{store: store}
+		service.swapServices.messenger = messenger
+		service.swapServices.toService = &timeOutDummy{}
+		require.NoError(t, service.swapServices.SetPersistWindow(window))
+		return service, store, messenger
+	}
+	// cancel runs a swap out through the swap request and the cancel of the
+	// swap partner.
+	cancel := func(t *testing.T, service *SwapService) *SwapStateMachine {
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		require.NoError(t, service.OnCancelReceived(swap.SwapId, &CancelMessage{SwapId: swap.SwapId, Message: "no"}))
+		return swap
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.Error(t, getTestSetup(initiator).swapServices.SetPersistWindow(-time.Second))
+	})
+
+	t.Run("rapid transitions", func(t *testing.T) {
+		service, store, _ := newService(t, 0)
+		cancel(t, service)
+		unbatched := len(store.writes())
+
+		service, store, messenger := newService(t, time.Hour)
+		swap := cancel(t, service)
+		assert.Equal(t, State_SwapCanceled, swap.Current)
+		writes := store.writes()
+		assert.Less(t, len(writes), unbatched)
+		// The finished swap is stored.
+		assert.Equal(t, State_SwapCanceled, writes[len(writes)-1])
+		// The swap was stored in the sending state before the request was
+		// sent.
+		assert.Equal(t, []StateType{State_SwapOutSender_SendRequest}, messenger.storedOnSends)
+	})
+
+	t.Run("flush after window", func(t *testing.T) {
+		service, store, _ := newService(t, 10*time.Millisecond)
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+
+		// The transition to the awaiting state is stored once the window
+		// passed.
+		assert.Eventually(t, func() bool {
+			writes := store.writes()
+			return writes[len(writes)-1] == State_SwapOutSender_AwaitAgreement
+		}, time.Second, 5*time.Millisecond)
+		swap.mutex.Lock()
+		assert.False(t, swap.dirty)
+		swap.mutex.Unlock()
+	})
+}
@@ -0,0 +1,57 @@
+package swap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStoreCannotDelete is returned if the store does not support the
+// deletion of swaps.
+var ErrStoreCannotDelete = errors.New("store can not delete swaps")
+
+// PruneSwaps deletes the finished swaps that were last updated before the
+// cutoff from the store and returns how many were deleted. Active swaps and
+// swaps in a non-terminal state are never deleted.
+func (s *SwapService) PruneSwaps(olderThan time.Time) (int, error) {
+	store, ok := s.swapServices.swapStore.(DeletingStore)
+	if !ok {
+		return 0, ErrStoreCannotDelete
+	}
+
+	cutoff := olderThan.Unix()
+	swaps, err := s.ListSwapsWhere(func(swap *SwapStateMachine) bool {
+		if !swap.IsFinished() || swap.Data == nil {
+			return false
+		}
+		updatedAt := swap.Data.UpdatedAt
+		if updatedAt == 0 {
+			updatedAt = swap.Data.CreatedAt
+		}
+		return updatedAt < cutoff
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var pruned int
+	for _, swap := range swaps {
+		swapId := swap.SwapId.String()
+		if _, err := s.GetActiveSwap(swapId); err == nil {
+			continue
+		}
+		// Keep the on-chain cost of a failed swap for the statistics.
+		if err := s.recordFailedSwapCost(swap); err != nil {
+			return pruned, fmt.Errorf("could not store the cost of swap %s: %w", swapId, err)
+		}
+		if err := store.DeleteById(swapId); err != nil {
+			return pruned, fmt.Errorf("could not delete swap %s: %w", swapId, err)
+		}
+		pruned++
+	}
+	if pruned > 0 {
+		s.invalidateStats()
+		s.swapServices.logger.Infof("[SwapService] Pruned %d finished swaps older than %s", pruned, olderThan.Format(time.RFC3339))
+	}
+	return pruned, nil
+}
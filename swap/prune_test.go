@@ -0,0 +1,82 @@
+package swap
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func Test_PruneSwaps(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = store
+
+	now := time.Now()
+	days := func(n int) int64 { return now.Add(time.Duration(-n) * 24 * time.Hour).Unix() }
+
+	swaps := map[string]*SwapStateMachine{
+		"claimed 40d":      {Current: State_ClaimedPreimage, Data: &SwapData{UpdatedAt: days(40)}},
+		"canceled 31d":     {Current: State_SwapCanceled, Data: &SwapData{UpdatedAt: days(31)}},
+		"csv 35d":          {Current: State_ClaimedCsv, Data: &SwapData{UpdatedAt: days(35)}},
+		"abandoned 60d":    {Current: State_SwapAbandoned, Data: &SwapData{CreatedAt: days(60)}},
+		"claimed 10d":      {Current: State_ClaimedPreimage, Data: &SwapData{UpdatedAt: days(10)}},
+		"canceled 1d":      {Current: State_SwapCanceled, Data: &SwapData{UpdatedAt: days(1)}},
+		"awaiting 50d":     {Current: State_SwapOutSender_AwaitTxConfirmation, Data: &SwapData{UpdatedAt: days(50)}},
+		"active coop 45d":  {Current: State_ClaimedCoop, Data: &SwapData{UpdatedAt: days(45)}},
+		"active await 45d": {Current: State_SwapInSender_AwaitClaimPayment, Data: &SwapData{UpdatedAt: days(45)}},
+	}
+	names := map[string]string{}
+	for name, swap := range swaps {
+		swap.SwapId = NewSwapId()
+		require.NoError(t, store.UpdateData(swap))
+		names[swap.SwapId.String()] = name
+	}
+	// The swap is finished but not yet removed from the active swaps.
+	service.AddActiveSwap(swaps["active coop 45d"].SwapId.String(), swaps["active coop 45d"])
+	service.AddActiveSwap(swaps["active await 45d"].SwapId.String(), swaps["active await 45d"])
+
+	remaining := func() []string {
+		all, err := service.ListSwaps()
+		require.NoError(t, err)
+		var got []string
+		for _, swap := range all {
+			got = append(got, names[swap.SwapId.String()])
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	pruned, err := service.PruneSwaps(now.Add(-30 * 24 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 4, pruned)
+	assert.Equal(t, []string{"active await 45d", "active coop 45d", "awaiting 50d", "canceled 1d", "claimed 10d"}, remaining())
+
+	// Nothing is left to prune with the same cutoff.
+	pruned, err = service.PruneSwaps(now.Add(-30 * 24 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, pruned)
+
+	// The finished active swap is pruned once it was removed.
+	service.RemoveActiveSwap(swaps["active coop 45d"].SwapId.String())
+	pruned, err = service.PruneSwaps(now)
+	require.NoError(t, err)
+	assert.Equal(t, 3, pruned)
+	assert.Equal(t, []string{"active await 45d", "awaiting 50d"}, remaining())
+}
+
+func Test_PruneSwaps_StoreCannotDelete(t *testing.T) {
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = &dummyStore{dataMap: map[string]*SwapStateMachine{}}
+	_, err := service.PruneSwaps(time.Now())
+	assert.ErrorIs(t, err, ErrStoreCannotDelete)
+}
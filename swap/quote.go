@@ -0,0 +1,61 @@
+package swap
+
+// SwapQuote holds the estimated costs of a swap that we would initiate.
+type SwapQuote struct {
+	Type      SwapType
+	Chain     string
+	AmountSat uint64
+	// OpeningTxFeeSat is the estimated fee of the opening transaction. On a
+	// swap-out it is payed to the peer with the fee invoice, on a swap-in
+	// it is payed by our wallet.
+	OpeningTxFeeSat uint64
+	// PremiumSat is the maximum premium that we accept to pay to the peer.
+	// The actual premium is only known from the agreement of the peer.
+	PremiumSat uint64
+	// TotalCostSat is the sum of the opening transaction fee and the
+	// premium.
+	TotalCostSat uint64
+}
+
+// QuoteSwapOut returns the estimated costs of a swap-out without starting the
+// swap or sending anything to the peer.
+func (s *SwapService) QuoteSwapOut(peer string, chain string, channelId string, amtSat uint64) (*SwapQuote, error) {
+	return s.quote(SWAPTYPE_OUT, peer, chain, channelId, amtSat)
+}
+
+// QuoteSwapIn returns the estimated costs of a swap-in without starting the
+// swap or sending anything to the peer.
+func (s *SwapService) QuoteSwapIn(peer string, chain string, channelId string, amtSat uint64) (*SwapQuote, error) {
+	return s.quote(SWAPTYPE_IN, peer, chain, channelId, amtSat)
+}
+
+func (s *SwapService) quote(swapType SwapType, peer string, chain string, channelId string, amtSat uint64) (*SwapQuote, error) {
+	if err := s.swapServices.checkChainEnabled(chain); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkNewSwap(peer, channelId, amtSat); err != nil {
+		return nil, err
+	}
+
+	if _, _, _, err := s.swapServices.getOnChainServices(chain); err != nil {
+		return nil, err
+	}
+
+	// The same estimation is used to check the fee invoice of a swap-out in
+	// PayFeeInvoiceAction.
+	openingFee, err := s.swapServices.feeEstimator.EstimateOpeningTxFee(chain, amtSat)
+	if err != nil {
+		return nil, err
+	}
+
+	premium := s.swapServices.maxPremiumSat
+	return &SwapQuote{
+		Type:            swapType,
+		Chain:           chain,
+		AmountSat:       amtSat,
+		OpeningTxFeeSat: openingFee,
+		PremiumSat:      premium,
+		TotalCostSat:    openingFee + premium,
+	}, nil
+}
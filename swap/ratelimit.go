@@ -0,0 +1,71 @@
+package swap
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRequestRateLimit is the default number of swap requests a peer
+	// may send per defaultRequestRateInterval.
+	defaultRequestRateLimit    = 5
+	defaultRequestRateInterval = time.Minute
+)
+
+// tokenBucket holds the tokens left for a peer at the time of the last
+// request.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// peerRateLimiter is an in-memory token bucket rate limiter keyed by the
+// peer id. Every peer may burst up to limit requests, the tokens are
+// refilled continuously over the interval.
+type peerRateLimiter struct {
+	sync.Mutex
+	limit    float64
+	interval time.Duration
+	buckets  map[string]*tokenBucket
+	now      func() time.Time
+}
+
+func newPeerRateLimiter(limit int, interval time.Duration) *peerRateLimiter {
+	return &peerRateLimiter{
+		limit:    float64(limit),
+		interval: interval,
+		buckets:  map[string]*tokenBucket{},
+		now:      time.Now,
+	}
+}
+
+// allow consumes a token for the peer and returns false if the peer has no
+// tokens left. A nil limiter allows all requests.
+func (r *peerRateLimiter) allow(peerId string) bool {
+	if r == nil {
+		return true
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	now := r.now()
+	bucket, ok := r.buckets[peerId]
+	if !ok {
+		bucket = &tokenBucket{tokens: r.limit, last: now}
+		r.buckets[peerId] = bucket
+	}
+
+	// Refill the tokens for the time passed since the last request.
+	elapsed := now.Sub(bucket.last)
+	bucket.tokens += elapsed.Seconds() / r.interval.Seconds() * r.limit
+	if bucket.tokens > r.limit {
+		bucket.tokens = r.limit
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
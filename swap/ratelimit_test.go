@@ -0,0 +1,39 @@
+package swap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PeerRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := newPeerRateLimiter(3, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	// Burst up to the limit.
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.allow("alice"))
+	}
+	assert.False(t, limiter.allow("alice"))
+
+	// Other peers have their own bucket.
+	assert.True(t, limiter.allow("bob"))
+
+	// A token is refilled after a third of the interval.
+	now = now.Add(20 * time.Second)
+	assert.True(t, limiter.allow("alice"))
+	assert.False(t, limiter.allow("alice"))
+
+	// Tokens do not accumulate beyond the limit.
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.allow("alice"))
+	}
+	assert.False(t, limiter.allow("alice"))
+
+	// A nil limiter allows all requests.
+	var disabled *peerRateLimiter
+	assert.True(t, disabled.allow("alice"))
+}
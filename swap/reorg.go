@@ -0,0 +1,93 @@
+package swap
+
+import (
+	"fmt"
+)
+
+// OnTxReorg is called by the tx watchers if the confirmation of the opening
+// transaction of a swap that was reported before was reorged out of the
+// chain. A swap that did not act on the confirmation yet, e.g. because the
+// claim payment was deferred while the lightning client was unavailable, is
+// rolled back to wait for the confirmation again. If the swap is too close to
+// the csv limit to wait again it is canceled cooperatively. A swap that
+// already paid the claim invoice can not be rolled back and only logs the
+// reorg.
+func (s *SwapService) OnTxReorg(swapId string) error {
+	swap, err := s.GetActiveSwap(swapId)
+	if err != nil {
+		return err
+	}
+
+	swap.mutex.Lock()
+	state := swap.Current
+	if !swap.EventIsValid(Event_OnTxConfirmed) {
+		swap.mutex.Unlock()
+		swap.logger().Warnf("[SwapService] Opening tx was reorged in state %s, the swap can not be rolled back", state)
+		s.swapServices.publishSwapEvent(newSwapEvent(SwapEventOpeningTxReorged, swap, state, state))
+		return nil
+	}
+	if swap.Data.OpeningTxHex == "" {
+		// We did not receive the confirmation yet.
+		swap.mutex.Unlock()
+		return nil
+	}
+
+	// Drop the confirmation, a deferred confirmation event is not sent
+	// again without it.
+	swap.Data.OpeningTxHex = ""
+	err = swap.write()
+	if err == nil {
+		err = s.rewatchOpeningTx(swap.Data)
+	}
+	swap.mutex.Unlock()
+	if err != nil {
+		swap.logger().Warnf("[SwapService] Could not wait for the reorged opening tx again: %v", err)
+		done, err := s.sendEvent(swap, Event_ActionFailed, &SwapErrorContext{Err: err})
+		if err == ErrEventRejected {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if done {
+			s.RemoveActiveSwap(swapId)
+		}
+		return nil
+	}
+
+	swap.logger().Infof("[SwapService] Opening tx was reorged, awaiting confirmation again")
+	s.swapServices.publishSwapEvent(newSwapEvent(SwapEventOpeningTxReorged, swap, state, state))
+	return nil
+}
+
+// rewatchOpeningTx adds the opening transaction of the swap to the tx watcher
+// again. It fails if the swap is too close to the csv limit to wait for the
+// confirmation again.
+func (s *SwapService) rewatchOpeningTx(swap *SwapData) error {
+	txWatcher, wallet, validator, err := s.swapServices.getOnChainServices(swap.GetChain())
+	if err != nil {
+		return err
+	}
+
+	now, err := txWatcher.GetBlockHeight()
+	if err != nil {
+		return err
+	}
+	if swap.StartingBlockHeight > 0 && now >= swap.StartingBlockHeight+(validator.GetCSVHeight()/2) {
+		return fmt.Errorf("exceeded csv limit")
+	}
+
+	wantScript, err := wallet.GetOutputScript(swap.GetOpeningParams())
+	if err != nil {
+		return err
+	}
+	txWatcher.AddWaitForConfirmationTx(swap.GetId().String(), swap.OpeningTxBroadcasted.TxId, swap.OpeningTxBroadcasted.ScriptOut, swap.StartingBlockHeight, wantScript)
+	return nil
+}
+
+// hasOpeningTxHex returns true if the confirmed opening transaction of the
+// swap is known.
+func (s *SwapStateMachine) hasOpeningTxHex() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.Data != nil && s.Data.OpeningTxHex != ""
+}
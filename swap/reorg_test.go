@@ -0,0 +1,107 @@
+package swap
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elementsproject/peerswap/isdev"
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// downLightningClient is a LightningClient whose payments fail as unavailable
+// while it is down.
+type downLightningClient struct {
+	*amountLightningClient
+	sync.Mutex
+	down bool
+}
+
+func (d *downLightningClient) setDown(down bool) {
+	d.Lock()
+	defer d.Unlock()
+	d.down = down
+}
+
+func (d *downLightningClient) RebalancePayment(payreq string, channel string) (string, error) {
+	d.Lock()
+	down := d.down
+	d.Unlock()
+	if down {
+		return "", fmt.Errorf("could not reach lightning node: %w", ErrLightningUnavailable)
+	}
+	return d.amountLightningClient.RebalancePayment(payreq, channel)
+}
+
+func Test_OnTxReorg(t *testing.T) {
+	if !isdev.FastTests() {
+		t.Skip("waits for the claim payment retry")
+	}
+	// The claim payment is tried once before it is deferred.
+	defer os.Setenv("PAYMENT_RETRY_TIME", os.Getenv("PAYMENT_RETRY_TIME"))
+	os.Setenv("PAYMENT_RETRY_TIME", "2")
+
+	for _, chain := range []string{btc_chain, l_btc_chain} {
+		t.Run(chain, func(t *testing.T) {
+			// The asset id is validated as 33 bytes.
+			initiator, peer, asset, _, channelId := getTestParams()
+			alice, bob, aliceMsgChan, bobMsgChan := splitTestSetup(t, initiator, peer)
+			for _, service := range []*SwapService{alice, bob} {
+				service.swapServices.liquidWallet = &assetWallet{dummyChain: service.swapServices.liquidWallet.(*dummyChain), asset: asset}
+			}
+			lightning := &downLightningClient{amountLightningClient: alice.swapServices.lightning.(*amountLightningClient), down: true}
+			alice.swapServices.lightning = lightning
+			require.NoError(t, alice.swapServices.SetLightningRetry(50*time.Millisecond, time.Minute))
+			watcher, _, _, err := alice.swapServices.getOnChainServices(chain)
+			require.NoError(t, err)
+			reorgFunc := watcher.(*dummyChain).reorgFunc
+			require.NotNil(t, reorgFunc)
+			events, unsubscribe := alice.Subscribe()
+			defer unsubscribe()
+
+			_, err = alice.SwapOut(peer, chain, channelId, initiator, 100000)
+			require.NoError(t, err)
+			aliceSwap, bobSwap := negotiateSwapOut(t, alice, bob, aliceMsgChan, bobMsgChan)
+			swapId := aliceSwap.SwapId.String()
+			bob.swapServices.lightning.(*amountLightningClient).TriggerPayment(swapId, INVOICE_FEE)
+			require.Equal(t, messages.MESSAGETYPE_OPENINGTXBROADCASTED, <-aliceMsgChan)
+
+			// The claim payment is deferred on the confirmation.
+			require.NoError(t, watcher.(*dummyChain).txConfirmedFunc(swapId, "txhex"))
+			aliceSwap.mutex.Lock()
+			assert.Equal(t, State_SwapOutSender_AwaitTxConfirmation, aliceSwap.Current)
+			assert.Equal(t, "txhex", aliceSwap.Data.OpeningTxHex)
+			aliceSwap.mutex.Unlock()
+
+			// The confirmation is reorged out before the lightning client
+			// is available again.
+			require.NoError(t, reorgFunc(swapId))
+			lightning.setDown(false)
+			assert.Len(t, drainEvents(events, func(event SwapEvent) bool {
+				return event.Kind == SwapEventOpeningTxReorged
+			}), 1)
+
+			// The deferred confirmation is not sent again.
+			time.Sleep(200 * time.Millisecond)
+			aliceSwap.mutex.Lock()
+			assert.Equal(t, State_SwapOutSender_AwaitTxConfirmation, aliceSwap.Current)
+			assert.Empty(t, aliceSwap.Data.OpeningTxHex)
+			aliceSwap.mutex.Unlock()
+
+			// The maker did not act on a confirmation, the swap is
+			// left as is.
+			require.NoError(t, bob.OnTxReorg(swapId))
+			assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, bobSwap.Current)
+
+			// The swap continues on the new confirmation.
+			bob.swapServices.lightning.(*amountLightningClient).TriggerPayment(swapId, INVOICE_CLAIM)
+			require.NoError(t, watcher.(*dummyChain).txConfirmedFunc(swapId, "txhex"))
+			assert.Equal(t, State_ClaimedPreimage, aliceSwap.Current)
+			assert.ErrorIs(t, alice.OnTxReorg(swapId), ErrSwapDoesNotExist)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package swap
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ReplayMessage passes a captured raw peer message through the same handlers
+// as a message received from the peer. It is a support and testing entry
+// point to reproduce a failed swap without a live peer, it must not be used
+// during normal operation. Messages for active swaps that committed on-chain
+// funds are rejected with ErrSwapFundsCommitted, use ForceReplayMessage to
+// replay them anyway.
+func (s *SwapService) ReplayMessage(peerId, msgTypeString string, payload []byte) error {
+	return s.replayMessage(peerId, msgTypeString, payload, false)
+}
+
+// ForceReplayMessage is ReplayMessage without the check for committed funds.
+// A replayed message can move a swap that committed funds, e.g. to its refund
+// path, so it is only meant for swaps that are known to be stuck.
+func (s *SwapService) ForceReplayMessage(peerId, msgTypeString string, payload []byte) error {
+	return s.replayMessage(peerId, msgTypeString, payload, true)
+}
+
+func (s *SwapService) replayMessage(peerId, msgTypeString string, payload []byte, force bool) error {
+	if !force {
+		if err := s.checkReplayAllowed(payload); err != nil {
+			return err
+		}
+	}
+	s.swapServices.logger.Infof("[SwapService] Replaying message of type %s from %s", msgTypeString, peerId)
+	return s.OnMessageReceived(peerId, msgTypeString, payload)
+}
+
+// checkReplayAllowed returns ErrSwapFundsCommitted if the payload addresses
+// an active swap that committed on-chain funds. Payloads that can not be
+// parsed are left to the message handlers.
+func (s *SwapService) checkReplayAllowed(payload []byte) error {
+	var msg struct {
+		SwapId *SwapId `json:"swap_id"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.SwapId == nil {
+		return nil
+	}
+	swap, err := s.GetActiveSwap(msg.SwapId.String())
+	if err != nil {
+		return nil
+	}
+
+	swap.mutex.Lock()
+	defer swap.mutex.Unlock()
+	if swap.Data != nil && (swap.Data.OpeningTxHex != "" || swap.Data.GetOpeningTxId() != "") {
+		return fmt.Errorf("%w: swap %s is in state %s", ErrSwapFundsCommitted, msg.SwapId.String(), swap.Current)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package swap
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReplayMessage(t *testing.T) {
+	initiator, peer, pubkey, _, channelId := getTestParams()
+
+	replay := func(service *SwapService, msg PeerMessage) error {
+		payload, err := json.Marshal(msg)
+		require.NoError(t, err)
+		return service.ReplayMessage(peer, messages.MessageTypeToHexString(msg.MessageType()), payload)
+	}
+
+	t.Run("requests", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		swapOutId := NewSwapId()
+		require.NoError(t, replay(service, &SwapOutRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapOutId,
+			Network:         "mainnet",
+			Scid:            channelId,
+			Amount:          100000,
+			Pubkey:          pubkey,
+		}))
+		swapInId := NewSwapId()
+		require.NoError(t, replay(service, &SwapInRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapInId,
+			Network:         "mainnet",
+			Scid:            "100x2x4",
+			Amount:          100000,
+			Pubkey:          pubkey,
+		}))
+
+		for _, swapId := range []*SwapId{swapOutId, swapInId} {
+			swap, err := service.GetActiveSwap(swapId.String())
+			require.NoError(t, err)
+			assert.Equal(t, peer, swap.Data.PeerNodeId)
+		}
+	})
+
+	t.Run("unknown swap", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		swapId := NewSwapId()
+		for _, msg := range []PeerMessage{
+			&SwapOutAgreementMessage{SwapId: swapId},
+			&SwapInAgreementMessage{SwapId: swapId},
+			&OpeningTxBroadcastedMessage{SwapId: swapId},
+			&CancelMessage{SwapId: swapId},
+			&CoopCloseMessage{SwapId: swapId},
+			&PingMessage{SwapId: swapId},
+			&PongMessage{SwapId: swapId},
+		} {
+			assert.ErrorIs(t, replay(service, msg), ErrSwapDoesNotExist, "%T", msg)
+		}
+		// A reunion for an unknown swap is answered with a cancel message.
+		assert.NoError(t, replay(service, &SwapReunionMessage{SwapId: swapId}))
+		// Poll messages are left to the poll service.
+		assert.NoError(t, service.ReplayMessage(peer, messages.MessageTypeToHexString(messages.MESSAGETYPE_POLL), []byte("{}")))
+	})
+
+	t.Run("committed funds", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		swapId := NewSwapId()
+		swap := newSwapOutReceiverFSM(swapId, service.swapServices, peer)
+		swap.Current = State_SwapOutReceiver_AwaitClaimInvoicePayment
+		swap.Data.SwapOutRequest = &SwapOutRequestMessage{SwapId: swapId, Network: "mainnet", Amount: 100000}
+		swap.Data.OpeningTxHex = "txhex"
+		swap.Data.OpeningTxBroadcasted = &OpeningTxBroadcastedMessage{SwapId: swapId, Payreq: "claim", TxId: "txid"}
+		service.AddActiveSwap(swapId.String(), swap)
+
+		cancel := &CancelMessage{SwapId: swapId, Message: "replayed"}
+		assert.ErrorIs(t, replay(service, cancel), ErrSwapFundsCommitted)
+		assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, swap.Current)
+
+		payload, err := json.Marshal(cancel)
+		require.NoError(t, err)
+		require.NoError(t, service.ForceReplayMessage(peer, messages.MessageTypeToHexString(cancel.MessageType()), payload))
+		assert.Equal(t, State_WaitCsv, swap.Current)
+	})
+
+	t.Run("no committed funds", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+
+		require.NoError(t, replay(service, &CancelMessage{SwapId: swap.SwapId, Message: "replayed"}))
+		assert.Equal(t, State_SwapCanceled, swap.Current)
+	})
+}
@@ -0,0 +1,41 @@
+package swap
+
+import "time"
+
+// messageRetry retries failed message sends with an exponential backoff until
+// the total time waited would exceed the window.
+type messageRetry struct {
+	backoff time.Duration
+	window  time.Duration
+	sleep   func(time.Duration)
+}
+
+func newMessageRetry(backoff, window time.Duration) *messageRetry {
+	return &messageRetry{
+		backoff: backoff,
+		window:  window,
+		sleep:   time.Sleep,
+	}
+}
+
+// send calls send until it succeeds or the retry window is used up and
+// returns the last error. A nil messageRetry calls send once.
+func (r *messageRetry) send(logger Logger, send func() error) error {
+	err := send()
+	if err == nil || r == nil {
+		return err
+	}
+
+	var waited time.Duration
+	for backoff := r.backoff; waited+backoff <= r.window; backoff *= 2 {
+		if logger != nil {
+			logger.Debugf("[SwapService] Sending message failed: %v, retrying in %v", err, backoff)
+		}
+		r.sleep(backoff)
+		waited += backoff
+		if err = send(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package swap
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyMessenger fails the given number of sends before it succeeds, or every
+// send if failures is negative. Successfully sent messages are recorded.
+type flakyMessenger struct {
+	sync.Mutex
+	failures int
+	attempts int
+	sent     [][]byte
+}
+
+func (f *flakyMessenger) SendMessage(peerId string, message []byte, messageType int) error {
+	f.Lock()
+	defer f.Unlock()
+	f.attempts++
+	if f.failures < 0 || f.attempts <= f.failures {
+		return errors.New("peer disconnected")
+	}
+	f.sent = append(f.sent, message)
+	return nil
+}
+
+func (f *flakyMessenger) AddMessageHandler(func(peerId string, msgType string, payload []byte) error) {
+}
+
+func Test_MessageRetry(t *testing.T) {
+	var slept []time.Duration
+	retry := newMessageRetry(time.Second, 15*time.Second)
+	retry.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	// Eventual delivery after two failures.
+	messenger := &flakyMessenger{failures: 2}
+	err := retry.send(nil, func() error {
+		return messenger.SendMessage("bob", []byte("msg"), 0)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, messenger.attempts)
+	assert.Equal(t, [][]byte{[]byte("msg")}, messenger.sent)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, slept)
+
+	// Give up once the window is used up.
+	slept = nil
+	messenger = &flakyMessenger{failures: -1}
+	err = retry.send(nil, func() error {
+		return messenger.SendMessage("bob", []byte("msg"), 0)
+	})
+	assert.EqualError(t, err, "peer disconnected")
+	assert.Equal(t, 5, messenger.attempts)
+	assert.Empty(t, messenger.sent)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}, slept)
+
+	// A nil retry sends once.
+	var disabled *messageRetry
+	messenger = &flakyMessenger{failures: 1}
+	err = disabled.send(nil, func() error {
+		return messenger.SendMessage("bob", []byte("msg"), 0)
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, messenger.attempts)
+}
+
+func Test_SetMessageRetry(t *testing.T) {
+	swapServices := getSwapServices(make(chan PeerMessage))
+	// Retries are opt-in.
+	assert.Nil(t, swapServices.messageRetry)
+	assert.Error(t, swapServices.SetMessageRetry(0, time.Minute))
+	assert.Error(t, swapServices.SetMessageRetry(time.Second, -time.Second))
+	// The window must be shorter than the state timeouts.
+	assert.Error(t, swapServices.SetMessageRetry(time.Second, defaultNegotiationTimeout))
+	require.NoError(t, swapServices.SetMessageRetry(time.Second, time.Minute))
+	require.NoError(t, swapServices.SetMessageRetry(0, 0))
+	assert.Nil(t, swapServices.messageRetry)
+}
+
+func Test_SendMessageAction_Retry(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		failures int
+		want     EventType
+		attempts int
+	}{
+		{name: "eventual delivery", failures: 3, want: Event_ActionSucceeded, attempts: 4},
+		{name: "give up", failures: -1, want: Event_ActionFailed, attempts: 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			messenger := &flakyMessenger{failures: tc.failures}
+			swapServices := getSwapServices(make(chan PeerMessage))
+			swapServices.messenger = messenger
+			require.NoError(t, swapServices.SetMessageRetry(time.Second, 15*time.Second))
+			swapServices.messageRetry.sleep = func(time.Duration) {}
+
+			swap := &SwapData{
+				PeerNodeId:      "bob",
+				NextMessage:     []byte("msg"),
+				NextMessageType: 0,
+			}
+			assert.Equal(t, tc.want, (&SendMessageAction{}).Execute(swapServices, swap))
+			assert.Equal(t, tc.attempts, messenger.attempts)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package swap
+
+import (
+	"errors"
+	"fmt"
+)
+
+// OnPeerConnected sends a SwapReunionMessage for every active swap with the
+// peer, so that the peer can reconcile its state of the swaps with ours.
+func (s *SwapService) OnPeerConnected(peerId string) {
+	if s.isStopped() {
+		return
+	}
+	s.stopDisconnectTimer(peerId)
+
+	for _, swap := range s.GetActiveSwaps() {
+		if swap.Data == nil || swap.Data.PeerNodeId != peerId {
+			continue
+		}
+
+		swap.mutex.Lock()
+		msg := &SwapReunionMessage{
+			SwapId:      swap.SwapId,
+			State:       swap.Current,
+			OpeningTxId: swap.Data.GetOpeningTxId(),
+		}
+		swap.mutex.Unlock()
+
+		msgBytes, msgType, err := MarshalPeerswapMessage(msg)
+		if err != nil {
+			s.swapServices.logger.Errorf("[SwapService] Could not marshal reunion message of swap %s: %v", swap.SwapId.String(), err)
+			continue
+		}
+		if err := s.swapServices.messenger.SendMessage(peerId, msgBytes, msgType); err != nil {
+			s.swapServices.logger.Infof("[SwapService] Could not send reunion message of swap %s: %v", swap.SwapId.String(), err)
+		}
+	}
+}
+
+// OnReunionReceived compares the state of the swap partner with our state of
+// the swap and reconciles a divergence. If the swap is not active on our side
+// the partner missed our cancel message and we send it a cancel message. If
+// the partner does not know our opening transaction it missed our message and
+// we send it again. If the partner knows another opening transaction than we
+// do the swap can not be continued and is canceled.
+func (s *SwapService) OnReunionReceived(peerId string, msg *SwapReunionMessage) error {
+	swap, err := s.GetActiveSwap(msg.SwapId.String())
+	if errors.Is(err, ErrSwapDoesNotExist) {
+		return s.onReunionForInactiveSwap(peerId, msg)
+	} else if err != nil {
+		return err
+	}
+	if err := s.checkMessageSender(peerId, msg.SwapId); err != nil {
+		return err
+	}
+
+	swap.mutex.Lock()
+	ourState := swap.Current
+	ourTxId := swap.Data.GetOpeningTxId()
+	swap.mutex.Unlock()
+
+	switch {
+	case msg.OpeningTxId == ourTxId:
+		s.swapServices.logger.Debugf("[SwapService] Swap %s is in sync with the peer in state %s", swap.SwapId.String(), ourState)
+		return nil
+	case msg.OpeningTxId == "":
+		s.swapServices.logger.Infof("[SwapService] Peer does not know the opening transaction %s of swap %s, resending the last message", ourTxId, swap.SwapId.String())
+		return s.ResendLastMessage(swap.SwapId.String())
+	case ourTxId == "":
+		// The partner broadcasted the opening transaction and resends its
+		// message once it receives our reunion message.
+		s.swapServices.logger.Infof("[SwapService] Peer knows the opening transaction %s of swap %s that we do not know", msg.OpeningTxId, swap.SwapId.String())
+		return nil
+	default:
+		return s.cancelDivergedSwap(swap, CancelReasonInvalidMessage, fmt.Sprintf("opening transaction %s does not match %s", msg.OpeningTxId, ourTxId))
+	}
+}
+
+// onReunionForInactiveSwap answers a reunion message for a swap that is
+// unknown or finished on our side with a cancel message, so that the partner
+// does not wait for a message of ours that it missed. A swap that we claimed
+// with the preimage was paid, the partner learns of it from the payment.
+func (s *SwapService) onReunionForInactiveSwap(peerId string, msg *SwapReunionMessage) error {
+	swapId := msg.SwapId.String()
+	reason := fmt.Sprintf("unknown swap %s", swapId)
+	stored, err := s.swapServices.swapStore.GetData(swapId)
+	if err == nil {
+		if stored.Data != nil && stored.Data.PeerNodeId != peerId {
+			s.swapServices.logger.Warnf("[SwapService] Received a message for swap %s from unexpected peer %s, expected %s", swapId, peerId, stored.Data.PeerNodeId)
+			return ErrReceivedMessageFromUnexpectedPeer(peerId, msg.SwapId)
+		}
+		if stored.Current == State_ClaimedPreimage {
+			s.swapServices.logger.Debugf("[SwapService] Received reunion message for swap %s that was claimed with the preimage", swapId)
+			return nil
+		}
+		reason = fmt.Sprintf("swap %s is finished in state %s", swapId, stored.Current)
+	} else if !errors.Is(err, ErrDataNotAvailable) {
+		return err
+	}
+
+	s.swapServices.logger.Infof("[SwapService] Peer %s is in state %s of swap %s that is not active: %s", peerId, msg.State, swapId, reason)
+	msgBytes, msgType, err := MarshalPeerswapMessage(&CancelMessage{
+		SwapId:  msg.SwapId,
+		Reason:  CancelReasonOther,
+		Message: reason,
+	})
+	if err != nil {
+		return err
+	}
+	return s.swapServices.messenger.SendMessage(peerId, msgBytes, msgType)
+}
+
+// cancelDivergedSwap cancels the swap as if the peer sent a cancel message
+// with the reason code and reason.
+func (s *SwapService) cancelDivergedSwap(swap *SwapStateMachine, code CancelReason, reason string) error {
+	s.swapServices.logger.Warnf("[SwapService] State of swap %s diverged from the peer: %s", swap.SwapId.String(), reason)
+	done, err := s.sendEvent(swap, Event_OnCancelReceived, &CancelMessage{
+		SwapId:  swap.SwapId,
+		Reason:  code,
+		Message: reason,
+	})
+	if errors.Is(err, ErrEventRejected) {
+		return fmt.Errorf("could not cancel swap %s in state %s: %s", swap.SwapId.String(), swap.Current, reason)
+	} else if err != nil {
+		return err
+	}
+	if done {
+		s.RemoveActiveSwap(swap.SwapId.String())
+	}
+	return nil
+}
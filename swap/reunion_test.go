@@ -0,0 +1,151 @@
+package swap
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Reunion_InSync(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	alice, bob, aliceMsgChan, bobMsgChan := splitTestSetup(t, initiator, peer)
+
+	_, err := alice.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	aliceSwap, bobSwap := negotiateSwapOut(t, alice, bob, aliceMsgChan, bobMsgChan)
+	bob.swapServices.lightning.(*amountLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_FEE)
+	require.Equal(t, messages.MESSAGETYPE_OPENINGTXBROADCASTED, <-aliceMsgChan)
+
+	// Both sides know the opening transaction, nothing changes.
+	alice.OnPeerConnected(peer)
+	assert.Equal(t, messages.MESSAGETYPE_SWAPREUNION, <-bobMsgChan)
+	bob.OnPeerConnected(initiator)
+	assert.Equal(t, messages.MESSAGETYPE_SWAPREUNION, <-aliceMsgChan)
+
+	assert.Equal(t, State_SwapOutSender_AwaitTxConfirmation, aliceSwap.Current)
+	assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, bobSwap.Current)
+	assert.NoError(t, alice.swapServices.messenger.(*ConnectedMessenger).lastErr)
+	assert.NoError(t, bob.swapServices.messenger.(*ConnectedMessenger).lastErr)
+}
+
+func Test_Reunion_PeerMissedOpeningTx(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	alice, bob, aliceMsgChan, bobMsgChan := splitTestSetup(t, initiator, peer)
+
+	_, err := alice.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	_, bobSwap := negotiateSwapOut(t, alice, bob, aliceMsgChan, bobMsgChan)
+	bob.swapServices.lightning.(*amountLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_FEE)
+	require.Equal(t, messages.MESSAGETYPE_OPENINGTXBROADCASTED, <-aliceMsgChan)
+
+	// Bob sends the opening transaction again if alice does not know it.
+	err = bob.OnReunionReceived(initiator, &SwapReunionMessage{
+		SwapId: bobSwap.SwapId,
+		State:  State_SwapOutSender_AwaitTxBroadcastedMessage,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, messages.MESSAGETYPE_OPENINGTXBROADCASTED, <-aliceMsgChan)
+	assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, bobSwap.Current)
+}
+
+func Test_Reunion_Diverged(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+
+	t.Run("peer canceled", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+
+		// We missed the cancel message of the peer, the peer does not
+		// know the swap any longer and answers our reunion with a cancel
+		// message.
+		peerService, messenger := getRecordingTestSetup(peer)
+		err = peerService.OnReunionReceived(initiator, &SwapReunionMessage{
+			SwapId: swap.SwapId,
+			State:  swap.Current,
+		})
+		require.NoError(t, err)
+		require.Len(t, messenger.sent, 1)
+		cancel := messenger.sent[0]
+		assert.Equal(t, initiator, cancel.peerId)
+		assert.Equal(t, int(messages.MESSAGETYPE_CANCELED), cancel.msgType)
+
+		msgType := messages.MessageTypeToHexString(messages.MESSAGETYPE_CANCELED)
+		require.NoError(t, service.OnMessageReceived(peer, msgType, cancel.payload))
+		assert.Equal(t, State_SwapCanceled, swap.Current)
+		assert.Equal(t, "unknown swap "+swap.SwapId.String(), swap.Data.GetCancelMessage())
+		assert.Empty(t, service.GetActiveSwaps())
+	})
+
+	t.Run("opening tx mismatch", func(t *testing.T) {
+		alice, bob, aliceMsgChan, bobMsgChan := splitTestSetup(t, initiator, peer)
+		_, err := alice.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		aliceSwap, bobSwap := negotiateSwapOut(t, alice, bob, aliceMsgChan, bobMsgChan)
+		bob.swapServices.lightning.(*amountLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_FEE)
+		require.Equal(t, messages.MESSAGETYPE_OPENINGTXBROADCASTED, <-aliceMsgChan)
+
+		// Alice awaits the confirmation of the opening transaction and can no
+		// longer cancel the swap.
+		err = alice.OnReunionReceived(peer, &SwapReunionMessage{
+			SwapId:      aliceSwap.SwapId,
+			State:       State_SwapOutReceiver_AwaitClaimInvoicePayment,
+			OpeningTxId: "othertx",
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "opening transaction othertx does not match")
+		assert.Equal(t, State_SwapOutSender_AwaitTxConfirmation, aliceSwap.Current)
+	})
+}
+
+func Test_Reunion_InactiveSwap(t *testing.T) {
+	initiator, peer, _, _, _ := getTestParams()
+
+	for _, tc := range []struct {
+		name    string
+		state   StateType
+		peerId  string
+		message string
+		err     bool
+	}{
+		{name: "canceled", state: State_SwapCanceled, peerId: initiator, message: "is finished in state State_SwapCanceled"},
+		{name: "claimed by csv", state: State_ClaimedCsv, peerId: initiator, message: "is finished in state State_ClaimedCsv"},
+		{name: "claimed by preimage", state: State_ClaimedPreimage, peerId: initiator},
+		{name: "unexpected peer", state: State_SwapCanceled, peerId: malloryId, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service, messenger := getRecordingTestSetup(peer)
+			finished := &SwapStateMachine{
+				SwapId:  NewSwapId(),
+				Type:    SWAPTYPE_OUT,
+				Role:    SWAPROLE_RECEIVER,
+				Current: tc.state,
+				Data:    &SwapData{PeerNodeId: initiator},
+			}
+			require.NoError(t, service.swapServices.swapStore.UpdateData(finished))
+
+			err := service.OnReunionReceived(tc.peerId, &SwapReunionMessage{
+				SwapId: finished.SwapId,
+				State:  State_SwapOutSender_AwaitTxConfirmation,
+			})
+			if tc.err {
+				assert.Error(t, err)
+				assert.Empty(t, messenger.sent)
+				return
+			}
+			require.NoError(t, err)
+			if tc.message == "" {
+				assert.Empty(t, messenger.sent)
+				return
+			}
+			require.Len(t, messenger.sent, 1)
+			var cancel CancelMessage
+			require.NoError(t, json.Unmarshal(messenger.sent[0].payload, &cancel))
+			assert.Equal(t, finished.SwapId, cancel.SwapId)
+			assert.Contains(t, cancel.Message, tc.message)
+		})
+	}
+}
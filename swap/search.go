@@ -0,0 +1,121 @@
+package swap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SwapFilter selects swaps in SearchSwaps. Fields with a zero value do not
+// restrict the result.
+type SwapFilter struct {
+	PeerNodeId string
+	Type       SwapType
+	Role       SwapRole
+	State      StateType
+	// CreatedAfter selects swaps that were created at or after the time.
+	CreatedAfter time.Time
+	// CreatedBefore selects swaps that were created before the time.
+	CreatedBefore time.Time
+}
+
+// matches returns true if the swap is selected by the filter.
+func (f SwapFilter) matches(swap *SwapStateMachine) bool {
+	if swap.Data == nil {
+		return false
+	}
+	if f.PeerNodeId != "" && swap.Data.PeerNodeId != f.PeerNodeId {
+		return false
+	}
+	if f.Type != 0 && swap.Type != f.Type {
+		return false
+	}
+	if f.Role != 0 && swap.Role != f.Role {
+		return false
+	}
+	if f.State != "" && swap.Current != f.State {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && swap.Data.CreatedAt < f.CreatedAfter.Unix() {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && swap.Data.CreatedAt >= f.CreatedBefore.Unix() {
+		return false
+	}
+	return true
+}
+
+// SearchSwaps returns the stored swaps that are selected by the filter.
+func (s *SwapService) SearchSwaps(filter SwapFilter) ([]*SwapStateMachine, error) {
+	return s.ListSwapsWhere(filter.matches)
+}
+
+// GetSwapByTxId returns the swap whose opening transaction has the id. Active
+// swaps are looked up in an index, other swaps are searched in the store.
+func (s *SwapService) GetSwapByTxId(txId string) (*SwapStateMachine, error) {
+	if txId == "" {
+		return nil, errors.New("txid must not be empty")
+	}
+
+	s.RLock()
+	swapId, ok := s.activeSwapsByTxId[txId]
+	s.RUnlock()
+	if ok {
+		if swap, err := s.GetActiveSwap(swapId); err == nil {
+			return swap, nil
+		}
+	}
+
+	swaps, err := s.ListSwapsWhere(func(swap *SwapStateMachine) bool {
+		return s.swapServices.openingTxId(swap) == txId
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(swaps) == 0 {
+		return nil, fmt.Errorf("%w: no swap with opening tx %s", ErrSwapDoesNotExist, txId)
+	}
+	return swaps[0], nil
+}
+
+// indexOpeningTx adds the opening transaction of the active swap to the
+// index once it is known.
+func (s *SwapService) indexOpeningTx(swap *SwapStateMachine) {
+	swap.mutex.Lock()
+	txId := s.swapServices.openingTxId(swap)
+	swap.mutex.Unlock()
+	if txId == "" {
+		return
+	}
+
+	swapId := swap.SwapId.String()
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.activeSwaps[swapId]; ok {
+		s.activeSwapsByTxId[txId] = swapId
+	}
+}
+
+// openingTxId returns the id of the opening transaction of the swap or an
+// empty string if it is not known yet. The id is derived from the opening
+// transaction if the swap did not receive or send the broadcasted message.
+func (s *SwapServices) openingTxId(swap *SwapStateMachine) string {
+	if swap.Data == nil {
+		return ""
+	}
+	if txId := swap.Data.GetOpeningTxId(); txId != "" {
+		return txId
+	}
+	if swap.Data.OpeningTxHex == "" {
+		return ""
+	}
+	_, _, validator, err := s.getOnChainServices(swap.Data.GetChain())
+	if err != nil {
+		return ""
+	}
+	txId, err := validator.TxIdFromHex(swap.Data.OpeningTxHex)
+	if err != nil {
+		return ""
+	}
+	return txId
+}
@@ -0,0 +1,128 @@
+package swap
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func Test_SearchSwaps(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = store
+
+	now := time.Now()
+	days := func(n int) time.Time { return now.Add(time.Duration(-n) * 24 * time.Hour) }
+
+	swaps := map[string]*SwapStateMachine{
+		"bob out 1d":     {Type: SWAPTYPE_OUT, Role: SWAPROLE_SENDER, Current: State_ClaimedPreimage, Data: &SwapData{PeerNodeId: "bob", CreatedAt: days(1).Unix()}},
+		"bob in 3d":      {Type: SWAPTYPE_IN, Role: SWAPROLE_RECEIVER, Current: State_SwapCanceled, Data: &SwapData{PeerNodeId: "bob", CreatedAt: days(3).Unix()}},
+		"bob out 10d":    {Type: SWAPTYPE_OUT, Role: SWAPROLE_RECEIVER, Current: State_ClaimedPreimage, Data: &SwapData{PeerNodeId: "bob", CreatedAt: days(10).Unix()}},
+		"carol in 2d":    {Type: SWAPTYPE_IN, Role: SWAPROLE_SENDER, Current: State_ClaimedPreimage, Data: &SwapData{PeerNodeId: "carol", CreatedAt: days(2).Unix()}},
+		"carol out 20d":  {Type: SWAPTYPE_OUT, Role: SWAPROLE_SENDER, Current: State_SwapCanceled, Data: &SwapData{PeerNodeId: "carol", CreatedAt: days(20).Unix()}},
+		"carol out open": {Type: SWAPTYPE_OUT, Role: SWAPROLE_SENDER, Current: State_SwapOutSender_AwaitTxConfirmation, Data: &SwapData{PeerNodeId: "carol", CreatedAt: now.Unix()}},
+	}
+	names := map[string]string{}
+	for name, swap := range swaps {
+		swap.SwapId = NewSwapId()
+		require.NoError(t, store.UpdateData(swap))
+		names[swap.SwapId.String()] = name
+	}
+
+	for _, tc := range []struct {
+		name     string
+		filter   SwapFilter
+		expected []string
+	}{
+		{name: "all", filter: SwapFilter{}, expected: []string{"bob in 3d", "bob out 10d", "bob out 1d", "carol in 2d", "carol out 20d", "carol out open"}},
+		{name: "peer", filter: SwapFilter{PeerNodeId: "bob"}, expected: []string{"bob in 3d", "bob out 10d", "bob out 1d"}},
+		{name: "type", filter: SwapFilter{Type: SWAPTYPE_IN}, expected: []string{"bob in 3d", "carol in 2d"}},
+		{name: "role", filter: SwapFilter{Role: SWAPROLE_RECEIVER}, expected: []string{"bob in 3d", "bob out 10d"}},
+		{name: "state", filter: SwapFilter{State: State_SwapCanceled}, expected: []string{"bob in 3d", "carol out 20d"}},
+		{name: "created after", filter: SwapFilter{CreatedAfter: days(7)}, expected: []string{"bob in 3d", "bob out 1d", "carol in 2d", "carol out open"}},
+		{name: "created before", filter: SwapFilter{CreatedBefore: days(7)}, expected: []string{"bob out 10d", "carol out 20d"}},
+		{name: "time range", filter: SwapFilter{CreatedAfter: days(15), CreatedBefore: days(2).Add(time.Hour)}, expected: []string{"bob in 3d", "bob out 10d", "carol in 2d"}},
+		{name: "peer in the last 7 days", filter: SwapFilter{PeerNodeId: "bob", CreatedAfter: days(7)}, expected: []string{"bob in 3d", "bob out 1d"}},
+		{name: "peer, type and state", filter: SwapFilter{PeerNodeId: "carol", Type: SWAPTYPE_OUT, State: State_SwapCanceled}, expected: []string{"carol out 20d"}},
+		{name: "type and role", filter: SwapFilter{Type: SWAPTYPE_OUT, Role: SWAPROLE_SENDER}, expected: []string{"bob out 1d", "carol out 20d", "carol out open"}},
+		{name: "no match", filter: SwapFilter{PeerNodeId: "dave"}, expected: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			found, err := service.SearchSwaps(tc.filter)
+			require.NoError(t, err)
+			var got []string
+			for _, swap := range found {
+				got = append(got, names[swap.SwapId.String()])
+			}
+			sort.Strings(got)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func Test_GetSwapByTxId(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = store
+
+	active := &SwapStateMachine{
+		SwapId:  NewSwapId(),
+		Type:    SWAPTYPE_OUT,
+		Role:    SWAPROLE_SENDER,
+		Current: State_SwapOutSender_AwaitTxConfirmation,
+		Data:    &SwapData{PeerNodeId: "bob", OpeningTxBroadcasted: &OpeningTxBroadcastedMessage{TxId: "active-tx"}},
+	}
+	service.AddActiveSwap(active.SwapId.String(), active)
+
+	stored := &SwapStateMachine{
+		SwapId:  NewSwapId(),
+		Type:    SWAPTYPE_IN,
+		Role:    SWAPROLE_SENDER,
+		Current: State_ClaimedPreimage,
+		Data:    &SwapData{PeerNodeId: "carol", OpeningTxBroadcasted: &OpeningTxBroadcastedMessage{TxId: "stored-tx"}},
+	}
+	require.NoError(t, store.UpdateData(stored))
+
+	t.Run("active", func(t *testing.T) {
+		swap, err := service.GetSwapByTxId("active-tx")
+		require.NoError(t, err)
+		assert.Same(t, active, swap)
+	})
+
+	t.Run("stored", func(t *testing.T) {
+		swap, err := service.GetSwapByTxId("stored-tx")
+		require.NoError(t, err)
+		assert.Equal(t, stored.SwapId, swap.SwapId)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := service.GetSwapByTxId("unknown-tx")
+		assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+		_, err = service.GetSwapByTxId("")
+		assert.Error(t, err)
+	})
+
+	t.Run("removed from active", func(t *testing.T) {
+		require.NoError(t, store.UpdateData(active))
+		service.RemoveActiveSwap(active.SwapId.String())
+		assert.Empty(t, service.activeSwapsByTxId)
+		swap, err := service.GetSwapByTxId("active-tx")
+		require.NoError(t, err)
+		assert.Equal(t, active.SwapId, swap.SwapId)
+	})
+}
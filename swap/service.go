@@ -1,25 +1,78 @@
 package swap
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"runtime/debug"
 	"strings"
 	"sync"
-
-	"github.com/elementsproject/peerswap/log"
+	"time"
 
 	"github.com/elementsproject/peerswap/messages"
 )
 
 const (
 	PEERSWAP_PROTOCOL_VERSION = 2
+
+	// unknownMessageLogInterval is the interval in which a message of an
+	// unknown type is logged at most once per type.
+	unknownMessageLogInterval = 10 * time.Minute
+	// maxUnknownMessagesLogged is the number of unknown message types that
+	// are remembered as logged within unknownMessageLogInterval. Types
+	// beyond are not logged, as the message types are chosen by the peers.
+	maxUnknownMessagesLogged = 64
+)
+
+var (
+	ErrSwapDoesNotExist  = errors.New("swap does not exist")
+	ErrServiceStopped    = errors.New("swap service is stopped")
+	ErrServicePaused     = errors.New("swap service is paused")
+	ErrSwapNotCancelable = errors.New("swap can not be canceled")
+	ErrDuplicateSwapId   = errors.New("duplicate swap id")
+	ErrNoMessageToResend = errors.New("swap has no message to resend")
+	ErrNoPendingMessage  = errors.New("swap has no pending message")
+	ErrSwapPanicked      = errors.New("swap action panicked")
+	ErrUnexpectedPeer    = errors.New("received a message from an unexpected peer")
+	ErrSwapAlreadyActive = errors.New("swap is already active")
+	ErrSwapFinished      = errors.New("swap is already finished")
+	ErrPeerNotConnected  = errors.New("peer not connected")
+	ErrSwapNotRefundable = errors.New("swap can not be refunded")
+	ErrWrongInitiator    = errors.New("initiator is not the local node")
+	ErrPayloadTooLarge   = errors.New("payload is unexpectedly large")
+	ErrInvalidPeerId     = errors.New("invalid peer id")
+
+	ErrChannelCapacityExceeded = errors.New("swaps exceed the channel capacity")
+	ErrSwapNotAbandonable      = errors.New("swap can not be abandoned")
+	ErrIdempotencyKeyReused    = errors.New("idempotency key was used for another swap")
+	ErrSwapNotRebroadcastable  = errors.New("swap has no opening transaction to rebroadcast")
+	ErrRebroadcastNotSupported = errors.New("wallet does not support rebroadcasting")
+	ErrSwapNotBumpable         = errors.New("swap has no claim transaction to bump")
+	ErrBumpNotSupported        = errors.New("wallet does not support fee bumping")
+	ErrServiceAlreadyStarted   = errors.New("swap service is already started")
+	ErrCircuitBreakerOpen      = errors.New("circuit breaker is open after too many failed swaps")
+	ErrSwapFundsCommitted      = errors.New("swap committed on-chain funds")
+	ErrAllowlistNotEditable    = errors.New("policy can not edit the allowlist")
 )
 
+// The rejections of new swaps by the policy of the service. The typed errors
+// of the rejections match them with errors.Is.
 var (
-	AllowedAssets       = []string{"btc", "lbtc"}
-	ErrSwapDoesNotExist = errors.New("swap does not exist")
+	ErrSwapAlreadyActiveOnChannel = errors.New("already has an active swap on channel")
+	ErrSwapsDisabled              = errors.New("swaps are disabled")
+	ErrPeerNotAllowed             = errors.New("peer is not allowed")
+	ErrPeerRateLimited            = errors.New("peer exceeded the swap request rate limit")
+	ErrPeerSuspicious             = errors.New("peer is suspicious")
+	ErrPeerSwapLimit              = errors.New("peer has the maximum of active swaps")
+	ErrSwapLimit                  = errors.New("node has the maximum of active swaps")
+	ErrSwapAmount                 = errors.New("swap amount is out of the limits")
+	ErrProtocolVersion            = errors.New("incompatible peerswap version")
+	ErrChainNotSupported          = errors.New("chain is not supported")
+	ErrFeeRateTooHigh             = errors.New("fee rate is too high")
+	ErrSwapCostTooHigh            = errors.New("swap cost is too high")
+	ErrCrossingSwap               = errors.New("crossing swap")
 )
 
 type ErrMinimumSwapSize uint64
@@ -28,6 +81,51 @@ func (u ErrMinimumSwapSize) Error() string {
 	return fmt.Sprintf("a minimum swap amount of %d msat is required", uint64(u))
 }
 
+func (u ErrMinimumSwapSize) Is(target error) bool {
+	return target == ErrSwapAmount
+}
+
+// ActiveSwapOnChannelError is returned if a swap is requested on a channel
+// that already has an active swap.
+type ActiveSwapOnChannelError struct {
+	ChannelId string
+	SwapId    string
+}
+
+func (e ActiveSwapOnChannelError) Error() string {
+	return fmt.Sprintf("already has an active swap on channel %s: %s", e.ChannelId, e.SwapId)
+}
+
+func (e ActiveSwapOnChannelError) Is(target error) bool {
+	return target == ErrSwapAlreadyActiveOnChannel
+}
+
+// CrossingSwapError is returned if a peer requests a swap on a channel on
+// which we requested a swap from the peer at the same time, and our swap
+// wins.
+type CrossingSwapError struct {
+	ChannelId string
+	SwapId    string
+}
+
+func (e CrossingSwapError) Error() string {
+	return fmt.Sprintf("crossing swap %s on channel %s", e.SwapId, e.ChannelId)
+}
+
+func (e CrossingSwapError) Is(target error) bool {
+	return target == ErrCrossingSwap
+}
+
+type ErrMaximumSwapSize uint64
+
+func (u ErrMaximumSwapSize) Error() string {
+	return fmt.Sprintf("a maximum swap amount of %d msat is allowed", uint64(u))
+}
+
+func (u ErrMaximumSwapSize) Is(target error) bool {
+	return target == ErrSwapAmount
+}
+
 type ErrUnknownSwapMessageType string
 
 func (s ErrUnknownSwapMessageType) Error() string {
@@ -40,54 +138,253 @@ func (s PeerNotAllowedError) Error() string {
 	return fmt.Sprintf("peer %s is not on allowlist", string(s))
 }
 
+func (s PeerNotAllowedError) Is(target error) bool {
+	return target == ErrPeerNotAllowed
+}
+
+type PeerRateLimitedError string
+
+func (s PeerRateLimitedError) Error() string {
+	return fmt.Sprintf("peer %s exceeded the swap request rate limit", string(s))
+}
+
+func (s PeerRateLimitedError) Is(target error) bool {
+	return target == ErrPeerRateLimited
+}
+
 type PeerIsSuspiciousError string
 
 func (s PeerIsSuspiciousError) Error() string {
 	return fmt.Sprintf("peer %s is on suspicious peer list", string(s))
 }
 
+func (s PeerIsSuspiciousError) Is(target error) bool {
+	return target == ErrPeerSuspicious
+}
+
 func ErrReceivedMessageFromUnexpectedPeer(peerId string, swapId *SwapId) error {
-	return fmt.Errorf("received a message from an unexpected peer, peerId: %s, swapId: %s", peerId, swapId.String())
+	return fmt.Errorf("%w, peerId: %s, swapId: %s", ErrUnexpectedPeer, peerId, swapId.String())
 }
 
 // SwapService contains the logic for swaps
 type SwapService struct {
 	swapServices *SwapServices
 
-	activeSwaps    map[string]*SwapStateMachine
-	BitcoinEnabled bool
-	LiquidEnabled  bool
+	activeSwaps map[string]*SwapStateMachine
+	// activeSwapsByChannel maps a channel id to the id of the active swap on
+	// that channel, activeSwapChannels holds the reverse mapping.
+	activeSwapsByChannel map[string]string
+	activeSwapChannels   map[string]string
+	// activeSwapsByTxId maps the id of an opening transaction to the id of
+	// the active swap it belongs to.
+	activeSwapsByTxId map[string]string
+	// blocklist holds the peers that may neither request nor be requested
+	// new swaps, also if they are on the allowlist.
+	blocklist map[string]struct{}
+	// inFlightRequests holds the ids of swap requests that are handled but
+	// not yet added to the active swaps.
+	inFlightRequests map[string]struct{}
+	// finishedCallbacks are called when a swap in a terminal state is
+	// removed from the active swaps.
+	finishedCallbacks []func(*SwapStateMachine)
+	BitcoinEnabled    bool
+	LiquidEnabled     bool
+	started           bool
+	stopped           bool
+	paused            bool
+
+	// unknownMessagesLogged holds the time an unknown message type was
+	// last logged.
+	unknownMessagesLogged map[string]time.Time
+	// keepalives holds the outstanding pings of the active swaps.
+	keepalives map[string]*keepaliveState
+	// expiredSwaps holds the committed swaps that exceeded the max swap age
+	// and were already reported.
+	expiredSwaps map[string]struct{}
+	// disconnectTimers holds the timers that cancel the swaps with a peer
+	// that disconnected and did not reconnect within the grace period.
+	disconnectTimers map[string]*time.Timer
+	// statsCache holds the statistics of the swaps.
+	statsCache statsCache
+	// idempotencyMutex serializes the swaps that are started with an
+	// idempotency key, so that a key can not start two swaps.
+	idempotencyMutex sync.Mutex
+	// paymentMutex serializes the processing of invoice payments, so that a
+	// payment that is reported twice is only processed once.
+	paymentMutex sync.Mutex
+	// messageMiddlewares are chained around the handling of peer messages,
+	// the first middleware is the outermost.
+	messageMiddlewares []MessageMiddleware
 	sync.RWMutex
 }
 
 func NewSwapService(services *SwapServices) *SwapService {
 	return &SwapService{
-		swapServices:   services,
-		activeSwaps:    map[string]*SwapStateMachine{},
-		LiquidEnabled:  services.liquidEnabled,
-		BitcoinEnabled: services.bitcoinEnabled,
+		swapServices:         services,
+		activeSwaps:          map[string]*SwapStateMachine{},
+		activeSwapsByChannel: map[string]string{},
+		activeSwapChannels:   map[string]string{},
+		activeSwapsByTxId:    map[string]string{},
+		inFlightRequests:     map[string]struct{}{},
+		LiquidEnabled:        services.liquidEnabled,
+		BitcoinEnabled:       services.bitcoinEnabled,
+
+		unknownMessagesLogged: map[string]time.Time{},
+		keepalives:            map[string]*keepaliveState{},
+		expiredSwaps:          map[string]struct{}{},
+		disconnectTimers:      map[string]*time.Timer{},
 	}
 }
 
 // Start adds callback to the messenger, txwatcher services and lightning client
 func (s *SwapService) Start() error {
+	if err := s.checkNotStarted(); err != nil {
+		return err
+	}
+
 	s.swapServices.toService = newTimeOutService(s.createTimeoutCallback)
 	s.swapServices.messenger.AddMessageHandler(s.OnMessageReceived)
 
 	if s.LiquidEnabled {
+		s.swapServices.setRequiredConfirmations(l_btc_chain, s.swapServices.liquidTxWatcher, s.swapServices.liquidConfirmations)
 		s.swapServices.liquidTxWatcher.AddConfirmationCallback(s.OnTxConfirmed)
 		s.swapServices.liquidTxWatcher.AddCsvCallback(s.OnCsvPassed)
+		if watcher, ok := s.swapServices.liquidTxWatcher.(ReorgWatcher); ok {
+			watcher.AddReorgCallback(s.OnTxReorg)
+		}
+		if watcher, ok := s.swapServices.liquidTxWatcher.(MempoolWatcher); ok {
+			watcher.AddMempoolCallback(s.OnTxSeenInMempool)
+		}
 	}
 	if s.BitcoinEnabled {
+		s.swapServices.setRequiredConfirmations(btc_chain, s.swapServices.bitcoinTxWatcher, s.swapServices.bitcoinConfirmations)
 		s.swapServices.bitcoinTxWatcher.AddConfirmationCallback(s.OnTxConfirmed)
 		s.swapServices.bitcoinTxWatcher.AddCsvCallback(s.OnCsvPassed)
+		if watcher, ok := s.swapServices.bitcoinTxWatcher.(ReorgWatcher); ok {
+			watcher.AddReorgCallback(s.OnTxReorg)
+		}
+		if watcher, ok := s.swapServices.bitcoinTxWatcher.(MempoolWatcher); ok {
+			watcher.AddMempoolCallback(s.OnTxSeenInMempool)
+		}
 	}
 
 	s.swapServices.lightning.AddPaymentCallback(s.OnPayment)
 
+	s.Lock()
+	s.started = true
+	s.Unlock()
+
+	if s.swapServices.keepaliveInterval > 0 {
+		go s.runKeepalives(s.swapServices.keepaliveInterval)
+	}
+	if s.swapServices.swapAgeCheckInterval > 0 {
+		go s.runSwapExpiry(s.swapServices.swapAgeCheckInterval)
+	}
+	if s.swapServices.invoiceCheckInterval > 0 {
+		go s.runInvoiceExpiryCheck(s.swapServices.invoiceCheckInterval)
+	}
+
+	return nil
+}
+
+// checkNotStarted returns ErrServiceAlreadyStarted if the service was already
+// started, or has active swaps or a timeout service that only a start sets.
+func (s *SwapService) checkNotStarted() error {
+	s.RLock()
+	defer s.RUnlock()
+	if s.started {
+		return ErrServiceAlreadyStarted
+	}
+	if n := len(s.activeSwaps); n > 0 {
+		return fmt.Errorf("%w: %d swaps are active", ErrServiceAlreadyStarted, n)
+	}
+	if s.swapServices.toService != nil {
+		return fmt.Errorf("%w: the timeout service is set", ErrServiceAlreadyStarted)
+	}
+	return nil
+}
+
+// Stop cancels all pending timeouts and stops the service from handling
+// incoming messages and starting new swaps. The messenger does not support
+// removing a handler, so messages that arrive after Stop are dropped. The
+// active swaps are persisted so that they can be recovered on the next start.
+func (s *SwapService) Stop() error {
+	s.Lock()
+	if s.stopped {
+		s.Unlock()
+		return nil
+	}
+	s.stopped = true
+	for peerId, timer := range s.disconnectTimers {
+		timer.Stop()
+		delete(s.disconnectTimers, peerId)
+	}
+	swaps := make([]*SwapStateMachine, 0, len(s.activeSwaps))
+	for _, swap := range s.activeSwaps {
+		swaps = append(swaps, swap)
+	}
+	s.Unlock()
+
+	if toService, ok := s.swapServices.toService.(*timeOutService); ok {
+		toService.stop()
+	}
+
+	var errs []string
+	for _, swap := range swaps {
+		swap.mutex.Lock()
+		err := swap.write()
+		swap.mutex.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("swap %s: %v", swap.SwapId.String(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not persist active swaps: %s", strings.Join(errs, ", "))
+	}
 	return nil
 }
 
+// isStopped returns true if Stop was called on the service.
+func (s *SwapService) isStopped() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.stopped
+}
+
+// Pause stops the service from starting new swaps and from accepting swap
+// requests of peers, e.g. during a maintenance of the wallet. Requests are
+// rejected with a cancel message that tells the peer that we are temporarily
+// unavailable. The active swaps continue normally.
+func (s *SwapService) Pause() {
+	s.Lock()
+	defer s.Unlock()
+	s.paused = true
+}
+
+// Resume lets the service start new swaps and accept swap requests again
+// after Pause.
+func (s *SwapService) Resume() {
+	s.Lock()
+	defer s.Unlock()
+	s.paused = false
+}
+
+// IsPaused returns true if the service was paused.
+func (s *SwapService) IsPaused() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.paused
+}
+
+// checkAcceptingSwaps returns an error if new swaps are not accepted because
+// the service is paused or the circuit breaker is open.
+func (s *SwapService) checkAcceptingSwaps() error {
+	if s.IsPaused() {
+		return ErrServicePaused
+	}
+	return s.checkCircuitBreaker()
+}
+
 func (s *SwapService) HasActiveSwaps() (bool, error) {
 	swaps, err := s.swapServices.swapStore.ListAll()
 	if err != nil {
@@ -101,56 +398,351 @@ func (s *SwapService) HasActiveSwaps() (bool, error) {
 	return false, nil
 }
 
-// RecoverSwaps tries to recover swaps that are not yet finished
+// RecoverSwaps tries to recover swaps that are not yet finished. A swap that
+// fails to recover does not stop the recovery of the remaining swaps, all
+// errors are returned combined.
 func (s *SwapService) RecoverSwaps() error {
 	swaps, err := s.swapServices.swapStore.ListAll()
 	if err != nil {
 		return err
 	}
+	var errs []string
 	for _, swap := range swaps {
 		if swap.IsFinished() {
 			continue
 		}
-		if swap.Type == SWAPTYPE_IN && swap.Role == SWAPROLE_SENDER {
-			swap = swapInSenderFromStore(swap, s.swapServices)
-		} else if swap.Type == SWAPTYPE_IN && swap.Role == SWAPROLE_RECEIVER {
-			swap = swapInReceiverFromStore(swap, s.swapServices)
-
-		} else if swap.Type == SWAPTYPE_OUT && swap.Role == SWAPROLE_SENDER {
-			swap = swapOutSenderFromStore(swap, s.swapServices)
-
-		} else if swap.Type == SWAPTYPE_OUT && swap.Role == SWAPROLE_RECEIVER {
-			swap = swapOutReceiverFromStore(swap, s.swapServices)
-		}
-
+		swap = s.swapFromStore(swap)
 		s.AddActiveSwap(swap.SwapId.String(), swap)
 
 		done, err := swap.Recover()
 		if err != nil {
-			return err
+			// Do not let a single broken swap block the recovery of all
+			// other swaps.
+			s.swapServices.logger.Errorf("[SwapService] Could not recover swap %s: %v", swap.SwapId.String(), err)
+			errs = append(errs, fmt.Sprintf("swap %s: %v", swap.SwapId.String(), err))
+			continue
 		}
 
 		if done {
 			s.RemoveActiveSwap(swap.SwapId.String())
 		}
 	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not recover swaps: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// RecoverSwap recovers a single stored swap that is not yet finished. It can
+// be used to retrigger the recovery of a stuck swap.
+func (s *SwapService) RecoverSwap(swapId string) error {
+	if s.isStopped() {
+		return ErrServiceStopped
+	}
+	if _, err := s.GetActiveSwap(swapId); err == nil {
+		return fmt.Errorf("%w: %s", ErrSwapAlreadyActive, swapId)
+	}
+
+	swap, err := s.swapServices.swapStore.GetData(swapId)
+	if err != nil {
+		return err
+	}
+	if swap.IsFinished() {
+		return fmt.Errorf("%w: swap %s is in state %s", ErrSwapFinished, swapId, swap.Current)
+	}
+
+	swap = s.swapFromStore(swap)
+	s.AddActiveSwap(swap.SwapId.String(), swap)
+
+	done, err := swap.Recover()
+	if err != nil {
+		return err
+	}
+	if done {
+		s.RemoveActiveSwap(swap.SwapId.String())
+	}
+	return nil
+}
+
+// AbandonSwap marks a stored swap that is not yet finished as abandoned, so
+// that it is skipped by future recoveries. It is meant for swaps that can not
+// be recovered. No on-chain action is taken and the peer is not notified. A
+// swap whose opening transaction was broadcasted can not be abandoned as the
+// funds are committed and have to be claimed or refunded.
+func (s *SwapService) AbandonSwap(swapId string) error {
+	swap, err := s.GetActiveSwap(swapId)
+	active := err == nil
+	if !active {
+		swap, err = s.swapServices.swapStore.GetData(swapId)
+		if err != nil {
+			return err
+		}
+	}
+
+	swap.mutex.Lock()
+	if swap.IsFinished() {
+		swap.mutex.Unlock()
+		return fmt.Errorf("%w: swap %s is in state %s", ErrSwapFinished, swapId, swap.Current)
+	}
+	if swap.Data != nil && (swap.Data.OpeningTxHex != "" || swap.Data.GetOpeningTxId() != "") {
+		swap.mutex.Unlock()
+		return fmt.Errorf("%w: swap %s committed on-chain funds in state %s", ErrSwapNotAbandonable, swapId, swap.Current)
+	}
+
+	swap.Previous = swap.Current
+	swap.Current = State_SwapAbandoned
+	if swap.Data != nil {
+		swap.Data.cancelTimeout()
+		swap.Data.SetState(swap.Current)
+		swap.Data.UpdatedAt = time.Now().Unix()
+		swap.recordTransition("")
+	}
+	err = s.swapServices.swapStore.UpdateData(swap)
+	swap.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.swapServices.logger.Warnf("[SwapService] Swap %s was abandoned in state %s", swapId, swap.Previous)
+	s.invalidateStats()
+	s.swapServices.messengerManager.RemoveSender(swapId)
+	if active {
+		s.RemoveActiveSwap(swapId)
+	}
+	return nil
+}
+
+// RebroadcastOpeningTx broadcasts the opening transaction of an active swap
+// again, e.g. if it was dropped from the mempool. Only the maker of a swap
+// that awaits the claim payment knows the signed opening transaction, other
+// swaps return ErrSwapNotRebroadcastable. The fee of the transaction is not
+// bumped.
+func (s *SwapService) RebroadcastOpeningTx(swapId string) error {
+	swap, err := s.GetActiveSwap(swapId)
+	if err != nil {
+		return err
+	}
+
+	swap.mutex.Lock()
+	state := swap.Current
+	chain := swap.Data.GetChain()
+	txId := swap.Data.GetOpeningTxId()
+	txHex := swap.Data.OpeningTxHex
+	swap.mutex.Unlock()
+
+	if (state != State_SwapOutReceiver_AwaitClaimInvoicePayment && state != State_SwapInSender_AwaitClaimPayment) || txHex == "" {
+		return fmt.Errorf("%w: swap %s is in state %s", ErrSwapNotRebroadcastable, swapId, state)
+	}
+
+	_, wallet, _, err := s.swapServices.getOnChainServices(chain)
+	if err != nil {
+		return err
+	}
+	rebroadcaster, ok := wallet.(OpeningTxRebroadcaster)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrRebroadcastNotSupported, chain)
+	}
+	if _, err := rebroadcaster.RebroadcastOpeningTx(txHex); err != nil {
+		return fmt.Errorf("could not rebroadcast opening tx %s of swap %s: %w", txId, swapId, err)
+	}
+	swap.logger().Infof("[SwapService] Rebroadcasted opening tx %s", txId)
+	return nil
+}
+
+// claimStates are the states of a swap that claims the opening transaction
+// or that claimed it. The confirmation of the claim transaction is not
+// awaited, so a claimed swap may still have to be bumped.
+var claimStates = map[StateType]struct{}{
+	State_SwapOutSender_ClaimSwap:       {},
+	State_SwapOutReceiver_ClaimSwapCsv:  {},
+	State_SwapOutReceiver_ClaimSwapCoop: {},
+	State_SwapInSender_ClaimSwapCsv:     {},
+	State_SwapInSender_ClaimSwapCoop:    {},
+	State_SwapInReceiver_ClaimSwap:      {},
+	State_ClaimedPreimage:               {},
+	State_ClaimedCsv:                    {},
+	State_ClaimedCoop:                   {},
+}
+
+// BumpClaimTx bumps the fee of the claim transaction of a swap that confirms
+// slowly, so that it is not outrun by the CSV path of the swap partner. The
+// wallet decides whether a child pays for the claim transaction or whether
+// it is replaced, a replacement becomes the claim transaction of the swap.
+// Swaps that did not broadcast a claim transaction return
+// ErrSwapNotBumpable.
+func (s *SwapService) BumpClaimTx(swapId string) error {
+	swap, err := s.getSwap(swapId)
+	if err != nil {
+		return err
+	}
+
+	swap.mutex.Lock()
+	state := swap.Current
+	chain := swap.Data.GetChain()
+	claimTxId := swap.Data.ClaimTxId
+	swap.mutex.Unlock()
+
+	if _, ok := claimStates[state]; !ok || claimTxId == "" {
+		return fmt.Errorf("%w: swap %s is in state %s", ErrSwapNotBumpable, swapId, state)
+	}
+
+	_, wallet, _, err := s.swapServices.getOnChainServices(chain)
+	if err != nil {
+		return err
+	}
+	bumper, ok := wallet.(ClaimTxBumper)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrBumpNotSupported, chain)
+	}
+	replacementTxId, err := bumper.BumpClaimTx(claimTxId)
+	if err != nil {
+		return fmt.Errorf("could not bump claim tx %s of swap %s: %w", claimTxId, swapId, err)
+	}
+	if replacementTxId == "" {
+		s.swapServices.logger.Infof("[SwapService] Bumped claim tx %s of swap %s", claimTxId, swapId)
+		return nil
+	}
+
+	swap.mutex.Lock()
+	swap.Data.ClaimTxId = replacementTxId
+	err = s.swapServices.swapStore.UpdateData(swap)
+	swap.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	s.swapServices.logger.Infof("[SwapService] Replaced claim tx %s of swap %s with %s", claimTxId, swapId, replacementTxId)
 	return nil
 }
 
-// OnMessageReceived handles incoming valid peermessages
+// swapFromStore returns the statemachine for the type and role of the stored
+// swap.
+func (s *SwapService) swapFromStore(swap *SwapStateMachine) *SwapStateMachine {
+	if swap.Type == SWAPTYPE_IN && swap.Role == SWAPROLE_SENDER {
+		return swapInSenderFromStore(swap, s.swapServices)
+	} else if swap.Type == SWAPTYPE_IN && swap.Role == SWAPROLE_RECEIVER {
+		return swapInReceiverFromStore(swap, s.swapServices)
+	} else if swap.Type == SWAPTYPE_OUT && swap.Role == SWAPROLE_SENDER {
+		return swapOutSenderFromStore(swap, s.swapServices)
+	} else if swap.Type == SWAPTYPE_OUT && swap.Role == SWAPROLE_RECEIVER {
+		return swapOutReceiverFromStore(swap, s.swapServices)
+	}
+	return swap
+}
+
+// onUnknownMessage counts the message of an unknown type and logs it at most
+// once per type in unknownMessageLogInterval. The message is counted by
+// whether the type lies in the peerswap message range.
+func (s *SwapService) onUnknownMessage(peerId string, msgType string, inRange bool) {
+	s.swapServices.metrics.unknownMessageReceived(inRange)
+
+	now := time.Now()
+	s.Lock()
+	if len(s.unknownMessagesLogged) >= maxUnknownMessagesLogged {
+		for loggedType, last := range s.unknownMessagesLogged {
+			if now.Sub(last) >= unknownMessageLogInterval {
+				delete(s.unknownMessagesLogged, loggedType)
+			}
+		}
+	}
+	last, ok := s.unknownMessagesLogged[msgType]
+	shouldLog := !ok || now.Sub(last) >= unknownMessageLogInterval
+	if !ok && len(s.unknownMessagesLogged) >= maxUnknownMessagesLogged {
+		shouldLog = false
+	}
+	if shouldLog {
+		s.unknownMessagesLogged[msgType] = now
+	}
+	s.Unlock()
+
+	if shouldLog {
+		s.swapServices.logger.Debugf("[SwapService] Received unknown message type %s from peer %s", msgType, peerId)
+	}
+}
+
+// OnMessageReceived handles incoming valid peermessages. Oversized payloads
+// are rejected before the message passes the message middlewares.
 func (s *SwapService) OnMessageReceived(peerId string, msgTypeString string, payload []byte) error {
-	if len(payload) > 100*1024 {
-		return errors.New("Payload is unexpectedly large")
+	if s.isStopped() {
+		return nil
 	}
-	msgType, err := messages.HexStringToMessageType(msgTypeString)
+	peerId, err := s.swapServices.normalizePeerId(peerId)
 	if err != nil {
 		return err
 	}
+	if len(payload) > s.swapServices.maxMessageSize {
+		s.swapServices.metrics.oversizedPayloadReceived()
+		return PayloadTooLargeError{
+			PeerId: peerId,
+			Size:   len(payload),
+			Limit:  s.swapServices.maxMessageSize,
+		}
+	}
+	return s.messageHandler()(peerId, msgTypeString, payload)
+}
+
+// handleMessage handles a peermessage that passed the message middlewares.
+func (s *SwapService) handleMessage(peerId string, msgTypeString string, payload []byte) error {
+	msgType, err := messages.HexStringToMessageType(msgTypeString)
+	if errors.Is(err, messages.ErrMessageNotInRange) {
+		// Unknown message types are not an error, they are only logged once
+		// per interval so that they do not spam the cln log.
+		s.onUnknownMessage(peerId, msgTypeString, false)
+		return nil
+	} else if err != nil {
+		return err
+	}
 	msgBytes := []byte(payload)
-	log.Debugf("[Messenger] From: %s got msgtype: %s payload: %s", peerId, msgTypeString, payload)
+	messageLogger(s.swapServices.logger, msgBytes).Debugf("[Messenger] From: %s got msgtype: %s payload: %s", peerId, msgTypeString, payload)
 	switch msgType {
 	default:
-		// Do nothing here, as it will spam the cln log.
+		s.onUnknownMessage(peerId, msgTypeString, true)
+		return nil
+	case messages.MESSAGETYPE_SWAPREUNION:
+		var msg *SwapReunionMessage
+		err := json.Unmarshal(msgBytes, &msg)
+		if err != nil {
+			return err
+		}
+
+		// The sender is checked by OnReunionReceived, as a reunion for
+		// a swap that is not active is answered.
+		err = s.OnReunionReceived(peerId, msg)
+		if err != nil {
+			return err
+		}
+	case messages.MESSAGETYPE_PING:
+		var msg *PingMessage
+		err := json.Unmarshal(msgBytes, &msg)
+		if err != nil {
+			return err
+		}
+
+		// Check if sender is expected swap partner peer.
+		err = s.checkMessageSender(peerId, msg.SwapId)
+		if err != nil {
+			return err
+		}
+
+		err = s.OnPingReceived(peerId, msg)
+		if err != nil {
+			return err
+		}
+	case messages.MESSAGETYPE_PONG:
+		var msg *PongMessage
+		err := json.Unmarshal(msgBytes, &msg)
+		if err != nil {
+			return err
+		}
+
+		// Check if sender is expected swap partner peer.
+		err = s.checkMessageSender(peerId, msg.SwapId)
+		if err != nil {
+			return err
+		}
+
+		s.OnPongReceived(msg)
+	case messages.MESSAGETYPE_POLL, messages.MESSAGETYPE_REQUEST_POLL:
+		// Poll messages are handled by the poll service.
 		return nil
 	case messages.MESSAGETYPE_SWAPOUTREQUEST:
 		var msg *SwapOutRequestMessage
@@ -170,13 +762,10 @@ func (s *SwapService) OnMessageReceived(peerId string, msgTypeString string, pay
 		}
 
 		// Check if sender is expected swap partner peer.
-		ok, err := s.isMessageSenderExpectedPeer(peerId, msg.SwapId)
+		err = s.checkMessageSender(peerId, msg.SwapId)
 		if err != nil {
 			return err
 		}
-		if !ok {
-			return ErrReceivedMessageFromUnexpectedPeer(peerId, msg.SwapId)
-		}
 
 		err = s.OnSwapOutAgreementReceived(msg)
 		if err != nil {
@@ -190,13 +779,10 @@ func (s *SwapService) OnMessageReceived(peerId string, msgTypeString string, pay
 		}
 
 		// Check if sender is expected swap partner peer.
-		ok, err := s.isMessageSenderExpectedPeer(peerId, msg.SwapId)
+		err = s.checkMessageSender(peerId, msg.SwapId)
 		if err != nil {
 			return err
 		}
-		if !ok {
-			return ErrReceivedMessageFromUnexpectedPeer(peerId, msg.SwapId)
-		}
 
 		err = s.OnTxOpenedMessage(msg)
 		if err != nil {
@@ -210,13 +796,10 @@ func (s *SwapService) OnMessageReceived(peerId string, msgTypeString string, pay
 		}
 
 		// Check if sender is expected swap partner peer.
-		ok, err := s.isMessageSenderExpectedPeer(peerId, msg.SwapId)
+		err = s.checkMessageSender(peerId, msg.SwapId)
 		if err != nil {
 			return err
 		}
-		if !ok {
-			return ErrReceivedMessageFromUnexpectedPeer(peerId, msg.SwapId)
-		}
 
 		err = s.OnCancelReceived(msg.SwapId, msg)
 		if err != nil {
@@ -240,13 +823,10 @@ func (s *SwapService) OnMessageReceived(peerId string, msgTypeString string, pay
 		}
 
 		// Check if sender is expected swap partner peer.
-		ok, err := s.isMessageSenderExpectedPeer(peerId, msg.SwapId)
+		err = s.checkMessageSender(peerId, msg.SwapId)
 		if err != nil {
 			return err
 		}
-		if !ok {
-			return ErrReceivedMessageFromUnexpectedPeer(peerId, msg.SwapId)
-		}
 
 		err = s.OnSwapInAgreementReceived(msg)
 		if err != nil {
@@ -260,13 +840,10 @@ func (s *SwapService) OnMessageReceived(peerId string, msgTypeString string, pay
 		}
 
 		// Check if sender is expected swap partner peer.
-		ok, err := s.isMessageSenderExpectedPeer(peerId, msg.SwapId)
+		err = s.checkMessageSender(peerId, msg.SwapId)
 		if err != nil {
 			return err
 		}
-		if !ok {
-			return ErrReceivedMessageFromUnexpectedPeer(peerId, msg.SwapId)
-		}
 
 		err = s.OnCoopCloseReceived(msg.SwapId, msg)
 		if err != nil {
@@ -276,15 +853,26 @@ func (s *SwapService) OnMessageReceived(peerId string, msgTypeString string, pay
 	return nil
 }
 
-// OnTxConfirmed sends the txconfirmed event to the corresponding swap
+// OnTxConfirmed sends the txconfirmed event to the corresponding swap. The
+// txwatchers may report a confirmation more than once, a confirmation for a
+// swap that already consumed it is ignored.
 func (s *SwapService) OnTxConfirmed(swapId string, txHex string) error {
 	swap, err := s.GetActiveSwap(swapId)
 	if err != nil {
 		return err
 	}
+
+	swap.mutex.Lock()
+	if !swap.EventIsValid(Event_OnTxConfirmed) {
+		swap.mutex.Unlock()
+		swap.logger().Debugf("[SwapService] Ignoring duplicate tx confirmation in state %s", swap.Current)
+		return nil
+	}
 	// todo move to eventctx
 	swap.Data.OpeningTxHex = txHex
-	done, err := swap.SendEvent(Event_OnTxConfirmed, nil)
+	swap.mutex.Unlock()
+
+	done, err := s.sendEvent(swap, Event_OnTxConfirmed, nil)
 	if err == ErrEventRejected {
 		return nil
 	} else if err != nil {
@@ -296,13 +884,45 @@ func (s *SwapService) OnTxConfirmed(swapId string, txHex string) error {
 	return nil
 }
 
+// OnTxSeenInMempool records the time at which the opening transaction of the
+// swap was first seen unconfirmed and publishes SwapEventOpeningTxSeen. It
+// gives early feedback that the maker broadcasted the opening transaction,
+// the swap still waits for the confirmation. Later sightings are ignored.
+func (s *SwapService) OnTxSeenInMempool(swapId string, txId string) error {
+	swap, err := s.GetActiveSwap(swapId)
+	if err != nil {
+		return err
+	}
+
+	swap.mutex.Lock()
+	if swap.Data.OpeningTxSeenAt != 0 {
+		swap.mutex.Unlock()
+		return nil
+	}
+	if openingTxId := swap.Data.GetOpeningTxId(); openingTxId != txId {
+		swap.mutex.Unlock()
+		return fmt.Errorf("tx %s is not the opening tx %s of swap %s", txId, openingTxId, swapId)
+	}
+	swap.Data.OpeningTxSeenAt = s.swapServices.now().Unix()
+	err = s.swapServices.swapStore.UpdateData(swap)
+	event := newSwapEvent(SwapEventOpeningTxSeen, swap, swap.Current, swap.Current)
+	swap.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	swap.logger().Infof("[SwapService] Opening tx %s is in the mempool", txId)
+	s.swapServices.publishSwapEvent(event)
+	return nil
+}
+
 // OnCsvPassed sends the csvpassed event to the corresponding swap
 func (s *SwapService) OnCsvPassed(swapId string) error {
 	swap, err := s.GetActiveSwap(swapId)
 	if err != nil {
 		return err
 	}
-	done, err := swap.SendEvent(Event_OnCsvPassed, nil)
+	done, err := s.sendEvent(swap, Event_OnCsvPassed, nil)
 	if err == ErrEventRejected {
 		return nil
 	} else if err != nil {
@@ -314,49 +934,148 @@ func (s *SwapService) OnCsvPassed(swapId string) error {
 	return nil
 }
 
+// ListRefundableSwaps returns the active swaps that wait for the csv of the
+// opening transaction and whose csv has passed at the current block height.
+// These swaps are refunded once the txwatcher reports the passed csv.
+func (s *SwapService) ListRefundableSwaps() ([]*SwapStateMachine, error) {
+	var refundable []*SwapStateMachine
+	for _, swap := range s.GetActiveSwaps() {
+		passed, err := s.csvPassed(swap)
+		if err != nil {
+			return nil, err
+		}
+		if passed {
+			refundable = append(refundable, swap)
+		}
+	}
+	return refundable, nil
+}
+
+// ForceRefund refunds the swap if its csv has passed, as if the txwatcher
+// reported the passed csv. It is meant for the case that the txwatcher missed
+// the csv of the opening transaction.
+func (s *SwapService) ForceRefund(swapId string) error {
+	swap, err := s.GetActiveSwap(swapId)
+	if err != nil {
+		return err
+	}
+
+	passed, err := s.csvPassed(swap)
+	if err != nil {
+		return err
+	}
+	if !passed {
+		return fmt.Errorf("%w: csv of swap %s has not passed", ErrSwapNotRefundable, swapId)
+	}
+
+	done, err := s.sendEvent(swap, Event_OnCsvPassed, nil)
+	if err == ErrEventRejected {
+		return fmt.Errorf("%w: swap %s is in state %s", ErrSwapNotRefundable, swapId, swap.Current)
+	} else if err != nil {
+		return err
+	}
+	if done {
+		s.RemoveActiveSwap(swap.SwapId.String())
+	}
+	return nil
+}
+
+// csvPassed returns true if the swap waits for the csv of the opening
+// transaction and the csv has passed at the current block height.
+func (s *SwapService) csvPassed(swap *SwapStateMachine) (bool, error) {
+	swap.mutex.Lock()
+	defer swap.mutex.Unlock()
+
+	if !swap.EventIsValid(Event_OnCsvPassed) || swap.Data.StartingBlockHeight == 0 {
+		return false, nil
+	}
+
+	txWatcher, _, validator, err := s.swapServices.getOnChainServices(swap.Data.GetChain())
+	if err != nil {
+		return false, err
+	}
+	height, err := txWatcher.GetBlockHeight()
+	if err != nil {
+		return false, err
+	}
+	return height >= swap.Data.StartingBlockHeight+validator.GetCSVHeight(), nil
+}
+
 // todo move wallet and chain / channel validation logic here
 // SwapOut starts a new swap out process
 func (s *SwapService) SwapOut(peer string, chain string, channelId string, initiator string, amtSat uint64) (*SwapStateMachine, error) {
-	if !s.swapServices.policy.NewSwapsAllowed() {
-		return nil, fmt.Errorf("swaps are disabled")
+	return s.SwapOutContext(context.Background(), peer, chain, channelId, initiator, amtSat)
+}
+
+// SwapOutContext starts a new swap out process. The swap is aborted with
+// the context error if the context is done before the swap request was sent.
+func (s *SwapService) SwapOutContext(ctx context.Context, peer string, chain string, channelId string, initiator string, amtSat uint64) (*SwapStateMachine, error) {
+	return s.swapOut(ctx, "", peer, []string{chain}, channelId, initiator, amtSat)
+}
+
+// swapOut starts a new swap out process that stores the idempotency key.
+func (s *SwapService) swapOut(ctx context.Context, idempotencyKey string, peer string, chains []string, channelId string, initiator string, amtSat uint64) (*SwapStateMachine, error) {
+	peer, err := s.swapServices.normalizePeerId(peer)
+	if err != nil {
+		return nil, err
 	}
 
-	if s.hasActiveSwapOnChannel(channelId) {
-		return nil, fmt.Errorf("already has an active swap on channel")
+	if s.isPeerBlocked(peer) {
+		return nil, PeerNotAllowedError(peer)
 	}
 
-	if s.swapServices.policy.IsPeerSuspicious(peer) {
-		return nil, PeerIsSuspiciousError(peer)
+	if err := s.checkAcceptingSwaps(); err != nil {
+		return nil, err
 	}
 
-	if amtSat*1000 < s.swapServices.policy.GetMinSwapAmountMsat() {
-		return nil, ErrMinimumSwapSize(s.swapServices.policy.GetMinSwapAmountMsat())
+	if err := s.swapServices.checkChainsEnabled(chains); err != nil {
+		return nil, err
 	}
 
-	swap := newSwapOutSenderFSM(s.swapServices, initiator, peer)
-	s.AddActiveSwap(swap.SwapId.String(), swap)
+	if err := s.checkNewSwap(peer, channelId, amtSat); err != nil {
+		return nil, err
+	}
 
-	var bitcoinNetwork string
-	var elementsAsset string
-	if chain == l_btc_chain {
-		elementsAsset = s.swapServices.liquidWallet.GetAsset()
-	} else if chain == btc_chain {
-		bitcoinNetwork = s.swapServices.bitcoinWallet.GetNetwork()
-	} else {
-		return nil, errors.New("invalid chain")
+	if err := s.swapServices.checkPeerConnected(peer); err != nil {
+		return nil, err
+	}
+
+	if err := s.swapServices.checkInitiator(initiator); err != nil {
+		return nil, err
+	}
+
+	options, err := s.getChainOptions(ctx, chains)
+	if err != nil {
+		return nil, err
+	}
+
+	swap := newSwapOutSenderFSM(s.swapServices, initiator, peer)
+	swap.Data.IdempotencyKey = idempotencyKey
+	if err := s.addNewActiveSwap(swap.SwapId.String(), channelId, swap); err != nil {
+		return nil, err
 	}
 
 	request := &SwapOutRequestMessage{
 		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
 		SwapId:          swap.SwapId,
-		Asset:           elementsAsset,
-		Network:         bitcoinNetwork,
+		Asset:           options[0].Asset,
+		Network:         options[0].Network,
 		Scid:            channelId,
 		Amount:          amtSat,
 		Pubkey:          hex.EncodeToString(swap.Data.GetPrivkey().PubKey().SerializeCompressed()),
+		Nonce:           newNonce(),
+	}
+	if len(options) > 1 {
+		request.ChainOptions = options
+	}
+
+	if err := ctx.Err(); err != nil {
+		s.RemoveActiveSwap(swap.SwapId.String())
+		return nil, err
 	}
 
-	done, err := swap.SendEvent(Event_OnSwapOutStarted, request)
+	done, err := s.sendEvent(swap, Event_OnSwapOutStarted, request)
+	s.swapServices.metrics.swapStarted(swap)
 	if err != nil {
 		return nil, err
 	}
@@ -370,45 +1089,77 @@ func (s *SwapService) SwapOut(peer string, chain string, channelId string, initi
 // todo check prerequisites
 // SwapIn starts a new swap in process
 func (s *SwapService) SwapIn(peer string, chain string, channelId string, initiator string, amtSat uint64) (*SwapStateMachine, error) {
-	if !s.swapServices.policy.NewSwapsAllowed() {
-		return nil, fmt.Errorf("swaps are disabled")
+	return s.SwapInContext(context.Background(), peer, chain, channelId, initiator, amtSat)
+}
+
+// SwapInContext starts a new swap in process. The swap is aborted with
+// the context error if the context is done before the swap request was sent.
+func (s *SwapService) SwapInContext(ctx context.Context, peer string, chain string, channelId string, initiator string, amtSat uint64) (*SwapStateMachine, error) {
+	return s.swapIn(ctx, "", peer, []string{chain}, channelId, initiator, amtSat)
+}
+
+// swapIn starts a new swap in process that stores the idempotency key.
+func (s *SwapService) swapIn(ctx context.Context, idempotencyKey string, peer string, chains []string, channelId string, initiator string, amtSat uint64) (*SwapStateMachine, error) {
+	peer, err := s.swapServices.normalizePeerId(peer)
+	if err != nil {
+		return nil, err
 	}
 
-	if s.hasActiveSwapOnChannel(channelId) {
-		return nil, fmt.Errorf("already has an active swap on channel")
+	if s.isPeerBlocked(peer) {
+		return nil, PeerNotAllowedError(peer)
 	}
 
-	if s.swapServices.policy.IsPeerSuspicious(peer) {
-		return nil, PeerIsSuspiciousError(peer)
+	if err := s.checkAcceptingSwaps(); err != nil {
+		return nil, err
 	}
 
-	if amtSat*1000 < s.swapServices.policy.GetMinSwapAmountMsat() {
-		return nil, ErrMinimumSwapSize(s.swapServices.policy.GetMinSwapAmountMsat())
+	if err := s.swapServices.checkChainsEnabled(chains); err != nil {
+		return nil, err
 	}
 
-	var bitcoinNetwork string
-	var elementsAsset string
-	if chain == l_btc_chain {
-		elementsAsset = s.swapServices.liquidWallet.GetAsset()
-	} else if chain == btc_chain {
-		bitcoinNetwork = s.swapServices.bitcoinWallet.GetNetwork()
-	} else {
-		return nil, errors.New("invalid chain")
+	if err := s.checkNewSwap(peer, channelId, amtSat); err != nil {
+		return nil, err
+	}
+
+	if err := s.swapServices.checkPeerConnected(peer); err != nil {
+		return nil, err
+	}
+
+	if err := s.swapServices.checkInitiator(initiator); err != nil {
+		return nil, err
+	}
+
+	options, err := s.getChainOptions(ctx, chains)
+	if err != nil {
+		return nil, err
 	}
 	swap := newSwapInSenderFSM(s.swapServices, initiator, peer)
-	s.AddActiveSwap(swap.SwapId.String(), swap)
+	swap.Data.IdempotencyKey = idempotencyKey
+	if err := s.addNewActiveSwap(swap.SwapId.String(), channelId, swap); err != nil {
+		return nil, err
+	}
 
 	request := &SwapInRequestMessage{
 		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
 		SwapId:          swap.SwapId,
-		Asset:           elementsAsset,
-		Network:         bitcoinNetwork,
+		Asset:           options[0].Asset,
+		Network:         options[0].Network,
 		Scid:            channelId,
 		Amount:          amtSat,
 		Pubkey:          hex.EncodeToString(swap.Data.GetPrivkey().PubKey().SerializeCompressed()),
+		Nonce:           newNonce(),
+	}
+	if len(options) > 1 {
+		request.ChainOptions = options
+	}
+
+	if err := ctx.Err(); err != nil {
+		s.RemoveActiveSwap(swap.SwapId.String())
+		return nil, err
 	}
 
-	done, err := swap.SendEvent(Event_SwapInSender_OnSwapInRequested, request)
+	done, err := s.sendEvent(swap, Event_SwapInSender_OnSwapInRequested, request)
+	s.swapServices.metrics.swapStarted(swap)
 	if err != nil {
 		return nil, err
 	}
@@ -418,42 +1169,415 @@ func (s *SwapService) SwapIn(peer string, chain string, channelId string, initia
 	return swap, nil
 }
 
+// checkNewSwap checks if we are allowed to start a new swap with the peer on
+// the channel.
+func (s *SwapService) checkNewSwap(peer string, channelId string, amtSat uint64) error {
+	if s.isStopped() {
+		return ErrServiceStopped
+	}
+
+	if !s.swapServices.policy.NewSwapsAllowed() {
+		return ErrSwapsDisabled
+	}
+
+	if err := validateScid(channelId); err != nil {
+		return fmt.Errorf("%w: %q", err, channelId)
+	}
+
+	if err := s.checkChannelAvailable(channelId, amtSat); err != nil {
+		return err
+	}
+
+	if err := s.checkSwapLimit(); err != nil {
+		return err
+	}
+
+	if err := s.checkPeerSwapLimit(peer); err != nil {
+		return err
+	}
+
+	if s.swapServices.policy.IsPeerSuspicious(peer) {
+		return PeerIsSuspiciousError(peer)
+	}
+
+	return s.checkAmount(amtSat)
+}
+
+// checkAmount returns an error if the amount of a new swap is below the
+// minimum of the policy or out of the configured swap amount limits.
+func (s *SwapService) checkAmount(amtSat uint64) error {
+	if amtSat*1000 < s.swapServices.policy.GetMinSwapAmountMsat() {
+		return ErrMinimumSwapSize(s.swapServices.policy.GetMinSwapAmountMsat())
+	}
+
+	return s.swapServices.checkSwapAmount(amtSat)
+}
+
+// getChainParams returns the bitcoin network or the elements asset for the
+// chain. The wallet is queried in the background so that the call returns
+// with the context error if the context is done first.
+func (s *SwapService) getChainParams(ctx context.Context, chain string) (bitcoinNetwork string, elementsAsset string, err error) {
+	if !s.swapServices.isAssetAllowed(chain) {
+		return "", "", WrongAssetError(chain)
+	}
+
+	var get func() string
+	if chain == l_btc_chain {
+		get = s.swapServices.liquidWallet.GetAsset
+	} else if chain == btc_chain {
+		get = s.swapServices.bitcoinWallet.GetNetwork
+	} else {
+		return "", "", errors.New("invalid chain")
+	}
+
+	res := make(chan string, 1)
+	go func() {
+		res <- get()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", "", ctx.Err()
+	case param := <-res:
+		if chain == l_btc_chain {
+			return "", param, nil
+		}
+		return param, "", nil
+	}
+}
+
 // OnSwapInRequestReceived creates a new swap-in process and sends the event to the swap statemachine
 func (s *SwapService) OnSwapInRequestReceived(swapId *SwapId, peerId string, message *SwapInRequestMessage) error {
+	// reject a blocked peer before any other processing
+	if s.isPeerBlocked(peerId) {
+		return s.rejectRequest(swapId, peerId, CancelReasonPeerNotAllowed, PeerNotAllowedError(peerId))
+	}
+
+	// drop a retransmitted request for a swap that is already handled
+	if !s.beginRequest(swapId.String()) {
+		return fmt.Errorf("%w %s", ErrDuplicateSwapId, swapId.String())
+	}
+	defer s.endRequest(swapId.String())
+
+	// reject the request if the swap id was already used
+	if err := s.checkSwapIdUnused(swapId, peerId); err != nil {
+		return err
+	}
+
+	// reject the request while the service is paused or the circuit
+	// breaker is open
+	if err := s.checkAcceptingSwaps(); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonUnavailable, err)
+	}
+
+	// resolve a swap that we requested from the peer on the channel at the
+	// same time
+	if err := s.resolveCrossingSwap(swapId, peerId, message.Scid); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonCrossingSwap, err)
+	}
+
 	// check if a swap is already active on the channel
-	if s.hasActiveSwapOnChannel(message.Scid) {
-		return fmt.Errorf("already has an active swap on channel")
+	if err := s.checkChannelAvailable(message.Scid, message.Amount); err != nil {
+		return err
+	}
+
+	if !s.isPeerOnAllowlist(peerId) {
+		return s.rejectRequest(swapId, peerId, CancelReasonPeerNotAllowed, PeerNotAllowedError(peerId))
+	}
+
+	// reject the request before any work is done if the peer sends too many
+	// requests
+	if !s.swapServices.allowRequest(peerId) {
+		return s.rejectRequest(swapId, peerId, CancelReasonRateLimited, PeerRateLimitedError(peerId))
+	}
+
+	// reject the request if the peer speaks an incompatible protocol version
+	if !s.swapServices.isProtocolVersionAccepted(message.ProtocolVersion) {
+		return s.rejectRequest(swapId, peerId, CancelReasonProtocolVersion, ProtocolVersionError(message.ProtocolVersion))
+	}
+
+	// reject the request if the pubkey can not be used in the opening script
+	if err := validatePubkey("pubkey", message.Pubkey); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonInvalidMessage, fmt.Errorf("invalid message: %w", err))
+	}
+
+	// reject the request if we have too many active swaps
+	if err := s.checkSwapLimit(); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonSwapLimit, err)
+	}
+
+	// reject the request if the peer has too many active swaps
+	if err := s.checkPeerSwapLimit(peerId); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonSwapLimit, err)
+	}
+
+	// select the first chain of the chain options that we support
+	if len(message.ChainOptions) > 0 {
+		option, err := s.swapServices.selectChainOption(message.ChainOptions)
+		if err != nil {
+			return s.rejectRequest(swapId, peerId, CancelReasonUnsupportedAsset, err)
+		}
+		selected := *message
+		selected.Asset, selected.Network = option.Asset, option.Network
+		message = &selected
+	}
+
+	// reject the request if the chain was disabled at runtime
+	if chain := getChain(message.Asset, message.Network); s.swapServices.isChainDisabled(chain) {
+		return s.rejectRequest(swapId, peerId, CancelReasonUnsupportedAsset, ChainDisabledError(chain))
 	}
 
 	swap := newSwapInReceiverFSM(swapId, s.swapServices, peerId)
-	s.AddActiveSwap(swapId.String(), swap)
+	if err := s.addNewActiveSwap(swapId.String(), message.Scid, swap); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonSwapLimit, err)
+	}
 
-	done, err := swap.SendEvent(Event_SwapInReceiver_OnRequestReceived, message)
+	done, err := s.sendEvent(swap, Event_SwapInReceiver_OnRequestReceived, message)
+	s.swapServices.metrics.swapStarted(swap)
 	if done {
 		s.RemoveActiveSwap(swap.SwapId.String())
 	}
 	return err
 }
 
-// OnSwapInRequestReceived creates a new swap-out process and sends the event to the swap statemachine
-func (s *SwapService) OnSwapOutRequestReceived(swapId *SwapId, peerId string, message *SwapOutRequestMessage) error {
-	// check if a swap is already active on the channel
-	if s.hasActiveSwapOnChannel(message.Scid) {
-		return fmt.Errorf("already has an active swap on channel")
+// OnSwapInRequestReceived creates a new swap-out process and sends the event to the swap statemachine
+func (s *SwapService) OnSwapOutRequestReceived(swapId *SwapId, peerId string, message *SwapOutRequestMessage) error {
+	// reject a blocked peer before any other processing
+	if s.isPeerBlocked(peerId) {
+		return s.rejectRequest(swapId, peerId, CancelReasonPeerNotAllowed, PeerNotAllowedError(peerId))
+	}
+
+	// drop a retransmitted request for a swap that is already handled
+	if !s.beginRequest(swapId.String()) {
+		return fmt.Errorf("%w %s", ErrDuplicateSwapId, swapId.String())
+	}
+	defer s.endRequest(swapId.String())
+
+	// reject the request if the swap id was already used
+	if err := s.checkSwapIdUnused(swapId, peerId); err != nil {
+		return err
+	}
+
+	// reject the request while the service is paused or the circuit
+	// breaker is open
+	if err := s.checkAcceptingSwaps(); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonUnavailable, err)
+	}
+
+	// resolve a swap that we requested from the peer on the channel at the
+	// same time
+	if err := s.resolveCrossingSwap(swapId, peerId, message.Scid); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonCrossingSwap, err)
+	}
+
+	// check if a swap is already active on the channel
+	if err := s.checkChannelAvailable(message.Scid, message.Amount); err != nil {
+		return err
+	}
+
+	if !s.isPeerOnAllowlist(peerId) {
+		return s.rejectRequest(swapId, peerId, CancelReasonPeerNotAllowed, PeerNotAllowedError(peerId))
+	}
+
+	// reject the request before any work is done if the peer sends too many
+	// requests
+	if !s.swapServices.allowRequest(peerId) {
+		return s.rejectRequest(swapId, peerId, CancelReasonRateLimited, PeerRateLimitedError(peerId))
+	}
+
+	// reject the request if the peer speaks an incompatible protocol version
+	if !s.swapServices.isProtocolVersionAccepted(message.ProtocolVersion) {
+		return s.rejectRequest(swapId, peerId, CancelReasonProtocolVersion, ProtocolVersionError(message.ProtocolVersion))
+	}
+
+	// reject the request if the pubkey can not be used in the opening script
+	if err := validatePubkey("pubkey", message.Pubkey); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonInvalidMessage, fmt.Errorf("invalid message: %w", err))
+	}
+
+	// reject the request if we have too many active swaps
+	if err := s.checkSwapLimit(); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonSwapLimit, err)
+	}
+
+	// reject the request if the peer has too many active swaps
+	if err := s.checkPeerSwapLimit(peerId); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonSwapLimit, err)
+	}
+
+	// select the first chain of the chain options that we support
+	if len(message.ChainOptions) > 0 {
+		option, err := s.swapServices.selectChainOption(message.ChainOptions)
+		if err != nil {
+			return s.rejectRequest(swapId, peerId, CancelReasonUnsupportedAsset, err)
+		}
+		selected := *message
+		selected.Asset, selected.Network = option.Asset, option.Network
+		message = &selected
+	}
+
+	// reject the request if the chain was disabled at runtime
+	if chain := getChain(message.Asset, message.Network); s.swapServices.isChainDisabled(chain) {
+		return s.rejectRequest(swapId, peerId, CancelReasonUnsupportedAsset, ChainDisabledError(chain))
+	}
+
+	// reject the request if we would pay too much for the opening
+	// transaction
+	if err := s.checkOpeningTxFeeRate(getChain(message.Asset, message.Network)); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonFeeTooHigh, err)
+	}
+
+	swap := newSwapOutReceiverFSM(swapId, s.swapServices, peerId)
+
+	if err := s.addNewActiveSwap(swapId.String(), message.Scid, swap); err != nil {
+		return s.rejectRequest(swapId, peerId, CancelReasonSwapLimit, err)
+	}
+
+	done, err := s.sendEvent(swap, Event_OnSwapOutRequestReceived, message)
+	s.swapServices.metrics.swapStarted(swap)
+	if err != nil {
+		return err
+	}
+	if done {
+		s.RemoveActiveSwap(swap.SwapId.String())
+	}
+	return nil
+}
+
+// ReloadAllowlist replaces the peers on the allowlist of the policy, which
+// stays the only source of the allowlist. The policy file is reloaded first,
+// so that the peers are diffed against its current content. Swaps that are
+// already active with a peer that is removed from the allowlist are not
+// affected. An empty or nil slice removes all peers from the allowlist.
+func (s *SwapService) ReloadAllowlist(peers []string) error {
+	editor, ok := s.swapServices.policy.(AllowlistEditor)
+	if !ok {
+		return ErrAllowlistNotEditable
+	}
+
+	allowlist := make(map[string]struct{}, len(peers))
+	for _, peer := range peers {
+		peer = canonicalPeerId(peer)
+		if err := validateHexString("peer", peer, 33); err != nil {
+			return err
+		}
+		allowlist[peer] = struct{}{}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if err := editor.ReloadFile(); err != nil {
+		return err
+	}
+	current := make(map[string]struct{})
+	for _, peer := range editor.GetPeerAllowlist() {
+		current[peer] = struct{}{}
+		if _, ok := allowlist[peer]; ok {
+			continue
+		}
+		if err := editor.RemoveFromAllowlist(peer); err != nil {
+			return err
+		}
+	}
+	for peer := range allowlist {
+		if _, ok := current[peer]; ok {
+			continue
+		}
+		if err := editor.AddToAllowlist(peer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isPeerOnAllowlist returns true if the policy allows the peer to request
+// new swaps.
+func (s *SwapService) isPeerOnAllowlist(peerId string) bool {
+	return s.swapServices.policy.IsPeerAllowed(peerId)
+}
+
+// ReloadBlocklist replaces the set of peers that are blocked from swaps. A
+// blocked peer is rejected before any other check, also if it is on the
+// allowlist, and we do not start new swaps with it. Swaps that are already
+// active with a peer that is added to the set are not affected. An empty or
+// nil slice unblocks all peers.
+func (s *SwapService) ReloadBlocklist(peers []string) error {
+	blocklist := make(map[string]struct{}, len(peers))
+	for _, peer := range peers {
+		peer = canonicalPeerId(peer)
+		if err := validateHexString("peer", peer, 33); err != nil {
+			return err
+		}
+		blocklist[peer] = struct{}{}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.blocklist = blocklist
+	return nil
+}
+
+// isPeerBlocked returns true if the peer is on the blocklist.
+func (s *SwapService) isPeerBlocked(peerId string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.blocklist[peerId]
+	return ok
+}
+
+// beginRequest marks the request of the swap as in flight. Returns false if
+// a request of the swap is already in flight or the swap is active.
+func (s *SwapService) beginRequest(swapId string) bool {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.inFlightRequests[swapId]; ok {
+		return false
+	}
+	if _, ok := s.activeSwaps[swapId]; ok {
+		return false
 	}
+	s.inFlightRequests[swapId] = struct{}{}
+	return true
+}
 
-	swap := newSwapOutReceiverFSM(swapId, s.swapServices, peerId)
+// endRequest clears the in flight mark of a request that was rejected before
+// the swap was added to the active swaps.
+func (s *SwapService) endRequest(swapId string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.inFlightRequests, swapId)
+}
 
-	s.AddActiveSwap(swapId.String(), swap)
+// checkSwapIdUnused rejects the request if a swap with the id already exists
+// in the store. The stored swap is not touched.
+func (s *SwapService) checkSwapIdUnused(swapId *SwapId, peerId string) error {
+	_, err := s.swapServices.swapStore.GetData(swapId.String())
+	if err == ErrDataNotAvailable {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.rejectRequest(swapId, peerId, CancelReasonDuplicateSwapId, fmt.Errorf("%w %s", ErrDuplicateSwapId, swapId.String()))
+}
 
-	done, err := swap.SendEvent(Event_OnSwapOutRequestReceived, message)
+// rejectRequest sends a cancel message with the reason code for a swap
+// request that is rejected before a swap statemachine was created and returns
+// the reason.
+func (s *SwapService) rejectRequest(swapId *SwapId, peerId string, code CancelReason, reason error) error {
+	msgBytes, msgType, err := MarshalPeerswapMessage(&CancelMessage{
+		SwapId:  swapId,
+		Reason:  code,
+		Message: reason.Error(),
+	})
 	if err != nil {
 		return err
 	}
-	if done {
-		s.RemoveActiveSwap(swap.SwapId.String())
+	err = s.swapServices.messenger.SendMessage(peerId, msgBytes, msgType)
+	if err != nil {
+		return err
 	}
-	return nil
+	return reason
 }
 
 // OnSwapInAgreementReceived sends the agreementreceived event to the corresponding swap state machine
@@ -463,7 +1587,7 @@ func (s *SwapService) OnSwapInAgreementReceived(msg *SwapInAgreementMessage) err
 		return err
 	}
 
-	done, err := swap.SendEvent(Event_SwapInSender_OnAgreementReceived, msg)
+	done, err := s.sendEvent(swap, Event_SwapInSender_OnAgreementReceived, msg)
 	if err != nil {
 		return err
 	}
@@ -480,7 +1604,7 @@ func (s *SwapService) OnSwapOutAgreementReceived(message *SwapOutAgreementMessag
 		return err
 	}
 
-	done, err := swap.SendEvent(Event_OnFeeInvoiceReceived, message)
+	done, err := s.sendEvent(swap, Event_OnFeeInvoiceReceived, message)
 	if err != nil {
 		return err
 	}
@@ -497,7 +1621,7 @@ func (s *SwapService) OnFeeInvoiceNotification(swapId *SwapId) error {
 		return err
 	}
 
-	done, err := swap.SendEvent(Event_OnFeeInvoicePaid, nil)
+	done, err := s.sendEvent(swap, Event_OnFeeInvoicePaid, nil)
 	if err != nil {
 		return err
 	}
@@ -515,7 +1639,7 @@ func (s *SwapService) OnClaimInvoiceNotification(swapId *SwapId) error {
 		return err
 	}
 
-	done, err := swap.SendEvent(Event_OnClaimInvoicePaid, nil)
+	done, err := s.sendEvent(swap, Event_OnClaimInvoicePaid, nil)
 	if err != nil {
 		return err
 	}
@@ -532,7 +1656,7 @@ func (s *SwapService) OnTxOpenedMessage(message *OpeningTxBroadcastedMessage) er
 		return err
 	}
 
-	done, err := swap.SendEvent(Event_OnTxOpenedMessage, message)
+	done, err := s.sendEvent(swap, Event_OnTxOpenedMessage, message)
 	if err != nil {
 		return err
 	}
@@ -542,57 +1666,94 @@ func (s *SwapService) OnTxOpenedMessage(message *OpeningTxBroadcastedMessage) er
 	return nil
 }
 
-// OnTxOpenedMessage sends the TxConfirmed event to the corresponding swap state machine
+// SenderOnTxConfirmed sends the TxConfirmed event to the corresponding swap state machine
 func (s *SwapService) SenderOnTxConfirmed(swapId string) error {
 	swap, err := s.GetActiveSwap(swapId)
 	if err != nil {
 		return err
 	}
-	done, err := swap.SendEvent(Event_OnTxConfirmed, nil)
+	done, err := s.sendEvent(swap, Event_OnTxConfirmed, nil)
 	if err != nil {
 		return err
 	}
 	if done {
 		s.RemoveActiveSwap(swap.SwapId.String())
 	}
-	s.RemoveActiveSwap(swap.SwapId.String())
 	return nil
 }
 
 const PaymentLabelSeparator = "_"
 
-func getPaymentLabel(description string) string {
-	parts := strings.SplitN(description, PaymentLabelSeparator, 2)
-	if len(parts) != 2 {
-		return ""
-	}
-	return parts[0]
-}
-
 // OnPayment handles incoming payments and if it corresponds to a claim or
 // fee invoice passes the dater to the corresponding function
 func (s *SwapService) OnPayment(swapIdStr string, invoiceType InvoiceType) {
 	swapId, err := ParseSwapIdFromString(swapIdStr)
 	if err != nil {
-		log.Infof("parse swapId error")
+		s.swapServices.logger.Warnf("[SwapService] Could not parse swap id %s: %v", swapIdStr, err)
 		return
 	}
 
 	// Check for claim_ label
+	var onPaid func(swapId *SwapId) error
 	switch invoiceType {
 	case INVOICE_FEE:
-		if err := s.OnFeeInvoiceNotification(swapId); err != nil {
-			log.Infof("[SwapService] Error OnFeeInvoiceNotification: %v", err)
-			return
-		}
+		onPaid = s.OnFeeInvoiceNotification
 	case INVOICE_CLAIM:
-		if err := s.OnClaimInvoiceNotification(swapId); err != nil {
-			log.Infof("[SwapService] Error OnClaimInvoiceNotification: %v", err)
-			return
-		}
+		onPaid = s.OnClaimInvoiceNotification
 	default:
 		return
 	}
+
+	// The lightning client may report a payment more than once, e.g. when it
+	// replays the recent payments after a reconnect.
+	paymentId := fmt.Sprintf("%s_%s", invoiceType, swapIdStr)
+	s.paymentMutex.Lock()
+	defer s.paymentMutex.Unlock()
+	if s.isPaymentProcessed(paymentId) {
+		s.swapServices.logger.Debugf("[SwapService] Skipping %s invoice payment of swap %s that was already processed", invoiceType, swapIdStr)
+		return
+	}
+
+	err = onPaid(swapId)
+	if err == nil {
+		s.setPaymentProcessed(paymentId)
+	}
+
+	// A payment for a swap that is not active anymore is usually a retried
+	// payment of a finished swap and no reason to worry.
+	if errors.Is(err, ErrSwapDoesNotExist) {
+		s.swapServices.metrics.orphanPaymentReceived(invoiceType)
+		s.swapServices.logger.Debugf("[SwapService] Received %s invoice payment for swap %s that is not active", invoiceType, swapIdStr)
+	} else if err != nil {
+		s.swapServices.logger.Errorf("[SwapService] Error on %s invoice payment of swap %s: %v", invoiceType, swapIdStr, err)
+	}
+}
+
+// isPaymentProcessed returns true if the store recorded the payment as
+// processed. If the store does not record payments or can not be read, the
+// payment is treated as not processed.
+func (s *SwapService) isPaymentProcessed(paymentId string) bool {
+	store, ok := s.swapServices.swapStore.(ProcessedPaymentStore)
+	if !ok {
+		return false
+	}
+	processed, err := store.IsPaymentProcessed(paymentId)
+	if err != nil {
+		s.swapServices.logger.Warnf("[SwapService] Could not read processed payment %s: %v", paymentId, err)
+		return false
+	}
+	return processed
+}
+
+// setPaymentProcessed records the payment as processed in the store.
+func (s *SwapService) setPaymentProcessed(paymentId string) {
+	store, ok := s.swapServices.swapStore.(ProcessedPaymentStore)
+	if !ok {
+		return
+	}
+	if err := store.SetPaymentProcessed(paymentId); err != nil {
+		s.swapServices.logger.Warnf("[SwapService] Could not record processed payment %s: %v", paymentId, err)
+	}
 }
 
 // OnCancelReceived sends the CancelReceived event to the corresponding swap state machine
@@ -602,16 +1763,146 @@ func (s *SwapService) OnCancelReceived(swapId *SwapId, cancelMsg *CancelMessage)
 		return err
 	}
 
-	done, err := swap.SendEvent(Event_OnCancelReceived, cancelMsg)
+	done, err := s.sendEvent(swap, Event_OnCancelReceived, cancelMsg)
+	if err != nil {
+		return err
+	}
+	if done {
+		s.RemoveActiveSwap(swap.SwapId.String())
+	}
+	return nil
+}
+
+// sendEvent sends the event to the swap with runEvent.
+func (s *SwapService) sendEvent(swap *SwapStateMachine, event EventType, eventCtx EventContext) (done bool, err error) {
+	return s.runEvent(swap, func() (bool, error) {
+		return swap.SendEvent(event, eventCtx)
+	})
+}
+
+// runEvent runs send on the swap. If an action of the swap panics, the panic
+// is recovered and the swap is failed, so that a single swap can not crash the
+// service. An event that was deferred because the lightning client is
+// unavailable is sent again after the backoff and is not reported as an error.
+func (s *SwapService) runEvent(swap *SwapStateMachine, send func() (bool, error)) (done bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w in state %s: %v", ErrSwapPanicked, swap.Current, r)
+			swap.logger().Errorf("[SwapService] %v\n%s", err, debug.Stack())
+			done = s.failSwap(swap, err)
+			if done {
+				s.RemoveActiveSwap(swap.SwapId.String())
+			}
+		}
+	}()
+
+	done, err = send()
+	s.indexOpeningTx(swap)
+	var deferred *eventDeferredError
+	if errors.As(err, &deferred) {
+		s.deferEvent(swap, deferred)
+		return false, nil
+	}
+	return done, err
+}
+
+// failSwap fails the action of the current state of the swap. The swap is
+// moved to the state that the statemachine defines for a failed action, the
+// swap is left as is if there is no such state. Returns true if the swap is
+// done.
+func (s *SwapService) failSwap(swap *SwapStateMachine, reason error) (done bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			swap.logger().Errorf("[SwapService] Could not fail swap: %v", r)
+			done = false
+		}
+	}()
+
+	if !swap.EventIsValid(Event_ActionFailed) {
+		return false
+	}
+
+	swap.mutex.Lock()
+	// Do not leak the details of the failure to the peer.
+	if swap.Data.CancelMessage == "" {
+		swap.Data.CancelMessage = "internal error"
+		swap.Data.CancelReason = CancelReasonInternalError
+	}
+	swap.Data.HandleError(reason)
+	swap.mutex.Unlock()
+
+	done, err := swap.SendEvent(Event_ActionFailed, nil)
+	if err != nil {
+		swap.logger().Errorf("[SwapService] Could not fail swap: %v", err)
+		return false
+	}
+	return done
+}
+
+// CancelSwap cancels an active swap on behalf of the node operator and sends
+// a cancel message with the given reason to the peer. Only swaps that did not
+// commit any funds yet can be canceled.
+func (s *SwapService) CancelSwap(swapId string, reason string) error {
+	if reason == "" {
+		reason = "canceled by operator"
+	}
+	return s.cancelSwap(swapId, CancelReasonOperator, reason)
+}
+
+// cancelSwap cancels an active swap that did not commit any funds yet and
+// sends a cancel message with the reason code and reason to the peer. A swap
+// in taker that waits for the opening tx sends a coop close message instead,
+// as the maker may already have broadcasted it.
+func (s *SwapService) cancelSwap(swapId string, code CancelReason, reason string) error {
+	swap, err := s.GetActiveSwap(swapId)
 	if err != nil {
 		return err
 	}
+
+	if !swap.EventIsValid(Event_OnOperatorCancel) {
+		return fmt.Errorf("%w: swap %s is in state %s", ErrSwapNotCancelable, swapId, swap.Current)
+	}
+
+	done, err := s.sendEvent(swap, Event_OnOperatorCancel, &SwapErrorContext{
+		Err:      errors.New(reason),
+		SendPeer: true,
+		Reason:   code,
+	})
+	if err == ErrEventRejected {
+		return fmt.Errorf("%w: swap %s is in state %s", ErrSwapNotCancelable, swapId, swap.Current)
+	} else if err != nil {
+		return err
+	}
 	if done {
 		s.RemoveActiveSwap(swap.SwapId.String())
 	}
 	return nil
 }
 
+// CancelAllActive cancels all active swaps that did not commit any funds yet
+// on behalf of the node operator and sends a cancel message with the given
+// reason to the peers. The ids of the canceled swaps and of the swaps that
+// were skipped because they can no longer be canceled are returned. If a
+// swap fails to cancel for another reason the remaining swaps are still
+// canceled and the first error is returned.
+func (s *SwapService) CancelAllActive(reason string) (cancelled []string, skipped []string, err error) {
+	for _, swap := range s.GetActiveSwaps() {
+		swapId := swap.SwapId.String()
+		cancelErr := s.CancelSwap(swapId, reason)
+		switch {
+		case cancelErr == nil:
+			cancelled = append(cancelled, swapId)
+		case errors.Is(cancelErr, ErrSwapNotCancelable):
+			skipped = append(skipped, swapId)
+		case errors.Is(cancelErr, ErrSwapDoesNotExist):
+			// the swap ended in the meantime
+		case err == nil:
+			err = fmt.Errorf("could not cancel swap %s: %w", swapId, cancelErr)
+		}
+	}
+	return cancelled, skipped, err
+}
+
 // OnCoopCloseReceived sends the CoopMessage event to the corresponding swap state mahcine
 func (s *SwapService) OnCoopCloseReceived(swapId *SwapId, coopCloseMessage *CoopCloseMessage) error {
 	swap, err := s.GetActiveSwap(swapId.String())
@@ -619,7 +1910,7 @@ func (s *SwapService) OnCoopCloseReceived(swapId *SwapId, coopCloseMessage *Coop
 		return err
 	}
 
-	done, err := swap.SendEvent(Event_OnCoopCloseReceived, coopCloseMessage)
+	done, err := s.sendEvent(swap, Event_OnCoopCloseReceived, coopCloseMessage)
 	if err != nil {
 		return err
 	}
@@ -639,29 +1930,123 @@ func (s *SwapService) ListSwapsByPeer(peer string) ([]*SwapStateMachine, error)
 	return s.swapServices.swapStore.ListAllByPeer(peer)
 }
 
+// ListSwapsByState returns the swaps that are in the given state. The states
+// State_ClaimedPreimage, State_ClaimedCoop, State_ClaimedCsv,
+// State_SwapCanceled and State_SwapAbandoned are terminal, swaps in these
+// states are finished.
+func (s *SwapService) ListSwapsByState(state StateType) ([]*SwapStateMachine, error) {
+	return s.ListSwapsWhere(func(swap *SwapStateMachine) bool {
+		return swap.Current == state
+	})
+}
+
+// ListSwapsWhere returns the swaps for which the predicate returns true.
+func (s *SwapService) ListSwapsWhere(predicate func(swap *SwapStateMachine) bool) ([]*SwapStateMachine, error) {
+	if store, ok := s.swapServices.swapStore.(FilteringStore); ok {
+		return store.ListWhere(predicate)
+	}
+	swaps, err := s.swapServices.swapStore.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	var filtered []*SwapStateMachine
+	for _, swap := range swaps {
+		if predicate(swap) {
+			filtered = append(filtered, swap)
+		}
+	}
+	return filtered, nil
+}
+
+// GetSwap returns the swap. An active swap is returned as a snapshot that is
+// copied from memory under the lock of the swap, as its state can be ahead of
+// the last state that was persisted, other swaps are read from the store.
 func (s *SwapService) GetSwap(swapId string) (*SwapStateMachine, error) {
+	if swap, err := s.GetActiveSwap(swapId); err == nil {
+		swap.mutex.Lock()
+		defer swap.mutex.Unlock()
+		return swap.snapshot(), nil
+	}
+	return s.swapServices.swapStore.GetData(swapId)
+}
+
+// getSwap returns the active swap from memory, or the swap from the store.
+func (s *SwapService) getSwap(swapId string) (*SwapStateMachine, error) {
+	if swap, err := s.GetActiveSwap(swapId); err == nil {
+		return swap, nil
+	}
 	return s.swapServices.swapStore.GetData(swapId)
 }
 
+// ResendLastMessage sends the last message of the swap to the swap partner
+// again. ErrNoMessageToResend is returned if the swap has no message.
 func (s *SwapService) ResendLastMessage(swapId string) error {
 	swap, err := s.GetActiveSwap(swapId)
 	if err != nil {
 		return err
 	}
-	action := &SendMessageAction{}
-	event := action.Execute(s.swapServices, swap.Data)
-	if event == Event_ActionFailed {
-		return swap.Data.LastErr
+
+	swap.mutex.Lock()
+	defer swap.mutex.Unlock()
+	if len(swap.Data.NextMessage) == 0 {
+		return ErrNoMessageToResend
 	}
-	return nil
+	// Send the message directly, a failed resend must not change the swap.
+	return s.swapServices.sendMessage(swap.Data.PeerNodeId, swap.Data.NextMessage, swap.Data.NextMessageType)
 }
 
 // AddActiveSwap adds a swap to the active swaps
 func (s *SwapService) AddActiveSwap(swapId string, swap *SwapStateMachine) {
 	// todo: why does this function take a swapId if we have a swap struct containing the swapId?
+	var channelId string
+	if swap.Data != nil {
+		channelId = swap.Data.GetScid()
+	}
+	s.addActiveSwap(swapId, channelId, swap)
+}
+
+// addNewActiveSwap adds a new swap to the active swaps unless the maximum
+// number of active swaps is reached. The limit is checked in the same critical
+// section as the swap is added, so that concurrent new swaps can not exceed
+// it after they passed checkSwapLimit.
+func (s *SwapService) addNewActiveSwap(swapId string, channelId string, swap *SwapStateMachine) error {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.swapLimitError(); err != nil {
+		return err
+	}
+	s.insertActiveSwap(swapId, channelId, swap)
+	return nil
+}
+
+// addActiveSwap adds a swap to the active swaps and indexes it by the channel
+// it is performed on. The channel id is passed explicitly as new swaps do not
+// carry their request data until the first event was sent.
+func (s *SwapService) addActiveSwap(swapId string, channelId string, swap *SwapStateMachine) {
 	s.Lock()
 	defer s.Unlock()
+	s.insertActiveSwap(swapId, channelId, swap)
+}
+
+// insertActiveSwap adds the swap to the active swaps, the caller must hold
+// the lock.
+func (s *SwapService) insertActiveSwap(swapId string, channelId string, swap *SwapStateMachine) {
 	s.activeSwaps[swapId] = swap
+	delete(s.inFlightRequests, swapId)
+	s.swapServices.metrics.setActiveSwaps(len(s.activeSwaps))
+	s.swapServices.publishSwapEvent(newSwapEvent(SwapEventAdded, swap, "", swap.Current))
+	s.invalidateStats()
+	if txId := s.swapServices.openingTxId(swap); txId != "" {
+		s.activeSwapsByTxId[txId] = swapId
+	}
+	if channelId == "" {
+		return
+	}
+	s.activeSwapChannels[swapId] = channelId
+	// Keep the swap that was on the channel first if two swaps collide.
+	if _, ok := s.activeSwapsByChannel[channelId]; !ok {
+		s.activeSwapsByChannel[channelId] = swapId
+	}
 }
 
 func (s *SwapService) ListActiveSwaps() ([]*SwapStateMachine, error) {
@@ -691,23 +2076,339 @@ func (s *SwapService) GetActiveSwap(swapId string) (*SwapStateMachine, error) {
 	return nil, ErrSwapDoesNotExist
 }
 
+// GetActiveSwaps returns a snapshot of the swaps that are currently active in
+// memory.
+func (s *SwapService) GetActiveSwaps() []*SwapStateMachine {
+	s.RLock()
+	defer s.RUnlock()
+	swaps := make([]*SwapStateMachine, 0, len(s.activeSwaps))
+	for _, swap := range s.activeSwaps {
+		swaps = append(swaps, swap)
+	}
+	return swaps
+}
+
 // RemoveActiveSwap removes a swap from the active swap map
 func (s *SwapService) RemoveActiveSwap(swapId string) {
+	swap, callbacks := s.removeActiveSwap(swapId)
+	if swap == nil || !swap.IsFinished() {
+		return
+	}
+	s.recordSwapResult(swap)
+	if err := s.recordFailedSwapCost(swap); err != nil {
+		s.swapServices.logger.Warnf("[SwapService] Could not store the on-chain cost of swap %s: %v", swapId, err)
+	}
+	s.invalidateStats()
+	// The callbacks are called without holding the lock so that they can
+	// use the service.
+	for _, callback := range callbacks {
+		callback(swap)
+	}
+}
+
+// removeActiveSwap removes the swap from the active swaps and returns the
+// removed swap together with the swap finished callbacks.
+func (s *SwapService) removeActiveSwap(swapId string) (*SwapStateMachine, []func(*SwapStateMachine)) {
+	s.Lock()
+	defer s.Unlock()
+	swap, ok := s.activeSwaps[swapId]
+	if ok {
+		delete(s.activeSwaps, swapId)
+		s.swapServices.metrics.swapFinished(swap)
+		s.swapServices.metrics.setActiveSwaps(len(s.activeSwaps))
+		s.swapServices.publishSwapEvent(newSwapEvent(SwapEventRemoved, swap, swap.Current, swap.Current))
+	}
+	callbacks := append([]func(*SwapStateMachine){}, s.finishedCallbacks...)
+	for txId, id := range s.activeSwapsByTxId {
+		if id == swapId {
+			delete(s.activeSwapsByTxId, txId)
+		}
+	}
+
+	channelId, ok := s.activeSwapChannels[swapId]
+	if !ok {
+		return swap, callbacks
+	}
+	delete(s.activeSwapChannels, swapId)
+	if s.activeSwapsByChannel[channelId] != swapId {
+		return swap, callbacks
+	}
+	delete(s.activeSwapsByChannel, channelId)
+	// Hand the channel over to a swap that collided with the removed one.
+	for otherId, otherChannelId := range s.activeSwapChannels {
+		if otherChannelId == channelId {
+			s.activeSwapsByChannel[channelId] = otherId
+			break
+		}
+	}
+	return swap, callbacks
+}
+
+// OnSwapFinished registers a callback that is called when a swap reached a
+// terminal state and is removed from the active swaps. The callback is called
+// once per swap with the final swap statemachine.
+func (s *SwapService) OnSwapFinished(fn func(*SwapStateMachine)) {
 	s.Lock()
 	defer s.Unlock()
-	delete(s.activeSwaps, swapId)
+	s.finishedCallbacks = append(s.finishedCallbacks, fn)
+}
+
+// Subscribe returns a channel that receives an event whenever a swap is added
+// to the active swaps, transitions to a new state or is removed from the
+// active swaps. Events are dropped if the subscriber does not keep up. The
+// returned function unsubscribes and closes the channel.
+func (s *SwapService) Subscribe() (<-chan SwapEvent, func()) {
+	return s.swapServices.swapEvents.subscribe()
+}
+
+// WaitForSwap blocks until the swap reached a terminal state and was removed
+// from the active swaps and returns the finished swap. A swap that is not
+// active is returned from the store right away. The removal is noticed from
+// the swap events and by polling the active swaps, as events are dropped for
+// a slow subscriber. The context error is returned if the context is done
+// before the swap finished.
+func (s *SwapService) WaitForSwap(ctx context.Context, swapId string) (*SwapStateMachine, error) {
+	// Subscribe before the swap is looked up so that the removal of the swap
+	// is not missed.
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	if _, err := s.GetActiveSwap(swapId); err == nil {
+		if _, err := s.waitSwapRemoved(ctx, events, swapId); err != nil {
+			return nil, err
+		}
+	}
+	return s.swapServices.swapStore.GetData(swapId)
+}
+
+// ActiveSwapOnChannel returns the active swap on the channel, if any.
+func (s *SwapService) ActiveSwapOnChannel(channelId string) (*SwapStateMachine, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	swapId, ok := s.activeSwapsByChannel[channelId]
+	if !ok {
+		return nil, false
+	}
+	swap, ok := s.activeSwaps[swapId]
+	return swap, ok
 }
 
-func (s *SwapService) hasActiveSwapOnChannel(channelId string) bool {
+// checkChannelAvailable checks if a new swap of amtSat can be started on the
+// channel. Only one active swap per channel is allowed unless concurrent
+// channel swaps are enabled, then the amounts of all active swaps on the
+// channel must not exceed the capacity of the channel.
+func (s *SwapService) checkChannelAvailable(channelId string, amtSat uint64) error {
+	activeSwap, ok := s.ActiveSwapOnChannel(channelId)
+	if !ok {
+		return nil
+	}
+	if !s.swapServices.allowConcurrentChannelSwaps {
+		return ActiveSwapOnChannelError{ChannelId: channelId, SwapId: activeSwap.SwapId.String()}
+	}
+
+	var committedSat uint64
+	for _, swap := range s.activeSwapsOnChannel(channelId) {
+		if swap.Data != nil {
+			committedSat += swap.Data.GetAmount()
+		}
+	}
+
+	capacitySat, err := s.swapServices.channelCapacity(channelId)
+	if err != nil {
+		return err
+	}
+	if committedSat+amtSat > capacitySat {
+		return fmt.Errorf("%w: %d sat of active swaps and %d sat requested on channel %s with a capacity of %d sat",
+			ErrChannelCapacityExceeded, committedSat, amtSat, channelId, capacitySat)
+	}
+	return nil
+}
+
+// activeSwapsOnChannel returns all active swaps on the channel.
+func (s *SwapService) activeSwapsOnChannel(channelId string) []*SwapStateMachine {
+	s.RLock()
+	defer s.RUnlock()
+	var swaps []*SwapStateMachine
+	for swapId, swapChannelId := range s.activeSwapChannels {
+		if swapChannelId != channelId {
+			continue
+		}
+		if swap, ok := s.activeSwaps[swapId]; ok {
+			swaps = append(swaps, swap)
+		}
+	}
+	return swaps
+}
+
+// activeSwapCountForPeer returns the number of active swaps with the peer.
+func (s *SwapService) activeSwapCountForPeer(peerId string) int {
 	s.RLock()
 	defer s.RUnlock()
+	var count int
 	for _, swap := range s.activeSwaps {
-		if swap.Data.GetScid() == channelId {
-			return true
+		if swap.Data != nil && swap.Data.PeerNodeId == peerId {
+			count++
 		}
 	}
+	return count
+}
+
+// checkSwapLimit returns an error if a new swap would exceed the maximum
+// number of active swaps of the node.
+func (s *SwapService) checkSwapLimit() error {
+	s.RLock()
+	defer s.RUnlock()
+	return s.swapLimitError()
+}
+
+// swapLimitError returns an error if the maximum number of active swaps is
+// reached, the caller must hold the lock.
+func (s *SwapService) swapLimitError() error {
+	max := s.swapServices.maxActiveSwaps
+	if max > 0 && len(s.activeSwaps) >= max {
+		return MaxActiveSwapsError(max)
+	}
+	return nil
+}
+
+// checkPeerSwapLimit returns an error if a new swap with the peer would
+// exceed the maximum number of active swaps per peer.
+func (s *SwapService) checkPeerSwapLimit(peerId string) error {
+	max := s.swapServices.maxActiveSwapsPerPeer
+	if max > 0 && s.activeSwapCountForPeer(peerId) >= max {
+		return MaxActiveSwapsPerPeerError{PeerId: peerId, Max: max}
+	}
+	return nil
+}
+
+// checkOpeningTxFeeRate returns an error if the estimated fee rate on the
+// chain exceeds the maximum opening tx fee rate of the chain. Unknown fee
+// rates are not checked.
+func (s *SwapService) checkOpeningTxFeeRate(chain string) error {
+	max, ok := s.swapServices.maxOpeningTxFeeRates[chain]
+	if !ok {
+		return nil
+	}
+	feeRate, err := s.swapServices.feeEstimator.EstimateFeeRate(chain)
+	if err != nil {
+		s.swapServices.logger.Infof("[SwapService] Could not estimate the %s fee rate: %v", chain, err)
+		return fmt.Errorf("could not estimate the %s fee rate", chain)
+	}
+	if feeRate > max {
+		return OpeningTxFeeRateTooHighError{Chain: chain, FeeRate: feeRate, Max: max}
+	}
+	return nil
+}
+
+// OpeningTxFeeRateTooHighError is returned if the fee rate of the opening
+// transaction would exceed the maximum fee rate of the chain.
+type OpeningTxFeeRateTooHighError struct {
+	Chain   string
+	FeeRate float64
+	Max     float64
+}
+
+func (e OpeningTxFeeRateTooHighError) Error() string {
+	return fmt.Sprintf("%s fee rate of %.2f sat/vB exceeds the maximum of %.2f sat/vB", e.Chain, e.FeeRate, e.Max)
+}
+
+func (e OpeningTxFeeRateTooHighError) Is(target error) bool {
+	return target == ErrFeeRateTooHigh
+}
+
+// SwapCostTooHighError is returned if the cost of a swap exceeds the maximum
+// cost relative to the swap amount.
+type SwapCostTooHighError struct {
+	CostSat uint64
+	MaxSat  uint64
+	PPM     uint64
+}
+
+func (e SwapCostTooHighError) Error() string {
+	return fmt.Sprintf("swap cost of %d sat exceeds the maximum of %d sat (%d ppm of the amount)", e.CostSat, e.MaxSat, e.PPM)
+}
+
+func (e SwapCostTooHighError) Is(target error) bool {
+	return target == ErrSwapCostTooHigh
+}
+
+// MaxActiveSwapsError is returned if a new swap would exceed the maximum
+// number of active swaps of the node.
+type MaxActiveSwapsError int
+
+func (e MaxActiveSwapsError) Error() string {
+	return fmt.Sprintf("node already has the maximum of %d active swaps", int(e))
+}
+
+func (e MaxActiveSwapsError) Is(target error) bool {
+	return target == ErrSwapLimit
+}
+
+// MaxActiveSwapsPerPeerError is returned if a new swap would exceed the
+// maximum number of active swaps with a peer.
+type MaxActiveSwapsPerPeerError struct {
+	PeerId string
+	Max    int
+}
+
+func (e MaxActiveSwapsPerPeerError) Error() string {
+	return fmt.Sprintf("peer %s already has the maximum of %d active swaps", e.PeerId, e.Max)
+}
 
-	return false
+func (e MaxActiveSwapsPerPeerError) Is(target error) bool {
+	return target == ErrPeerSwapLimit
+}
+
+// PayloadTooLargeError is returned if a peer sends a message whose payload
+// exceeds the maximum message size.
+type PayloadTooLargeError struct {
+	PeerId string
+	Size   int
+	Limit  int
+}
+
+func (e PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("payload of %d bytes from peer %s exceeds the limit of %d bytes", e.Size, e.PeerId, e.Limit)
+}
+
+func (e PayloadTooLargeError) Is(target error) bool {
+	return target == ErrPayloadTooLarge
+}
+
+// ProtocolVersionError is returned if a peer requests a swap with a
+// peerswap protocol version that is not accepted.
+type ProtocolVersionError uint8
+
+func (v ProtocolVersionError) Error() string {
+	return fmt.Sprintf("incompatible peerswap version: %d", uint8(v))
+}
+
+func (v ProtocolVersionError) Is(target error) bool {
+	return target == ErrProtocolVersion
+}
+
+// ChainDisabledError is returned if a swap is requested on a chain that is
+// not enabled.
+type ChainDisabledError string
+
+func (e ChainDisabledError) Error() string {
+	return fmt.Sprintf("%s swaps are not supported", string(e))
+}
+
+func (e ChainDisabledError) Is(target error) bool {
+	return target == ErrChainNotSupported
+}
+
+// NoCommonChainError is returned if none of the chain options of a swap
+// request is supported.
+type NoCommonChainError []string
+
+func (e NoCommonChainError) Error() string {
+	return fmt.Sprintf("none of the chains %s are supported", strings.Join(e, ", "))
+}
+
+func (e NoCommonChainError) Is(target error) bool {
+	return target == ErrChainNotSupported
 }
 
 type WrongAssetError string
@@ -716,24 +2417,41 @@ func (e WrongAssetError) Error() string {
 	return fmt.Sprintf("unallowed asset: %s", string(e))
 }
 
-// isMessageSenderExpectedPeer returns true if the senderId matches the
-// PeerNodeId of the swap, false if not.
-func (s *SwapService) isMessageSenderExpectedPeer(senderId string, swapId *SwapId) (bool, error) {
+func (e WrongAssetError) Is(target error) bool {
+	return target == ErrChainNotSupported
+}
+
+// checkMessageSender returns ErrSwapDoesNotExist if there is no active swap
+// for the message and ErrUnexpectedPeer if the sender is not the peer of the
+// swap. A message for an unknown swap is usually a late or replayed message
+// of a finished swap, a message from an unexpected peer may be an
+// impersonation attempt.
+func (s *SwapService) checkMessageSender(senderId string, swapId *SwapId) error {
 	swap, err := s.GetActiveSwap(swapId.String())
-	if err != nil {
-		return false, err
+	if err == ErrSwapDoesNotExist {
+		s.swapServices.logger.Infof("[SwapService] Received a message from %s for unknown swap %s", senderId, swapId.String())
+		return fmt.Errorf("%w: %s", ErrSwapDoesNotExist, swapId.String())
+	} else if err != nil {
+		return err
+	}
+	if swap.Data.PeerNodeId != senderId {
+		s.swapServices.logger.Warnf("[SwapService] Received a message for swap %s from unexpected peer %s, expected %s", swapId.String(), senderId, swap.Data.PeerNodeId)
+		return ErrReceivedMessageFromUnexpectedPeer(senderId, swapId)
 	}
-	return swap.Data.PeerNodeId == senderId, nil
+	return nil
 }
 
 func (s *SwapService) createTimeoutCallback(swapId string) func() {
 	return func() {
+		if s.isStopped() {
+			return
+		}
 		swap, err := s.GetActiveSwap(swapId)
 		if err == ErrSwapDoesNotExist {
 			return
 		}
 		if err != nil {
-			log.Debugf("[SwapService] timeout callback: %v", err)
+			newSwapLogger(s.swapServices.logger, swapId).Debugf("[SwapService] timeout callback: %v", err)
 			return
 		}
 
@@ -742,12 +2460,22 @@ func (s *SwapService) createTimeoutCallback(swapId string) func() {
 			swap.Data.toCancel = nil
 		}
 
-		done, err := swap.SendEvent(Event_OnTimeout, nil)
+		if swap.EventIsValid(Event_OnTimeout) {
+			s.swapServices.metrics.swapTimedOut(swap)
+			swap.mutex.Lock()
+			if swap.Data.CancelMessage == "" {
+				swap.Data.CancelReason = CancelReasonTimeout
+				swap.Data.CancelCategory = CancelCategoryTimeout
+				swap.Data.CancelMessage = "swap timed out"
+			}
+			swap.mutex.Unlock()
+		}
+		done, err := s.sendEvent(swap, Event_OnTimeout, nil)
 		if err == ErrEventRejected {
 			return
 		}
 		if err != nil {
-			log.Debugf("[SwapService] SendEvent(): %v", err)
+			swap.logger().Debugf("[SwapService] SendEvent(): %v", err)
 			return
 		}
 
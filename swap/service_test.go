@@ -1,10 +1,20 @@
 package swap
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -12,8 +22,11 @@ import (
 
 	"github.com/elementsproject/peerswap/messages"
 	"github.com/elementsproject/peerswap/policy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
 )
 
 func Test_GoodCase(t *testing.T) {
@@ -23,6 +36,13 @@ func Test_GoodCase(t *testing.T) {
 
 	aliceSwapService := getTestSetup(initiator)
 	bobSwapService := getTestSetup(peer)
+	aliceRegistry := prometheus.NewRegistry()
+	require.NoError(t, aliceSwapService.swapServices.SetMetricsRegisterer(aliceRegistry))
+	require.NoError(t, bobSwapService.swapServices.SetMetricsRegisterer(prometheus.NewRegistry()))
+
+	// Registering twice fails.
+	assert.Error(t, aliceSwapService.swapServices.SetMetricsRegisterer(aliceRegistry))
+
 	aliceSwapService.swapServices.messenger.(*ConnectedMessenger).other = bobSwapService.swapServices.messenger.(*ConnectedMessenger)
 	bobSwapService.swapServices.messenger.(*ConnectedMessenger).other = aliceSwapService.swapServices.messenger.(*ConnectedMessenger)
 
@@ -51,6 +71,16 @@ func Test_GoodCase(t *testing.T) {
 
 	aliceReceivedMsg := <-aliceMsgChan
 	assert.Equal(t, messages.MESSAGETYPE_SWAPOUTAGREEMENT, aliceReceivedMsg)
+
+	aliceMetrics := aliceSwapService.swapServices.metrics
+	bobMetrics := bobSwapService.swapServices.metrics
+	senderLabels := prometheus.Labels{"type": "swap-out", "role": "sender", "chain": btc_chain}
+	receiverLabels := prometheus.Labels{"type": "swap-out", "role": "receiver", "chain": btc_chain}
+	assert.Equal(t, float64(1), testutil.ToFloat64(aliceMetrics.started.With(senderLabels)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(bobMetrics.started.With(receiverLabels)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(aliceMetrics.activeSwaps))
+	assert.Equal(t, float64(1), testutil.ToFloat64(bobMetrics.activeSwaps))
+
 	assert.Equal(t, State_SwapOutSender_AwaitTxBroadcastedMessage, aliceSwap.Current)
 	assert.Equal(t, State_SwapOutReceiver_AwaitFeeInvoicePayment, bobSwap.Current)
 	bobSwapService.swapServices.lightning.(*dummyLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_FEE)
@@ -69,7 +99,205 @@ func Test_GoodCase(t *testing.T) {
 	// trigger bob payment received
 	bobSwapService.swapServices.lightning.(*dummyLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_CLAIM)
 	assert.Equal(t, State_ClaimedPreimage, bobSwap.Current)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(aliceMetrics.completed.With(senderLabels)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(bobMetrics.completed.With(receiverLabels)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(aliceMetrics.activeSwaps))
+	assert.Equal(t, float64(0), testutil.ToFloat64(bobMetrics.activeSwaps))
+}
+
+func Test_SwapCost(t *testing.T) {
+	amount := uint64(100000)
+	initiator, peer, _, _, channelId := getTestParams()
+
+	aliceSwapService := getTestSetup(initiator)
+	bobSwapService := getTestSetup(peer)
+	bobSwapService.swapServices.bitcoinWallet = &openingFeeWallet{
+		dummyChain: bobSwapService.swapServices.bitcoinWallet.(*dummyChain),
+		fee:        250,
+	}
+	aliceSwapService.swapServices.messenger.(*ConnectedMessenger).other = bobSwapService.swapServices.messenger.(*ConnectedMessenger)
+	bobSwapService.swapServices.messenger.(*ConnectedMessenger).other = aliceSwapService.swapServices.messenger.(*ConnectedMessenger)
+
+	aliceSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan = make(chan messages.MessageType)
+	bobSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan = make(chan messages.MessageType)
+
+	aliceMsgChan := aliceSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan
+	bobMsgChan := bobSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan
+
+	require.NoError(t, aliceSwapService.Start())
+	require.NoError(t, bobSwapService.Start())
+
+	aliceSwap, err := aliceSwapService.SwapOut(peer, btc_chain, channelId, initiator, amount)
+	require.NoError(t, err)
+	swapId := aliceSwap.SwapId.String()
+
+	<-bobMsgChan
+	<-aliceMsgChan
+	bobSwap, err := bobSwapService.GetActiveSwap(swapId)
+	require.NoError(t, err)
+	bobSwapService.swapServices.lightning.(*dummyLightningClient).TriggerPayment(swapId, INVOICE_FEE)
+	<-aliceMsgChan
+
+	err = aliceSwapService.swapServices.liquidTxWatcher.(*dummyChain).txConfirmedFunc(swapId, aliceSwap.Data.OpeningTxHex)
+	require.NoError(t, err)
+	bobSwapService.swapServices.lightning.(*dummyLightningClient).TriggerPayment(swapId, INVOICE_CLAIM)
+	require.Equal(t, State_ClaimedPreimage, aliceSwap.Current)
+	require.Equal(t, State_ClaimedPreimage, bobSwap.Current)
+
+	// Alice paid the fee invoice and the claim invoice.
+	aliceStored, err := aliceSwapService.GetSwap(swapId)
+	require.NoError(t, err)
+	assert.Equal(t, SwapCost{
+		FeeInvoiceSat:   100,
+		ClaimInvoiceSat: amount,
+		TotalSat:        100 + amount,
+	}, aliceStored.Data.Cost)
+
+	// Bob created the invoices and paid the fee of the opening transaction.
+	bobStored, err := bobSwapService.GetSwap(swapId)
+	require.NoError(t, err)
+	assert.Equal(t, SwapCost{
+		FeeInvoiceSat:   100,
+		ClaimInvoiceSat: amount,
+		OpeningTxFeeSat: 250,
+		TotalSat:        100 + amount + 250,
+	}, bobStored.Data.Cost)
+}
+
+func Test_OnSwapFinished(t *testing.T) {
+	amount := uint64(100000)
+	initiator, peer, _, _, channelId := getTestParams()
+
+	aliceSwapService := getTestSetup(initiator)
+	bobSwapService := getTestSetup(peer)
+	aliceSwapService.swapServices.messenger.(*ConnectedMessenger).other = bobSwapService.swapServices.messenger.(*ConnectedMessenger)
+	bobSwapService.swapServices.messenger.(*ConnectedMessenger).other = aliceSwapService.swapServices.messenger.(*ConnectedMessenger)
+
+	aliceSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan = make(chan messages.MessageType)
+	bobSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan = make(chan messages.MessageType)
+
+	aliceMsgChan := aliceSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan
+	bobMsgChan := bobSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan
+
+	// Register two callbacks, the second one uses the service to check that
+	// the callbacks are called without holding the lock.
+	finished := make(chan *SwapStateMachine, 10)
+	aliceSwapService.OnSwapFinished(func(swap *SwapStateMachine) {
+		finished <- swap
+	})
+	activeSwaps := make(chan int, 10)
+	aliceSwapService.OnSwapFinished(func(swap *SwapStateMachine) {
+		activeSwaps <- len(aliceSwapService.GetActiveSwaps())
+	})
+
+	require.NoError(t, aliceSwapService.Start())
+	require.NoError(t, bobSwapService.Start())
+
+	aliceSwap, err := aliceSwapService.SwapOut(peer, btc_chain, channelId, initiator, amount)
+	require.NoError(t, err)
+
+	assert.Equal(t, messages.MESSAGETYPE_SWAPOUTREQUEST, <-bobMsgChan)
+	bobSwap := bobSwapService.activeSwaps[aliceSwap.SwapId.String()]
+	assert.Equal(t, messages.MESSAGETYPE_SWAPOUTAGREEMENT, <-aliceMsgChan)
+	bobSwapService.swapServices.lightning.(*dummyLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_FEE)
+	assert.Equal(t, messages.MESSAGETYPE_OPENINGTXBROADCASTED, <-aliceMsgChan)
+
+	// The callbacks are not called for a swap that is not finished.
+	assert.Empty(t, finished)
+
+	err = aliceSwapService.swapServices.liquidTxWatcher.(*dummyChain).txConfirmedFunc(aliceSwap.SwapId.String(), aliceSwap.Data.OpeningTxHex)
+	require.NoError(t, err)
+	assert.Equal(t, State_ClaimedPreimage, aliceSwap.Current)
+
+	require.Len(t, finished, 1)
+	swap := <-finished
+	assert.Equal(t, aliceSwap.SwapId, swap.SwapId)
+	assert.Equal(t, State_ClaimedPreimage, swap.Current)
+	assert.Equal(t, 0, <-activeSwaps)
+
+	// Removing the swap again does not call the callbacks.
+	aliceSwapService.RemoveActiveSwap(aliceSwap.SwapId.String())
+	assert.Empty(t, finished)
+}
+
+// Test_Metrics_TimedOutSwap checks that a swap that times out is counted as
+// timed out and canceled. The metrics of a successful swap are checked in
+// Test_GoodCase.
+func Test_Metrics_TimedOutSwap(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	service := getTestSetup(initiator)
+	service.swapServices.messenger = &noopMessenger{}
+	service.swapServices.toService = &timeOutDummy{}
+	require.NoError(t, service.swapServices.SetMetricsRegisterer(prometheus.NewRegistry()))
+
+	swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	service.createTimeoutCallback(swap.SwapId.String())()
+	require.Equal(t, State_SwapCanceled, swap.Current)
+
+	metrics := service.swapServices.metrics
+	senderLabels := prometheus.Labels{"type": "swap-out", "role": "sender", "chain": btc_chain}
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.started.With(senderLabels)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.timedOut.With(senderLabels)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.canceled.With(senderLabels)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.activeSwaps))
+}
+
+func Test_StateDurationMetrics(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	service := getTestSetup(initiator)
+	service.swapServices.messenger = &noopMessenger{}
+	service.swapServices.toService = &timeOutDummy{}
+	registry := prometheus.NewRegistry()
+	require.NoError(t, service.swapServices.SetMetricsRegisterer(registry))
+
+	now := time.Unix(1600000000, 0)
+	service.swapServices.clock = func() time.Time { return now }
+
+	swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	require.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Current)
+
+	now = now.Add(90 * time.Second)
+	require.NoError(t, service.CancelSwap(swap.SwapId.String(), ""))
+	require.Equal(t, State_SwapCanceled, swap.Current)
+
+	type observed struct {
+		count uint64
+		sum   float64
+	}
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	got := map[string]observed{}
+	for _, family := range families {
+		if family.GetName() != "peerswap_swap_state_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			assert.Equal(t, "swap-out", labels["type"])
+			assert.Equal(t, btc_chain, labels["chain"])
+			got[labels["state"]] = observed{
+				count: metric.GetHistogram().GetSampleCount(),
+				sum:   metric.GetHistogram().GetSampleSum(),
+			}
+		}
+	}
+
+	// The initial state is not entered by a transition, so its duration is
+	// not known.
+	assert.Equal(t, map[string]observed{
+		string(State_SwapOutSender_CreateSwap):     {count: 1, sum: 0},
+		string(State_SwapOutSender_SendRequest):    {count: 1, sum: 0},
+		string(State_SwapOutSender_AwaitAgreement): {count: 1, sum: 90},
+		string(State_SendCancel):                   {count: 1, sum: 0},
+	}, got)
 }
+
 func Test_FeePaymentFailed(t *testing.T) {
 	amount := uint64(100000)
 	initiator, peer, _, _, channelId := getTestParams()
@@ -177,7 +405,7 @@ func Test_ClaimPaymentFailedCoopClose(t *testing.T) {
 }
 
 func Test_OnlyOneActiveSwapPerChannel(t *testing.T) {
-	service := getTestSetup("alice")
+	service := getTestSetup(aliceId)
 	swapId := NewSwapId()
 	service.AddActiveSwap(swapId.String(), &SwapStateMachine{
 		SwapId: swapId,
@@ -197,7 +425,7 @@ func Test_OnlyOneActiveSwapPerChannel(t *testing.T) {
 			CancelMessage:    "",
 			LastErr:          nil,
 			LastErrString:    "",
-			SwapInRequest:    &SwapInRequestMessage{Scid: "channelID"},
+			SwapInRequest:    &SwapInRequestMessage{Scid: "100x1x1"},
 		},
 		Type:     0,
 		Role:     0,
@@ -223,14 +451,61 @@ func Test_OnlyOneActiveSwapPerChannel(t *testing.T) {
 		failures: 0,
 	})
 
-	_, err := service.SwapOut("peer", "lbtc", "channelID", "alice", uint64(200))
+	expected := ActiveSwapOnChannelError{ChannelId: "100x1x1", SwapId: swapId.String()}
+
+	_, err := service.SwapOut(bobId, "lbtc", "100x1x1", aliceId, uint64(200))
 	if assert.Error(t, err, "expected error") {
-		assert.Equal(t, "already has an active swap on channel", err.Error())
+		assert.Equal(t, expected, err)
+		assert.Equal(t, fmt.Sprintf("already has an active swap on channel 100x1x1: %s", swapId.String()), err.Error())
 	}
 
-	_, err = service.SwapIn("peer", "lbtc", "channelID", "alice", uint64(200))
+	_, err = service.SwapIn(bobId, "lbtc", "100x1x1", aliceId, uint64(200))
 	if assert.Error(t, err, "expected error") {
-		assert.Equal(t, "already has an active swap on channel", err.Error())
+		assert.Equal(t, expected, err)
+	}
+}
+
+func Test_SwapInvalidScid(t *testing.T) {
+	service := getTestSetup(aliceId)
+	service.swapServices.messenger = &noopMessenger{}
+	service.swapServices.toService = &timeOutDummy{}
+
+	for _, scid := range []string{"100x2x3", "100:2:3", "0x0x0", "16777215x16777215x65535"} {
+		_, err := service.SwapOut(bobId, btc_chain, scid, aliceId, 100000)
+		assert.NoError(t, err, scid)
+	}
+
+	for _, scid := range []string{"", "channelID", "100x2", "100x2x3x4", "ax2x3", "100xbx3", "100x2x", "100x2:3", "-1x2x3", "16777216x2x3", "100x2x65536"} {
+		_, err := service.SwapOut(bobId, btc_chain, scid, aliceId, 100000)
+		assert.ErrorIs(t, err, InvalidScidError, scid)
+		_, err = service.SwapIn(bobId, btc_chain, scid, aliceId, 100000)
+		assert.ErrorIs(t, err, InvalidScidError, scid)
+	}
+	assert.Len(t, service.GetActiveSwaps(), 4)
+}
+
+func Test_SwapDisabledChain(t *testing.T) {
+	for _, chain := range []string{btc_chain, l_btc_chain} {
+		t.Run(chain, func(t *testing.T) {
+			service := getTestSetup(aliceId)
+			service.swapServices.messenger = &noopMessenger{}
+			service.swapServices.toService = &timeOutDummy{}
+			if chain == btc_chain {
+				service.swapServices.bitcoinEnabled = false
+				service.swapServices.bitcoinWallet = nil
+			} else {
+				service.swapServices.liquidEnabled = false
+				service.swapServices.liquidWallet = nil
+			}
+
+			_, err := service.SwapOut(bobId, chain, "100x2x3", aliceId, 100000)
+			assert.Equal(t, ChainDisabledError(chain), err)
+			_, err = service.SwapIn(bobId, chain, "100x2x3", aliceId, 100000)
+			assert.Equal(t, ChainDisabledError(chain), err)
+			_, err = service.QuoteSwapOut(bobId, chain, "100x2x3", 100000)
+			assert.Equal(t, ChainDisabledError(chain), err)
+			assert.Empty(t, service.GetActiveSwaps())
+		})
 	}
 }
 
@@ -280,7 +555,7 @@ func TestMessageFromUnexpectedPeer(t *testing.T) {
 	// Setup done.
 	// Sending messages from unexpected peer.
 	charlieMessenger := &ConnectedMessenger{
-		thisPeerId:      "charlie",
+		thisPeerId:      charlieId,
 		other:           aliceSwapService.swapServices.messenger.(*ConnectedMessenger),
 		msgReceivedChan: make(chan messages.MessageType),
 	}
@@ -297,8 +572,8 @@ func TestMessageFromUnexpectedPeer(t *testing.T) {
 		{name: "opening tx broadcasted message", message: &OpeningTxBroadcastedMessage{SwapId: aliceSwap.SwapId}, assertError: true},
 		{name: "coop close message", message: &CoopCloseMessage{SwapId: aliceSwap.SwapId}, assertError: true},
 		{name: "cancel message", message: &CancelMessage{SwapId: aliceSwap.SwapId}, assertError: true},
-		{name: "swap in request message", message: &SwapInRequestMessage{SwapId: NewSwapId()}, assertError: false},
-		{name: "swap out request message", message: &SwapOutRequestMessage{SwapId: NewSwapId()}, assertError: false},
+		{name: "swap in request message", message: &SwapInRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: NewSwapId(), Pubkey: getRandom33ByteHexString()}, assertError: false},
+		{name: "swap out request message", message: &SwapOutRequestMessage{ProtocolVersion: PEERSWAP_PROTOCOL_VERSION, SwapId: NewSwapId(), Pubkey: getRandom33ByteHexString()}, assertError: false},
 	}
 
 	for _, tc := range tests {
@@ -308,10 +583,12 @@ func TestMessageFromUnexpectedPeer(t *testing.T) {
 			aliceMessenger.lastErr = nil
 			require.NoError(t, aliceMessenger.lastErr)
 
-			msgBytes, msgType, err := MarshalPeerswapMessage(tc.message)
+			// The messages lack required fields, so they are marshaled
+			// without the checks of MarshalPeerswapMessage.
+			msgBytes, err := json.Marshal(tc.message)
 			require.NoError(t, err)
 
-			charlieMessenger.SendMessage("alice", msgBytes, msgType)
+			charlieMessenger.SendMessage(aliceId, msgBytes, int(tc.message.MessageType()))
 			<-aliceMsgChan
 
 			if tc.assertError {
@@ -326,11 +603,11 @@ func TestMessageFromUnexpectedPeer(t *testing.T) {
 
 func TestTimeout(t *testing.T) {
 	t.Parallel()
-	sws := getTestSetup("alice")
+	sws := getTestSetup(aliceId)
 	sws.swapServices.messenger = &noopMessenger{}
 	sws.Start()
 
-	fsm := newSwapInSenderFSM(sws.swapServices, "alice", "bob")
+	fsm := newSwapInSenderFSM(sws.swapServices, aliceId, bobId)
 	sws.AddActiveSwap(fsm.SwapId.String(), fsm)
 
 	fsm.Current = State_SwapInSender_AwaitAgreement
@@ -355,11 +632,34 @@ func TestTimeout(t *testing.T) {
 	}
 }
 
+func TestStopCancelsTimeouts(t *testing.T) {
+	t.Parallel()
+	sws := getTestSetup(aliceId)
+	sws.swapServices.messenger = &noopMessenger{}
+	require.NoError(t, sws.Start())
+
+	fsm := newSwapInSenderFSM(sws.swapServices, aliceId, bobId)
+	sws.AddActiveSwap(fsm.SwapId.String(), fsm)
+
+	fsm.Current = State_SwapInSender_AwaitAgreement
+	sws.swapServices.toService.addNewTimeOut(context.Background(), 10*time.Millisecond, fsm.SwapId.String())
+
+	require.NoError(t, sws.Stop())
+	time.Sleep(50 * time.Millisecond)
+
+	fsm.mutex.Lock()
+	assert.Equal(t, State_SwapInSender_AwaitAgreement, fsm.Current)
+	fsm.mutex.Unlock()
+
+	_, err := sws.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+	assert.ErrorIs(t, err, ErrServiceStopped)
+}
+
 // Test_SwapIn_PeerIsSuspicious checks that no swap is requested if the peer is
 // suspicious.
 func Test_SwapIn_PeerIsSuspicious(t *testing.T) {
-	const node = "alice"
-	const peer = "bob"
+	const node = aliceId
+	const peer = bobId
 
 	swapService := getTestSetup(node)
 	// Setup peer to be suspicious
@@ -368,7 +668,7 @@ func Test_SwapIn_PeerIsSuspicious(t *testing.T) {
 		newSwapsAllowedReturn:  policy.DefaultPolicy().AllowNewSwaps,
 	}
 
-	_, err := swapService.SwapOut(peer, "regtest", "", node, 100000)
+	_, err := swapService.SwapOut(peer, "regtest", "100x1x1", node, 100000)
 	assert.Error(t, err)
 	assert.ErrorIs(t, err, PeerIsSuspiciousError(peer))
 }
@@ -376,8 +676,8 @@ func Test_SwapIn_PeerIsSuspicious(t *testing.T) {
 // Test_SwapOut_PeerIsSuspicious checks that no swap is requested if the peer is
 // suspicious.
 func Test_SwapOut_PeerIsSuspicious(t *testing.T) {
-	const node = "alice"
-	const peer = "bob"
+	const node = aliceId
+	const peer = bobId
 
 	swapService := getTestSetup(node)
 	// Setup peer to be suspicious
@@ -387,104 +687,3074 @@ func Test_SwapOut_PeerIsSuspicious(t *testing.T) {
 		newSwapsAllowedReturn:      policy.DefaultPolicy().AllowNewSwaps,
 	}
 
-	_, err := swapService.SwapOut(peer, "regtest", "", node, 100000)
+	_, err := swapService.SwapOut(peer, "regtest", "100x1x1", node, 100000)
 	assert.Error(t, err)
 	assert.ErrorIs(t, err, PeerIsSuspiciousError(peer))
 }
 
-func getTestSetup(name string) *SwapService {
-	store := &dummyStore{dataMap: map[string]*SwapStateMachine{}}
-	reqSwapsStore := &requestedSwapsStoreMock{data: map[string][]RequestedSwap{}}
-	messenger := &ConnectedMessenger{
-		thisPeerId: name,
+// Test_SenderOnTxConfirmed_KeepsUnfinishedSwap checks that a swap is only
+// removed from the active swaps if the state machine reports that it is done.
+func Test_SenderOnTxConfirmed_KeepsUnfinishedSwap(t *testing.T) {
+	service := getTestSetup(aliceId)
+
+	swap := newSwapInSenderFSM(service.swapServices, aliceId, bobId)
+	swap.Current = State_SwapInSender_AwaitClaimPayment
+	swap.States = States{
+		State_SwapInSender_AwaitClaimPayment: {
+			Events: Events{
+				Event_OnTxConfirmed: State_WaitCsv,
+			},
+		},
+		State_WaitCsv: {
+			Action: &NoOpAction{},
+			Events: Events{
+				Event_OnCsvPassed: State_SwapInSender_ClaimSwapCsv,
+			},
+		},
 	}
-	mmgr := &MessengerManagerStub{}
-	lc := &dummyLightningClient{preimage: ""}
-	policy := &dummyPolicy{
-		getMinSwapAmountMsatReturn: policy.DefaultPolicy().MinSwapAmountMsat,
-		newSwapsAllowedReturn:      policy.DefaultPolicy().AllowNewSwaps,
+	service.AddActiveSwap(swap.SwapId.String(), swap)
+
+	err := service.SenderOnTxConfirmed(swap.SwapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, State_WaitCsv, swap.Current)
+
+	activeSwap, err := service.GetActiveSwap(swap.SwapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, swap, activeSwap)
+}
+
+func Test_OnTxConfirmed_Idempotent(t *testing.T) {
+	service := getTestSetup(aliceId)
+
+	swap := newSwapInReceiverFSM(NewSwapId(), service.swapServices, bobId)
+	swap.Current = State_SwapInReceiver_AwaitTxConfirmation
+	swap.States = States{
+		State_SwapInReceiver_AwaitTxConfirmation: {
+			Events: Events{
+				Event_OnTxConfirmed: State_SwapInReceiver_ValidateTxAndPayClaimInvoice,
+			},
+		},
+		State_SwapInReceiver_ValidateTxAndPayClaimInvoice: {
+			Action: &NoOpAction{},
+			Events: Events{
+				Event_ActionSucceeded: State_SwapInReceiver_ClaimSwap,
+			},
+		},
 	}
-	chain := &dummyChain{returnGetCSVHeight: 1008}
-	chain.SetBalance(10000000)
-	swapServices := NewSwapServices(store, reqSwapsStore, lc, messenger, mmgr, policy, true, chain, chain, chain, true, chain, chain, chain)
-	swapService := NewSwapService(swapServices)
-	return swapService
+	service.AddActiveSwap(swap.SwapId.String(), swap)
+
+	events, unsubscribe := service.Subscribe()
+	defer unsubscribe()
+
+	require.NoError(t, service.OnTxConfirmed(swap.SwapId.String(), "txhex"))
+	require.NoError(t, service.OnTxConfirmed(swap.SwapId.String(), "otherhex"))
+
+	assert.Equal(t, State_SwapInReceiver_ValidateTxAndPayClaimInvoice, swap.Current)
+	assert.Equal(t, "txhex", swap.Data.OpeningTxHex)
+
+	// Only the first confirmation transitioned the swap.
+	require.Len(t, events, 1)
+	event := <-events
+	assert.Equal(t, SwapEventTransition, event.Kind)
+	assert.Equal(t, State_SwapInReceiver_AwaitTxConfirmation, event.OldState)
+	assert.Equal(t, State_SwapInReceiver_ValidateTxAndPayClaimInvoice, event.NewState)
 }
 
-type ConnectedMessenger struct {
-	sync.Mutex
-	thisPeerId      string
-	OnMessage       func(peerId string, msgType string, msgBytes []byte) error
-	other           *ConnectedMessenger
-	msgReceivedChan chan messages.MessageType
-	lastErr         error
+func Test_OnMessageReceived_UnknownSwapAndUnexpectedPeer(t *testing.T) {
+	logger := &testLogger{}
+	service := getTestSetup(aliceId)
+	service.swapServices.logger = logger
+
+	swap := newSwapOutSenderFSM(service.swapServices, aliceId, bobId)
+	service.AddActiveSwap(swap.SwapId.String(), swap)
+
+	cancelMsg := func(swapId *SwapId) []byte {
+		msg, err := json.Marshal(&CancelMessage{SwapId: swapId, Message: "cancel"})
+		require.NoError(t, err)
+		return msg
+	}
+	msgType := messages.MessageTypeToHexString(messages.MESSAGETYPE_CANCELED)
+
+	// A message for a swap that is not active.
+	unknownId := NewSwapId()
+	err := service.OnMessageReceived(bobId, msgType, cancelMsg(unknownId))
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+	assert.NotErrorIs(t, err, ErrUnexpectedPeer)
+	require.Len(t, logger.lines[logLevelInfo], 1)
+	assert.Contains(t, logger.lines[logLevelInfo][0], "unknown swap "+unknownId.String())
+	assert.Empty(t, logger.lines[logLevelWarn])
+
+	// A message for an active swap from another peer.
+	err = service.OnMessageReceived(malloryId, msgType, cancelMsg(swap.SwapId))
+	assert.ErrorIs(t, err, ErrUnexpectedPeer)
+	assert.NotErrorIs(t, err, ErrSwapDoesNotExist)
+	require.Len(t, logger.lines[logLevelWarn], 1)
+	assert.Contains(t, logger.lines[logLevelWarn][0], "unexpected peer "+malloryId)
+
+	// The swap was not touched by the message of the other peer.
+	activeSwap, err := service.GetActiveSwap(swap.SwapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, Default, activeSwap.Current)
 }
 
-func (c *ConnectedMessenger) SendMessage(peerId string, msg []byte, msgType int) error {
-	go func() {
-		time.Sleep(time.Millisecond * 10)
-		msgString := messages.MessageTypeToHexString(messages.MessageType(msgType))
-		err := c.other.OnMessage(c.thisPeerId, msgString, msg)
-		if err != nil {
-			log.Printf("error on message send %v", err)
-			c.other.Lock()
-			c.other.lastErr = err
-			c.other.Unlock()
+func Test_OnMessageReceived_UnknownMessageType(t *testing.T) {
+	logger := &testLogger{}
+	service := getTestSetup(aliceId)
+	service.swapServices.logger = logger
+	registry := prometheus.NewRegistry()
+	require.NoError(t, service.swapServices.SetMetricsRegisterer(registry))
+
+	unknownType := messages.MessageTypeToHexString(messages.UPPER_MESSAGE_BOUND + 1)
+	otherType := messages.MessageTypeToHexString(messages.UPPER_MESSAGE_BOUND + 3)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, service.OnMessageReceived(bobId, unknownType, []byte("{}")))
+	}
+	assert.NoError(t, service.OnMessageReceived(bobId, otherType, []byte("{}")))
+
+	// Poll messages are known, they are handled by the poll service.
+	pollType := messages.MessageTypeToHexString(messages.MESSAGETYPE_POLL)
+	assert.NoError(t, service.OnMessageReceived(bobId, pollType, []byte("{}")))
+
+	// Every message is counted by its range, but every type is only logged
+	// once.
+	unknownMessages := service.swapServices.metrics.unknownMessages
+	assert.Equal(t, float64(4), testutil.ToFloat64(unknownMessages.WithLabelValues("out_of_range")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(unknownMessages.WithLabelValues("in_range")))
+
+	var logged []string
+	for _, line := range logger.lines[logLevelDebug] {
+		if strings.Contains(line, "unknown message type") {
+			logged = append(logged, line)
 		}
-		if c.other.msgReceivedChan != nil {
-			c.other.msgReceivedChan <- messages.MessageType(msgType)
+	}
+	require.Len(t, logged, 2)
+	assert.Contains(t, logged[0], unknownType+" from peer "+bobId)
+	assert.Contains(t, logged[1], otherType+" from peer "+bobId)
+}
+
+func Test_OnMessageReceived_UnknownMessageTypesBounded(t *testing.T) {
+	logger := &testLogger{}
+	service := getTestSetup(aliceId)
+	service.swapServices.logger = logger
+
+	for i := 0; i < maxUnknownMessagesLogged+10; i++ {
+		msgType := messages.MessageTypeToHexString(messages.UPPER_MESSAGE_BOUND + messages.MessageType(2*i+1))
+		assert.NoError(t, service.OnMessageReceived(bobId, msgType, []byte("{}")))
+	}
+	assert.Len(t, service.unknownMessagesLogged, maxUnknownMessagesLogged)
+	assert.Len(t, logger.lines[logLevelDebug], maxUnknownMessagesLogged)
+
+	// Types that were logged before the interval are pruned.
+	service.Lock()
+	for msgType := range service.unknownMessagesLogged {
+		service.unknownMessagesLogged[msgType] = time.Now().Add(-unknownMessageLogInterval)
+	}
+	service.Unlock()
+	newType := messages.MessageTypeToHexString(messages.UPPER_MESSAGE_BOUND + 1001)
+	assert.NoError(t, service.OnMessageReceived(bobId, newType, []byte("{}")))
+	assert.Len(t, service.unknownMessagesLogged, 1)
+	assert.Contains(t, service.unknownMessagesLogged, newType)
+}
+
+func Test_ListSwapsByState(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = store
+
+	states := []StateType{
+		State_SwapOutSender_AwaitTxConfirmation,
+		State_SwapOutSender_AwaitTxConfirmation,
+		State_ClaimedPreimage,
+		State_SwapCanceled,
+	}
+	swapIds := map[StateType][]string{}
+	for _, state := range states {
+		swap := newSwapOutSenderFSM(service.swapServices, aliceId, bobId)
+		swap.Current = state
+		require.NoError(t, store.UpdateData(swap))
+		swapIds[state] = append(swapIds[state], swap.SwapId.String())
+	}
+
+	for _, state := range []StateType{State_SwapOutSender_AwaitTxConfirmation, State_ClaimedPreimage, State_SwapCanceled} {
+		swaps, err := service.ListSwapsByState(state)
+		require.NoError(t, err)
+		var ids []string
+		for _, swap := range swaps {
+			assert.Equal(t, state, swap.Current)
+			ids = append(ids, swap.SwapId.String())
 		}
-	}()
+		assert.ElementsMatch(t, swapIds[state], ids)
+	}
 
-	return nil
+	swaps, err := service.ListSwapsByState(State_ClaimedCsv)
+	require.NoError(t, err)
+	assert.Empty(t, swaps)
+
+	finished, err := service.ListSwapsWhere(func(swap *SwapStateMachine) bool {
+		return swap.IsFinished()
+	})
+	require.NoError(t, err)
+	assert.Len(t, finished, 2)
 }
 
-func (c *ConnectedMessenger) AddMessageHandler(f func(peerId string, msgType string, msgBytes []byte) error) {
-	c.OnMessage = f
+func Test_ExportSwaps(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = store
+
+	expected := map[string]ExportedSwap{}
+	for i, state := range []StateType{State_ClaimedPreimage, State_SwapCanceled, State_SwapOutSender_AwaitTxConfirmation} {
+		swap := newSwapOutSenderFSM(service.swapServices, aliceId, bobId)
+		swap.Previous = State_SwapOutSender_AwaitAgreement
+		swap.Current = state
+		swap.Data.CreatedAt = int64(1000 + i)
+		swap.Data.UpdatedAt = int64(2000 + i)
+		swap.Data.SwapOutRequest = &SwapOutRequestMessage{
+			SwapId:  swap.SwapId,
+			Network: "mainnet",
+			Scid:    fmt.Sprintf("%dx1x1", i),
+			Amount:  uint64(100000 * (i + 1)),
+		}
+		swap.Data.SwapOutAgreement = &SwapOutAgreementMessage{SwapId: swap.SwapId, Premium: 10}
+		swap.Data.OpeningTxFee = 500
+		swap.Data.OpeningTxBroadcasted = &OpeningTxBroadcastedMessage{SwapId: swap.SwapId, TxId: fmt.Sprintf("opening%d", i)}
+		swap.Data.ClaimTxId = fmt.Sprintf("claim%d", i)
+		require.NoError(t, store.UpdateData(swap))
+
+		expected[swap.SwapId.String()] = ExportedSwap{
+			SwapId:          swap.SwapId.String(),
+			Type:            "swap-out",
+			Role:            "sender",
+			State:           state,
+			PreviousState:   State_SwapOutSender_AwaitAgreement,
+			Chain:           btc_chain,
+			ChannelId:       fmt.Sprintf("%dx1x1", i),
+			PeerNodeId:      bobId,
+			InitiatorNodeId: aliceId,
+			AmountSat:       uint64(100000 * (i + 1)),
+			PremiumSat:      10,
+			OpeningTxFeeSat: 500,
+			OpeningTxId:     fmt.Sprintf("opening%d", i),
+			ClaimTxId:       fmt.Sprintf("claim%d", i),
+			CreatedAt:       int64(1000 + i),
+			UpdatedAt:       int64(2000 + i),
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, service.ExportSwaps(&buf))
+	assert.NotContains(t, buf.String(), "private_key")
+	assert.Equal(t, 3, strings.Count(buf.String(), "\n"))
+
+	decoder := json.NewDecoder(&buf)
+	exported := map[string]ExportedSwap{}
+	for {
+		var swap ExportedSwap
+		err := decoder.Decode(&swap)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		exported[swap.SwapId] = swap
+	}
+	assert.Equal(t, expected, exported)
+
+	// A failing writer stops the export after the first swap.
+	w := &failingWriter{}
+	assert.ErrorIs(t, service.ExportSwaps(w), errWriteFailed)
+	assert.Equal(t, 1, w.writes)
 }
 
-type MessengerManagerStub struct {
-	sync.Mutex
-	called  int
-	added   int
-	removed int
+var errWriteFailed = errors.New("write failed")
+
+// failingWriter fails every write.
+type failingWriter struct {
+	writes int
 }
 
-func (s *MessengerManagerStub) AddSender(id string, messenger messages.StoppableMessenger) error {
-	s.Lock()
-	defer s.Unlock()
-	s.called++
-	s.added++
-	return nil
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errWriteFailed
 }
 
-func (s *MessengerManagerStub) RemoveSender(id string) {
-	s.Lock()
-	defer s.Unlock()
-	s.called++
-	s.removed++
+// slowStore delays the lookup of a swap.
+type slowStore struct {
+	Store
+	delay time.Duration
 }
 
-type noopMessenger struct {
+func (s *slowStore) GetData(id string) (*SwapStateMachine, error) {
+	time.Sleep(s.delay)
+	return s.Store.GetData(id)
 }
 
-func (m *noopMessenger) SendMessage(peerId string, msg []byte, msgType int) error {
-	return nil
+func Test_RequestReceived_ConcurrentDuplicates(t *testing.T) {
+	_, peer, pubkey, _, channelId := getTestParams()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service, _ := getRecordingTestSetup(aliceId)
+	// Widen the window between the duplicate check and the creation of the
+	// swap.
+	service.swapServices.swapStore = &slowStore{Store: store, delay: 10 * time.Millisecond}
+	require.NoError(t, service.swapServices.SetRequestRateLimit(0, time.Minute))
+	require.NoError(t, service.swapServices.SetAllowConcurrentChannelSwaps(true, func(string) (uint64, error) {
+		return math.MaxUint64 / 2, nil
+	}))
+
+	events, unsubscribe := service.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i < 20; i++ {
+		swapId := NewSwapId()
+		request := &SwapOutRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            channelId,
+			Amount:          100000,
+			Pubkey:          pubkey,
+		}
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		errs := make(chan error, 2)
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				errs <- service.OnSwapOutRequestReceived(swapId, peer, request)
+			}()
+		}
+		close(start)
+		wg.Wait()
+		close(errs)
+
+		var succeeded int
+		for err := range errs {
+			if err == nil {
+				succeeded++
+				continue
+			}
+			assert.ErrorIs(t, err, ErrDuplicateSwapId)
+		}
+		assert.Equal(t, 1, succeeded)
+
+		// Only one statemachine was created for the swap id.
+		var added int
+		for len(events) > 0 {
+			if event := <-events; event.Kind == SwapEventAdded {
+				assert.Equal(t, swapId.String(), event.SwapId)
+				added++
+			}
+		}
+		assert.Equal(t, 1, added)
+	}
+	assert.Len(t, service.GetActiveSwaps(), 20)
+	assert.Empty(t, service.inFlightRequests)
 }
 
-func (m *noopMessenger) AddMessageHandler(f func(peerId string, msgType string, msgBytes []byte) error) {
+func Test_MaxActiveSwapsPerPeer(t *testing.T) {
+	initiator, peer, pubkey, _, _ := getTestParams()
+	_, otherPeer, _, _, _ := getTestParams()
+
+	service, messenger := getRecordingTestSetup(initiator)
+	require.NoError(t, service.swapServices.SetRequestRateLimit(0, time.Minute))
+	assert.Error(t, service.swapServices.SetMaxActiveSwapsPerPeer(-1))
+	require.NoError(t, service.swapServices.SetMaxActiveSwapsPerPeer(2))
+
+	request := func(peerId, channelId string) error {
+		swapId := NewSwapId()
+		return service.OnSwapOutRequestReceived(swapId, peerId, &SwapOutRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            channelId,
+			Amount:          100000,
+			Pubkey:          pubkey,
+		})
+	}
+
+	// Swaps up to the limit are accepted.
+	require.NoError(t, request(peer, "100x1x1"))
+	require.NoError(t, request(peer, "100x1x2"))
+	assert.Equal(t, 2, service.activeSwapCountForPeer(peer))
+
+	// A request past the limit is canceled.
+	sent := len(messenger.sent)
+	err := request(peer, "100x1x3")
+	assert.Equal(t, MaxActiveSwapsPerPeerError{PeerId: peer, Max: 2}, err)
+	require.Len(t, messenger.sent, sent+1)
+	assert.Equal(t, int(messages.MESSAGETYPE_CANCELED), messenger.sent[sent].msgType)
+	assert.Equal(t, 2, service.activeSwapCountForPeer(peer))
+
+	// So is a swap that we start.
+	_, err = service.SwapOut(peer, btc_chain, "100x1x3", initiator, 100000)
+	assert.Equal(t, MaxActiveSwapsPerPeerError{PeerId: peer, Max: 2}, err)
+
+	// Other peers are not affected.
+	require.NoError(t, request(otherPeer, "100x1x3"))
+	assert.Equal(t, 1, service.activeSwapCountForPeer(otherPeer))
+
+	// The limit can be disabled.
+	require.NoError(t, service.swapServices.SetMaxActiveSwapsPerPeer(0))
+	require.NoError(t, request(peer, "100x1x4"))
+	assert.Equal(t, 3, service.activeSwapCountForPeer(peer))
 }
 
-func getTestParams() (pubkeyA, pubkeyB, takerPubkey, makerPubkey, scid string) {
-	return getRandom33ByteHexString(), getRandom33ByteHexString(), getRandom33ByteHexString(), getRandom33ByteHexString(), "100x2x3"
+func Test_MaxActiveSwaps(t *testing.T) {
+	initiator, peer, pubkey, _, _ := getTestParams()
+	_, otherPeer, _, _, _ := getTestParams()
+
+	service, messenger := getRecordingTestSetup(initiator)
+	require.NoError(t, service.swapServices.SetRequestRateLimit(0, time.Minute))
+	assert.Error(t, service.swapServices.SetMaxActiveSwaps(-1))
+	require.NoError(t, service.swapServices.SetMaxActiveSwaps(3))
+
+	request := func(peerId, channelId string) error {
+		swapId := NewSwapId()
+		return service.OnSwapInRequestReceived(swapId, peerId, &SwapInRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            channelId,
+			Amount:          100000,
+			Pubkey:          pubkey,
+		})
+	}
+
+	// Swaps of all peers count towards the limit.
+	require.NoError(t, request(peer, "100x1x1"))
+	require.NoError(t, request(otherPeer, "100x1x2"))
+	_, err := service.SwapOut(peer, btc_chain, "100x1x3", initiator, 100000)
+	require.NoError(t, err)
+	assert.Len(t, service.GetActiveSwaps(), 3)
+
+	// A request past the limit is canceled.
+	sent := len(messenger.sent)
+	err = request(otherPeer, "100x1x4")
+	assert.ErrorIs(t, err, ErrSwapLimit)
+	assert.Equal(t, MaxActiveSwapsError(3), err)
+	msg := lastCancelMessage(t, messenger)
+	assert.Len(t, messenger.sent, sent+1)
+	assert.Equal(t, CancelReasonSwapLimit, msg.Reason)
+	assert.Len(t, service.GetActiveSwaps(), 3)
+
+	// So are the swaps that we start.
+	_, err = service.SwapOut(otherPeer, btc_chain, "100x1x4", initiator, 100000)
+	assert.ErrorIs(t, err, ErrSwapLimit)
+	_, err = service.SwapIn(otherPeer, btc_chain, "100x1x4", initiator, 100000)
+	assert.ErrorIs(t, err, ErrSwapLimit)
+
+	// The limit can be disabled.
+	require.NoError(t, service.swapServices.SetMaxActiveSwaps(0))
+	require.NoError(t, request(otherPeer, "100x1x4"))
+	assert.Len(t, service.GetActiveSwaps(), 4)
 }
 
-func getRandom33ByteHexString() string {
-	privkey, _ := btcec.NewPrivateKey(btcec.S256())
-	return hex.EncodeToString(privkey.PubKey().SerializeCompressed())
+func Test_MaxActiveSwaps_Concurrent(t *testing.T) {
+	initiator, _, pubkey, _, _ := getTestParams()
+	service, _ := getRecordingTestSetup(initiator)
+	require.NoError(t, service.swapServices.SetRequestRateLimit(0, time.Minute))
+	require.NoError(t, service.swapServices.SetMaxActiveSwaps(2))
+
+	// The requests are held after the limit check until all requests
+	// passed it.
+	const requests = 5
+	estimator := &barrierFeeEstimator{n: requests, released: make(chan struct{})}
+	require.NoError(t, service.swapServices.SetFeeEstimator(estimator))
+	require.NoError(t, service.swapServices.SetMaxOpeningTxFeeRate(btc_chain, 20))
+
+	var wg sync.WaitGroup
+	var accepted int32
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			swapId := NewSwapId()
+			err := service.OnSwapOutRequestReceived(swapId, getRandom33ByteHexString(), &SwapOutRequestMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          swapId,
+				Network:         "mainnet",
+				Scid:            fmt.Sprintf("100x1x%d", i),
+				Amount:          100000,
+				Pubkey:          pubkey,
+			})
+			if err == nil {
+				atomic.AddInt32(&accepted, 1)
+			} else {
+				assert.ErrorIs(t, err, ErrSwapLimit)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), accepted)
+	assert.Len(t, service.GetActiveSwaps(), 2)
 }
 
-func getRandom32ByteHexString() string {
-	privkey, _ := btcec.NewPrivateKey(btcec.S256())
-	return hex.EncodeToString(privkey.Serialize())
+func Test_SwapInitiator(t *testing.T) {
+	initiator, peer, _, _, _ := getTestParams()
+
+	service, _ := getRecordingTestSetup(initiator)
+	service.swapServices.lightning = &nodeIdLightningClient{
+		dummyLightningClient: service.swapServices.lightning.(*dummyLightningClient),
+		nodeId:               initiator,
+	}
+
+	_, err := service.SwapOut(peer, btc_chain, "100x1x1", initiator, 100000)
+	assert.NoError(t, err)
+	_, err = service.SwapIn(peer, btc_chain, "100x1x2", initiator, 100000)
+	assert.NoError(t, err)
+
+	_, err = service.SwapOut(peer, btc_chain, "100x1x3", peer, 100000)
+	assert.ErrorIs(t, err, ErrWrongInitiator)
+	_, err = service.SwapIn(peer, btc_chain, "100x1x4", "", 100000)
+	assert.ErrorIs(t, err, ErrWrongInitiator)
+	assert.Len(t, service.GetActiveSwaps(), 2)
+}
+
+func Test_MaxOpeningTxFeeRate(t *testing.T) {
+	initiator, peer, pubkey, _, _ := getTestParams()
+
+	service, messenger := getRecordingTestSetup(initiator)
+	wallet := &feeRateWallet{dummyChain: service.swapServices.bitcoinWallet.(*dummyChain)}
+	service.swapServices.bitcoinWallet = wallet
+	require.NoError(t, service.swapServices.SetRequestRateLimit(0, time.Minute))
+	assert.Error(t, service.swapServices.SetMaxOpeningTxFeeRate("doge", 10))
+	assert.Error(t, service.swapServices.SetMaxOpeningTxFeeRate(btc_chain, -1))
+	require.NoError(t, service.swapServices.SetMaxOpeningTxFeeRate(btc_chain, 20))
+
+	request := func(channelId string) error {
+		swapId := NewSwapId()
+		return service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            channelId,
+			Amount:          100000,
+			Pubkey:          pubkey,
+		})
+	}
+
+	// A request is canceled if the fees are too high.
+	wallet.feeRate = 50
+	err := request("100x1x1")
+	assert.Equal(t, OpeningTxFeeRateTooHighError{Chain: btc_chain, FeeRate: 50, Max: 20}, err)
+	require.Len(t, messenger.sent, 1)
+	assert.Equal(t, int(messages.MESSAGETYPE_CANCELED), messenger.sent[0].msgType)
+	assert.Empty(t, service.GetActiveSwaps())
+
+	// It is accepted if the fees are low enough.
+	wallet.feeRate = 20
+	require.NoError(t, request("100x1x1"))
+	assert.Len(t, service.GetActiveSwaps(), 1)
+
+	// The limit can be disabled.
+	wallet.feeRate = 50
+	require.NoError(t, service.swapServices.SetMaxOpeningTxFeeRate(btc_chain, 0))
+	require.NoError(t, request("100x1x2"))
+	assert.Len(t, service.GetActiveSwaps(), 2)
+}
+
+// depthTxWatcher is a dummyChain that records the required confirmations.
+type depthTxWatcher struct {
+	*dummyChain
+	confs uint32
+}
+
+func (w *depthTxWatcher) SetRequiredConfirmations(confs uint32) {
+	w.confs = confs
+}
+
+func Test_ConfirmationDepth(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		bitcoinConfs        uint32
+		liquidConfs         uint32
+		liquidEnabled       bool
+		expectedBitcoinConf uint32
+		expectedLiquidConf  uint32
+	}{
+		{name: "per chain", bitcoinConfs: 3, liquidConfs: 2, liquidEnabled: true, expectedBitcoinConf: 3, expectedLiquidConf: 2},
+		{name: "watcher default", bitcoinConfs: 0, liquidConfs: 1, liquidEnabled: true, expectedBitcoinConf: 6, expectedLiquidConf: 1},
+		{name: "chain disabled", bitcoinConfs: 3, liquidConfs: 2, liquidEnabled: false, expectedBitcoinConf: 3, expectedLiquidConf: 6},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := getTestSetup(aliceId)
+			bitcoinWatcher := &depthTxWatcher{dummyChain: &dummyChain{}, confs: 6}
+			liquidWatcher := &depthTxWatcher{dummyChain: &dummyChain{}, confs: 6}
+			service.swapServices.bitcoinTxWatcher = bitcoinWatcher
+			service.swapServices.liquidTxWatcher = liquidWatcher
+			service.LiquidEnabled = tc.liquidEnabled
+			service.swapServices.SetBitcoinConfirmations(tc.bitcoinConfs)
+			service.swapServices.SetLiquidConfirmations(tc.liquidConfs)
+
+			require.NoError(t, service.Start())
+			assert.Equal(t, tc.expectedBitcoinConf, bitcoinWatcher.confs)
+			assert.Equal(t, tc.expectedLiquidConf, liquidWatcher.confs)
+			assert.NotNil(t, bitcoinWatcher.txConfirmedFunc)
+			assert.Equal(t, tc.liquidEnabled, liquidWatcher.txConfirmedFunc != nil)
+		})
+	}
+}
+
+func Test_FeeEstimator(t *testing.T) {
+	initiator, peer, pubkey, _, _ := getTestParams()
+
+	service, _ := getRecordingTestSetup(initiator)
+	estimator := &stubFeeEstimator{openingFee: 1234, feeRate: 50}
+	assert.Error(t, service.swapServices.SetFeeEstimator(nil))
+	require.NoError(t, service.swapServices.SetFeeEstimator(estimator))
+	require.NoError(t, service.swapServices.SetRequestRateLimit(0, time.Minute))
+	require.NoError(t, service.swapServices.SetMaxOpeningTxFeeRate(btc_chain, 20))
+
+	request := func(channelId string) error {
+		swapId := NewSwapId()
+		return service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            channelId,
+			Amount:          100000,
+			Pubkey:          pubkey,
+		})
+	}
+
+	// The fee rate of the estimator is checked.
+	err := request("100x1x1")
+	assert.Equal(t, OpeningTxFeeRateTooHighError{Chain: btc_chain, FeeRate: 50, Max: 20}, err)
+	assert.Equal(t, []string{"rate btc"}, estimator.calls)
+
+	// The opening fee of the estimator is used for the fee invoice.
+	estimator.feeRate = 10
+	estimator.calls = nil
+	require.NoError(t, request("100x1x1"))
+	assert.Equal(t, []string{"rate btc", "opening btc 100000"}, estimator.calls)
+
+	// And for quotes.
+	quote, err := service.QuoteSwapOut(peer, btc_chain, "100x1x2", 200000)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1234), quote.OpeningTxFeeSat)
+	assert.Equal(t, "opening btc 200000", estimator.calls[len(estimator.calls)-1])
+}
+
+// feeInvoiceLightningClient is a dummyLightningClient whose fee invoices are
+// of the form "fee <msat>" instead of a fixed amount.
+type feeInvoiceLightningClient struct {
+	*dummyLightningClient
+}
+
+func (f *feeInvoiceLightningClient) GetPayreq(msatAmount uint64, preimage string, swapId string, memo string, invoiceType InvoiceType, expiry uint64) (string, error) {
+	if invoiceType == INVOICE_FEE {
+		return fmt.Sprintf("fee %d", msatAmount), nil
+	}
+	return f.dummyLightningClient.GetPayreq(msatAmount, preimage, swapId, memo, invoiceType, expiry)
+}
+
+func (f *feeInvoiceLightningClient) DecodePayreq(payreq string) (string, uint64, error) {
+	var msatAmount uint64
+	if _, err := fmt.Sscanf(payreq, "fee %d", &msatAmount); err == nil {
+		return "foo", msatAmount, nil
+	}
+	return f.dummyLightningClient.DecodePayreq(payreq)
+}
+
+func Test_FeeBreakdown(t *testing.T) {
+	initiator, peer, pubkey, _, channelId := getTestParams()
+
+	for _, tc := range []struct {
+		name       string
+		invoiceSat uint64
+		premium    uint64
+		breakdown  *FeeBreakdown
+		accepted   bool
+		errContain string
+		reason     CancelReason
+	}{
+		{name: "all components accepted", invoiceSat: 1100, premium: 100, breakdown: &FeeBreakdown{OpeningTxFee: 1000, Premium: 100}, accepted: true},
+		{name: "premium accepted, opening tx fee rejected", invoiceSat: 50100, premium: 100, breakdown: &FeeBreakdown{OpeningTxFee: 50000, Premium: 100}, errContain: "opening tx fee of 50000 sat exceeds", reason: CancelReasonFeeTooHigh},
+		{name: "opening tx fee accepted, premium rejected", invoiceSat: 2000, premium: 1000, breakdown: &FeeBreakdown{OpeningTxFee: 1000, Premium: 1000}, errContain: "premium of 1000 sat exceeds", reason: CancelReasonFeeTooHigh},
+		{name: "components do not add up", invoiceSat: 2900, premium: 100, breakdown: &FeeBreakdown{OpeningTxFee: 1000, Premium: 100}, errContain: "does not match the fee breakdown", reason: CancelReasonFeeTooHigh},
+		{name: "premium does not match", invoiceSat: 1100, premium: 100, breakdown: &FeeBreakdown{OpeningTxFee: 1000, Premium: 200}, reason: CancelReasonInvalidMessage},
+		{name: "no breakdown", invoiceSat: 1100, premium: 100, accepted: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service, _ := getRecordingTestSetup(initiator)
+			service.swapServices.lightning = &feeInvoiceLightningClient{service.swapServices.lightning.(*dummyLightningClient)}
+			require.NoError(t, service.swapServices.SetFeeEstimator(&stubFeeEstimator{openingFee: 1000}))
+			service.swapServices.SetMaxPremium(500)
+
+			swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+			require.NoError(t, err)
+			err = service.OnSwapOutAgreementReceived(&SwapOutAgreementMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          swap.SwapId,
+				Pubkey:          pubkey,
+				Payreq:          fmt.Sprintf("fee %d", tc.invoiceSat*1000),
+				Premium:         tc.premium,
+				FeeBreakdown:    tc.breakdown,
+			})
+			require.NoError(t, err)
+
+			if tc.accepted {
+				assert.Equal(t, State_SwapOutSender_AwaitTxBroadcastedMessage, swap.Current)
+				assert.Equal(t, tc.invoiceSat, swap.Data.Cost.FeeInvoiceSat)
+				return
+			}
+			assert.Equal(t, State_SwapCanceled, swap.Current)
+			assert.Contains(t, swap.Data.LastErrString, tc.errContain)
+			assert.Equal(t, tc.reason, swap.Data.CancelReason)
+			assert.Zero(t, swap.Data.Cost.FeeInvoiceSat)
+		})
+	}
+}
+
+func Test_CheckSwapCost(t *testing.T) {
+	services := getTestSetup(aliceId).swapServices
+	assert.Error(t, services.SetMaxCostPPM(1_000_001))
+	require.NoError(t, services.SetMaxCostPPM(5000))
+
+	for _, tc := range []struct {
+		amount uint64
+		max    uint64
+	}{
+		{amount: 100_000, max: 500},
+		{amount: 1_234_567, max: 6172},
+		{amount: 250_000_000, max: 1_250_000},
+		// 21M btc would overflow amount * ppm.
+		{amount: 2_100_000_000_000_000, max: 10_500_000_000_000},
+	} {
+		assert.NoError(t, services.checkSwapCost(tc.amount, tc.max), tc.amount)
+		err := services.checkSwapCost(tc.amount, tc.max+1)
+		assert.ErrorIs(t, err, ErrSwapCostTooHigh, tc.amount)
+		assert.Equal(t, SwapCostTooHighError{CostSat: tc.max + 1, MaxSat: tc.max, PPM: 5000}, err)
+	}
+
+	require.NoError(t, services.SetMaxCostPPM(0))
+	assert.NoError(t, services.checkSwapCost(100_000, 100_000))
+}
+
+func Test_MaxCostPPM(t *testing.T) {
+	initiator, peer, pubkey, _, channelId := getTestParams()
+
+	newService := func(t *testing.T) *SwapService {
+		service, _ := getRecordingTestSetup(initiator)
+		service.swapServices.lightning = &feeInvoiceLightningClient{service.swapServices.lightning.(*dummyLightningClient)}
+		require.NoError(t, service.swapServices.SetFeeEstimator(&stubFeeEstimator{openingFee: 1000}))
+		service.swapServices.SetMaxPremium(10000)
+		// 2% of the amount.
+		require.NoError(t, service.swapServices.SetMaxCostPPM(20000))
+		return service
+	}
+
+	for _, tc := range []struct {
+		amount     uint64
+		invoiceSat uint64
+		accepted   bool
+	}{
+		{amount: 100000, invoiceSat: 2000, accepted: true},
+		{amount: 100000, invoiceSat: 2001},
+		{amount: 120000, invoiceSat: 2400, accepted: true},
+		{amount: 120000, invoiceSat: 2401},
+	} {
+		t.Run(fmt.Sprintf("swap out %d sat fee %d sat", tc.amount, tc.invoiceSat), func(t *testing.T) {
+			service := newService(t)
+			swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, tc.amount)
+			require.NoError(t, err)
+			require.NoError(t, service.OnSwapOutAgreementReceived(&SwapOutAgreementMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          swap.SwapId,
+				Pubkey:          pubkey,
+				Payreq:          fmt.Sprintf("fee %d", tc.invoiceSat*1000),
+			}))
+
+			if tc.accepted {
+				assert.Equal(t, State_SwapOutSender_AwaitTxBroadcastedMessage, swap.Current)
+				return
+			}
+			assert.Equal(t, State_SwapCanceled, swap.Current)
+			assert.Equal(t, CancelReasonFeeTooHigh, swap.Data.CancelReason)
+			assert.Contains(t, swap.Data.CancelMessage, "exceeds the maximum")
+			assert.Zero(t, swap.Data.Cost.FeeInvoiceSat)
+		})
+	}
+
+	// The cost of a swap in is the premium and the opening tx fee of 1000
+	// sat.
+	for _, tc := range []struct {
+		amount   uint64
+		premium  uint64
+		accepted bool
+	}{
+		{amount: 100000, premium: 1000, accepted: true},
+		{amount: 100000, premium: 1001},
+		{amount: 200000, premium: 3000, accepted: true},
+		{amount: 200000, premium: 3001},
+	} {
+		t.Run(fmt.Sprintf("swap in %d sat premium %d sat", tc.amount, tc.premium), func(t *testing.T) {
+			service := newService(t)
+			swap, err := service.SwapIn(peer, btc_chain, channelId, initiator, tc.amount)
+			require.NoError(t, err)
+			require.NoError(t, service.OnSwapInAgreementReceived(&SwapInAgreementMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          swap.SwapId,
+				Pubkey:          pubkey,
+				Premium:         tc.premium,
+			}))
+
+			if tc.accepted {
+				assert.NotEqual(t, State_SwapCanceled, swap.Current)
+				assert.Empty(t, swap.Data.CancelReason)
+				return
+			}
+			assert.Equal(t, State_SwapCanceled, swap.Current)
+			assert.Equal(t, CancelReasonFeeTooHigh, swap.Data.CancelReason)
+			assert.Contains(t, swap.Data.CancelMessage, "exceeds the maximum")
+		})
+	}
+}
+
+func Test_GetSwap_ActiveSwapAheadOfStore(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+
+	service, _ := getRecordingTestSetup(initiator)
+	swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	require.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Current)
+
+	// The store still holds an older state of the swap.
+	stale := &SwapStateMachine{
+		SwapId:   swap.SwapId,
+		Data:     swap.Data,
+		Previous: Default,
+		Current:  State_SwapOutSender_CreateSwap,
+	}
+	require.NoError(t, service.swapServices.swapStore.UpdateData(stale))
+
+	got, err := service.GetSwap(swap.SwapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, State_SwapOutSender_AwaitAgreement, got.Current)
+
+	// The active swap is returned as a snapshot.
+	assert.NotSame(t, swap, got)
+	assert.NotSame(t, swap.Data, got.Data)
+	assert.Equal(t, swap.Data.GetId(), got.Data.GetId())
+
+	// Swaps that are no longer active are read from the store.
+	service.RemoveActiveSwap(swap.SwapId.String())
+	got, err = service.GetSwap(swap.SwapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, State_SwapOutSender_CreateSwap, got.Current)
+}
+
+func Test_OnPayment(t *testing.T) {
+	_, peer, pubkey, _, channelId := getTestParams()
+
+	logger := &testLogger{}
+	service, _ := getRecordingTestSetup(aliceId)
+	service.swapServices.logger = logger
+	require.NoError(t, service.swapServices.SetMetricsRegisterer(prometheus.NewRegistry()))
+	orphanPayments := service.swapServices.metrics.orphanPayments
+
+	swapId := NewSwapId()
+	require.NoError(t, service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            channelId,
+		Amount:          100000,
+		Pubkey:          pubkey,
+	}))
+	swap, err := service.GetActiveSwap(swapId.String())
+	require.NoError(t, err)
+
+	// Payments to the active swap advance the swap.
+	service.OnPayment(swapId.String(), INVOICE_FEE)
+	assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, swap.Current)
+	service.OnPayment(swapId.String(), INVOICE_CLAIM)
+	assert.Equal(t, State_ClaimedPreimage, swap.Current)
+	assert.Empty(t, logger.lines[logLevelError])
+
+	// A retried payment to the finished swap is counted and not an error.
+	service.OnPayment(swapId.String(), INVOICE_CLAIM)
+	assert.Equal(t, float64(1), testutil.ToFloat64(orphanPayments.WithLabelValues("claim")))
+	assert.Empty(t, logger.lines[logLevelError])
+
+	// A payment with a garbage label is not counted.
+	service.OnPayment("garbage", INVOICE_CLAIM)
+	assert.Equal(t, float64(1), testutil.ToFloat64(orphanPayments.WithLabelValues("claim")))
+	require.Len(t, logger.lines[logLevelWarn], 1)
+	assert.Contains(t, logger.lines[logLevelWarn][0], "Could not parse swap id garbage")
+
+	// A payment that the active swap does not expect is still an error.
+	swapId = NewSwapId()
+	require.NoError(t, service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            channelId,
+		Amount:          100000,
+		Pubkey:          pubkey,
+	}))
+	service.OnPayment(swapId.String(), INVOICE_CLAIM)
+	require.Len(t, logger.lines[logLevelError], 1)
+	assert.Contains(t, logger.lines[logLevelError][0], "claim invoice payment of swap "+swapId.String())
+
+	// A payment of an unknown invoice type is ignored.
+	swap, err = service.GetActiveSwap(swapId.String())
+	require.NoError(t, err)
+	current := swap.Current
+	service.OnPayment(swapId.String(), InvoiceType(99))
+	assert.Equal(t, current, swap.Current)
+	assert.Len(t, logger.lines[logLevelError], 1)
+	assert.Len(t, logger.lines[logLevelWarn], 1)
+}
+
+func Test_RequestReceived_ProtocolVersion(t *testing.T) {
+	_, peer, pubkey, _, _ := getTestParams()
+
+	service, messenger := getRecordingTestSetup(aliceId)
+	require.NoError(t, service.swapServices.SetRequestRateLimit(0, time.Minute))
+
+	swapOut := func(version uint8, channelId string) error {
+		swapId := NewSwapId()
+		return service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+			ProtocolVersion: version,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            channelId,
+			Amount:          100000,
+			Pubkey:          pubkey,
+		})
+	}
+	swapIn := func(version uint8, channelId string) error {
+		swapId := NewSwapId()
+		return service.OnSwapInRequestReceived(swapId, peer, &SwapInRequestMessage{
+			ProtocolVersion: version,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            channelId,
+			Amount:          100000,
+			Pubkey:          pubkey,
+		})
+	}
+	lastCancelMessage := func() string {
+		messenger.Lock()
+		defer messenger.Unlock()
+		sent := messenger.sent[len(messenger.sent)-1]
+		require.Equal(t, int(messages.MESSAGETYPE_CANCELED), sent.msgType)
+		var cancelMsg CancelMessage
+		require.NoError(t, json.Unmarshal(sent.payload, &cancelMsg))
+		return cancelMsg.Message
+	}
+
+	// Matching version.
+	require.NoError(t, swapOut(PEERSWAP_PROTOCOL_VERSION, "1x1x1"))
+	require.NoError(t, swapIn(PEERSWAP_PROTOCOL_VERSION, "1x1x2"))
+	assert.Len(t, service.GetActiveSwaps(), 2)
+
+	// Lower and higher versions are rejected.
+	for _, version := range []uint8{PEERSWAP_PROTOCOL_VERSION - 1, PEERSWAP_PROTOCOL_VERSION + 1} {
+		err := swapOut(version, "1x1x3")
+		assert.Equal(t, ProtocolVersionError(version), err)
+		assert.Equal(t, fmt.Sprintf("incompatible peerswap version: %d", version), lastCancelMessage())
+
+		err = swapIn(version, "1x1x3")
+		assert.Equal(t, ProtocolVersionError(version), err)
+		assert.Equal(t, fmt.Sprintf("incompatible peerswap version: %d", version), lastCancelMessage())
+	}
+	assert.Len(t, service.GetActiveSwaps(), 2)
+
+	// A lower version is accepted if configured.
+	assert.Error(t, service.swapServices.SetAcceptedProtocolVersions(nil))
+	require.NoError(t, service.swapServices.SetAcceptedProtocolVersions([]uint8{PEERSWAP_PROTOCOL_VERSION - 1, PEERSWAP_PROTOCOL_VERSION}))
+	require.NoError(t, swapOut(PEERSWAP_PROTOCOL_VERSION-1, "1x1x3"))
+	assert.Len(t, service.GetActiveSwaps(), 3)
+	assert.Equal(t, ProtocolVersionError(PEERSWAP_PROTOCOL_VERSION+1), swapIn(PEERSWAP_PROTOCOL_VERSION+1, "1x1x4"))
+}
+
+func Test_RequestReceived_DuplicateSwapId(t *testing.T) {
+	_, peer, pubkey, _, channelId := getTestParams()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service, messenger := getRecordingTestSetup(aliceId)
+	service.swapServices.swapStore = store
+
+	// Store a completed swap.
+	swapId := NewSwapId()
+	request := &SwapOutRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            channelId,
+		Amount:          100000,
+		Pubkey:          pubkey,
+	}
+	completed := newSwapOutReceiverFSM(swapId, service.swapServices, peer)
+	completed.Data.SwapOutRequest = request
+	completed.Current = State_ClaimedPreimage
+	require.NoError(t, store.UpdateData(completed))
+
+	// Replay the request of the completed swap.
+	err = service.OnSwapOutRequestReceived(swapId, peer, request)
+	assert.ErrorIs(t, err, ErrDuplicateSwapId)
+	swapInRequest := &SwapInRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            channelId,
+		Amount:          100000,
+		Pubkey:          pubkey,
+	}
+	err = service.OnSwapInRequestReceived(swapId, peer, swapInRequest)
+	assert.ErrorIs(t, err, ErrDuplicateSwapId)
+
+	_, err = service.GetActiveSwap(swapId.String())
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+
+	stored, err := store.GetData(swapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, State_ClaimedPreimage, stored.Current)
+	assert.Equal(t, SWAPTYPE_OUT, stored.Type)
+
+	messenger.Lock()
+	defer messenger.Unlock()
+	require.Len(t, messenger.sent, 2)
+	for _, sent := range messenger.sent {
+		assert.Equal(t, int(messages.MESSAGETYPE_CANCELED), sent.msgType)
+		var cancelMsg CancelMessage
+		require.NoError(t, json.Unmarshal(sent.payload, &cancelMsg))
+		assert.Contains(t, cancelMsg.Message, ErrDuplicateSwapId.Error())
+	}
+}
+
+// panickingWallet is a dummyChain that panics when the swap-out fee is
+// estimated.
+type panickingWallet struct {
+	*dummyChain
+}
+
+func (w *panickingWallet) GetFlatSwapOutFee() (uint64, error) {
+	panic("no wallet")
+}
+
+// Test_SendEvent_ActionPanics checks that a panicking action fails the swap
+// instead of crashing the service.
+func Test_SendEvent_ActionPanics(t *testing.T) {
+	_, peer, pubkey, _, channelId := getTestParams()
+
+	logger := &testLogger{}
+	service, messenger := getRecordingTestSetup(aliceId)
+	service.swapServices.logger = logger
+	chain := service.swapServices.bitcoinWallet.(*dummyChain)
+	service.swapServices.bitcoinWallet = &panickingWallet{dummyChain: chain}
+
+	swapId := NewSwapId()
+	err := service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            channelId,
+		Amount:          100000,
+		Pubkey:          pubkey,
+	})
+	assert.ErrorIs(t, err, ErrSwapPanicked)
+	require.NotEmpty(t, logger.lines[logLevelError])
+	assert.Contains(t, logger.lines[logLevelError][0], "no wallet")
+
+	// The swap was canceled and removed from the active swaps.
+	_, err = service.GetActiveSwap(swapId.String())
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+	stored, err := service.swapServices.swapStore.GetData(swapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, State_SwapCanceled, stored.Current)
+	assert.ErrorIs(t, stored.Data.LastErr, ErrSwapPanicked)
+
+	messenger.Lock()
+	require.Len(t, messenger.sent, 1)
+	assert.Equal(t, int(messages.MESSAGETYPE_CANCELED), messenger.sent[0].msgType)
+	var cancelMsg CancelMessage
+	require.NoError(t, json.Unmarshal(messenger.sent[0].payload, &cancelMsg))
+	assert.Equal(t, "internal error", cancelMsg.Message)
+	messenger.Unlock()
+
+	// The service still handles new swaps on the channel.
+	service.swapServices.bitcoinWallet = chain
+	swapId = NewSwapId()
+	err = service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            channelId,
+		Amount:          100000,
+		Pubkey:          pubkey,
+	})
+	require.NoError(t, err)
+	swap, err := service.GetActiveSwap(swapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, State_SwapOutReceiver_AwaitFeeInvoicePayment, swap.Current)
+}
+
+// Test_ResendLastMessage_AfterRecovery checks that the pending message of a
+// swap is persisted and can be resent after the swap was recovered from the
+// store.
+func Test_ResendLastMessage_AfterRecovery(t *testing.T) {
+	_, peer, pubkey, _, channelId := getTestParams()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = store
+	service.swapServices.messenger = &noopMessenger{}
+	service.swapServices.toService = &timeOutDummy{}
+
+	swapId := NewSwapId()
+	err = service.OnSwapInRequestReceived(swapId, peer, &SwapInRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            channelId,
+		Amount:          100000,
+		Pubkey:          pubkey,
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.Stop())
+
+	// Recover the swap in a new service.
+	recovered, messenger := getRecordingTestSetup(aliceId)
+	recovered.swapServices.swapStore = store
+	require.NoError(t, recovered.RecoverSwaps())
+
+	swap, err := recovered.GetActiveSwap(swapId.String())
+	require.NoError(t, err)
+	require.Equal(t, State_SwapInReceiver_AwaitTxBroadcastedMessage, swap.Current)
+
+	err = recovered.ResendLastMessage(swapId.String())
+	require.NoError(t, err)
+
+	messenger.Lock()
+	defer messenger.Unlock()
+	require.Len(t, messenger.sent, 1)
+	assert.Equal(t, peer, messenger.sent[0].peerId)
+	assert.Equal(t, int(messages.MESSAGETYPE_SWAPINAGREEMENT), messenger.sent[0].msgType)
+	var agreement SwapInAgreementMessage
+	require.NoError(t, json.Unmarshal(messenger.sent[0].payload, &agreement))
+	assert.Equal(t, swapId.String(), agreement.SwapId.String())
+}
+
+func Test_RecoverSwap(t *testing.T) {
+	_, peer, pubkey, _, channelId := getTestParams()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = store
+	service.swapServices.messenger = &noopMessenger{}
+	service.swapServices.toService = &timeOutDummy{}
+
+	swapId := NewSwapId()
+	err = service.OnSwapInRequestReceived(swapId, peer, &SwapInRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            channelId,
+		Amount:          100000,
+		Pubkey:          pubkey,
+	})
+	require.NoError(t, err)
+
+	// An active swap can not be recovered.
+	err = service.RecoverSwap(swapId.String())
+	assert.ErrorIs(t, err, ErrSwapAlreadyActive)
+
+	// A finished swap can not be recovered.
+	finished := newSwapOutSenderFSM(service.swapServices, aliceId, peer)
+	finished.Current = State_ClaimedPreimage
+	require.NoError(t, store.UpdateData(finished))
+	err = service.RecoverSwap(finished.SwapId.String())
+	assert.ErrorIs(t, err, ErrSwapFinished)
+	_, err = service.GetActiveSwap(finished.SwapId.String())
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+
+	// An unknown swap can not be recovered.
+	err = service.RecoverSwap(NewSwapId().String())
+	assert.ErrorIs(t, err, ErrDataNotAvailable)
+
+	require.NoError(t, service.Stop())
+
+	// Recover only the unfinished swap in a new service.
+	recovered := getTestSetup(aliceId)
+	recovered.swapServices.swapStore = store
+	recovered.swapServices.messenger = &noopMessenger{}
+	recovered.swapServices.toService = &timeOutDummy{}
+	require.NoError(t, recovered.RecoverSwap(swapId.String()))
+
+	swap, err := recovered.GetActiveSwap(swapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, State_SwapInReceiver_AwaitTxBroadcastedMessage, swap.Current)
+	assert.Equal(t, SWAPTYPE_IN, swap.Type)
+	assert.Equal(t, SWAPROLE_RECEIVER, swap.Role)
+	assert.NotNil(t, swap.States[State_SwapInReceiver_AwaitTxBroadcastedMessage].Action)
+	assert.Len(t, recovered.GetActiveSwaps(), 1)
+}
+
+func Test_ListRefundableSwaps(t *testing.T) {
+	swapServices := getSwapServices(make(chan PeerMessage, 10))
+	chain := swapServices.bitcoinTxWatcher.(*dummyChain)
+	swapServices.bitcoinTxWatcher = &heightChain{dummyChain: chain, height: 1100}
+	service := NewSwapService(swapServices)
+
+	// The csv of 1008 blocks passed for the first swap but not for the
+	// second one.
+	newSwap := func(startingHeight uint32) *SwapStateMachine {
+		swap := newSwapInSenderFSM(swapServices, aliceId, bobId)
+		swap.Current = State_SwapInSender_AwaitClaimPayment
+		swap.Data.SwapInRequest = &SwapInRequestMessage{Network: "mainnet", Amount: 100000}
+		swap.Data.StartingBlockHeight = startingHeight
+		service.AddActiveSwap(swap.SwapId.String(), swap)
+		return swap
+	}
+	passed := newSwap(50)
+	pending := newSwap(1000)
+
+	refundable, err := service.ListRefundableSwaps()
+	require.NoError(t, err)
+	require.Len(t, refundable, 1)
+	assert.Equal(t, passed.SwapId, refundable[0].SwapId)
+
+	err = service.ForceRefund(pending.SwapId.String())
+	assert.ErrorIs(t, err, ErrSwapNotRefundable)
+	assert.Equal(t, State_SwapInSender_AwaitClaimPayment, pending.Current)
+
+	require.NoError(t, service.ForceRefund(passed.SwapId.String()))
+	assert.Equal(t, State_ClaimedCsv, passed.Current)
+	assert.Empty(t, passed.Data.GetCancelMessage())
+
+	refundable, err = service.ListRefundableSwaps()
+	require.NoError(t, err)
+	assert.Empty(t, refundable)
+
+	// The refunded swap is not active anymore.
+	assert.ErrorIs(t, service.ForceRefund(passed.SwapId.String()), ErrSwapDoesNotExist)
+}
+
+func Test_ResendLastMessage_NoMessage(t *testing.T) {
+	messenger := &recordingMessenger{}
+	service := getTestSetup(aliceId)
+	service.swapServices.messenger = messenger
+
+	swap := newSwapOutSenderFSM(service.swapServices, aliceId, bobId)
+	service.AddActiveSwap(swap.SwapId.String(), swap)
+
+	err := service.ResendLastMessage(swap.SwapId.String())
+	assert.ErrorIs(t, err, ErrNoMessageToResend)
+	assert.Empty(t, messenger.sent)
+	assert.Nil(t, swap.Data.LastErr)
+	assert.Empty(t, swap.Data.CancelMessage)
+}
+
+// Test_RequestReceived_PersistsSwapType checks that the swaps created from
+// incoming requests are stored with the correct swap type and can be reloaded
+// from the store.
+func Test_RequestReceived_PersistsSwapType(t *testing.T) {
+	_, peer, pubkey, _, channelId := getTestParams()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = store
+	service.swapServices.messenger = &noopMessenger{}
+	service.swapServices.toService = &timeOutDummy{}
+
+	swapInId := NewSwapId()
+	err = service.OnSwapInRequestReceived(swapInId, peer, &SwapInRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapInId,
+		Network:         "mainnet",
+		Scid:            channelId,
+		Amount:          100000,
+		Pubkey:          pubkey,
+	})
+	require.NoError(t, err)
+
+	swapOutId := NewSwapId()
+	err = service.OnSwapOutRequestReceived(swapOutId, peer, &SwapOutRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapOutId,
+		Network:         "mainnet",
+		Scid:            "100x2x4",
+		Amount:          100000,
+		Pubkey:          pubkey,
+	})
+	require.NoError(t, err)
+
+	swapIn, err := store.GetData(swapInId.String())
+	require.NoError(t, err)
+	assert.Equal(t, SWAPTYPE_IN, swapIn.Type)
+	assert.Equal(t, SWAPROLE_RECEIVER, swapIn.Role)
+
+	swapOut, err := store.GetData(swapOutId.String())
+	require.NoError(t, err)
+	assert.Equal(t, SWAPTYPE_OUT, swapOut.Type)
+	assert.Equal(t, SWAPROLE_RECEIVER, swapOut.Role)
+}
+
+func Test_OnMessageReceived_MaxMessageSize(t *testing.T) {
+	service := getTestSetup(aliceId)
+	require.Error(t, service.swapServices.SetMaxMessageSize(0))
+	require.Error(t, service.swapServices.SetMaxMessageSize(-1))
+	require.NoError(t, service.swapServices.SetMaxMessageSize(1024))
+
+	msgType := messages.MessageTypeToHexString(messages.MESSAGETYPE_POLL)
+
+	err := service.OnMessageReceived(bobId, msgType, make([]byte, 1024))
+	assert.NoError(t, err)
+
+	err = service.OnMessageReceived(bobId, msgType, make([]byte, 1025))
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+}
+
+func Test_OnMessageReceived_PayloadTooLargeError(t *testing.T) {
+	service := getTestSetup(aliceId)
+	registry := prometheus.NewRegistry()
+	require.NoError(t, service.swapServices.SetMetricsRegisterer(registry))
+	require.NoError(t, service.swapServices.SetMaxMessageSize(16))
+
+	msgType := messages.MessageTypeToHexString(messages.MESSAGETYPE_POLL)
+	err := service.OnMessageReceived(bobId, msgType, make([]byte, 20))
+
+	var tooLarge PayloadTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	assert.Equal(t, bobId, tooLarge.PeerId)
+	assert.Equal(t, 20, tooLarge.Size)
+	assert.Equal(t, 16, tooLarge.Limit)
+	assert.Equal(t, float64(1), testutil.ToFloat64(service.swapServices.metrics.oversizedPayloads))
+}
+
+func Test_OnMessageReceived_LogsPayloadOnDebug(t *testing.T) {
+	service := getTestSetup(aliceId)
+	logger := &testLogger{}
+	service.swapServices.SetLogger(logger)
+
+	msgType := messages.MessageTypeToHexString(messages.MESSAGETYPE_POLL)
+	err := service.OnMessageReceived(bobId, msgType, []byte("payload"))
+	require.NoError(t, err)
+
+	require.Len(t, logger.lines[logLevelDebug], 1)
+	assert.Contains(t, logger.lines[logLevelDebug][0], "payload")
+	assert.Empty(t, logger.lines[logLevelInfo])
+}
+
+// Test_SwapLogLinesContainSwapId checks that the log lines that are emitted
+// during the lifecycle of a swap carry the id of the swap.
+func Test_SwapLogLinesContainSwapId(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	service, _ := getRecordingTestSetup(initiator)
+	logger := &testLogger{}
+	service.swapServices.SetLogger(logger)
+
+	swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	swapId := swap.SwapId.String()
+
+	// A tx confirmation in the wrong state is ignored.
+	require.NoError(t, service.OnTxConfirmed(swapId, "txhex"))
+
+	payload, msgType, err := MarshalPeerswapMessage(&CancelMessage{SwapId: swap.SwapId, Message: "no liquidity"})
+	require.NoError(t, err)
+	err = service.OnMessageReceived(peer, messages.MessageTypeToHexString(messages.MessageType(msgType)), payload)
+	require.NoError(t, err)
+	require.Equal(t, State_SwapCanceled, swap.Current)
+
+	logger.Lock()
+	defer logger.Unlock()
+	require.NotEmpty(t, logger.lines[logLevelDebug])
+	require.NotEmpty(t, logger.lines[logLevelInfo])
+	for level, lines := range logger.lines {
+		for _, line := range lines {
+			assert.True(t, strings.HasPrefix(line, "[Swap:"+swapId+"] "), "%s line without swap id: %s", level, line)
+		}
+	}
+}
+
+// Test_RecoverSwaps_ContinuesOnError checks that a swap that fails to recover
+// does not prevent the recovery of the other swaps.
+func Test_RecoverSwaps_ContinuesOnError(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = store
+
+	// The store iterates the swaps ordered by id, sort them to make the
+	// broken swap the one in the middle.
+	ids := []*SwapId{NewSwapId(), NewSwapId(), NewSwapId()}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	for i, id := range ids {
+		swap := newSwapInSenderFSM(service.swapServices, aliceId, bobId)
+		swap.SwapId = id
+		swap.Current = State_SwapInSender_AwaitAgreement
+		if i == 1 {
+			swap.Current = "State_Unknown"
+		}
+		require.NoError(t, store.UpdateData(swap))
+	}
+
+	err = service.RecoverSwaps()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ids[1].String())
+
+	for _, id := range []*SwapId{ids[0], ids[2]} {
+		swap, err := service.GetActiveSwap(id.String())
+		require.NoError(t, err)
+		assert.Equal(t, State_SwapInSender_AwaitAgreement, swap.Current)
+	}
+}
+
+func Test_AbandonSwap(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = store
+
+	newStoredSwap := func(state StateType) *SwapStateMachine {
+		swap := newSwapInSenderFSM(service.swapServices, aliceId, bobId)
+		swap.Current = state
+		require.NoError(t, store.UpdateData(swap))
+		return swap
+	}
+	broken := newStoredSwap("State_Unknown")
+	// The opening transaction was broadcasted but the swap did not advance.
+	committed := newStoredSwap(State_SwapInSender_AwaitAgreement)
+	committed.Data.OpeningTxHex = "txhex"
+	require.NoError(t, store.UpdateData(committed))
+	finished := newStoredSwap(State_ClaimedPreimage)
+
+	// The broken swap fails the recovery.
+	err = service.RecoverSwaps()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), broken.SwapId.String())
+
+	// An abandoned swap is removed from the active swaps and stored as
+	// finished.
+	require.NoError(t, service.AbandonSwap(broken.SwapId.String()))
+	_, err = service.GetActiveSwap(broken.SwapId.String())
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+	stored, err := store.GetData(broken.SwapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, State_SwapAbandoned, stored.Current)
+	assert.True(t, stored.IsFinished())
+
+	// Swaps with committed funds can not be abandoned.
+	err = service.AbandonSwap(committed.SwapId.String())
+	assert.ErrorIs(t, err, ErrSwapNotAbandonable)
+	stored, err = store.GetData(committed.SwapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, State_SwapInSender_AwaitAgreement, stored.Current)
+
+	assert.ErrorIs(t, service.AbandonSwap(finished.SwapId.String()), ErrSwapFinished)
+	assert.ErrorIs(t, service.AbandonSwap(broken.SwapId.String()), ErrSwapFinished)
+	assert.ErrorIs(t, service.AbandonSwap(NewSwapId().String()), ErrDataNotAvailable)
+
+	// The abandoned swap is skipped on the next recovery.
+	recovered := getTestSetup(aliceId)
+	recovered.swapServices.swapStore = store
+	require.NoError(t, recovered.RecoverSwaps())
+	_, err = recovered.GetActiveSwap(broken.SwapId.String())
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+	_, err = recovered.GetActiveSwap(committed.SwapId.String())
+	assert.NoError(t, err)
+}
+
+// rebroadcastWallet is a dummyChain that records the rebroadcasted
+// transactions.
+type rebroadcastWallet struct {
+	*dummyChain
+	err          error
+	rebroadcasts []string
+}
+
+func (w *rebroadcastWallet) RebroadcastOpeningTx(txHex string) (string, error) {
+	if w.err != nil {
+		return "", w.err
+	}
+	w.rebroadcasts = append(w.rebroadcasts, txHex)
+	return "txid", nil
+}
+
+func Test_RebroadcastOpeningTx(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+
+	newService := func(state StateType) (*SwapService, *SwapStateMachine) {
+		service := getTestSetup(initiator)
+		swap := newSwapOutReceiverFSM(NewSwapId(), service.swapServices, peer)
+		swap.Current = state
+		swap.Data.SwapOutRequest = &SwapOutRequestMessage{SwapId: swap.SwapId, Network: "mainnet", Scid: channelId, Amount: 100000}
+		swap.Data.OpeningTxBroadcasted = &OpeningTxBroadcastedMessage{SwapId: swap.SwapId, TxId: "txid"}
+		swap.Data.OpeningTxHex = "txhex"
+		service.AddActiveSwap(swap.SwapId.String(), swap)
+		return service, swap
+	}
+
+	t.Run("awaiting claim payment", func(t *testing.T) {
+		service, swap := newService(State_SwapOutReceiver_AwaitClaimInvoicePayment)
+		wallet := &rebroadcastWallet{dummyChain: service.swapServices.bitcoinWallet.(*dummyChain)}
+		service.swapServices.bitcoinWallet = wallet
+
+		require.NoError(t, service.RebroadcastOpeningTx(swap.SwapId.String()))
+		assert.Equal(t, []string{"txhex"}, wallet.rebroadcasts)
+		assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, swap.Current)
+
+		wallet.err = errors.New("mempool full")
+		err := service.RebroadcastOpeningTx(swap.SwapId.String())
+		assert.ErrorIs(t, err, wallet.err)
+	})
+
+	t.Run("not broadcasted yet", func(t *testing.T) {
+		service, swap := newService(State_SwapOutReceiver_AwaitFeeInvoicePayment)
+		wallet := &rebroadcastWallet{dummyChain: service.swapServices.bitcoinWallet.(*dummyChain)}
+		service.swapServices.bitcoinWallet = wallet
+
+		err := service.RebroadcastOpeningTx(swap.SwapId.String())
+		assert.ErrorIs(t, err, ErrSwapNotRebroadcastable)
+		assert.Empty(t, wallet.rebroadcasts)
+	})
+
+	t.Run("wallet does not support rebroadcasting", func(t *testing.T) {
+		service, swap := newService(State_SwapOutReceiver_AwaitClaimInvoicePayment)
+
+		err := service.RebroadcastOpeningTx(swap.SwapId.String())
+		assert.ErrorIs(t, err, ErrRebroadcastNotSupported)
+	})
+
+	t.Run("unknown swap", func(t *testing.T) {
+		service := getTestSetup(initiator)
+		err := service.RebroadcastOpeningTx(NewSwapId().String())
+		assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+	})
+}
+
+// bumpWallet is a dummyChain that records the bumped claim transactions.
+type bumpWallet struct {
+	*dummyChain
+	replacement string
+	bumps       []string
+}
+
+func (w *bumpWallet) BumpClaimTx(claimTxId string) (string, error) {
+	w.bumps = append(w.bumps, claimTxId)
+	return w.replacement, nil
+}
+
+func Test_BumpClaimTx(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+
+	newService := func(state StateType, claimTxId string) (*SwapService, *SwapStateMachine, *bumpWallet) {
+		service := getTestSetup(initiator)
+		wallet := &bumpWallet{dummyChain: service.swapServices.bitcoinWallet.(*dummyChain)}
+		service.swapServices.bitcoinWallet = wallet
+		swap := newSwapOutSenderFSM(service.swapServices, initiator, peer)
+		swap.Current = state
+		swap.Data.SwapOutRequest = &SwapOutRequestMessage{SwapId: swap.SwapId, Network: "mainnet", Scid: channelId, Amount: 100000}
+		swap.Data.ClaimTxId = claimTxId
+		return service, swap, wallet
+	}
+
+	t.Run("claiming", func(t *testing.T) {
+		service, swap, wallet := newService(State_SwapOutSender_ClaimSwap, "claimtx")
+		service.AddActiveSwap(swap.SwapId.String(), swap)
+
+		require.NoError(t, service.BumpClaimTx(swap.SwapId.String()))
+		assert.Equal(t, []string{"claimtx"}, wallet.bumps)
+		assert.Equal(t, "claimtx", swap.Data.ClaimTxId)
+	})
+
+	t.Run("claimed and replaced", func(t *testing.T) {
+		service, swap, wallet := newService(State_ClaimedPreimage, "claimtx")
+		require.NoError(t, service.swapServices.swapStore.UpdateData(swap))
+		wallet.replacement = "replacementtx"
+
+		require.NoError(t, service.BumpClaimTx(swap.SwapId.String()))
+		assert.Equal(t, []string{"claimtx"}, wallet.bumps)
+		stored, err := service.swapServices.swapStore.GetData(swap.SwapId.String())
+		require.NoError(t, err)
+		assert.Equal(t, "replacementtx", stored.Data.ClaimTxId)
+	})
+
+	t.Run("not claiming", func(t *testing.T) {
+		service, swap, wallet := newService(State_SwapOutSender_AwaitTxConfirmation, "")
+		service.AddActiveSwap(swap.SwapId.String(), swap)
+
+		err := service.BumpClaimTx(swap.SwapId.String())
+		assert.ErrorIs(t, err, ErrSwapNotBumpable)
+		assert.Empty(t, wallet.bumps)
+	})
+
+	t.Run("wallet does not support bumping", func(t *testing.T) {
+		service, swap, _ := newService(State_SwapOutSender_ClaimSwap, "claimtx")
+		service.swapServices.bitcoinWallet = &dummyChain{}
+		service.AddActiveSwap(swap.SwapId.String(), swap)
+
+		err := service.BumpClaimTx(swap.SwapId.String())
+		assert.ErrorIs(t, err, ErrBumpNotSupported)
+	})
+}
+
+func Test_GetActiveSwaps(t *testing.T) {
+	service := getTestSetup(aliceId)
+	service.swapServices.messenger = &noopMessenger{}
+	service.swapServices.toService = &timeOutDummy{}
+
+	swap1, err := service.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+	require.NoError(t, err)
+	swap2, err := service.SwapIn(bobId, btc_chain, "100x2x4", aliceId, 100000)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []*SwapStateMachine{swap1, swap2}, service.GetActiveSwaps())
+
+	service.RemoveActiveSwap(swap1.SwapId.String())
+	assert.ElementsMatch(t, []*SwapStateMachine{swap2}, service.GetActiveSwaps())
+}
+
+func Test_ActiveSwapChannelIndex(t *testing.T) {
+	service := getTestSetup(aliceId)
+
+	newSwapOnChannel := func(channelId string) *SwapStateMachine {
+		swapId := NewSwapId()
+		return &SwapStateMachine{
+			SwapId: swapId,
+			Data: &SwapData{
+				SwapOutRequest: &SwapOutRequestMessage{SwapId: swapId, Scid: channelId},
+			},
+		}
+	}
+
+	// Add and remove a swap.
+	swap := newSwapOnChannel("1x1x1")
+	service.AddActiveSwap(swap.SwapId.String(), swap)
+	assert.True(t, hasActiveSwapOnChannel(service, "1x1x1"))
+	assert.False(t, hasActiveSwapOnChannel(service, "2x2x2"))
+
+	service.RemoveActiveSwap(swap.SwapId.String())
+	assert.False(t, hasActiveSwapOnChannel(service, "1x1x1"))
+	assert.Empty(t, service.activeSwapsByChannel)
+	assert.Empty(t, service.activeSwapChannels)
+
+	// Two swaps collide on the same channel, the channel stays occupied until
+	// both of them are removed.
+	first := newSwapOnChannel("1x1x1")
+	second := newSwapOnChannel("1x1x1")
+	service.AddActiveSwap(first.SwapId.String(), first)
+	service.AddActiveSwap(second.SwapId.String(), second)
+	assert.Equal(t, first.SwapId.String(), service.activeSwapsByChannel["1x1x1"])
+
+	service.RemoveActiveSwap(first.SwapId.String())
+	assert.True(t, hasActiveSwapOnChannel(service, "1x1x1"))
+	assert.Equal(t, second.SwapId.String(), service.activeSwapsByChannel["1x1x1"])
+
+	service.RemoveActiveSwap(second.SwapId.String())
+	assert.False(t, hasActiveSwapOnChannel(service, "1x1x1"))
+
+	// Swaps without a channel are not indexed.
+	noChannel := &SwapStateMachine{SwapId: NewSwapId(), Data: &SwapData{}}
+	service.AddActiveSwap(noChannel.SwapId.String(), noChannel)
+	assert.Empty(t, service.activeSwapsByChannel)
+	service.RemoveActiveSwap(noChannel.SwapId.String())
+}
+
+func Test_ActiveSwapOnChannel(t *testing.T) {
+	service := getTestSetup(aliceId)
+	swapId := NewSwapId()
+	swap := &SwapStateMachine{
+		SwapId: swapId,
+		Data: &SwapData{
+			SwapInRequest: &SwapInRequestMessage{SwapId: swapId, Scid: "1x1x1"},
+		},
+	}
+	service.AddActiveSwap(swapId.String(), swap)
+
+	found, ok := service.ActiveSwapOnChannel("1x1x1")
+	assert.True(t, ok)
+	assert.Equal(t, swap, found)
+
+	found, ok = service.ActiveSwapOnChannel("2x2x2")
+	assert.False(t, ok)
+	assert.Nil(t, found)
+}
+
+func Test_ConcurrentChannelSwaps(t *testing.T) {
+
+	// Only one swap per channel is allowed by default.
+	service, _ := getRecordingTestSetup(aliceId)
+	swap, err := service.SwapOut(bobId, btc_chain, "1x1x1", aliceId, 100000)
+	require.NoError(t, err)
+	_, err = service.SwapIn(bobId, btc_chain, "1x1x1", aliceId, 100000)
+	assert.Equal(t, ActiveSwapOnChannelError{ChannelId: "1x1x1", SwapId: swap.SwapId.String()}, err)
+
+	// Concurrent swaps are allowed up to the channel capacity.
+	service, _ = getRecordingTestSetup(aliceId)
+	assert.Error(t, service.swapServices.SetAllowConcurrentChannelSwaps(true, nil))
+	var capacityChannel string
+	require.NoError(t, service.swapServices.SetAllowConcurrentChannelSwaps(true, func(channelId string) (uint64, error) {
+		capacityChannel = channelId
+		return 500000, nil
+	}))
+	_, err = service.SwapOut(bobId, btc_chain, "1x1x1", aliceId, 100000)
+	require.NoError(t, err)
+	_, err = service.SwapIn(bobId, btc_chain, "1x1x1", aliceId, 150000)
+	require.NoError(t, err)
+	assert.Equal(t, "1x1x1", capacityChannel)
+	assert.Len(t, service.GetActiveSwaps(), 2)
+
+	_, err = service.SwapOut(bobId, btc_chain, "1x1x1", aliceId, 250001)
+	assert.ErrorIs(t, err, ErrChannelCapacityExceeded)
+	_, err = service.SwapOut(bobId, btc_chain, "1x1x1", aliceId, 250000)
+	require.NoError(t, err)
+	assert.Len(t, service.GetActiveSwaps(), 3)
+}
+
+func Test_CancelSwap(t *testing.T) {
+	msgChan := make(chan PeerMessage)
+	service := getTestSetup(aliceId)
+	service.swapServices.messenger = &dummyMessenger{msgChan: msgChan}
+	service.swapServices.toService = &timeOutDummy{}
+
+	swap, err := service.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+	require.NoError(t, err)
+	msg := <-msgChan
+	require.Equal(t, messages.MESSAGETYPE_SWAPOUTREQUEST, msg.MessageType())
+	require.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Current)
+
+	err = service.CancelSwap(swap.SwapId.String(), "maintenance")
+	require.NoError(t, err)
+	msg = <-msgChan
+	assert.Equal(t, messages.MESSAGETYPE_CANCELED, msg.MessageType())
+	assert.Equal(t, State_SwapCanceled, swap.Current)
+	assert.Equal(t, "maintenance", swap.Data.CancelMessage)
+
+	_, err = service.GetActiveSwap(swap.SwapId.String())
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+}
+
+func Test_CancelSwap_FundsCommitted(t *testing.T) {
+	service := getTestSetup(aliceId)
+
+	swap := newSwapOutSenderFSM(service.swapServices, aliceId, bobId)
+	swap.Current = State_SwapOutSender_AwaitTxConfirmation
+	service.AddActiveSwap(swap.SwapId.String(), swap)
+
+	err := service.CancelSwap(swap.SwapId.String(), "")
+	assert.ErrorIs(t, err, ErrSwapNotCancelable)
+	assert.Equal(t, State_SwapOutSender_AwaitTxConfirmation, swap.Current)
+
+	err = service.CancelSwap(NewSwapId().String(), "")
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+}
+
+func Test_SwapTransitions(t *testing.T) {
+	service, _ := getRecordingTestSetup(aliceId)
+
+	swap, err := service.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+	require.NoError(t, err)
+	require.NoError(t, service.CancelSwap(swap.SwapId.String(), "maintenance"))
+
+	type transition struct {
+		event    EventType
+		from, to StateType
+	}
+	expected := []transition{
+		{Event_OnSwapOutStarted, Default, State_SwapOutSender_CreateSwap},
+		{Event_ActionSucceeded, State_SwapOutSender_CreateSwap, State_SwapOutSender_SendRequest},
+		{Event_ActionSucceeded, State_SwapOutSender_SendRequest, State_SwapOutSender_AwaitAgreement},
+		{Event_OnOperatorCancel, State_SwapOutSender_AwaitAgreement, State_SendCancel},
+		{Event_ActionSucceeded, State_SendCancel, State_SwapCanceled},
+	}
+
+	got, err := service.GetSwap(swap.SwapId.String())
+	require.NoError(t, err)
+	require.Len(t, got.Data.Transitions, len(expected))
+	for i, tr := range got.Data.Transitions {
+		assert.Equal(t, expected[i], transition{tr.Event, tr.From, tr.To})
+		assert.False(t, tr.Time.IsZero())
+		if i > 0 {
+			assert.False(t, tr.Time.Before(got.Data.Transitions[i-1].Time))
+		}
+	}
+
+	// Only the latest transitions are kept.
+	assert.Error(t, service.swapServices.SetMaxTransitions(-1))
+	require.NoError(t, service.swapServices.SetMaxTransitions(2))
+	swap, err = service.SwapOut(bobId, btc_chain, "100x2x4", aliceId, 100000)
+	require.NoError(t, err)
+	require.Len(t, swap.Data.Transitions, 2)
+	assert.Equal(t, State_SwapOutSender_CreateSwap, swap.Data.Transitions[0].From)
+	assert.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Data.Transitions[1].To)
+
+	// Failed actions record their error.
+	swap.Data.LastErr = errors.New("action failed")
+	swap.recordTransition(Event_ActionFailed)
+	assert.Equal(t, "action failed", swap.Data.Transitions[1].Err)
+}
+
+func Test_SwapTimestamps(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service, _ := getRecordingTestSetup(aliceId)
+	service.swapServices.swapStore = store
+
+	before := time.Now().Unix()
+	swap, err := service.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, swap.Data.CreatedAt, before)
+	assert.GreaterOrEqual(t, swap.Data.UpdatedAt, swap.Data.CreatedAt)
+
+	// Pretend that the swap was last updated a while ago.
+	swap.Data.CreatedAt -= 100
+	swap.Data.UpdatedAt = swap.Data.CreatedAt
+	createdAt := swap.Data.CreatedAt
+
+	require.NoError(t, service.CancelSwap(swap.SwapId.String(), ""))
+	stored, err := service.GetSwap(swap.SwapId.String())
+	require.NoError(t, err)
+	assert.Equal(t, createdAt, stored.Data.CreatedAt)
+	assert.GreaterOrEqual(t, stored.Data.UpdatedAt, before)
+
+	swaps, err := service.ListSwaps()
+	require.NoError(t, err)
+	require.Len(t, swaps, 1)
+	assert.Equal(t, createdAt, swaps[0].Data.CreatedAt)
+	assert.Equal(t, stored.Data.UpdatedAt, swaps[0].Data.UpdatedAt)
+	assert.Less(t, swaps[0].Data.CreatedAt, swaps[0].Data.UpdatedAt)
+}
+
+func Test_CancelAllActive(t *testing.T) {
+	service, messenger := getRecordingTestSetup(aliceId)
+
+	first, err := service.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+	require.NoError(t, err)
+	second, err := service.SwapOut(bobId, btc_chain, "100x2x4", aliceId, 100000)
+	require.NoError(t, err)
+	committed := newSwapOutSenderFSM(service.swapServices, aliceId, bobId)
+	committed.Current = State_SwapOutSender_AwaitTxConfirmation
+	service.AddActiveSwap(committed.SwapId.String(), committed)
+	sent := len(messenger.sent)
+
+	cancelled, skipped, err := service.CancelAllActive("upgrade")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{first.SwapId.String(), second.SwapId.String()}, cancelled)
+	assert.Equal(t, []string{committed.SwapId.String()}, skipped)
+
+	for _, swap := range []*SwapStateMachine{first, second} {
+		assert.Equal(t, State_SwapCanceled, swap.Current)
+		assert.Equal(t, "upgrade", swap.Data.CancelMessage)
+	}
+	require.Len(t, messenger.sent, sent+2)
+	for _, msg := range messenger.sent[sent:] {
+		assert.Equal(t, int(messages.MESSAGETYPE_CANCELED), msg.msgType)
+	}
+
+	// The swap with committed funds is still active.
+	assert.Equal(t, State_SwapOutSender_AwaitTxConfirmation, committed.Current)
+	active := service.GetActiveSwaps()
+	require.Len(t, active, 1)
+	assert.Equal(t, committed, active[0])
+}
+
+func Test_StateTimeout_Fires(t *testing.T) {
+	service := getTestSetup(aliceId)
+	service.swapServices.messenger = &noopMessenger{}
+	service.swapServices.toService = newTimeOutService(service.createTimeoutCallback)
+	service.swapServices.SetStateTimeout(State_SwapOutSender_AwaitAgreement, 10*time.Millisecond)
+
+	swap, err := service.SwapOut(bobId, btc_chain, "1x1x1", aliceId, 100000)
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		_, err := service.GetActiveSwap(swap.SwapId.String())
+		return err == ErrSwapDoesNotExist
+	}, time.Second, 10*time.Millisecond)
+	swap.mutex.Lock()
+	defer swap.mutex.Unlock()
+	assert.Equal(t, State_SwapCanceled, swap.Current)
+}
+
+func Test_StateTimeout_CanceledOnAdvance(t *testing.T) {
+	_, peer, _, _, _ := getTestParams()
+	service := getTestSetup(aliceId)
+	service.swapServices.messenger = &noopMessenger{}
+	// Record the timeouts that reach the service instead of sending
+	// Event_OnTimeout.
+	fired := make(chan string, 10)
+	service.swapServices.toService = newTimeOutService(func(swapId string) func() {
+		return func() {
+			fired <- swapId
+		}
+	})
+	service.swapServices.SetStateTimeout(State_SwapOutSender_AwaitAgreement, 50*time.Millisecond)
+
+	advanced, err := service.SwapOut(peer, btc_chain, "1x1x1", aliceId, 100000)
+	require.NoError(t, err)
+	waiting, err := service.SwapOut(peer, btc_chain, "1x1x2", aliceId, 100000)
+	require.NoError(t, err)
+
+	// Advance the first swap before its timeout.
+	err = service.OnSwapOutAgreementReceived(&SwapOutAgreementMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          advanced.SwapId,
+		Pubkey:          peer,
+		Payreq:          "fee",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, State_SwapOutSender_AwaitTxBroadcastedMessage, advanced.Current)
+
+	// Only the timeout of the swap that did not advance fires.
+	select {
+	case swapId := <-fired:
+		assert.Equal(t, waiting.SwapId.String(), swapId)
+	case <-time.After(time.Second):
+		t.Fatal("timeout did not fire")
+	}
+	select {
+	case swapId := <-fired:
+		t.Fatalf("unexpected timeout of swap %s", swapId)
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.Equal(t, State_SwapOutSender_AwaitTxBroadcastedMessage, advanced.Current)
+}
+
+func Test_Subscribe(t *testing.T) {
+	service := getTestSetup(aliceId)
+	service.swapServices.messenger = &noopMessenger{}
+	service.swapServices.toService = &timeOutDummy{}
+
+	events, unsubscribe := service.Subscribe()
+
+	swap, err := service.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+	require.NoError(t, err)
+	err = service.CancelSwap(swap.SwapId.String(), "")
+	require.NoError(t, err)
+
+	var received []SwapEvent
+	for len(events) > 0 {
+		received = append(received, <-events)
+	}
+	require.NotEmpty(t, received)
+	for _, event := range received {
+		assert.Equal(t, swap.SwapId.String(), event.SwapId)
+		assert.Equal(t, SWAPTYPE_OUT, event.Type)
+		assert.Equal(t, SWAPROLE_SENDER, event.Role)
+		assert.Equal(t, bobId, event.PeerNodeId)
+	}
+
+	first, last := received[0], received[len(received)-1]
+	assert.Equal(t, SwapEventAdded, first.Kind)
+	assert.Equal(t, SwapEventTransition, received[1].Kind)
+	assert.Equal(t, State_SwapOutSender_CreateSwap, received[1].NewState)
+	assert.Equal(t, SwapEventRemoved, last.Kind)
+	assert.Equal(t, State_SwapCanceled, last.NewState)
+
+	unsubscribe()
+	_, ok := <-events
+	assert.False(t, ok, "expected channel to be closed")
+	// Calling unsubscribe twice must not panic.
+	unsubscribe()
+
+	// Publishing events after unsubscribing must not panic.
+	_, err = service.SwapOut(bobId, btc_chain, "100x2x4", aliceId, 100000)
+	require.NoError(t, err)
+}
+
+func Test_Subscribe_SlowConsumer(t *testing.T) {
+	service := getTestSetup(aliceId)
+	logger := &testLogger{}
+	service.swapServices.SetLogger(logger)
+
+	_, unsubscribe := service.Subscribe()
+	defer unsubscribe()
+	fast, unsubscribeFast := service.Subscribe()
+	defer unsubscribeFast()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < swapEventBufferSize+1; i++ {
+			swap := &SwapStateMachine{SwapId: NewSwapId(), Data: &SwapData{}}
+			service.AddActiveSwap(swap.SwapId.String(), swap)
+			<-fast
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow subscriber blocked the swap service")
+	}
+
+	logger.Lock()
+	defer logger.Unlock()
+	require.Len(t, logger.lines[logLevelWarn], 1)
+	assert.Contains(t, logger.lines[logLevelWarn][0], "slow subscriber")
+}
+
+func Test_WaitForSwap(t *testing.T) {
+	t.Run("completed", func(t *testing.T) {
+		initiator, peer, _, _, channelId := getTestParams()
+		alice, bob, aliceMsgChan, bobMsgChan := splitTestSetup(t, initiator, peer)
+
+		swap, err := alice.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+
+		done := make(chan *SwapStateMachine, 1)
+		go func() {
+			finished, err := alice.WaitForSwap(context.Background(), swap.SwapId.String())
+			assert.NoError(t, err)
+			done <- finished
+		}()
+
+		completeSwapOut(t, alice, bob, aliceMsgChan, bobMsgChan)
+		finished := <-done
+		assert.Equal(t, swap.SwapId, finished.SwapId)
+		assert.Equal(t, State_ClaimedPreimage, finished.Current)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		service := getTestSetup(aliceId)
+		service.swapServices.messenger = &noopMessenger{}
+		service.swapServices.toService = &timeOutDummy{}
+		swap, err := service.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+		require.NoError(t, err)
+
+		done := make(chan *SwapStateMachine, 1)
+		go func() {
+			finished, err := service.WaitForSwap(context.Background(), swap.SwapId.String())
+			assert.NoError(t, err)
+			done <- finished
+		}()
+
+		require.NoError(t, service.CancelSwap(swap.SwapId.String(), ""))
+		assert.Equal(t, State_SwapCanceled, (<-done).Current)
+
+		// A finished swap is returned right away.
+		finished, err := service.WaitForSwap(context.Background(), swap.SwapId.String())
+		require.NoError(t, err)
+		assert.Equal(t, State_SwapCanceled, finished.Current)
+	})
+
+	t.Run("removed event dropped", func(t *testing.T) {
+		service := getTestSetup(aliceId)
+		service.swapServices.messenger = &noopMessenger{}
+		service.swapServices.toService = &timeOutDummy{}
+		swap, err := service.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+		require.NoError(t, err)
+
+		done := make(chan *SwapStateMachine, 1)
+		go func() {
+			finished, err := service.WaitForSwap(context.Background(), swap.SwapId.String())
+			assert.NoError(t, err)
+			done <- finished
+		}()
+
+		// The swap is removed without the removed event.
+		swap.mutex.Lock()
+		swap.Current = State_SwapCanceled
+		require.NoError(t, service.swapServices.swapStore.UpdateData(swap))
+		swap.mutex.Unlock()
+		service.Lock()
+		delete(service.activeSwaps, swap.SwapId.String())
+		service.Unlock()
+
+		select {
+		case finished := <-done:
+			assert.Equal(t, State_SwapCanceled, finished.Current)
+		case <-time.After(3 * swapRemovedPollInterval):
+			t.Fatal("removal of the swap was not noticed")
+		}
+	})
+
+	t.Run("context timeout", func(t *testing.T) {
+		service := getTestSetup(aliceId)
+		service.swapServices.messenger = &noopMessenger{}
+		service.swapServices.toService = &timeOutDummy{}
+		swap, err := service.SwapOut(bobId, btc_chain, "100x2x3", aliceId, 100000)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = service.WaitForSwap(ctx, swap.SwapId.String())
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, State_SwapOutSender_AwaitAgreement, swap.Current)
+	})
+}
+
+func Test_OnPayment_MalformedSwapId(t *testing.T) {
+	service := getTestSetup(aliceId)
+	logger := &testLogger{}
+	service.swapServices.SetLogger(logger)
+
+	// Invoice descriptions instead of swap ids are rejected.
+	id := NewSwapId().String()
+	for _, swapId := range []string{"", "fee", "fee_", "fee_" + id, "claim", "claim_", "claim_" + id, "some random label"} {
+		assert.NotPanics(t, func() { service.OnPayment(swapId, INVOICE_FEE) })
+		assert.NotPanics(t, func() { service.OnPayment(swapId, INVOICE_CLAIM) })
+	}
+	assert.Len(t, logger.lines[logLevelWarn], 16)
+}
+
+func Test_SwapContext_Canceled(t *testing.T) {
+	service := getTestSetup(aliceId)
+	wallet := &blockingWallet{dummyChain: &dummyChain{}, unblock: make(chan struct{})}
+	defer close(wallet.unblock)
+	service.swapServices.bitcoinWallet = wallet
+	service.swapServices.liquidWallet = wallet
+
+	for _, chain := range []string{btc_chain, l_btc_chain} {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		_, err := service.SwapOutContext(ctx, bobId, chain, "1x1x1", aliceId, 100000)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		_, err = service.SwapInContext(ctx, bobId, chain, "1x1x1", aliceId, 100000)
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+
+	assert.Empty(t, service.GetActiveSwaps())
+}
+
+// blockingWallet blocks on GetAsset and GetNetwork until unblock is closed.
+type blockingWallet struct {
+	*dummyChain
+	unblock chan struct{}
+}
+
+func (w *blockingWallet) GetAsset() string {
+	<-w.unblock
+	return w.dummyChain.GetAsset()
+}
+
+func (w *blockingWallet) GetNetwork() string {
+	<-w.unblock
+	return w.dummyChain.GetNetwork()
+}
+
+func Test_AllowedAssets(t *testing.T) {
+	service := getTestSetup(aliceId)
+	service.swapServices.messenger = &noopMessenger{}
+	service.swapServices.toService = &timeOutDummy{}
+
+	err := service.swapServices.SetAllowedAssets(nil)
+	assert.Error(t, err)
+
+	err = service.swapServices.SetAllowedAssets([]string{l_btc_chain})
+	require.NoError(t, err)
+
+	_, err = service.SwapOut(bobId, btc_chain, "1x1x1", aliceId, 100000)
+	assert.Equal(t, WrongAssetError(btc_chain), err)
+	_, err = service.SwapIn(bobId, btc_chain, "1x1x1", aliceId, 100000)
+	assert.Equal(t, WrongAssetError(btc_chain), err)
+	assert.Empty(t, service.GetActiveSwaps())
+
+	_, _, _, err = service.swapServices.getOnChainServices(btc_chain)
+	assert.Equal(t, WrongAssetError(btc_chain), err)
+	_, _, _, err = service.swapServices.getOnChainServices(l_btc_chain)
+	assert.NoError(t, err)
+}
+
+func Test_SwapAmountLimits(t *testing.T) {
+	services := &SwapServices{}
+	assert.Error(t, services.SetSwapAmountLimits(200000, 100000))
+
+	// Zero disables the limits.
+	require.NoError(t, services.SetSwapAmountLimits(0, 0))
+	assert.NoError(t, services.checkSwapAmount(0))
+	assert.NoError(t, services.checkSwapAmount(math.MaxUint64))
+
+	require.NoError(t, services.SetSwapAmountLimits(100000, 200000))
+	assert.Equal(t, ErrMinimumSwapSize(100000*1000), services.checkSwapAmount(99999))
+	assert.NoError(t, services.checkSwapAmount(100000))
+	assert.NoError(t, services.checkSwapAmount(200000))
+	assert.Equal(t, ErrMaximumSwapSize(200000*1000), services.checkSwapAmount(200001))
+
+	// A single limit can be disabled.
+	require.NoError(t, services.SetSwapAmountLimits(100000, 0))
+	assert.NoError(t, services.checkSwapAmount(math.MaxUint64))
+	require.NoError(t, services.SetSwapAmountLimits(0, 200000))
+	assert.NoError(t, services.checkSwapAmount(1))
+
+	service := getTestSetup(aliceId)
+	require.NoError(t, service.swapServices.SetSwapAmountLimits(100000, 200000))
+	_, err := service.SwapOut(bobId, btc_chain, "1x1x1", aliceId, 200001)
+	assert.Equal(t, ErrMaximumSwapSize(200000*1000), err)
+	_, err = service.SwapIn(bobId, btc_chain, "1x1x1", aliceId, 99999)
+	assert.Equal(t, ErrMinimumSwapSize(100000*1000), err)
+	assert.Empty(t, service.GetActiveSwaps())
+}
+
+func Test_QuoteSwapOut(t *testing.T) {
+	amount := uint64(100000)
+	initiator, peer, _, _, channelId := getTestParams()
+
+	aliceSwapService := getTestSetup(initiator)
+	bobSwapService := getTestSetup(peer)
+	aliceSwapService.swapServices.messenger.(*ConnectedMessenger).other = bobSwapService.swapServices.messenger.(*ConnectedMessenger)
+	bobSwapService.swapServices.messenger.(*ConnectedMessenger).other = aliceSwapService.swapServices.messenger.(*ConnectedMessenger)
+
+	aliceSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan = make(chan messages.MessageType)
+	bobSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan = make(chan messages.MessageType)
+
+	aliceMsgChan := aliceSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan
+	bobMsgChan := bobSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan
+
+	aliceSwapService.swapServices.SetMaxPremium(50)
+	bobSwapService.swapServices.SetDefaultPremium(50)
+	aliceSwapService.swapServices.lightning = &feeInvoiceLightningClient{aliceSwapService.swapServices.lightning.(*dummyLightningClient)}
+	bobSwapService.swapServices.lightning = &feeInvoiceLightningClient{bobSwapService.swapServices.lightning.(*dummyLightningClient)}
+
+	require.NoError(t, aliceSwapService.Start())
+	require.NoError(t, bobSwapService.Start())
+
+	quote, err := aliceSwapService.QuoteSwapOut(peer, btc_chain, channelId, amount)
+	require.NoError(t, err)
+	assert.Equal(t, &SwapQuote{
+		Type:            SWAPTYPE_OUT,
+		Chain:           btc_chain,
+		AmountSat:       amount,
+		OpeningTxFeeSat: 100,
+		PremiumSat:      50,
+		TotalCostSat:    150,
+	}, quote)
+	assert.Empty(t, aliceSwapService.GetActiveSwaps())
+
+	aliceSwap, err := aliceSwapService.SwapOut(peer, btc_chain, channelId, initiator, amount)
+	require.NoError(t, err)
+
+	// The first message bob receives is the request of the swap, nothing was
+	// sent for the quote.
+	assert.Equal(t, messages.MESSAGETYPE_SWAPOUTREQUEST, <-bobMsgChan)
+	assert.Equal(t, messages.MESSAGETYPE_SWAPOUTAGREEMENT, <-aliceMsgChan)
+	assert.Equal(t, State_SwapOutSender_AwaitTxBroadcastedMessage, aliceSwap.Current)
+	assert.Equal(t, quote.PremiumSat, aliceSwap.Data.GetPremium())
+	assert.Equal(t, quote.OpeningTxFeeSat, aliceSwap.Data.SwapOutAgreement.FeeBreakdown.OpeningTxFee)
+	assert.Equal(t, quote.TotalCostSat, aliceSwap.Data.Cost.FeeInvoiceSat)
+}
+
+func Test_QuoteSwapIn(t *testing.T) {
+	amount := uint64(100000)
+	initiator, peer, _, _, channelId := getTestParams()
+
+	aliceSwapService := getTestSetup(initiator)
+	bobSwapService := getTestSetup(peer)
+	aliceSwapService.swapServices.messenger.(*ConnectedMessenger).other = bobSwapService.swapServices.messenger.(*ConnectedMessenger)
+	bobSwapService.swapServices.messenger.(*ConnectedMessenger).other = aliceSwapService.swapServices.messenger.(*ConnectedMessenger)
+
+	aliceSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan = make(chan messages.MessageType)
+	bobSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan = make(chan messages.MessageType)
+
+	aliceMsgChan := aliceSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan
+	bobMsgChan := bobSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan
+
+	aliceSwapService.swapServices.SetMaxPremium(50)
+	bobSwapService.swapServices.SetDefaultPremium(50)
+
+	require.NoError(t, aliceSwapService.Start())
+	require.NoError(t, bobSwapService.Start())
+
+	quote, err := aliceSwapService.QuoteSwapIn(peer, btc_chain, channelId, amount)
+	require.NoError(t, err)
+	assert.Equal(t, &SwapQuote{
+		Type:            SWAPTYPE_IN,
+		Chain:           btc_chain,
+		AmountSat:       amount,
+		OpeningTxFeeSat: 100,
+		PremiumSat:      50,
+		TotalCostSat:    150,
+	}, quote)
+	assert.Empty(t, aliceSwapService.GetActiveSwaps())
+
+	aliceSwap, err := aliceSwapService.SwapIn(peer, btc_chain, channelId, initiator, amount)
+	require.NoError(t, err)
+
+	assert.Equal(t, messages.MESSAGETYPE_SWAPINREQUEST, <-bobMsgChan)
+	assert.Equal(t, messages.MESSAGETYPE_SWAPINAGREEMENT, <-aliceMsgChan)
+	assert.Equal(t, quote.PremiumSat, aliceSwap.Data.GetPremium())
+
+	// The quote fails for the same reasons as the swap.
+	_, err = aliceSwapService.QuoteSwapIn(peer, btc_chain, channelId, amount)
+	assert.Equal(t, ActiveSwapOnChannelError{ChannelId: channelId, SwapId: aliceSwap.SwapId.String()}, err)
+	_, err = aliceSwapService.QuoteSwapIn(peer, "doge", "1x1x1", amount)
+	assert.Equal(t, WrongAssetError("doge"), err)
+}
+
+// Test_SwapIn_Premium runs a swap in with a premium to the end. The premium is
+// deducted from the claim invoice that the receiver pays.
+func Test_SwapIn_Premium(t *testing.T) {
+	amount := uint64(100000)
+	premium := uint64(50)
+	initiator, peer, _, _, channelId := getTestParams()
+
+	aliceSwapService := getTestSetup(initiator)
+	bobSwapService := getTestSetup(peer)
+	aliceSwapService.swapServices.messenger.(*ConnectedMessenger).other = bobSwapService.swapServices.messenger.(*ConnectedMessenger)
+	bobSwapService.swapServices.messenger.(*ConnectedMessenger).other = aliceSwapService.swapServices.messenger.(*ConnectedMessenger)
+
+	aliceSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan = make(chan messages.MessageType)
+	bobSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan = make(chan messages.MessageType)
+
+	aliceMsgChan := aliceSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan
+	bobMsgChan := bobSwapService.swapServices.messenger.(*ConnectedMessenger).msgReceivedChan
+
+	aliceSwapService.swapServices.SetMaxPremium(premium)
+	bobSwapService.swapServices.SetDefaultPremium(premium)
+
+	require.NoError(t, aliceSwapService.Start())
+	require.NoError(t, bobSwapService.Start())
+
+	aliceSwap, err := aliceSwapService.SwapIn(peer, btc_chain, channelId, initiator, amount)
+	require.NoError(t, err)
+	swapId := aliceSwap.SwapId.String()
+
+	assert.Equal(t, messages.MESSAGETYPE_SWAPINREQUEST, <-bobMsgChan)
+	bobSwap, err := bobSwapService.GetActiveSwap(swapId)
+	require.NoError(t, err)
+	assert.Equal(t, messages.MESSAGETYPE_SWAPINAGREEMENT, <-aliceMsgChan)
+	assert.Equal(t, messages.MESSAGETYPE_OPENINGTXBROADCASTED, <-bobMsgChan)
+	require.Equal(t, State_SwapInReceiver_AwaitTxConfirmation, bobSwap.Current)
+	assert.Equal(t, amount-premium, bobSwap.Data.Cost.ClaimInvoiceSat)
+
+	err = bobSwapService.swapServices.bitcoinTxWatcher.(*dummyChain).txConfirmedFunc(swapId, aliceSwap.Data.OpeningTxHex)
+	require.NoError(t, err)
+	require.Equal(t, State_ClaimedPreimage, bobSwap.Current)
+
+	aliceSwapService.swapServices.lightning.(*dummyLightningClient).TriggerPayment(swapId, INVOICE_CLAIM)
+	require.Equal(t, State_ClaimedPreimage, aliceSwap.Current)
+	assert.Equal(t, amount-premium, aliceSwap.Data.Cost.ClaimInvoiceSat)
+}
+
+func Test_SwapOut_PeerNotConnected(t *testing.T) {
+	amount := uint64(100000)
+	initiator, peer, _, _, channelId := getTestParams()
+
+	service := getTestSetup(initiator)
+	messenger := &connectionMessenger{}
+	service.swapServices.messenger = messenger
+	require.NoError(t, service.Start())
+	service.swapServices.toService = &timeOutDummy{}
+
+	// The swaps are rejected before they are started.
+	_, err := service.SwapOut(peer, btc_chain, channelId, initiator, amount)
+	assert.ErrorIs(t, err, ErrPeerNotConnected)
+	_, err = service.SwapIn(peer, btc_chain, channelId, initiator, amount)
+	assert.ErrorIs(t, err, ErrPeerNotConnected)
+	assert.Empty(t, service.GetActiveSwaps())
+	assert.Empty(t, messenger.sent)
+
+	messenger.connected = true
+	_, err = service.SwapOut(peer, btc_chain, channelId, initiator, amount)
+	require.NoError(t, err)
+	assert.Len(t, messenger.sent, 1)
+
+	// Swaps are started optimistically if the check is disabled.
+	messenger.connected = false
+	service.swapServices.SetCheckPeerConnection(false)
+	_, err = service.SwapOut(peer, btc_chain, "100x2x4", initiator, amount)
+	require.NoError(t, err)
+	assert.Len(t, messenger.sent, 2)
+}
+
+func Test_SwapRequest_RateLimited(t *testing.T) {
+	service, messenger := getRecordingTestSetup(aliceId)
+	require.NoError(t, service.swapServices.SetRequestRateLimit(2, time.Hour))
+
+	// Burst requests from bob. The requests are invalid and never become
+	// active, but count against the rate limit.
+	for i := 0; i < 2; i++ {
+		err := service.OnSwapOutRequestReceived(NewSwapId(), bobId, &SwapOutRequestMessage{Scid: fmt.Sprintf("1x1x%d", i)})
+		assert.NotEqual(t, PeerRateLimitedError(bobId), err)
+	}
+
+	swapId := NewSwapId()
+	err := service.OnSwapInRequestReceived(swapId, bobId, &SwapInRequestMessage{Scid: "1x1x3"})
+	assert.Equal(t, PeerRateLimitedError(bobId), err)
+	_, ok := service.ActiveSwapOnChannel("1x1x3")
+	assert.False(t, ok)
+
+	messenger.Lock()
+	require.NotEmpty(t, messenger.sent)
+	last := messenger.sent[len(messenger.sent)-1]
+	messenger.Unlock()
+	assert.Equal(t, bobId, last.peerId)
+	assert.Equal(t, int(messages.MESSAGETYPE_CANCELED), last.msgType)
+	var cancelMsg CancelMessage
+	require.NoError(t, json.Unmarshal(last.payload, &cancelMsg))
+	assert.Equal(t, swapId.String(), cancelMsg.SwapId.String())
+	assert.Equal(t, PeerRateLimitedError(bobId).Error(), cancelMsg.Message)
+
+	// Other peers are not affected.
+	err = service.OnSwapOutRequestReceived(NewSwapId(), charlieId, &SwapOutRequestMessage{Scid: "1x1x4"})
+	assert.NotEqual(t, PeerRateLimitedError(charlieId), err)
+}
+
+// setFilePolicy replaces the policy of the service with a policy that is
+// backed by an empty policy file, so that its allowlist can be edited.
+func setFilePolicy(t *testing.T, service *SwapService) *policy.Policy {
+	p, err := policy.CreateFromFile(filepath.Join(t.TempDir(), "policy.conf"))
+	require.NoError(t, err)
+	service.swapServices.policy = p
+	return p
+}
+
+func Test_ReloadAllowlist(t *testing.T) {
+	service, _ := getRecordingTestSetup(aliceId)
+
+	assert.ErrorIs(t, service.ReloadAllowlist(nil), ErrAllowlistNotEditable)
+	p := setFilePolicy(t, service)
+
+	_, bob, takerPubkey, _, _ := getTestParams()
+	newRequest := func(swapId *SwapId, scid string) *SwapOutRequestMessage {
+		return &SwapOutRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            scid,
+			Amount:          100000,
+			Pubkey:          takerPubkey,
+		}
+	}
+
+	assert.Error(t, service.ReloadAllowlist([]string{"bob"}))
+	require.NoError(t, service.ReloadAllowlist([]string{bob}))
+	assert.Equal(t, []string{bob}, p.GetPeerAllowlist())
+
+	swapId := NewSwapId()
+	err := service.OnSwapOutRequestReceived(swapId, bob, newRequest(swapId, "1x1x1"))
+	require.NoError(t, err)
+	swap, err := service.GetActiveSwap(swapId.String())
+	require.NoError(t, err)
+	require.Equal(t, State_SwapOutReceiver_AwaitFeeInvoicePayment, swap.Current)
+
+	// Remove bob from the allowlist.
+	require.NoError(t, service.ReloadAllowlist([]string{}))
+	assert.Empty(t, p.GetPeerAllowlist())
+
+	newSwapId := NewSwapId()
+	err = service.OnSwapOutRequestReceived(newSwapId, bob, newRequest(newSwapId, "2x2x2"))
+	assert.Equal(t, PeerNotAllowedError(bob), err)
+	_, err = service.GetActiveSwap(newSwapId.String())
+	assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+
+	// The swap that is already active proceeds.
+	err = service.OnFeeInvoiceNotification(swapId)
+	require.NoError(t, err)
+	assert.Equal(t, State_SwapOutReceiver_AwaitClaimInvoicePayment, swap.Current)
+
+	// Adding bob again accepts him again.
+	require.NoError(t, service.ReloadAllowlist([]string{bob}))
+	assert.True(t, service.isPeerOnAllowlist(bob))
+}
+
+func Test_ReloadBlocklist(t *testing.T) {
+	service, messenger := getRecordingTestSetup(aliceId)
+
+	_, bob, takerPubkey, _, _ := getTestParams()
+	_, carol, _, _, _ := getTestParams()
+	assert.Error(t, service.ReloadBlocklist([]string{"bob"}))
+	setFilePolicy(t, service)
+
+	// Bob is on the allowlist and on the blocklist, the block wins.
+	require.NoError(t, service.ReloadAllowlist([]string{bob, carol}))
+	require.NoError(t, service.ReloadBlocklist([]string{bob}))
+
+	swapId := NewSwapId()
+	err := service.OnSwapOutRequestReceived(swapId, bob, &SwapOutRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            "1x1x1",
+		Amount:          100000,
+		Pubkey:          takerPubkey,
+	})
+	assert.Equal(t, PeerNotAllowedError(bob), err)
+	msg := lastCancelMessage(t, messenger)
+	assert.Equal(t, swapId, msg.SwapId)
+	assert.Equal(t, CancelReasonPeerNotAllowed, msg.Reason)
+
+	swapId = NewSwapId()
+	err = service.OnSwapInRequestReceived(swapId, bob, &SwapInRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            "1x1x1",
+		Amount:          100000,
+		Pubkey:          takerPubkey,
+	})
+	assert.Equal(t, PeerNotAllowedError(bob), err)
+	assert.Equal(t, swapId, lastCancelMessage(t, messenger).SwapId)
+
+	// We do not start swaps with bob either.
+	_, err = service.SwapOut(bob, btc_chain, "1x1x1", aliceId, 100000)
+	assert.ErrorIs(t, err, ErrPeerNotAllowed)
+	_, err = service.SwapIn(bob, btc_chain, "1x1x1", aliceId, 100000)
+	assert.ErrorIs(t, err, ErrPeerNotAllowed)
+
+	// Carol is not blocked.
+	swapId = NewSwapId()
+	require.NoError(t, service.OnSwapOutRequestReceived(swapId, carol, &SwapOutRequestMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		Scid:            "2x2x2",
+		Amount:          100000,
+		Pubkey:          takerPubkey,
+	}))
+
+	// Unblocking bob defers to the allowlist again.
+	require.NoError(t, service.ReloadBlocklist(nil))
+	assert.False(t, service.isPeerBlocked(bob))
+	_, err = service.SwapOut(bob, btc_chain, "1x1x1", aliceId, 100000)
+	assert.NoError(t, err)
+}
+
+// recordingMessenger records all messages that are sent.
+type recordingMessenger struct {
+	sync.Mutex
+	sent []recordedMessage
+}
+
+type recordedMessage struct {
+	peerId  string
+	msgType int
+	payload []byte
+}
+
+func (r *recordingMessenger) SendMessage(peerId string, message []byte, messageType int) error {
+	r.Lock()
+	defer r.Unlock()
+	r.sent = append(r.sent, recordedMessage{peerId: peerId, msgType: messageType, payload: message})
+	return nil
+}
+
+func (r *recordingMessenger) AddMessageHandler(func(peerId string, msgType string, payload []byte) error) {
+}
+
+// heightChain is a dummyChain at the given block height.
+type heightChain struct {
+	*dummyChain
+	height uint32
+}
+
+func (h *heightChain) GetBlockHeight() (uint32, error) {
+	return h.height, nil
+}
+
+// openingFeeWallet is a dummyChain that creates opening transactions with
+// the given fee and reports the given fee for spending transactions.
+type openingFeeWallet struct {
+	*dummyChain
+	fee         uint64
+	spendingFee uint64
+}
+
+func (o *openingFeeWallet) CreateOpeningTransaction(swapParams *OpeningParams) (string, uint64, uint32, error) {
+	txHex, _, vout, err := o.dummyChain.CreateOpeningTransaction(swapParams)
+	return txHex, o.fee, vout, err
+}
+
+func (o *openingFeeWallet) GetSpendingTxFee(openingTxHex, spendingTxHex string) (uint64, error) {
+	return o.spendingFee, nil
+}
+
+// stubFeeEstimator returns the given estimations and records the calls.
+type stubFeeEstimator struct {
+	openingFee uint64
+	feeRate    float64
+	calls      []string
+}
+
+func (f *stubFeeEstimator) EstimateOpeningTxFee(chain string, amount uint64) (uint64, error) {
+	f.calls = append(f.calls, fmt.Sprintf("opening %s %d", chain, amount))
+	return f.openingFee, nil
+}
+
+func (f *stubFeeEstimator) EstimateFeeRate(chain string) (float64, error) {
+	f.calls = append(f.calls, fmt.Sprintf("rate %s", chain))
+	return f.feeRate, nil
+}
+
+// barrierFeeEstimator blocks the fee rate estimations until n estimations
+// were requested.
+type barrierFeeEstimator struct {
+	sync.Mutex
+	n        int
+	calls    int
+	released chan struct{}
+}
+
+func (f *barrierFeeEstimator) EstimateOpeningTxFee(chain string, amount uint64) (uint64, error) {
+	return 0, nil
+}
+
+func (f *barrierFeeEstimator) EstimateFeeRate(chain string) (float64, error) {
+	f.Lock()
+	f.calls++
+	if f.calls == f.n {
+		close(f.released)
+	}
+	f.Unlock()
+	<-f.released
+	return 1, nil
+}
+
+// nodeIdLightningClient is a dummyLightningClient that reports the given
+// local node id.
+type nodeIdLightningClient struct {
+	*dummyLightningClient
+	nodeId string
+}
+
+func (n *nodeIdLightningClient) GetNodeId() string {
+	return n.nodeId
+}
+
+// feeRateWallet is a dummyChain that estimates the given fee rate.
+type feeRateWallet struct {
+	*dummyChain
+	feeRate float64
+}
+
+func (f *feeRateWallet) GetFeeRate() (float64, error) {
+	return f.feeRate, nil
+}
+
+// connectionMessenger is a recordingMessenger that reports whether the peer
+// is connected.
+type connectionMessenger struct {
+	recordingMessenger
+	connected bool
+}
+
+func (c *connectionMessenger) IsPeerConnected(peerId string) (bool, error) {
+	return c.connected, nil
+}
+
+func hasActiveSwapOnChannel(service *SwapService, channelId string) bool {
+	_, ok := service.ActiveSwapOnChannel(channelId)
+	return ok
+}
+
+func getTestSetup(name string) *SwapService {
+	store := &dummyStore{dataMap: map[string]*SwapStateMachine{}}
+	reqSwapsStore := &requestedSwapsStoreMock{data: map[string][]RequestedSwap{}}
+	messenger := &ConnectedMessenger{
+		thisPeerId: name,
+	}
+	mmgr := &MessengerManagerStub{}
+	lc := &dummyLightningClient{preimage: ""}
+	policy := &dummyPolicy{
+		getMinSwapAmountMsatReturn: policy.DefaultPolicy().MinSwapAmountMsat,
+		newSwapsAllowedReturn:      policy.DefaultPolicy().AllowNewSwaps,
+	}
+	chain := &dummyChain{returnGetCSVHeight: 1008}
+	chain.SetBalance(10000000)
+	swapServices := NewSwapServices(store, reqSwapsStore, lc, messenger, mmgr, policy, true, chain, chain, chain, true, chain, chain, chain)
+	swapService := NewSwapService(swapServices)
+	return swapService
+}
+
+// getRecordingTestSetup returns a test setup whose messages to the peers are
+// recorded by the returned messenger and whose timeouts never fire.
+func getRecordingTestSetup(name string) (*SwapService, *recordingMessenger) {
+	service := getTestSetup(name)
+	messenger := &recordingMessenger{}
+	service.swapServices.messenger = messenger
+	service.swapServices.toService = &timeOutDummy{}
+	return service, messenger
+}
+
+// drainEvents returns the swap events that were published so far and match
+// the filter.
+func drainEvents(events <-chan SwapEvent, filter func(SwapEvent) bool) []SwapEvent {
+	var matched []SwapEvent
+	for len(events) > 0 {
+		event := <-events
+		if filter(event) {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+type ConnectedMessenger struct {
+	sync.Mutex
+	thisPeerId      string
+	OnMessage       func(peerId string, msgType string, msgBytes []byte) error
+	other           *ConnectedMessenger
+	msgReceivedChan chan messages.MessageType
+	lastErr         error
+}
+
+func (c *ConnectedMessenger) SendMessage(peerId string, msg []byte, msgType int) error {
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		msgString := messages.MessageTypeToHexString(messages.MessageType(msgType))
+		err := c.other.OnMessage(c.thisPeerId, msgString, msg)
+		if err != nil {
+			log.Printf("error on message send %v", err)
+			c.other.Lock()
+			c.other.lastErr = err
+			c.other.Unlock()
+		}
+		if c.other.msgReceivedChan != nil {
+			c.other.msgReceivedChan <- messages.MessageType(msgType)
+		}
+	}()
+
+	return nil
+}
+
+func (c *ConnectedMessenger) AddMessageHandler(f func(peerId string, msgType string, msgBytes []byte) error) {
+	c.OnMessage = f
+}
+
+const (
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"
+	logLevelWarn  = "warn"
+	logLevelError = "error"
+)
+
+// testLogger records the formatted log lines by level.
+type testLogger struct {
+	sync.Mutex
+	lines map[string][]string
+}
+
+func (l *testLogger) log(level, format string, v ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	if l.lines == nil {
+		l.lines = map[string][]string{}
+	}
+	l.lines[level] = append(l.lines[level], fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Debugf(format string, v ...interface{}) { l.log(logLevelDebug, format, v...) }
+func (l *testLogger) Infof(format string, v ...interface{})  { l.log(logLevelInfo, format, v...) }
+func (l *testLogger) Warnf(format string, v ...interface{})  { l.log(logLevelWarn, format, v...) }
+func (l *testLogger) Errorf(format string, v ...interface{}) { l.log(logLevelError, format, v...) }
+
+type MessengerManagerStub struct {
+	sync.Mutex
+	called  int
+	added   int
+	removed int
+}
+
+func (s *MessengerManagerStub) AddSender(id string, messenger messages.StoppableMessenger) error {
+	s.Lock()
+	defer s.Unlock()
+	s.called++
+	s.added++
+	return nil
+}
+
+func (s *MessengerManagerStub) RemoveSender(id string) {
+	s.Lock()
+	defer s.Unlock()
+	s.called++
+	s.removed++
+}
+
+type noopMessenger struct {
+}
+
+func (m *noopMessenger) SendMessage(peerId string, msg []byte, msgType int) error {
+	return nil
+}
+
+func (m *noopMessenger) AddMessageHandler(f func(peerId string, msgType string, msgBytes []byte) error) {
+}
+
+// Node ids of the test fixtures, peer ids are validated as node pubkeys.
+const (
+	aliceId   = "03b53d5084c77fd418fd9ca592b1a8fc9e4cef7100e94d5bd5b0911affaa8ad895"
+	bobId     = "02b5d963c3256eb0ea2437898e0e56b6489fd3d261b81cd6063a481560dbbe07ff"
+	charlieId = "03d5bbba4b3541f8e78c5165624f2d5f907bd563d1dcbe9a3e806b297e45f40992"
+	malloryId = "024ee96dfb16690ae65745d97be9840781d1d29862e6eeea67e80e32ab97765c91"
+)
+
+func getTestParams() (pubkeyA, pubkeyB, takerPubkey, makerPubkey, scid string) {
+	return getRandom33ByteHexString(), getRandom33ByteHexString(), getRandom33ByteHexString(), getRandom33ByteHexString(), "100x2x3"
+}
+
+func getRandom33ByteHexString() string {
+	privkey, _ := btcec.NewPrivateKey(btcec.S256())
+	return hex.EncodeToString(privkey.PubKey().SerializeCompressed())
+}
+
+func getRandom32ByteHexString() string {
+	privkey, _ := btcec.NewPrivateKey(btcec.S256())
+	return hex.EncodeToString(privkey.Serialize())
+}
+
+func Test_StartTwice(t *testing.T) {
+	service := getTestSetup(aliceId)
+	require.NoError(t, service.Start())
+	assert.ErrorIs(t, service.Start(), ErrServiceAlreadyStarted)
+
+	// A service with active swaps or a timeout service was initialized
+	// before.
+	service = getTestSetup(aliceId)
+	swap := newSwapOutSenderFSM(service.swapServices, aliceId, bobId)
+	service.AddActiveSwap(swap.SwapId.String(), swap)
+	assert.ErrorIs(t, service.Start(), ErrServiceAlreadyStarted)
+
+	service = getTestSetup(aliceId)
+	service.swapServices.toService = &timeOutDummy{}
+	assert.ErrorIs(t, service.Start(), ErrServiceAlreadyStarted)
+}
+
+func Test_PauseResume(t *testing.T) {
+	initiator, peer, pubkey, _, channelId := getTestParams()
+	service, messenger := getRecordingTestSetup(initiator)
+
+	// The active swap awaits the payment of the claim invoice.
+	activeId := NewSwapId()
+	active := newSwapOutReceiverFSM(activeId, service.swapServices, peer)
+	active.Current = State_SwapOutReceiver_AwaitClaimInvoicePayment
+	service.AddActiveSwap(activeId.String(), active)
+
+	service.Pause()
+	assert.True(t, service.IsPaused())
+
+	_, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	assert.ErrorIs(t, err, ErrServicePaused)
+	_, err = service.SwapIn(peer, btc_chain, channelId, initiator, 100000)
+	assert.ErrorIs(t, err, ErrServicePaused)
+
+	for _, request := range []func(swapId *SwapId) error{
+		func(swapId *SwapId) error {
+			return service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          swapId,
+				Network:         "mainnet",
+				Scid:            channelId,
+				Amount:          100000,
+				Pubkey:          pubkey,
+			})
+		},
+		func(swapId *SwapId) error {
+			return service.OnSwapInRequestReceived(swapId, peer, &SwapInRequestMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          swapId,
+				Network:         "mainnet",
+				Scid:            channelId,
+				Amount:          100000,
+				Pubkey:          pubkey,
+			})
+		},
+	} {
+		swapId := NewSwapId()
+		assert.ErrorIs(t, request(swapId), ErrServicePaused)
+		msg := lastCancelMessage(t, messenger)
+		assert.Equal(t, swapId, msg.SwapId)
+		assert.Equal(t, CancelReasonUnavailable, msg.Reason)
+		_, err := service.GetActiveSwap(swapId.String())
+		assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+	}
+
+	// The active swap goes on while the service is paused.
+	service.OnPayment(activeId.String(), INVOICE_CLAIM)
+	assert.Equal(t, State_ClaimedPreimage, active.Current)
+
+	service.Resume()
+	assert.False(t, service.IsPaused())
+	_, err = service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	assert.NoError(t, err)
+}
+
+func Test_PolicyRejectionErrors(t *testing.T) {
+	initiator, peer, pubkey, _, channelId := getTestParams()
+	swapOutRequest := func(service *SwapService, scid string, amount uint64) error {
+		swapId := NewSwapId()
+		return service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            scid,
+			Amount:          amount,
+			Pubkey:          pubkey,
+		})
+	}
+	swapInRequest := func(service *SwapService, scid string, amount uint64) error {
+		swapId := NewSwapId()
+		return service.OnSwapInRequestReceived(swapId, peer, &SwapInRequestMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swapId,
+			Network:         "mainnet",
+			Scid:            scid,
+			Amount:          amount,
+			Pubkey:          pubkey,
+		})
+	}
+
+	t.Run("active swap on channel", func(t *testing.T) {
+		// The active swap is with another peer, so that the requests are
+		// not crossing swaps.
+		_, otherPeer, _, _, _ := getTestParams()
+		service, _ := getRecordingTestSetup(initiator)
+		_, err := service.SwapOut(otherPeer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+
+		_, err = service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		assert.ErrorIs(t, err, ErrSwapAlreadyActiveOnChannel)
+		_, err = service.SwapIn(peer, btc_chain, channelId, initiator, 100000)
+		assert.ErrorIs(t, err, ErrSwapAlreadyActiveOnChannel)
+		assert.ErrorIs(t, swapOutRequest(service, channelId, 100000), ErrSwapAlreadyActiveOnChannel)
+		assert.ErrorIs(t, swapInRequest(service, channelId, 100000), ErrSwapAlreadyActiveOnChannel)
+	})
+
+	t.Run("swaps disabled", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		service.swapServices.policy.(*dummyPolicy).newSwapsAllowedReturn = false
+		_, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		assert.ErrorIs(t, err, ErrSwapsDisabled)
+		_, err = service.SwapIn(peer, btc_chain, channelId, initiator, 100000)
+		assert.ErrorIs(t, err, ErrSwapsDisabled)
+	})
+
+	t.Run("peer swap limit", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		require.NoError(t, service.swapServices.SetMaxActiveSwapsPerPeer(1))
+		_, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+
+		_, err = service.SwapIn(peer, btc_chain, "100x2x4", initiator, 100000)
+		assert.ErrorIs(t, err, ErrPeerSwapLimit)
+		assert.ErrorIs(t, swapOutRequest(service, "100x2x5", 100000), ErrPeerSwapLimit)
+		assert.ErrorIs(t, swapInRequest(service, "100x2x6", 100000), ErrPeerSwapLimit)
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		require.NoError(t, service.swapServices.SetRequestRateLimit(1, time.Hour))
+		require.NoError(t, swapOutRequest(service, "100x2x4", 100000))
+		assert.ErrorIs(t, swapInRequest(service, "100x2x5", 100000), ErrPeerRateLimited)
+	})
+
+	t.Run("swap amount", func(t *testing.T) {
+		service, _ := getRecordingTestSetup(initiator)
+		require.NoError(t, service.swapServices.SetSwapAmountLimits(0, 50000))
+		_, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+		assert.ErrorIs(t, err, ErrSwapAmount)
+	})
+
+	t.Run("typed errors", func(t *testing.T) {
+		for err, target := range map[error]error{
+			ActiveSwapOnChannelError{ChannelId: channelId}:       ErrSwapAlreadyActiveOnChannel,
+			CrossingSwapError{ChannelId: channelId}:              ErrCrossingSwap,
+			ErrMinimumSwapSize(1000):                             ErrSwapAmount,
+			ErrMaximumSwapSize(1000):                             ErrSwapAmount,
+			PeerNotAllowedError(peer):                            ErrPeerNotAllowed,
+			PeerRateLimitedError(peer):                           ErrPeerRateLimited,
+			PeerIsSuspiciousError(peer):                          ErrPeerSuspicious,
+			MaxActiveSwapsPerPeerError{PeerId: peer}:             ErrPeerSwapLimit,
+			ProtocolVersionError(1):                              ErrProtocolVersion,
+			ChainDisabledError(btc_chain):                        ErrChainNotSupported,
+			WrongAssetError("asset"):                             ErrChainNotSupported,
+			OpeningTxFeeRateTooHighError{Chain: btc_chain}:       ErrFeeRateTooHigh,
+			fmt.Errorf("wrapped: %w", PeerNotAllowedError(peer)): ErrPeerNotAllowed,
+		} {
+			assert.ErrorIs(t, err, target)
+			assert.False(t, errors.Is(err, ErrSwapsDisabled), "%v", err)
+		}
+		assert.ErrorIs(t, NoCommonChainError{btc_chain}, ErrChainNotSupported)
+	})
+}
+
+func Test_RequestWithInvalidPubkey(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	pubkey := "02" + strings.Repeat("00", 32)
+
+	for name, request := range map[string]func(service *SwapService, swapId *SwapId) error{
+		"swap out": func(service *SwapService, swapId *SwapId) error {
+			return service.OnSwapOutRequestReceived(swapId, peer, &SwapOutRequestMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          swapId,
+				Network:         "mainnet",
+				Scid:            channelId,
+				Amount:          100000,
+				Pubkey:          pubkey,
+			})
+		},
+		"swap in": func(service *SwapService, swapId *SwapId) error {
+			return service.OnSwapInRequestReceived(swapId, peer, &SwapInRequestMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          swapId,
+				Network:         "mainnet",
+				Scid:            channelId,
+				Amount:          100000,
+				Pubkey:          pubkey,
+			})
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			service, messenger := getRecordingTestSetup(initiator)
+
+			swapId := NewSwapId()
+			assert.Error(t, request(service, swapId))
+			msg := lastCancelMessage(t, messenger)
+			assert.Equal(t, swapId, msg.SwapId)
+			assert.Equal(t, CancelReasonInvalidMessage, msg.Reason)
+			_, err := service.GetActiveSwap(swapId.String())
+			assert.ErrorIs(t, err, ErrSwapDoesNotExist)
+		})
+	}
 }
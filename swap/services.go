@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/elementsproject/peerswap/messages"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/btcsuite/btcd/btcec"
 )
@@ -14,13 +16,125 @@ import (
 const (
 	btc_chain   = "btc"
 	l_btc_chain = "lbtc"
+
+	// defaultMaxMessageSize is the default upper limit in bytes for the
+	// payload of an incoming peer message.
+	defaultMaxMessageSize = 100 * 1024
+
+	// defaultNegotiationTimeout is the default time we wait for the swap
+	// partner to respond during the negotiation of a swap.
+	defaultNegotiationTimeout = 10 * time.Minute
+
+	// defaultMaxTransitions is the default number of state transitions that
+	// are kept in the history of a swap.
+	defaultMaxTransitions = 100
+
+	// defaultIdempotencyKeyTTL is the default time after the last update of
+	// a swap during which its idempotency key returns the swap.
+	defaultIdempotencyKeyTTL = 24 * time.Hour
 )
 
+// defaultStateTimeouts returns the timeouts of the states that wait for the
+// swap partner before funds are committed.
+func defaultStateTimeouts() map[StateType]time.Duration {
+	return map[StateType]time.Duration{
+		State_SwapOutSender_AwaitAgreement:             defaultNegotiationTimeout,
+		State_SwapInSender_AwaitAgreement:              defaultNegotiationTimeout,
+		State_SwapOutReceiver_AwaitFeeInvoicePayment:   defaultNegotiationTimeout,
+		State_SwapInReceiver_AwaitTxBroadcastedMessage: defaultNegotiationTimeout,
+	}
+}
+
+// defaultAllowedAssets are the assets that swaps are allowed for if not
+// configured otherwise.
+var defaultAllowedAssets = []string{btc_chain, l_btc_chain}
+
+// Messenger is the transport of the peer messages. The lightning clients
+// send them as custom messages to the peer, but any transport that follows
+// the contract below can carry the swap negotiation.
+//
+// SendMessage delivers the payload of the message type to the peer and must
+// not wait for the peer to handle it, the handler of the peer may send
+// messages back before SendMessage returns. Messages to a peer are expected
+// to arrive in the order they were sent. An error means that the message was
+// not sent, messages may still get lost after they were sent, the swaps
+// resend them if necessary.
+//
+// AddMessageHandler registers the handler for incoming messages. The handler
+// is called with the id of the sending peer, the message type as hex string,
+// see messages.MessageTypeToHexString, and the payload. Messages that are
+// not swap messages may be passed to the handler, the service ignores
+// message types that it does not know.
+//
+// A messenger may additionally implement PeerConnectionChecker and
+// HealthChecker.
 type Messenger interface {
 	SendMessage(peerId string, message []byte, messageType int) error
 	AddMessageHandler(func(peerId string, msgType string, payload []byte) error)
 }
 
+// PeerConnectionChecker is implemented by messengers that know whether a
+// peer is currently connected.
+type PeerConnectionChecker interface {
+	IsPeerConnected(peerId string) (bool, error)
+}
+
+// NodeIdProvider is implemented by lightning clients that know the pubkey of
+// the local node.
+type NodeIdProvider interface {
+	GetNodeId() string
+}
+
+// InvoiceExpiryChecker is implemented by lightning clients that can tell
+// whether an invoice expired.
+type InvoiceExpiryChecker interface {
+	IsInvoiceExpired(payreq string) (bool, error)
+}
+
+// FeeRateEstimator is implemented by wallets that can estimate the current
+// on-chain fee rate.
+type FeeRateEstimator interface {
+	// GetFeeRate returns the estimated fee rate in sat/vB.
+	GetFeeRate() (float64, error)
+}
+
+// FeeEstimator estimates the on-chain fees that are checked when swaps are
+// requested and agreed on.
+type FeeEstimator interface {
+	// EstimateOpeningTxFee returns the estimated fee in sat of the opening
+	// transaction of a swap of amount sat on the chain.
+	EstimateOpeningTxFee(chain string, amount uint64) (uint64, error)
+	// EstimateFeeRate returns the estimated fee rate in sat/vB on the chain.
+	// A fee rate of 0 means that the fee rate is not known.
+	EstimateFeeRate(chain string) (float64, error)
+}
+
+// walletFeeEstimator is the default FeeEstimator that takes the estimations
+// of the wallets of the chains.
+type walletFeeEstimator struct {
+	services *SwapServices
+}
+
+func (w *walletFeeEstimator) EstimateOpeningTxFee(chain string, amount uint64) (uint64, error) {
+	_, wallet, _, err := w.services.getOnChainServices(chain)
+	if err != nil {
+		return 0, err
+	}
+	return wallet.GetFlatSwapOutFee()
+}
+
+func (w *walletFeeEstimator) EstimateFeeRate(chain string) (float64, error) {
+	_, wallet, _, err := w.services.getOnChainServices(chain)
+	if err != nil {
+		return 0, err
+	}
+	estimator, ok := wallet.(FeeRateEstimator)
+	if !ok {
+		return 0, nil
+	}
+	return estimator.GetFeeRate()
+}
+
 type MessengerManager interface {
 	AddSender(id string, messenger messages.StoppableMessenger) error
 	RemoveSender(id string)
@@ -37,6 +151,15 @@ type Policy interface {
 	NewSwapsAllowed() bool
 }
 
+// AllowlistEditor is implemented by policies that can change their allowlist
+// at runtime.
+type AllowlistEditor interface {
+	GetPeerAllowlist() []string
+	AddToAllowlist(pubkey string) error
+	RemoveFromAllowlist(pubkey string) error
+	ReloadFile() error
+}
+
 type LightningClient interface {
 	DecodePayreq(payreq string) (paymentHash string, amountMsat uint64, err error)
 	PayInvoice(payreq string) (preImage string, err error)
@@ -55,6 +178,24 @@ type TxWatcher interface {
 	GetBlockHeight() (uint32, error)
 }
 
+// MempoolWatcher is implemented by tx watchers that report when the opening
+// transaction of a swap is first seen unconfirmed in the mempool.
+type MempoolWatcher interface {
+	AddMempoolCallback(func(swapId string, txId string) error)
+}
+
+// ConfirmationDepthSetter is implemented by tx watchers whose required number
+// of confirmations of the opening transaction can be set.
+type ConfirmationDepthSetter interface {
+	SetRequiredConfirmations(confs uint32)
+}
+
+// ReorgWatcher is implemented by tx watchers that notice when the confirmation
+// of an opening transaction they reported was reorged out of the chain.
+type ReorgWatcher interface {
+	AddReorgCallback(func(swapId string) error)
+}
+
 type Validator interface {
 	TxIdFromHex(txHex string) (string, error)
 	ValidateTx(swapParams *OpeningParams, txHex string) (bool, error)
@@ -76,6 +217,26 @@ type Wallet interface {
 	GetOnchainBalance() (uint64, error)
 }
 
+// OpeningTxRebroadcaster is implemented by wallets that can broadcast an
+// opening transaction that was already signed and broadcasted again.
+type OpeningTxRebroadcaster interface {
+	RebroadcastOpeningTx(txHex string) (txId string, err error)
+}
+
+// SpendingTxFeeGetter is implemented by wallets that can tell the fee that a
+// transaction paid that spends the output of an opening transaction.
+type SpendingTxFeeGetter interface {
+	GetSpendingTxFee(openingTxHex, spendingTxHex string) (uint64, error)
+}
+
+// ClaimTxBumper is implemented by wallets that can bump the fee of a claim
+// transaction, either with a child that pays for the parent or by replacing
+// the transaction. The id of the replacement is returned if the transaction
+// was replaced, an empty id otherwise.
+type ClaimTxBumper interface {
+	BumpClaimTx(claimTxId string) (replacementTxId string, err error)
+}
+
 type OpeningParams struct {
 	TakerPubkey      string
 	MakerPubkey      string
@@ -131,9 +292,61 @@ type SwapServices struct {
 	liquidValidator     Validator
 	liquidWallet        Wallet
 	liquidEnabled       bool
-	toService           TimeOutService
+	// disabledChains holds the chains that were disabled at runtime with
+	// SetChainEnabled.
+	disabledChains     map[string]struct{}
+	chainsMutex        sync.RWMutex
+	toService          TimeOutService
+	maxMessageSize     int
+	logger             Logger
+	swapEvents         *swapEventBroker
+	allowedAssets      []string
+	minSwapAmountSat   uint64
+	maxSwapAmountSat   uint64
+	requestRateLimiter *peerRateLimiter
+	maxPremiumSat      uint64
+	maxCostPPM         uint64
+	metrics            *swapMetrics
+	stateTimeouts      map[StateType]time.Duration
+
+	allowConcurrentChannelSwaps bool
+	channelCapacity             ChannelCapacityFunc
+	acceptedProtocolVersions    []uint8
+	messageRetry                *messageRetry
+	checkPeerConnection         bool
+	checkPeerIds                bool
+	maxActiveSwapsPerPeer       int
+	maxActiveSwaps              int
+	maxOpeningTxFeeRates        map[string]float64
+	feeEstimator                FeeEstimator
+	maxTransitions              int
+	keepaliveInterval           time.Duration
+	keepaliveWindow             time.Duration
+	idempotencyKeyTTL           time.Duration
+	lightningRetryBackoff       time.Duration
+	lightningRetryWindow        time.Duration
+	maxSwapAge                  time.Duration
+	swapAgeCheckInterval        time.Duration
+	invoiceExpiry               time.Duration
+	invoiceCheckInterval        time.Duration
+	bitcoinConfirmations        uint32
+	liquidConfirmations         uint32
+	circuitBreaker              *circuitBreaker
+	persistWindow               time.Duration
+	disconnectGracePeriod       time.Duration
+	clock                       func() time.Time
+
+	// peerSettingsMutex guards the premiums and the peer policies, which
+	// can be changed at runtime.
+	peerSettingsMutex sync.RWMutex
+	defaultPremiumSat uint64
+	peerPremiumsSat   map[string]uint64
+	peerPolicies      map[string]*peerPolicy
 }
 
+// ChannelCapacityFunc returns the capacity in sats of the channel.
+type ChannelCapacityFunc func(channelId string) (uint64, error)
+
 func NewSwapServices(
 	swapStore Store,
 	requestedSwapsStore RequestedSwapsStore,
@@ -149,7 +362,7 @@ func NewSwapServices(
 	liquidWallet Wallet,
 	liquidValidator Validator,
 	liquidTxWatcher TxWatcher) *SwapServices {
-	return &SwapServices{
+	services := &SwapServices{
 		swapStore:           swapStore,
 		requestedSwapsStore: requestedSwapsStore,
 		lightning:           lightning,
@@ -164,13 +377,474 @@ func NewSwapServices(
 		liquidWallet:        liquidWallet,
 		liquidValidator:     liquidValidator,
 		liquidTxWatcher:     liquidTxWatcher,
+		maxMessageSize:      defaultMaxMessageSize,
+		logger:              defaultLogger{},
+		swapEvents:          newSwapEventBroker(),
+		allowedAssets:       append([]string{}, defaultAllowedAssets...),
+		requestRateLimiter:  newPeerRateLimiter(defaultRequestRateLimit, defaultRequestRateInterval),
+		stateTimeouts:       defaultStateTimeouts(),
+
+		acceptedProtocolVersions: []uint8{PEERSWAP_PROTOCOL_VERSION},
+		checkPeerConnection:      true,
+		checkPeerIds:             true,
+		maxTransitions:           defaultMaxTransitions,
+		idempotencyKeyTTL:        defaultIdempotencyKeyTTL,
+		lightningRetryBackoff:    defaultLightningRetryBackoff,
+		lightningRetryWindow:     defaultLightningRetryWindow,
+	}
+	services.feeEstimator = &walletFeeEstimator{services: services}
+	return services
+}
+
+// SetLogger replaces the logger that is used by the swap service.
+func (s *SwapServices) SetLogger(logger Logger) {
+	s.logger = logger
+}
+
+// SetMaxMessageSize sets the maximum payload size in bytes that is accepted
+// for incoming peer messages.
+func (s *SwapServices) SetMaxMessageSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("max message size must be positive, got %d", size)
+	}
+	s.maxMessageSize = size
+	return nil
+}
+
+// publishSwapEvent sends the event to all subscribers of the swap events.
+func (s *SwapServices) publishSwapEvent(event SwapEvent) {
+	s.swapEvents.publish(s.logger, event)
+}
+
+// SetAllowConcurrentChannelSwaps allows more than one active swap on a
+// channel as long as the amounts of the swaps do not exceed the capacity of
+// the channel that is returned by channelCapacity. Only one active swap per
+// channel is allowed by default.
+func (s *SwapServices) SetAllowConcurrentChannelSwaps(allow bool, channelCapacity ChannelCapacityFunc) error {
+	if allow && channelCapacity == nil {
+		return fmt.Errorf("the channel capacity is required to allow concurrent channel swaps")
+	}
+	s.allowConcurrentChannelSwaps = allow
+	s.channelCapacity = channelCapacity
+	return nil
+}
+
+// SetMaxActiveSwapsPerPeer sets the maximum number of active swaps with a
+// single peer across all channels. A maximum of 0 disables the limit.
+func (s *SwapServices) SetMaxActiveSwapsPerPeer(max int) error {
+	if max < 0 {
+		return fmt.Errorf("max active swaps per peer must not be negative, got %d", max)
 	}
+	s.maxActiveSwapsPerPeer = max
+	return nil
+}
+
+// SetMaxActiveSwaps sets the maximum number of active swaps of the node
+// across all peers, so that many concurrent requests can not commit more
+// funds than the wallets hold. A maximum of 0 disables the limit.
+func (s *SwapServices) SetMaxActiveSwaps(max int) error {
+	if max < 0 {
+		return fmt.Errorf("max active swaps must not be negative, got %d", max)
+	}
+	s.maxActiveSwaps = max
+	return nil
+}
+
+// SetFeeEstimator replaces the estimator of the on-chain fees. The wallets
+// of the chains estimate the fees by default.
+func (s *SwapServices) SetFeeEstimator(estimator FeeEstimator) error {
+	if estimator == nil {
+		return fmt.Errorf("fee estimator must not be nil")
+	}
+	s.feeEstimator = estimator
+	return nil
+}
+
+// SetMaxTransitions sets the number of state transitions that are kept in
+// the history of a swap. The oldest transitions are dropped first. A maximum
+// of 0 disables the history.
+func (s *SwapServices) SetMaxTransitions(max int) error {
+	if max < 0 {
+		return fmt.Errorf("max transitions must not be negative, got %d", max)
+	}
+	s.maxTransitions = max
+	return nil
+}
+
+// now returns the current time of the clock of the services, which can be
+// replaced in tests.
+func (s *SwapServices) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock()
+}
+
+// SetKeepalive enables keepalive pings to the partners of the active swaps
+// every interval. A partner that does not answer a ping within the window is
+// reported with a SwapEventPeerUnresponsive event, the swap is not canceled.
+// An interval of 0 disables the keepalive pings, which is the default.
+func (s *SwapServices) SetKeepalive(interval, window time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("keepalive interval must not be negative, got %v", interval)
+	}
+	if interval > 0 && window <= 0 {
+		return fmt.Errorf("keepalive window must be positive, got %v", window)
+	}
+	s.keepaliveInterval = interval
+	s.keepaliveWindow = window
+	return nil
+}
+
+// SetIdempotencyKeyTTL sets the time after the last update of a swap during
+// which a swap that is started with the same idempotency key returns the swap
+// instead of starting a new one. Active swaps are always returned.
+func (s *SwapServices) SetIdempotencyKeyTTL(ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("idempotency key ttl must not be negative, got %v", ttl)
+	}
+	s.idempotencyKeyTTL = ttl
+	return nil
+}
+
+// SetBitcoinConfirmations sets the number of confirmations of the opening
+// transaction that are required on bitcoin. The depth is passed to the tx
+// watcher when the swap service is started. A depth of 0 keeps the depth that
+// the tx watcher was created with.
+func (s *SwapServices) SetBitcoinConfirmations(confs uint32) {
+	s.bitcoinConfirmations = confs
+}
+
+// SetLiquidConfirmations sets the number of confirmations of the opening
+// transaction that are required on liquid. The depth is passed to the tx
+// watcher when the swap service is started. A depth of 0 keeps the depth that
+// the tx watcher was created with.
+func (s *SwapServices) SetLiquidConfirmations(confs uint32) {
+	s.liquidConfirmations = confs
+}
+
+// setRequiredConfirmations passes the confirmation depth of the chain to the
+// tx watcher if it implements ConfirmationDepthSetter.
+func (s *SwapServices) setRequiredConfirmations(chain string, watcher TxWatcher, confs uint32) {
+	if confs == 0 {
+		return
+	}
+	setter, ok := watcher.(ConfirmationDepthSetter)
+	if !ok {
+		s.logger.Warnf("[SwapService] Tx watcher of chain %s does not support setting the confirmation depth, keeping its default", chain)
+		return
+	}
+	setter.SetRequiredConfirmations(confs)
+}
+
+// SetMaxOpeningTxFeeRate sets the maximum fee rate in sat/vB on the chain at
+// which we accept swap out requests and pay for the opening transaction. A
+// maximum of 0 disables the limit for the chain.
+func (s *SwapServices) SetMaxOpeningTxFeeRate(chain string, satPerVByte float64) error {
+	if chain != btc_chain && chain != l_btc_chain {
+		return WrongAssetError(chain)
+	}
+	if satPerVByte < 0 {
+		return fmt.Errorf("max opening tx fee rate must not be negative, got %v", satPerVByte)
+	}
+	if satPerVByte == 0 {
+		delete(s.maxOpeningTxFeeRates, chain)
+		return nil
+	}
+	if s.maxOpeningTxFeeRates == nil {
+		s.maxOpeningTxFeeRates = make(map[string]float64)
+	}
+	s.maxOpeningTxFeeRates[chain] = satPerVByte
+	return nil
+}
+
+// SetAllowedAssets sets the assets that swaps are allowed for.
+func (s *SwapServices) SetAllowedAssets(assets []string) error {
+	if len(assets) == 0 {
+		return fmt.Errorf("at least one allowed asset is required")
+	}
+	s.allowedAssets = append([]string{}, assets...)
+	return nil
+}
+
+// isAssetAllowed returns true if swaps are allowed for the asset.
+func (s *SwapServices) isAssetAllowed(asset string) bool {
+	for _, allowed := range s.allowedAssets {
+		if allowed == asset {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAcceptedProtocolVersions sets the peerswap protocol versions that are
+// accepted in swap requests of peers.
+func (s *SwapServices) SetAcceptedProtocolVersions(versions []uint8) error {
+	if len(versions) == 0 {
+		return fmt.Errorf("at least one accepted protocol version is required")
+	}
+	s.acceptedProtocolVersions = append([]uint8{}, versions...)
+	return nil
+}
+
+// isProtocolVersionAccepted returns true if swap requests with the protocol
+// version are accepted.
+func (s *SwapServices) isProtocolVersionAccepted(version uint8) bool {
+	for _, accepted := range s.acceptedProtocolVersions {
+		if accepted == version {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRequestRateLimit sets the number of swap requests a peer may send per
+// interval. A limit of 0 disables the rate limiting.
+func (s *SwapServices) SetRequestRateLimit(limit int, interval time.Duration) error {
+	if limit < 0 {
+		return fmt.Errorf("request rate limit must not be negative, got %d", limit)
+	}
+	if limit == 0 {
+		s.requestRateLimiter = nil
+		return nil
+	}
+	if interval <= 0 {
+		return fmt.Errorf("request rate interval must be positive, got %v", interval)
+	}
+	s.requestRateLimiter = newPeerRateLimiter(limit, interval)
+	return nil
+}
+
+// SetMessageRetry sets the backoff before the first retry of a failed message
+// send and the total time that a failed send is retried. The backoff doubles
+// with every retry. The window must be shorter than the configured state
+// timeouts so that a retried send does not race the timeout of the swap. A
+// window of 0 disables the retries, which is the default. A send is retried
+// while the swap is locked, so an unreachable peer blocks the swap for up to
+// the window.
+func (s *SwapServices) SetMessageRetry(backoff, window time.Duration) error {
+	if window < 0 {
+		return fmt.Errorf("message retry window must not be negative, got %v", window)
+	}
+	if window == 0 {
+		s.messageRetry = nil
+		return nil
+	}
+	if backoff <= 0 {
+		return fmt.Errorf("message retry backoff must be positive, got %v", backoff)
+	}
+	for state, timeout := range s.stateTimeouts {
+		if window >= timeout {
+			return fmt.Errorf("message retry window %v must be shorter than the timeout %v of state %s", window, timeout, state)
+		}
+	}
+	s.messageRetry = newMessageRetry(backoff, window)
+	return nil
+}
+
+// sendMessage sends the message to the peer and retries on failure.
+func (s *SwapServices) sendMessage(peerId string, message []byte, messageType int) error {
+	return s.messageRetry.send(s.logger, func() error {
+		return s.messenger.SendMessage(peerId, message, messageType)
+	})
+}
+
+// SetCheckPeerConnection sets whether a new swap is only started if the
+// messenger reports the peer as connected. The check is enabled by default
+// and skipped if the messenger does not implement PeerConnectionChecker.
+func (s *SwapServices) SetCheckPeerConnection(check bool) {
+	s.checkPeerConnection = check
+}
+
+// checkPeerConnected returns ErrPeerNotConnected if the check is enabled and
+// the messenger reports the peer as not connected.
+func (s *SwapServices) checkPeerConnected(peerId string) error {
+	if !s.checkPeerConnection {
+		return nil
+	}
+	checker, ok := s.messenger.(PeerConnectionChecker)
+	if !ok {
+		return nil
+	}
+	connected, err := checker.IsPeerConnected(peerId)
+	if err != nil {
+		return fmt.Errorf("could not check the connection to peer %s: %w", peerId, err)
+	}
+	if !connected {
+		return fmt.Errorf("%w: %s", ErrPeerNotConnected, peerId)
+	}
+	return nil
+}
+
+// checkInitiator returns ErrWrongInitiator if the lightning client reports a
+// local node id that differs from the initiator of a swap.
+func (s *SwapServices) checkInitiator(initiator string) error {
+	provider, ok := s.lightning.(NodeIdProvider)
+	if !ok {
+		return nil
+	}
+	if nodeId := provider.GetNodeId(); initiator != nodeId {
+		return fmt.Errorf("%w: got %s, local node is %s", ErrWrongInitiator, initiator, nodeId)
+	}
+	return nil
+}
+
+// SetDefaultPremium sets the premium in sats that is asked for in the
+// agreement of a swap requested by a peer without a peer specific premium.
+func (s *SwapServices) SetDefaultPremium(premiumSat uint64) {
+	s.peerSettingsMutex.Lock()
+	defer s.peerSettingsMutex.Unlock()
+	s.defaultPremiumSat = premiumSat
+}
+
+// SetPeerPremium sets the premium in sats that is asked for in the agreement
+// of a swap requested by the peer.
+func (s *SwapServices) SetPeerPremium(peerId string, premiumSat uint64) {
+	s.peerSettingsMutex.Lock()
+	defer s.peerSettingsMutex.Unlock()
+	if s.peerPremiumsSat == nil {
+		s.peerPremiumsSat = map[string]uint64{}
+	}
+	s.peerPremiumsSat[canonicalPeerId(peerId)] = premiumSat
+}
+
+// SetMaxPremium sets the maximum premium in sats that we accept to pay to the
+// swap partner. Swaps with a higher premium are canceled.
+func (s *SwapServices) SetMaxPremium(premiumSat uint64) {
+	s.maxPremiumSat = premiumSat
+}
+
+// SetMaxCostPPM sets the maximum total cost of a swap that we initiate in
+// parts per million of the swap amount, e.g. 5000 for 0.5%. The cost is
+// the fee invoice of a swap out, or the premium and the estimated opening
+// tx fee of a swap in. Swaps that cost more are canceled. A maximum of 0
+// disables the limit, it applies in addition to the swap amount limits.
+func (s *SwapServices) SetMaxCostPPM(ppm uint64) error {
+	if ppm > 1_000_000 {
+		return fmt.Errorf("max cost ppm must not exceed 1000000, got %d", ppm)
+	}
+	s.maxCostPPM = ppm
+	return nil
+}
+
+// checkSwapCost returns SwapCostTooHighError if the cost of a swap of the
+// amount exceeds the maximum cost.
+func (s *SwapServices) checkSwapCost(amtSat, costSat uint64) error {
+	if s.maxCostPPM == 0 {
+		return nil
+	}
+	// Split the amount so that the multiplication can not overflow.
+	maxSat := amtSat/1_000_000*s.maxCostPPM + amtSat%1_000_000*s.maxCostPPM/1_000_000
+	if costSat > maxSat {
+		return SwapCostTooHighError{CostSat: costSat, MaxSat: maxSat, PPM: s.maxCostPPM}
+	}
+	return nil
+}
+
+// getPremium returns the premium in sats for a swap requested by the peer.
+func (s *SwapServices) getPremium(peerId string) uint64 {
+	s.peerSettingsMutex.RLock()
+	defer s.peerSettingsMutex.RUnlock()
+	if policy, ok := s.peerPolicies[peerId]; ok {
+		return policy.PremiumSat
+	}
+	if premium, ok := s.peerPremiumsSat[peerId]; ok {
+		return premium
+	}
+	return s.defaultPremiumSat
+}
+
+// SetStateTimeout sets the duration after which Event_OnTimeout is sent to a
+// swap that remains in the state. A duration of 0 disables the timeout for
+// the state.
+func (s *SwapServices) SetStateTimeout(state StateType, d time.Duration) {
+	if s.stateTimeouts == nil {
+		s.stateTimeouts = map[StateType]time.Duration{}
+	}
+	if d <= 0 {
+		delete(s.stateTimeouts, state)
+		return
+	}
+	s.stateTimeouts[state] = d
+}
+
+// startStateTimeout cancels the timeout of the previous state and starts the
+// timeout of the current state of the swap, if one is configured.
+func (s *SwapServices) startStateTimeout(swap *SwapData) {
+	swap.cancelTimeout()
+	swap.toCancel = nil
+
+	d, ok := s.stateTimeouts[swap.GetCurrentState()]
+	if !ok {
+		return
+	}
+	toCtx, cancel := context.WithCancel(context.Background())
+	swap.toCancel = cancel
+	s.toService.addNewTimeOut(toCtx, d, swap.GetId().String())
+}
+
+// SetMetricsRegisterer registers the swap metrics with the registerer. The
+// metrics are not recorded if no registerer is set.
+func (s *SwapServices) SetMetricsRegisterer(registerer prometheus.Registerer) error {
+	metrics, err := newSwapMetrics(registerer)
+	if err != nil {
+		return err
+	}
+	s.metrics = metrics
+	return nil
+}
+
+// SetSwapAmountLimits sets the minimum and maximum amount in sats that is
+// accepted for a swap. A limit of 0 disables the check.
+func (s *SwapServices) SetSwapAmountLimits(minSat, maxSat uint64) error {
+	if maxSat != 0 && minSat > maxSat {
+		return fmt.Errorf("minimum swap amount %d sat is greater than maximum swap amount %d sat", minSat, maxSat)
+	}
+	s.minSwapAmountSat = minSat
+	s.maxSwapAmountSat = maxSat
+	return nil
+}
+
+// checkSwapAmount returns an error if the amount is out of the configured
+// swap amount limits.
+func (s *SwapServices) checkSwapAmount(amtSat uint64) error {
+	if s.minSwapAmountSat != 0 && amtSat < s.minSwapAmountSat {
+		return ErrMinimumSwapSize(s.minSwapAmountSat * 1000)
+	}
+	if s.maxSwapAmountSat != 0 && amtSat > s.maxSwapAmountSat {
+		return ErrMaximumSwapSize(s.maxSwapAmountSat * 1000)
+	}
+	return nil
+}
+
+// checkChainEnabled returns ChainDisabledError if swaps on the chain are not
+// enabled.
+func (s *SwapServices) checkChainEnabled(chain string) error {
+	if (chain == btc_chain && !s.bitcoinEnabled) || (chain == l_btc_chain && !s.liquidEnabled) {
+		return ChainDisabledError(chain)
+	}
+	if s.isChainDisabled(chain) {
+		return ChainDisabledError(chain)
+	}
+	return nil
+}
+
+// isChainDisabled returns true if swaps on the chain were disabled at
+// runtime.
+func (s *SwapServices) isChainDisabled(chain string) bool {
+	s.chainsMutex.RLock()
+	defer s.chainsMutex.RUnlock()
+	_, ok := s.disabledChains[chain]
+	return ok
 }
 
 func (s *SwapServices) getOnChainServices(asset string) (TxWatcher, Wallet, Validator, error) {
 	if asset == "" {
 		return nil, nil, nil, fmt.Errorf("missing asset")
 	}
+	if !s.isAssetAllowed(asset) {
+		return nil, nil, nil, WrongAssetError(asset)
+	}
 	if asset == btc_chain {
 		return s.bitcoinTxWatcher, s.bitcoinWallet, s.bitcoinValidator, nil
 	}
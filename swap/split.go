@@ -0,0 +1,100 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// swapRemovedPollInterval is the interval in which waitSwapRemoved checks
+// the active swaps, in case the removed event was dropped.
+const swapRemovedPollInterval = time.Second
+
+// splitAmount divides the total amount into amounts of maxPerSwap and a last
+// amount with the remainder, if any.
+func splitAmount(totalAmount, maxPerSwap uint64) []uint64 {
+	var amounts []uint64
+	for totalAmount > maxPerSwap {
+		amounts = append(amounts, maxPerSwap)
+		totalAmount -= maxPerSwap
+	}
+	return append(amounts, totalAmount)
+}
+
+// SwapOutSplit swaps out the total amount in sequential swaps of at most
+// maxPerSwap sats.
+func (s *SwapService) SwapOutSplit(peer, chain, channelId, initiator string, totalAmount, maxPerSwap uint64) ([]*SwapStateMachine, error) {
+	return s.SwapOutSplitContext(context.Background(), peer, chain, channelId, initiator, totalAmount, maxPerSwap)
+}
+
+// SwapOutSplitContext swaps out the total amount in sequential swaps of at
+// most maxPerSwap sats. The next swap is only started after the previous swap
+// claimed the preimage. If a swap can not be started or does not claim the
+// preimage, or the context is done, no further swaps are started and the
+// swaps that were started so far are returned together with the error.
+func (s *SwapService) SwapOutSplitContext(ctx context.Context, peer, chain, channelId, initiator string, totalAmount, maxPerSwap uint64) ([]*SwapStateMachine, error) {
+	if totalAmount == 0 || maxPerSwap == 0 {
+		return nil, fmt.Errorf("total amount and max amount per swap must be positive")
+	}
+
+	// Check all amounts up front so that the remainder does not fail after
+	// the other swaps were done.
+	amounts := splitAmount(totalAmount, maxPerSwap)
+	for _, amount := range amounts {
+		if err := s.checkAmount(amount); err != nil {
+			return nil, err
+		}
+	}
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	var swaps []*SwapStateMachine
+	for i, amount := range amounts {
+		swap, err := s.SwapOutContext(ctx, peer, chain, channelId, initiator, amount)
+		if err != nil {
+			return swaps, fmt.Errorf("could not start swap %d of %d: %w", i+1, len(amounts), err)
+		}
+		swaps = append(swaps, swap)
+
+		state, err := s.waitSwapRemoved(ctx, events, swap.SwapId.String())
+		if err != nil {
+			return swaps, err
+		}
+		if state != State_ClaimedPreimage {
+			return swaps, fmt.Errorf("swap %d of %d %s ended in state %s", i+1, len(amounts), swap.SwapId.String(), state)
+		}
+	}
+	return swaps, nil
+}
+
+// waitSwapRemoved waits until the swap is removed from the active swaps and
+// returns the state that the swap ended in. As the events are dropped if the
+// subscriber does not keep up, the active swaps are checked on a ticker too
+// and the state is read from the store.
+func (s *SwapService) waitSwapRemoved(ctx context.Context, events <-chan SwapEvent, swapId string) (StateType, error) {
+	ticker := time.NewTicker(swapRemovedPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			if event.Kind == SwapEventRemoved && event.SwapId == swapId {
+				return event.NewState, nil
+			}
+		case <-ticker.C:
+			_, err := s.GetActiveSwap(swapId)
+			if !errors.Is(err, ErrSwapDoesNotExist) {
+				continue
+			}
+			swap, err := s.swapServices.swapStore.GetData(swapId)
+			if err != nil {
+				return "", err
+			}
+			return swap.Current, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package swap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_splitAmount(t *testing.T) {
+	assert.Equal(t, []uint64{100000, 100000, 100000}, splitAmount(300000, 100000))
+	assert.Equal(t, []uint64{100000, 100000, 50000}, splitAmount(250000, 100000))
+	assert.Equal(t, []uint64{50000}, splitAmount(50000, 100000))
+}
+
+// amountLightningClient is a dummyLightningClient whose claim invoices are for
+// the amount of the swap instead of a fixed amount.
+type amountLightningClient struct {
+	*dummyLightningClient
+}
+
+func (a *amountLightningClient) GetPayreq(msatAmount uint64, preimage string, swapId string, memo string, invoiceType InvoiceType, expiry uint64) (string, error) {
+	if invoiceType == INVOICE_CLAIM {
+		return fmt.Sprintf("claim %d", msatAmount), nil
+	}
+	return a.dummyLightningClient.GetPayreq(msatAmount, preimage, swapId, memo, invoiceType, expiry)
+}
+
+func (a *amountLightningClient) DecodePayreq(payreq string) (string, uint64, error) {
+	var msatAmount uint64
+	if _, err := fmt.Sscanf(payreq, "claim %d", &msatAmount); err == nil {
+		return "foo", msatAmount, nil
+	}
+	return a.dummyLightningClient.DecodePayreq(payreq)
+}
+
+// splitTestSetup returns two connected swap services and the channels of the
+// messages they receive.
+func splitTestSetup(t *testing.T, initiator, peer string) (alice, bob *SwapService, aliceMsgChan, bobMsgChan chan messages.MessageType) {
+	alice = getTestSetup(initiator)
+	bob = getTestSetup(peer)
+	aliceMessenger := alice.swapServices.messenger.(*ConnectedMessenger)
+	bobMessenger := bob.swapServices.messenger.(*ConnectedMessenger)
+	aliceMessenger.other = bobMessenger
+	bobMessenger.other = aliceMessenger
+
+	// The redundant messages of a swap must stop once the swap is done, so
+	// that they do not interfere with the next swap.
+	alice.swapServices.messengerManager = messages.NewManager()
+	bob.swapServices.messengerManager = messages.NewManager()
+
+	alice.swapServices.lightning = &amountLightningClient{alice.swapServices.lightning.(*dummyLightningClient)}
+	bob.swapServices.lightning = &amountLightningClient{bob.swapServices.lightning.(*dummyLightningClient)}
+
+	aliceMsgChan = make(chan messages.MessageType)
+	bobMsgChan = make(chan messages.MessageType)
+	aliceMessenger.msgReceivedChan = aliceMsgChan
+	bobMessenger.msgReceivedChan = bobMsgChan
+
+	require.NoError(t, alice.Start())
+	require.NoError(t, bob.Start())
+	return alice, bob, aliceMsgChan, bobMsgChan
+}
+
+// negotiateSwapOut waits until the next swap out of alice was agreed on by
+// bob and returns the swaps of alice and bob.
+func negotiateSwapOut(t *testing.T, alice, bob *SwapService, aliceMsgChan, bobMsgChan chan messages.MessageType) (aliceSwap, bobSwap *SwapStateMachine) {
+	require.Equal(t, messages.MESSAGETYPE_SWAPOUTREQUEST, <-bobMsgChan)
+	bobSwaps := bob.GetActiveSwaps()
+	require.Len(t, bobSwaps, 1)
+	bobSwap = bobSwaps[0]
+
+	require.Equal(t, messages.MESSAGETYPE_SWAPOUTAGREEMENT, <-aliceMsgChan)
+	aliceSwap, err := alice.GetActiveSwap(bobSwap.SwapId.String())
+	require.NoError(t, err)
+	return aliceSwap, bobSwap
+}
+
+// completeSwapOut runs the next swap out of alice until both sides claimed
+// the preimage.
+func completeSwapOut(t *testing.T, alice, bob *SwapService, aliceMsgChan, bobMsgChan chan messages.MessageType) {
+	aliceSwap, bobSwap := negotiateSwapOut(t, alice, bob, aliceMsgChan, bobMsgChan)
+
+	bob.swapServices.lightning.(*amountLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_FEE)
+	require.Equal(t, messages.MESSAGETYPE_OPENINGTXBROADCASTED, <-aliceMsgChan)
+
+	// Bob is done before alice so that bob accepts the next swap on the
+	// channel.
+	bob.swapServices.lightning.(*amountLightningClient).TriggerPayment(bobSwap.SwapId.String(), INVOICE_CLAIM)
+	require.Equal(t, State_ClaimedPreimage, bobSwap.Current)
+
+	err := alice.swapServices.liquidTxWatcher.(*dummyChain).txConfirmedFunc(aliceSwap.SwapId.String(), aliceSwap.Data.OpeningTxHex)
+	require.NoError(t, err)
+}
+
+func Test_SwapOutSplit(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		totalAmount uint64
+		maxPerSwap  uint64
+		amounts     []uint64
+	}{
+		{name: "clean division", totalAmount: 200000, maxPerSwap: 100000, amounts: []uint64{100000, 100000}},
+		{name: "remainder", totalAmount: 250000, maxPerSwap: 150000, amounts: []uint64{150000, 100000}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			initiator, peer, _, _, channelId := getTestParams()
+			alice, bob, aliceMsgChan, bobMsgChan := splitTestSetup(t, initiator, peer)
+
+			type result struct {
+				swaps []*SwapStateMachine
+				err   error
+			}
+			done := make(chan result, 1)
+			go func() {
+				swaps, err := alice.SwapOutSplit(peer, btc_chain, channelId, initiator, tc.totalAmount, tc.maxPerSwap)
+				done <- result{swaps, err}
+			}()
+
+			for range tc.amounts {
+				completeSwapOut(t, alice, bob, aliceMsgChan, bobMsgChan)
+			}
+
+			res := <-done
+			require.NoError(t, res.err)
+			require.Len(t, res.swaps, len(tc.amounts))
+			for i, swap := range res.swaps {
+				assert.Equal(t, tc.amounts[i], swap.Data.GetAmount())
+				assert.Equal(t, State_ClaimedPreimage, swap.Current)
+			}
+		})
+	}
+}
+
+func Test_SwapOutSplit_Failure(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	alice, bob, aliceMsgChan, bobMsgChan := splitTestSetup(t, initiator, peer)
+
+	type result struct {
+		swaps []*SwapStateMachine
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		swaps, err := alice.SwapOutSplit(peer, btc_chain, channelId, initiator, 300000, 100000)
+		done <- result{swaps, err}
+	}()
+
+	completeSwapOut(t, alice, bob, aliceMsgChan, bobMsgChan)
+
+	// The second swap is canceled by bob, the third swap is not started.
+	_, bobSwap := negotiateSwapOut(t, alice, bob, aliceMsgChan, bobMsgChan)
+	require.NoError(t, bob.CancelSwap(bobSwap.SwapId.String(), ""))
+	assert.Equal(t, messages.MESSAGETYPE_CANCELED, <-aliceMsgChan)
+
+	res := <-done
+	assert.Error(t, res.err)
+	require.Len(t, res.swaps, 2)
+	assert.Equal(t, State_ClaimedPreimage, res.swaps[0].Current)
+	assert.Equal(t, State_SwapCanceled, res.swaps[1].Current)
+	assert.Empty(t, alice.GetActiveSwaps())
+}
+
+func Test_SwapOutSplit_RemainderTooSmall(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	alice, _, _, _ := splitTestSetup(t, initiator, peer)
+
+	// The remainder of 50000 sats is below the minimum swap amount, no swap
+	// is started.
+	swaps, err := alice.SwapOutSplit(peer, btc_chain, channelId, initiator, 250000, 100000)
+	assert.Error(t, err)
+	assert.Empty(t, swaps)
+	assert.Empty(t, alice.GetActiveSwaps())
+}
+
+// Test_waitSwapRemoved_DroppedEvent checks that the removal of a swap is
+// noticed if the removed event was dropped.
+func Test_waitSwapRemoved_DroppedEvent(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	service, _ := getRecordingTestSetup(initiator)
+
+	swap, err := service.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+
+	// The events never deliver the removed event.
+	events := make(chan SwapEvent)
+	done := make(chan StateType, 1)
+	go func() {
+		state, err := service.waitSwapRemoved(context.Background(), events, swap.SwapId.String())
+		assert.NoError(t, err)
+		done <- state
+	}()
+
+	msgType := messages.MessageTypeToHexString(messages.MESSAGETYPE_CANCELED)
+	msgBytes, err := json.Marshal(&CancelMessage{SwapId: swap.SwapId, Message: "canceled"})
+	require.NoError(t, err)
+	require.NoError(t, service.OnMessageReceived(peer, msgType, msgBytes))
+
+	select {
+	case state := <-done:
+		assert.Equal(t, State_SwapCanceled, state)
+	case <-time.After(3 * swapRemovedPollInterval):
+		t.Fatal("removal of the swap was not noticed")
+	}
+}
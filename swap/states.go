@@ -8,6 +8,9 @@ const (
 	State_ClaimedCsv      StateType = "State_ClaimedCsv"
 	State_ClaimedPreimage StateType = "State_ClaimedPreimage"
 	State_ClaimedCoop     StateType = "State_ClaimedCoop"
+	// State_SwapAbandoned is set by the node operator on a swap that can
+	// not be recovered, no further action is taken on the swap.
+	State_SwapAbandoned StateType = "State_SwapAbandoned"
 )
 
 // Swap Out Sender States
@@ -83,6 +86,14 @@ const (
 
 	Event_OnTimeout = "Event_OnTimeout"
 
+	// Event_OnOperatorCancel is sent if the node operator cancels a swap
+	// that did not commit any funds yet.
+	Event_OnOperatorCancel EventType = "Event_OnOperatorCancel"
+
+	// Event_OnClaimInvoiceExpired is sent if the claim invoice expired
+	// before the swap partner paid it.
+	Event_OnClaimInvoiceExpired EventType = "Event_OnClaimInvoiceExpired"
+
 	Event_ActionSucceeded                  EventType = "Event_ActionSucceeded"
 	Event_SwapInSender_OnSwapInRequested   EventType = "Event_SwapInSender_OnSwapInRequested"
 	Event_SwapInSender_OnAgreementReceived EventType = "Event_SwapInSender_OnAgreementReceived"
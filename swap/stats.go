@@ -0,0 +1,190 @@
+package swap
+
+import (
+	"sync"
+	"time"
+)
+
+// SwapStatistics sums up the swaps of the node.
+type SwapStatistics struct {
+	// Swaps is the number of swaps.
+	Swaps int `json:"swaps"`
+	// ByType, ByRole and ByState count the swaps by their type, role and
+	// current state.
+	ByType  map[string]int    `json:"by_type"`
+	ByRole  map[string]int    `json:"by_role"`
+	ByState map[StateType]int `json:"by_state"`
+	// VolumeSat is the sum of the amounts of the successful swaps.
+	VolumeSat uint64 `json:"volume_sat"`
+	// SuccessRate is the share of the finished swaps that succeeded.
+	SuccessRate float64 `json:"success_rate"`
+	// AverageDuration is the average time from the creation of a finished
+	// swap to its last update.
+	AverageDuration time.Duration `json:"average_duration"`
+	// Chains holds the statistics of the swaps per chain.
+	Chains map[string]*ChainStatistics `json:"chains"`
+
+	// FailedSwaps is the number of swaps whose opening transaction was
+	// refunded.
+	FailedSwaps int `json:"failed_swaps"`
+	// FailedOnChainCostSat is the sum of the fees of the opening and the
+	// refund transactions of the failed swaps.
+	FailedOnChainCostSat uint64 `json:"failed_on_chain_cost_sat"`
+	// Peers holds the stats of the peers that failed swaps with us.
+	Peers map[string]*PeerSwapStats `json:"peers"`
+}
+
+// ChainStatistics sums up the swaps on a chain.
+type ChainStatistics struct {
+	Swaps           int    `json:"swaps"`
+	SuccessfulSwaps int    `json:"successful_swaps"`
+	VolumeSat       uint64 `json:"volume_sat"`
+}
+
+// PeerSwapStats sums up the on-chain fees that were lost on the failed swaps
+// with a peer.
+type PeerSwapStats struct {
+	FailedSwaps          int    `json:"failed_swaps"`
+	FailedOnChainCostSat uint64 `json:"failed_on_chain_cost_sat"`
+}
+
+// statsCache holds the statistics until a swap is started or finishes.
+type statsCache struct {
+	sync.Mutex
+	stats *SwapStatistics
+}
+
+// SwapStats returns the statistics of the stored swaps. A swap succeeded if
+// it ended with the claim of the preimage. The on-chain fees that were lost
+// on swaps whose opening transaction was broadcasted by us and refunded
+// after the swap failed are summed up in total and per peer, a failed swap
+// earns no premium to offset them, so peers that cause costly failures
+// repeatedly stand out. The costs are kept apart from the swaps if the store
+// supports it, so that they are still counted after the swaps are pruned.
+// Other statistics do not count pruned swaps.
+//
+// The statistics are cached until a swap is started or finishes, the state
+// of the swaps that are active in the meantime may be outdated.
+func (s *SwapService) SwapStats() (*SwapStatistics, error) {
+	s.statsCache.Lock()
+	defer s.statsCache.Unlock()
+	if s.statsCache.stats != nil {
+		return s.statsCache.stats, nil
+	}
+
+	swaps, err := s.ListSwaps()
+	if err != nil {
+		return nil, err
+	}
+	costs := map[string]*FailedSwapCost{}
+	if store, ok := s.swapServices.swapStore.(FailedSwapCostStore); ok {
+		costs, err = store.ListFailedSwapCosts()
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.statsCache.stats = computeSwapStats(swaps, costs)
+	return s.statsCache.stats, nil
+}
+
+// recordFailedSwapCost keeps the on-chain cost of a refunded swap in the
+// store apart from the swap. Other swaps are ignored.
+func (s *SwapService) recordFailedSwapCost(swap *SwapStateMachine) error {
+	store, ok := s.swapServices.swapStore.(FailedSwapCostStore)
+	if !ok || !isRefunded(swap) {
+		return nil
+	}
+	return store.SetFailedSwapCost(swap.SwapId.String(), newFailedSwapCost(swap))
+}
+
+// invalidateStats drops the cached statistics.
+func (s *SwapService) invalidateStats() {
+	s.statsCache.Lock()
+	s.statsCache.stats = nil
+	s.statsCache.Unlock()
+}
+
+// computeSwapStats sums up the swaps. The on-chain costs of the failed swaps
+// are summed up from the costs by swap id together with the refunded swaps.
+func computeSwapStats(swaps []*SwapStateMachine, costs map[string]*FailedSwapCost) *SwapStatistics {
+	stats := &SwapStatistics{
+		ByType:  map[string]int{},
+		ByRole:  map[string]int{},
+		ByState: map[StateType]int{},
+		Chains:  map[string]*ChainStatistics{},
+		Peers:   map[string]*PeerSwapStats{},
+	}
+
+	var finished, succeeded int
+	var duration time.Duration
+	for _, swap := range swaps {
+		stats.Swaps++
+		stats.ByType[swap.Type.String()]++
+		stats.ByRole[swap.Role.String()]++
+		stats.ByState[swap.Current]++
+		if swap.Data == nil {
+			continue
+		}
+
+		chain := swap.Data.GetChain()
+		chainStats, ok := stats.Chains[chain]
+		if !ok {
+			chainStats = &ChainStatistics{}
+			stats.Chains[chain] = chainStats
+		}
+		chainStats.Swaps++
+
+		if swap.IsFinished() {
+			finished++
+			duration += time.Unix(swap.Data.UpdatedAt, 0).Sub(time.Unix(swap.Data.CreatedAt, 0))
+		}
+		if swap.Current == State_ClaimedPreimage {
+			succeeded++
+			stats.VolumeSat += swap.Data.GetAmount()
+			chainStats.SuccessfulSwaps++
+			chainStats.VolumeSat += swap.Data.GetAmount()
+		}
+
+		if isRefunded(swap) {
+			costs[swap.SwapId.String()] = newFailedSwapCost(swap)
+		}
+	}
+
+	for _, cost := range costs {
+		stats.FailedSwaps++
+		stats.FailedOnChainCostSat += cost.CostSat
+
+		peer, ok := stats.Peers[cost.PeerNodeId]
+		if !ok {
+			peer = &PeerSwapStats{}
+			stats.Peers[cost.PeerNodeId] = peer
+		}
+		peer.FailedSwaps++
+		peer.FailedOnChainCostSat += cost.CostSat
+	}
+	if finished > 0 {
+		stats.SuccessRate = float64(succeeded) / float64(finished)
+		stats.AverageDuration = duration / time.Duration(finished)
+	}
+	return stats
+}
+
+// isRefunded returns true if we are the maker of the swap, broadcasted the
+// opening transaction and refunded it.
+func isRefunded(swap *SwapStateMachine) bool {
+	if swap.Current != State_ClaimedCsv && swap.Current != State_ClaimedCoop {
+		return false
+	}
+	maker := (swap.Type == SWAPTYPE_OUT && swap.Role == SWAPROLE_RECEIVER) ||
+		(swap.Type == SWAPTYPE_IN && swap.Role == SWAPROLE_SENDER)
+	return maker && swap.Data != nil && swap.Data.OpeningTxHex != ""
+}
+
+// newFailedSwapCost returns the fees of the opening and the refund
+// transactions of a refunded swap.
+func newFailedSwapCost(swap *SwapStateMachine) *FailedSwapCost {
+	return &FailedSwapCost{
+		PeerNodeId: swap.Data.PeerNodeId,
+		CostSat:    swap.Data.Cost.OpeningTxFeeSat + swap.Data.Cost.RefundTxFeeSat,
+	}
+}
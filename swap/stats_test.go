@@ -0,0 +1,140 @@
+package swap
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func Test_SwapStats(t *testing.T) {
+	initiator, peer, takerPubkey, _, _ := getTestParams()
+	_, otherPeer, _, _, _ := getTestParams()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service, _ := getRecordingTestSetup(initiator)
+	service.swapServices.swapStore = store
+	service.swapServices.bitcoinWallet = &openingFeeWallet{dummyChain: service.swapServices.bitcoinWallet.(*dummyChain), fee: 500, spendingFee: 150}
+
+	// failSwapIn runs a swap in that fails after we broadcasted the opening
+	// transaction and refunds it cooperatively.
+	failSwapIn := func(peer, channelId string) {
+		swap, err := service.SwapIn(peer, btc_chain, channelId, initiator, 100000)
+		require.NoError(t, err)
+		require.NoError(t, service.OnSwapInAgreementReceived(&SwapInAgreementMessage{
+			ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			SwapId:          swap.SwapId,
+			Pubkey:          takerPubkey,
+			Nonce:           swap.Data.GetNonce(),
+		}))
+		require.Equal(t, State_SwapInSender_AwaitClaimPayment, swap.Current)
+		require.NoError(t, service.OnCoopCloseReceived(swap.SwapId, &CoopCloseMessage{
+			SwapId:  swap.SwapId,
+			Privkey: getRandom32ByteHexString(),
+			Nonce:   swap.Data.GetNonce(),
+		}))
+		require.Equal(t, State_ClaimedCoop, swap.Current)
+	}
+
+	stats, err := service.SwapStats()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.FailedSwaps)
+	assert.Empty(t, stats.Peers)
+
+	failSwapIn(peer, "100x1x1")
+	failSwapIn(peer, "100x1x2")
+	failSwapIn(otherPeer, "100x1x3")
+
+	// A canceled swap did not broadcast an opening transaction and costs
+	// nothing on-chain.
+	canceled, err := service.SwapIn(peer, btc_chain, "100x1x4", initiator, 100000)
+	require.NoError(t, err)
+	require.NoError(t, service.CancelSwap(canceled.SwapId.String(), ""))
+
+	// The opening fee of 500 and the refund fee of 150 that was paid, not
+	// the estimated one, are lost per swap.
+	stats, err = service.SwapStats()
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.FailedSwaps)
+	assert.Equal(t, uint64(1950), stats.FailedOnChainCostSat)
+	assert.Equal(t, &PeerSwapStats{FailedSwaps: 2, FailedOnChainCostSat: 1300}, stats.Peers[peer])
+	assert.Equal(t, &PeerSwapStats{FailedSwaps: 1, FailedOnChainCostSat: 650}, stats.Peers[otherPeer])
+
+	// The losses are kept after the swaps are pruned.
+	pruned, err := service.PruneSwaps(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 4, pruned)
+	stats, err = service.SwapStats()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Swaps)
+	assert.Equal(t, 3, stats.FailedSwaps)
+	assert.Equal(t, uint64(1950), stats.FailedOnChainCostSat)
+	assert.Equal(t, &PeerSwapStats{FailedSwaps: 2, FailedOnChainCostSat: 1300}, stats.Peers[peer])
+}
+
+func Test_SwapStatistics(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "swaps"), 0700, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewBboltStore(db)
+	require.NoError(t, err)
+
+	service := getTestSetup(aliceId)
+	service.swapServices.swapStore = store
+
+	now := time.Now().Unix()
+	request := func(network string, amount uint64) *SwapOutRequestMessage {
+		return &SwapOutRequestMessage{Network: network, Amount: amount}
+	}
+	swaps := []*SwapStateMachine{
+		{Type: SWAPTYPE_OUT, Role: SWAPROLE_SENDER, Current: State_ClaimedPreimage, Data: &SwapData{SwapOutRequest: request("mainnet", 100000), CreatedAt: now - 600, UpdatedAt: now}},
+		{Type: SWAPTYPE_OUT, Role: SWAPROLE_RECEIVER, Current: State_ClaimedPreimage, Data: &SwapData{SwapOutRequest: request("mainnet", 200000), CreatedAt: now - 1200, UpdatedAt: now}},
+		{Type: SWAPTYPE_IN, Role: SWAPROLE_SENDER, Current: State_ClaimedPreimage, Data: &SwapData{SwapInRequest: &SwapInRequestMessage{Asset: "lbtc", Amount: 300000}, CreatedAt: now - 1800, UpdatedAt: now}},
+		{Type: SWAPTYPE_OUT, Role: SWAPROLE_SENDER, Current: State_SwapCanceled, Data: &SwapData{SwapOutRequest: request("mainnet", 400000), CreatedAt: now, UpdatedAt: now}},
+		{Type: SWAPTYPE_OUT, Role: SWAPROLE_SENDER, Current: State_SwapOutSender_AwaitTxConfirmation, Data: &SwapData{SwapOutRequest: request("mainnet", 500000), CreatedAt: now}},
+	}
+	for _, swap := range swaps {
+		swap.SwapId = NewSwapId()
+		require.NoError(t, store.UpdateData(swap))
+	}
+
+	stats, err := service.SwapStats()
+	require.NoError(t, err)
+	assert.Equal(t, 5, stats.Swaps)
+	assert.Equal(t, map[string]int{"swap-out": 4, "swap-in": 1}, stats.ByType)
+	assert.Equal(t, map[string]int{"sender": 4, "receiver": 1}, stats.ByRole)
+	assert.Equal(t, map[StateType]int{
+		State_ClaimedPreimage:                   3,
+		State_SwapCanceled:                      1,
+		State_SwapOutSender_AwaitTxConfirmation: 1,
+	}, stats.ByState)
+	assert.Equal(t, uint64(600000), stats.VolumeSat)
+	assert.Equal(t, 0.75, stats.SuccessRate)
+	assert.Equal(t, 15*time.Minute, stats.AverageDuration)
+	assert.Equal(t, &ChainStatistics{Swaps: 4, SuccessfulSwaps: 2, VolumeSat: 300000}, stats.Chains[btc_chain])
+	assert.Equal(t, &ChainStatistics{Swaps: 1, SuccessfulSwaps: 1, VolumeSat: 300000}, stats.Chains[l_btc_chain])
+
+	// The statistics are cached.
+	stored := &SwapStateMachine{SwapId: NewSwapId(), Type: SWAPTYPE_IN, Role: SWAPROLE_RECEIVER, Current: State_ClaimedPreimage, Data: &SwapData{SwapInRequest: &SwapInRequestMessage{Network: "mainnet", Amount: 50000}, CreatedAt: now, UpdatedAt: now}}
+	require.NoError(t, store.UpdateData(stored))
+	cached, err := service.SwapStats()
+	require.NoError(t, err)
+	assert.Same(t, stats, cached)
+
+	// A finished swap invalidates the cache.
+	service.AddActiveSwap(stored.SwapId.String(), stored)
+	service.RemoveActiveSwap(stored.SwapId.String())
+	stats, err = service.SwapStats()
+	require.NoError(t, err)
+	assert.Equal(t, 6, stats.Swaps)
+	assert.Equal(t, uint64(650000), stats.VolumeSat)
+	assert.Equal(t, 0.8, stats.SuccessRate)
+}
@@ -1,9 +1,13 @@
 package swap
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
@@ -12,6 +16,9 @@ var (
 	swapBuckets          = []byte("swaps")
 	versionBucket        = []byte("version")
 	requestedSwapsBucket = []byte("requested-swaps")
+	healthBucket         = []byte("health")
+	paymentsBucket       = []byte("processed-payments")
+	failedCostsBucket    = []byte("failed-swap-costs")
 
 	ErrDoesNotExist  = fmt.Errorf("does not exist")
 	ErrAlreadyExists = fmt.Errorf("swap already exist")
@@ -62,6 +69,108 @@ func (p *bboltStore) GetData(id string) (*SwapStateMachine, error) {
 	return swap, nil
 }
 
+// HealthCheck writes a probe to the store and reads it back to check that
+// the store is writable.
+func (p *bboltStore) HealthCheck(ctx context.Context) error {
+	key := []byte("probe")
+	probe := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	err := p.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(healthBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, probe)
+	})
+	if err != nil {
+		return err
+	}
+
+	var read []byte
+	err = p.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(healthBucket)
+		if b == nil {
+			return fmt.Errorf("bucket nil")
+		}
+		read = append(read, b.Get(key)...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(read, probe) {
+		return fmt.Errorf("read probe %q, expected %q", read, probe)
+	}
+	return nil
+}
+
+// IsPaymentProcessed returns true if the payment was marked as processed.
+func (p *bboltStore) IsPaymentProcessed(paymentId string) (bool, error) {
+	var processed bool
+	err := p.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(paymentsBucket)
+		if b == nil {
+			return nil
+		}
+		processed = b.Get([]byte(paymentId)) != nil
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return processed, nil
+}
+
+// SetPaymentProcessed marks the payment as processed.
+func (p *bboltStore) SetPaymentProcessed(paymentId string) error {
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(paymentsBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(paymentId), []byte(strconv.FormatInt(time.Now().Unix(), 10)))
+	})
+}
+
+// SetFailedSwapCost stores the on-chain cost of a failed swap. The cost of a
+// swap that is stored again is replaced.
+func (p *bboltStore) SetFailedSwapCost(swapId string, cost *FailedSwapCost) error {
+	data, err := json.Marshal(cost)
+	if err != nil {
+		return err
+	}
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(failedCostsBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(swapId), data)
+	})
+}
+
+// ListFailedSwapCosts returns the stored on-chain costs of the failed swaps by
+// their swap ids.
+func (p *bboltStore) ListFailedSwapCosts() (map[string]*FailedSwapCost, error) {
+	costs := map[string]*FailedSwapCost{}
+	err := p.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(failedCostsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			cost := &FailedSwapCost{}
+			if err := json.Unmarshal(v, cost); err != nil {
+				return err
+			}
+			costs[string(k)] = cost
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return costs, nil
+}
+
 func (p *bboltStore) Create(swap *SwapStateMachine) error {
 	exists, err := p.idExists(swap.SwapId.String())
 	if err != nil {
@@ -194,6 +303,62 @@ func (p *bboltStore) ListAll() ([]*SwapStateMachine, error) {
 	return swaps, nil
 }
 
+// ListWhere returns the swaps for which the predicate returns true.
+func (p *bboltStore) ListWhere(predicate func(swap *SwapStateMachine) bool) ([]*SwapStateMachine, error) {
+	tx, err := p.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(swapBuckets)
+	if b == nil {
+		return nil, fmt.Errorf("bucket nil")
+	}
+
+	var swaps []*SwapStateMachine
+	err = b.ForEach(func(k, v []byte) error {
+		swap := &SwapStateMachine{}
+		if err := json.Unmarshal(v, swap); err != nil {
+			return err
+		}
+		if predicate(swap) {
+			swaps = append(swaps, swap)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return swaps, nil
+}
+
+// ForEach calls fn for every stored swap, in the order of the swap ids.
+func (p *bboltStore) ForEach(fn func(swap *SwapStateMachine) error) error {
+	tx, err := p.db.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := tx.Bucket(swapBuckets)
+	if b == nil {
+		return fmt.Errorf("bucket nil")
+	}
+
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		swap := &SwapStateMachine{}
+		if err := json.Unmarshal(v, swap); err != nil {
+			return err
+		}
+		if err := fn(swap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *bboltStore) ListAllByPeer(peer string) ([]*SwapStateMachine, error) {
 	tx, err := p.db.Begin(false)
 	if err != nil {
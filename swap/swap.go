@@ -103,23 +103,36 @@ type SwapData struct {
 
 	// cancel message
 	CancelMessage string `json:"cancel_message"`
-
-	PeerNodeId          string    `json:"peer_node_id"`
-	InitiatorNodeId     string    `json:"initiator_node_id"`
-	CreatedAt           int64     `json:"created_at"`
-	Role                SwapRole  `json:"role"`
-	FSMState            StateType `json:"fsm_state"`
-	PrivkeyBytes        []byte    `json:"private_key"`
-	FeePreimage         string    `json:"fee_preimage"`
-	OpeningTxFee        uint64    `json:"opening_tx_fee"`
-	OpeningTxHex        string    `json:"opening_tx_hex"`
-	StartingBlockHeight uint32    `json:"opening_block_height"`
-	ClaimTxId           string    `json:"claim_tx_id"`
-	ClaimPaymentHash    string    `json:"claim_payment_hash"`
-	ClaimPreimage       string    `json:"claim_preimage"`
+	// CancelReason is the reason code of the cancel message that we send.
+	CancelReason CancelReason `json:"cancel_reason,omitempty"`
+	// CancelCategory is the category of the cancellation of the swap.
+	CancelCategory CancelCategory `json:"cancel_category,omitempty"`
+
+	PeerNodeId      string    `json:"peer_node_id"`
+	InitiatorNodeId string    `json:"initiator_node_id"`
+	IdempotencyKey  string    `json:"idempotency_key,omitempty"`
+	CreatedAt       int64     `json:"created_at"`
+	UpdatedAt       int64     `json:"updated_at"`
+	Role            SwapRole  `json:"role"`
+	FSMState        StateType `json:"fsm_state"`
+	PrivkeyBytes    []byte    `json:"private_key"`
+	FeePreimage     string    `json:"fee_preimage"`
+	OpeningTxFee    uint64    `json:"opening_tx_fee"`
+	OpeningTxHex    string    `json:"opening_tx_hex"`
+	// OpeningTxSeenAt is the unix time at which the opening transaction
+	// was first seen unconfirmed in the mempool.
+	OpeningTxSeenAt     int64  `json:"opening_tx_seen_at,omitempty"`
+	StartingBlockHeight uint32 `json:"opening_block_height"`
+	ClaimTxId           string `json:"claim_tx_id"`
+	ClaimPaymentHash    string `json:"claim_payment_hash"`
+	ClaimPreimage       string `json:"claim_preimage"`
 
 	BlindingKeyHex string `json:"blinding_key"`
 
+	Cost SwapCost `json:"cost"`
+
+	Transitions []StateTransition `json:"transitions,omitempty"`
+
 	LastMessage EventContext `json:"last_message"`
 
 	NextMessage     []byte `json:"next_message"`
@@ -131,6 +144,74 @@ type SwapData struct {
 	// TimeOut cancel func. If set and called cancels the timout context so that
 	// the TimeOut callback does not get called after cancel.
 	toCancel context.CancelFunc
+
+	// flush stores the swap if a write of the swap was deferred.
+	flush func() error
+}
+
+// SwapCost sums up the amounts of a swap as they become known while the swap
+// progresses.
+type SwapCost struct {
+	// FeeInvoiceSat is the amount of the fee invoice of a swap out. It
+	// contains the fee of the opening transaction and the premium.
+	FeeInvoiceSat uint64 `json:"fee_invoice_sat"`
+	// ClaimInvoiceSat is the amount of the claim invoice.
+	ClaimInvoiceSat uint64 `json:"claim_invoice_sat"`
+	// OpeningTxFeeSat is the on-chain fee of the opening transaction. It is
+	// only known to the side that broadcasts the opening transaction.
+	OpeningTxFeeSat uint64 `json:"opening_tx_fee_sat"`
+	// RefundTxFeeSat is the on-chain fee that the transaction paid that
+	// refunds the opening transaction of a failed swap to its maker. It is
+	// only known if the wallet reports the fee of the transaction.
+	RefundTxFeeSat uint64 `json:"refund_tx_fee_sat"`
+	// TotalSat is the sum of the amounts above.
+	TotalSat uint64 `json:"total_sat"`
+}
+
+func (c *SwapCost) setFeeInvoice(sat uint64) {
+	c.FeeInvoiceSat = sat
+	c.updateTotal()
+}
+
+func (c *SwapCost) setClaimInvoice(sat uint64) {
+	c.ClaimInvoiceSat = sat
+	c.updateTotal()
+}
+
+func (c *SwapCost) setOpeningTxFee(sat uint64) {
+	c.OpeningTxFeeSat = sat
+	c.updateTotal()
+}
+
+func (c *SwapCost) setRefundTxFee(sat uint64) {
+	c.RefundTxFeeSat = sat
+	c.updateTotal()
+}
+
+func (c *SwapCost) updateTotal() {
+	c.TotalSat = c.FeeInvoiceSat + c.ClaimInvoiceSat + c.OpeningTxFeeSat + c.RefundTxFeeSat
+}
+
+// StateTransition records a transition of the swap state machine.
+type StateTransition struct {
+	Event EventType `json:"event"`
+	From  StateType `json:"from"`
+	To    StateType `json:"to"`
+	Time  time.Time `json:"time"`
+	// Err is the error that caused a failed action event.
+	Err string `json:"err,omitempty"`
+}
+
+// addTransition appends the transition to the history of the swap and drops
+// the oldest transitions that exceed max.
+func (s *SwapData) addTransition(transition StateTransition, max int) {
+	if max <= 0 {
+		return
+	}
+	s.Transitions = append(s.Transitions, transition)
+	if len(s.Transitions) > max {
+		s.Transitions = append([]StateTransition{}, s.Transitions[len(s.Transitions)-max:]...)
+	}
 }
 
 func (s *SwapData) GetId() *SwapId {
@@ -185,6 +266,30 @@ func (s *SwapData) GetScid() string {
 	return ""
 }
 
+// GetNonce returns the nonce of the request or an empty string if the
+// request did not set one.
+func (s *SwapData) GetNonce() string {
+	if s.SwapInRequest != nil {
+		return s.SwapInRequest.Nonce
+	}
+	if s.SwapOutRequest != nil {
+		return s.SwapOutRequest.Nonce
+	}
+	return ""
+}
+
+// getAgreementNonce returns the nonce that the agreement echoed or an empty
+// string if there is no agreement or it did not echo the nonce.
+func (s *SwapData) getAgreementNonce() string {
+	if s.SwapInAgreement != nil {
+		return s.SwapInAgreement.Nonce
+	}
+	if s.SwapOutAgreement != nil {
+		return s.SwapOutAgreement.Nonce
+	}
+	return ""
+}
+
 func (s *SwapData) GetScidInBoltFormat() string {
 	if s.SwapInRequest != nil {
 		return strings.ReplaceAll(s.SwapInRequest.Scid, ":", "x")
@@ -239,14 +344,18 @@ func (s *SwapData) GetNetwork() string {
 }
 
 func (s *SwapData) GetChain() string {
-	if s.GetAsset() != "" && s.GetNetwork() == "" {
+	return getChain(s.GetAsset(), s.GetNetwork())
+}
+
+// getChain returns the chain of a swap request with the asset and network.
+func getChain(asset, network string) string {
+	if asset != "" && network == "" {
 		return l_btc_chain
-	} else if s.GetAsset() == "" && s.GetNetwork() != "" {
+	} else if asset == "" && network != "" {
 		return btc_chain
 	} else {
 		return ""
 	}
-
 }
 
 func (s *SwapData) GetMakerPubkey() string {
@@ -269,6 +378,28 @@ func (s *SwapData) GetTakerPubkey() string {
 	return ""
 }
 
+// GetPremium returns the premium in sats that the swap partner asked for in
+// the agreement.
+func (s *SwapData) GetPremium() uint64 {
+	if s.SwapOutAgreement != nil {
+		return s.SwapOutAgreement.Premium
+	}
+	if s.SwapInAgreement != nil {
+		return s.SwapInAgreement.Premium
+	}
+	return 0
+}
+
+// GetClaimInvoiceAmount returns the amount in sats of the claim invoice. The
+// premium of a swap-in is deducted from the claim invoice.
+func (s *SwapData) GetClaimInvoiceAmount() uint64 {
+	amount := s.GetAmount()
+	if s.SwapInAgreement != nil {
+		amount -= s.SwapInAgreement.Premium
+	}
+	return amount
+}
+
 func (s *SwapData) GetPreimage() string {
 	return s.ClaimPreimage
 }
@@ -355,6 +486,15 @@ func (s *SwapData) GetCancelMessage() string {
 	return ""
 }
 
+// GetCancelReason returns the reason code of the cancel message that we
+// received, or of the cancel message that we sent.
+func (s *SwapData) GetCancelReason() CancelReason {
+	if s.Cancel != nil {
+		return s.Cancel.Reason
+	}
+	return s.CancelReason
+}
+
 func (s *SwapData) cancelTimeout() {
 	if s.toCancel != nil {
 		s.toCancel()
@@ -368,21 +508,25 @@ func (s *SwapData) GetPrivkey() *btcec.PrivateKey {
 
 // NewSwapData returns a new swap with a random hex id and the given arguments
 func NewSwapData(swapId *SwapId, initiatorNodeId string, peerNodeId string) *SwapData {
+	now := time.Now().Unix()
 	return &SwapData{
 		PeerNodeId:      peerNodeId,
 		InitiatorNodeId: initiatorNodeId,
 		PrivkeyBytes:    getRandomPrivkey().Serialize(),
-		CreatedAt:       time.Now().Unix(),
+		CreatedAt:       now,
+		UpdatedAt:       now,
 		Role:            SWAPROLE_SENDER,
 	}
 }
 
 // NewSwapDataFromRequest returns a new swap created from a swap request
 func NewSwapDataFromRequest(swapId *SwapId, senderNodeId string) *SwapData {
+	now := time.Now().Unix()
 	return &SwapData{
 		PeerNodeId:      senderNodeId,
 		InitiatorNodeId: senderNodeId,
-		CreatedAt:       time.Now().Unix(),
+		CreatedAt:       now,
+		UpdatedAt:       now,
 		PrivkeyBytes:    getRandomPrivkey().Serialize(),
 		Role:            SWAPROLE_RECEIVER,
 	}
@@ -460,6 +604,9 @@ func ParseSwapIdFromString(str string) (*SwapId, error) {
 type SwapErrorContext struct {
 	Err      error
 	SendPeer bool
+	// Reason is the reason code of the cancel message, if it is sent to
+	// the peer.
+	Reason CancelReason
 }
 
 func (s SwapErrorContext) ApplyToSwapData(data *SwapData) error {
@@ -468,6 +615,8 @@ func (s SwapErrorContext) ApplyToSwapData(data *SwapData) error {
 		data.LastErrString = s.Err.Error()
 		if s.SendPeer {
 			data.CancelMessage = s.Err.Error()
+			data.CancelReason = s.Reason
+			data.CancelCategory = s.Reason.category()
 		}
 	}
 	return nil
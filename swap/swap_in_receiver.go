@@ -50,6 +50,9 @@ func getSwapInReceiverStates() States {
 				Event_OnCancelReceived:  State_SwapCanceled,
 				Event_ActionFailed:      State_SwapInReceiver_SendPrivkey,
 				Event_OnInvalid_Message: State_SendCancel,
+				// The maker may already have broadcasted the opening
+				// tx, so we cancel cooperatively.
+				Event_OnOperatorCancel: State_SwapInReceiver_SendPrivkey,
 				// fixme: We have to tinker about a good value for a timeout
 				// here.
 				Event_OnTimeout: State_SwapInReceiver_SendPrivkey,
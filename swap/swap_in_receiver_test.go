@@ -88,6 +88,46 @@ func Test_SwapInReceiverCancel1(t *testing.T) {
 
 }
 
+func Test_SwapInReceiverOperatorCancel(t *testing.T) {
+	swapId := NewSwapId()
+	swapAmount := uint64(100000)
+	initiator, peer, _, _, chanId := getTestParams()
+	msgChan := make(chan PeerMessage)
+
+	swapServices := getSwapServices(msgChan)
+	swap := newSwapInReceiverFSM(swapId, swapServices, peer)
+
+	_, err := swap.SendEvent(Event_SwapInReceiver_OnRequestReceived, &SwapInRequestMessage{
+		Amount:          swapAmount,
+		Pubkey:          initiator,
+		Scid:            chanId,
+		SwapId:          swapId,
+		Network:         "mainnet",
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := <-msgChan
+	assert.Equal(t, messages.MESSAGETYPE_SWAPINAGREEMENT, msg.MessageType())
+	assert.Equal(t, State_SwapInReceiver_AwaitTxBroadcastedMessage, swap.Current)
+
+	// The maker may already have broadcasted the opening tx, so the swap
+	// is closed cooperatively.
+	errChan := make(chan error)
+	go func() {
+		_, err := swap.SendEvent(Event_OnOperatorCancel, &SwapErrorContext{Err: fmt.Errorf("canceled by operator"), SendPeer: true, Reason: CancelReasonOperator})
+		errChan <- err
+	}()
+	msg = <-msgChan
+	assert.Equal(t, messages.MESSAGETYPE_COOPCLOSE, msg.MessageType())
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, State_ClaimedCoop, swap.Current)
+	assert.Equal(t, "canceled by operator", swap.Data.CancelMessage)
+}
+
 func Test_SwapInReceiverCancel2(t *testing.T) {
 
 	swapId := NewSwapId()
@@ -169,3 +209,50 @@ func Test_SwapInReceiver_PeerIsSuspicious(t *testing.T) {
 	assert.Equal(t, State_SwapCanceled, swap.Data.GetCurrentState())
 	assert.Equal(t, fmt.Sprintf("peer %s not allowed to request swaps", initiator), swap.Data.CancelMessage)
 }
+
+// Test_SwapInReceiver_AmountOutOfRange checks that a swap request is rejected
+// if the amount is out of the configured swap amount limits.
+func Test_SwapInReceiver_AmountOutOfRange(t *testing.T) {
+	tests := []struct {
+		name          string
+		amount        uint64
+		cancelMessage string
+	}{
+		{"below minimum", 99999, ErrMinimumSwapSize(100000 * 1000).Error()},
+		{"above maximum", 200001, ErrMaximumSwapSize(200000 * 1000).Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swapId := NewSwapId()
+			_, initiator, _, _, chanId := getTestParams()
+
+			msgChan := make(chan PeerMessage)
+
+			swapServices := getSwapServices(msgChan)
+			err := swapServices.SetSwapAmountLimits(100000, 200000)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			swap := newSwapInReceiverFSM(swapId, swapServices, initiator)
+
+			_, err = swap.SendEvent(Event_SwapInReceiver_OnRequestReceived, &SwapInRequestMessage{
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+				SwapId:          swapId,
+				Network:         "mainnet",
+				Asset:           "",
+				Scid:            chanId,
+				Amount:          tt.amount,
+				Pubkey:          initiator,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg := <-msgChan
+			assert.Equal(t, messages.MESSAGETYPE_CANCELED, msg.MessageType())
+			assert.Equal(t, State_SwapCanceled, swap.Data.GetCurrentState())
+			assert.Equal(t, tt.cancelMessage, swap.Data.CancelMessage)
+		})
+	}
+}
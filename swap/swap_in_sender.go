@@ -50,10 +50,11 @@ func getSwapInSenderStates() States {
 				Event_OnTimeout:                        State_SendCancel,
 				Event_SwapInSender_OnAgreementReceived: State_SwapInSender_BroadcastOpeningTx,
 				Event_OnInvalid_Message:                State_SendCancel,
+				Event_OnOperatorCancel:                 State_SendCancel,
 			},
 		},
 		State_SwapInSender_BroadcastOpeningTx: {
-			Action: &CreateAndBroadcastOpeningTransaction{},
+			Action: &CheckPremiumWrapperAction{next: &CheckSwapCostWrapperAction{next: &CreateAndBroadcastOpeningTransaction{}}},
 			Events: Events{
 				Event_ActionSucceeded: State_SwapInSender_SendTxBroadcastedMessage,
 				Event_ActionFailed:    State_SendCancel,
@@ -69,11 +70,12 @@ func getSwapInSenderStates() States {
 		State_SwapInSender_AwaitClaimPayment: {
 			Action: &AwaitPaymentOrCsvAction{},
 			Events: Events{
-				Event_OnClaimInvoicePaid:  State_ClaimedPreimage,
-				Event_OnCsvPassed:         State_SwapInSender_ClaimSwapCsv,
-				Event_OnCancelReceived:    State_WaitCsv,
-				Event_OnCoopCloseReceived: State_SwapInSender_ClaimSwapCoop,
-				Event_OnInvalid_Message:   State_WaitCsv,
+				Event_OnClaimInvoicePaid:    State_ClaimedPreimage,
+				Event_OnCsvPassed:           State_SwapInSender_ClaimSwapCsv,
+				Event_OnCancelReceived:      State_WaitCsv,
+				Event_OnCoopCloseReceived:   State_SwapInSender_ClaimSwapCoop,
+				Event_OnInvalid_Message:     State_WaitCsv,
+				Event_OnClaimInvoiceExpired: State_WaitCsv,
 			},
 		},
 		State_SwapInSender_ClaimSwapCsv: {
@@ -83,6 +83,44 @@ func Test_SwapInSenderCancel1(t *testing.T) {
 	assert.Equal(t, State_SwapCanceled, swap.Current)
 }
 
+func Test_SwapInSenderPremiumTooHigh(t *testing.T) {
+	swapAmount := uint64(100000)
+	initiator, peer, _, _, chanId := getTestParams()
+	msgChan := make(chan PeerMessage)
+
+	swapServices := getSwapServices(msgChan)
+	swapServices.SetMaxPremium(100)
+	swap := newSwapInSenderFSM(swapServices, initiator, peer)
+
+	_, err := swap.SendEvent(Event_SwapInSender_OnSwapInRequested, &SwapInRequestMessage{
+		Amount:          swapAmount,
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swap.SwapId,
+		Network:         "mainnet",
+		Scid:            chanId,
+		Pubkey:          initiator,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := <-msgChan
+	assert.Equal(t, messages.MESSAGETYPE_SWAPINREQUEST, msg.MessageType())
+
+	_, err = swap.SendEvent(Event_SwapInSender_OnAgreementReceived, &SwapInAgreementMessage{
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+		SwapId:          swap.SwapId,
+		Pubkey:          peer,
+		Premium:         101,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg = <-msgChan
+	assert.Equal(t, messages.MESSAGETYPE_CANCELED, msg.MessageType())
+	assert.Equal(t, State_SwapCanceled, swap.Current)
+	assert.Equal(t, "premium of 101 sat exceeds the maximum premium of 100 sat", swap.Data.CancelMessage)
+}
+
 func Test_SwapInSenderCoopClose(t *testing.T) {
 
 	swapAmount := uint64(100000)
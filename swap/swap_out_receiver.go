@@ -52,6 +52,7 @@ func getSwapOutReceiverStates() States {
 			Events: Events{
 				Event_OnFeeInvoicePaid: State_SwapOutReceiver_BroadcastOpeningTx,
 				Event_OnCancelReceived: State_SwapCanceled,
+				Event_OnOperatorCancel: State_SendCancel,
 			},
 		},
 		State_SwapOutReceiver_BroadcastOpeningTx: {
@@ -71,11 +72,12 @@ func getSwapOutReceiverStates() States {
 		State_SwapOutReceiver_AwaitClaimInvoicePayment: {
 			Action: &AwaitPaymentOrCsvAction{},
 			Events: Events{
-				Event_OnClaimInvoicePaid:  State_ClaimedPreimage,
-				Event_OnCancelReceived:    State_WaitCsv,
-				Event_OnCoopCloseReceived: State_SwapOutReceiver_ClaimSwapCoop,
-				Event_OnCsvPassed:         State_SwapOutReceiver_ClaimSwapCsv,
-				Event_OnInvalid_Message:   State_WaitCsv,
+				Event_OnClaimInvoicePaid:    State_ClaimedPreimage,
+				Event_OnCancelReceived:      State_WaitCsv,
+				Event_OnCoopCloseReceived:   State_SwapOutReceiver_ClaimSwapCoop,
+				Event_OnCsvPassed:           State_SwapOutReceiver_ClaimSwapCsv,
+				Event_OnInvalid_Message:     State_WaitCsv,
+				Event_OnClaimInvoiceExpired: State_WaitCsv,
 			},
 		},
 		State_SwapOutReceiver_ClaimSwapCoop: {
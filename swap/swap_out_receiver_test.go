@@ -1,6 +1,7 @@
 package swap
 
 import (
+	"encoding/hex"
 	"fmt"
 	"testing"
 
@@ -47,6 +48,33 @@ func Test_SwapOutReceiverValidSwap(t *testing.T) {
 
 }
 
+func Test_SwapOutReceiverPremium(t *testing.T) {
+	swapId := NewSwapId()
+	_, peer, takerPubkeyHash, _, chanId := getTestParams()
+
+	msgChan := make(chan PeerMessage)
+
+	swapServices := getSwapServices(msgChan)
+	swapServices.SetDefaultPremium(100)
+	swapServices.SetPeerPremium(peer, 500)
+	swapFSM := newSwapOutReceiverFSM(swapId, swapServices, peer)
+
+	_, err := swapFSM.SendEvent(Event_OnSwapOutRequestReceived, &SwapOutRequestMessage{
+		Amount:          100000,
+		Scid:            chanId,
+		SwapId:          swapId,
+		Pubkey:          takerPubkeyHash,
+		Network:         "mainnet",
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(500), swapFSM.Data.SwapOutAgreement.Premium)
+	assert.Equal(t, uint64(500), swapServices.getPremium(peer))
+	assert.Equal(t, uint64(100), swapServices.getPremium("other"))
+}
+
 func Test_SwapOutReceiverClaimCoop(t *testing.T) {
 	swapAmount := uint64(100000)
 	swapId := NewSwapId()
@@ -180,7 +208,53 @@ func Test_SwapOutReceiverInsufficientBalance(t *testing.T) {
 	msg := <-msgChan
 	assert.Equal(t, messages.MESSAGETYPE_CANCELED, msg.MessageType())
 	assert.Equal(t, State_SwapCanceled, swapFSM.Data.GetCurrentState())
+	assert.Equal(t, "insufficient funds", swapFSM.Data.CancelMessage)
+}
+
+func Test_SwapOutReceiverInsufficientBalanceLiquid(t *testing.T) {
+	swapAmount := uint64(100000)
+	swapId := NewSwapId()
+	_, peer, takerPubkeyHash, _, chanId := getTestParams()
+
+	msgChan := make(chan PeerMessage)
+
+	swapServices := getSwapServices(msgChan)
+	// The asset is validated to be 33 bytes long.
+	privkey := getRandomPrivkey()
+	asset := hex.EncodeToString(privkey.PubKey().SerializeCompressed())
+	wallet := &fixedAssetWallet{dummyChain: &dummyChain{}, asset: asset}
+	wallet.SetBalance(swapAmount)
+	swapServices.liquidWallet = wallet
+
+	swapFSM := newSwapOutReceiverFSM(swapId, swapServices, peer)
+
+	_, err := swapFSM.SendEvent(Event_OnSwapOutRequestReceived, &SwapOutRequestMessage{
+		Amount:          swapAmount,
+		Scid:            chanId,
+		SwapId:          swapId,
+		Pubkey:          takerPubkeyHash,
+		Asset:           wallet.asset,
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-msgChan
+	assert.Equal(t, messages.MESSAGETYPE_CANCELED, msg.MessageType())
+	assert.Equal(t, State_SwapCanceled, swapFSM.Data.GetCurrentState())
+	assert.Equal(t, "insufficient funds", swapFSM.Data.CancelMessage)
+	assert.Contains(t, swapFSM.Data.LastErrString, "insufficient walletbalance")
+}
+
+// fixedAssetWallet is a dummyChain that returns a fixed liquid asset.
+type fixedAssetWallet struct {
+	*dummyChain
+	asset string
+}
 
+func (w *fixedAssetWallet) GetAsset() string {
+	return w.asset
 }
 
 // Test_SwapOutReceiver_PeerIsSuspicious checks that a swap request is rejected
@@ -222,3 +296,38 @@ func Test_SwapOutReceiver_PeerIsSuspicious(t *testing.T) {
 	assert.Equal(t, State_SwapCanceled, swapFSM.Data.GetCurrentState())
 	assert.Equal(t, fmt.Sprintf("peer %s not allowed to request swaps", peer), swapFSM.Data.CancelMessage)
 }
+
+// Test_SwapOutReceiver_AssetNotAllowed checks that a swap request is rejected
+// if the asset is not in the configured allowed assets.
+func Test_SwapOutReceiver_AssetNotAllowed(t *testing.T) {
+	swapAmount := uint64(100000)
+	swapId := NewSwapId()
+	_, peer, takerPubkeyHash, _, chanId := getTestParams()
+
+	msgChan := make(chan PeerMessage)
+
+	swapServices := getSwapServices(msgChan)
+	err := swapServices.SetAllowedAssets([]string{l_btc_chain})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	swapFSM := newSwapOutReceiverFSM(swapId, swapServices, peer)
+
+	_, err = swapFSM.SendEvent(Event_OnSwapOutRequestReceived, &SwapOutRequestMessage{
+		Amount:          swapAmount,
+		Scid:            chanId,
+		SwapId:          swapId,
+		Pubkey:          takerPubkeyHash,
+		Network:         "mainnet",
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-msgChan
+	assert.Equal(t, messages.MESSAGETYPE_CANCELED, msg.MessageType())
+	assert.Equal(t, State_SwapCanceled, swapFSM.Data.GetCurrentState())
+	assert.Equal(t, WrongAssetError(btc_chain).Error(), swapFSM.Data.CancelMessage)
+}
@@ -51,11 +51,12 @@ func getSwapOutSenderStates() States {
 				Event_OnTimeout:            State_SendCancel,
 				Event_OnFeeInvoiceReceived: State_SwapOutSender_PayFeeInvoice,
 				Event_OnInvalid_Message:    State_SendCancel,
+				Event_OnOperatorCancel:     State_SendCancel,
 			},
 			FailOnrecover: true,
 		},
 		State_SwapOutSender_PayFeeInvoice: {
-			Action: &PayFeeInvoiceAction{},
+			Action: &CheckPremiumWrapperAction{next: &PayFeeInvoiceAction{}},
 			Events: Events{
 				Event_ActionFailed:    State_SendCancel,
 				Event_ActionSucceeded: State_SwapOutSender_AwaitTxBroadcastedMessage,
@@ -3,7 +3,11 @@ package swap
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/elementsproject/peerswap/lightning"
 	"github.com/elementsproject/peerswap/messages"
@@ -89,6 +93,105 @@ func Test_ValidSwap(t *testing.T) {
 	assert.Equal(t, State_ClaimedPreimage, swapFSM.Data.GetCurrentState())
 }
 
+func Test_SwapOutSenderPremium(t *testing.T) {
+	tests := []struct {
+		name          string
+		premium       uint64
+		maxPremium    uint64
+		expectedState StateType
+		cancelMessage string
+	}{
+		{"zero premium", 0, 0, State_SwapOutSender_AwaitTxBroadcastedMessage, ""},
+		{"accept premium", 1000, 1000, State_SwapOutSender_AwaitTxBroadcastedMessage, ""},
+		{"reject premium", 1001, 1000, State_SwapCanceled, "premium of 1001 sat exceeds the maximum premium of 1000 sat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initiator, peer, takerpubkeyhash, _, chanId := getTestParams()
+			msgChan := make(chan PeerMessage, 10)
+
+			swapServices := getSwapServices(msgChan)
+			swapServices.SetMaxPremium(tt.maxPremium)
+			swapFSM := newSwapOutSenderFSM(swapServices, initiator, peer)
+
+			_, err := swapFSM.SendEvent(Event_OnSwapOutStarted, &SwapOutRequestMessage{
+				Amount:          100000,
+				Scid:            chanId,
+				SwapId:          swapFSM.SwapId,
+				Pubkey:          takerpubkeyhash,
+				Network:         "mainnet",
+				ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = swapFSM.SendEvent(Event_OnFeeInvoiceReceived, &SwapOutAgreementMessage{
+				Payreq:  "fee",
+				Pubkey:  peer,
+				Premium: tt.premium,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.expectedState, swapFSM.Data.GetCurrentState())
+			assert.Equal(t, tt.cancelMessage, swapFSM.Data.CancelMessage)
+		})
+	}
+}
+
+func Test_StateTimeouts(t *testing.T) {
+	initiator, peer, takerpubkeyhash, _, chanId := getTestParams()
+	msgChan := make(chan PeerMessage, 10)
+
+	timeOutD := &timeOutDummy{}
+
+	swapServices := getSwapServices(msgChan)
+	swapServices.toService = timeOutD
+	swapServices.SetStateTimeout(State_SwapOutSender_AwaitAgreement, time.Minute)
+	swapServices.SetStateTimeout(State_SwapOutSender_AwaitTxConfirmation, time.Hour)
+	swapFSM := newSwapOutSenderFSM(swapServices, initiator, peer)
+
+	_, err := swapFSM.SendEvent(Event_OnSwapOutStarted, &SwapOutRequestMessage{
+		Amount:          100000,
+		Scid:            chanId,
+		SwapId:          swapFSM.SwapId,
+		Pubkey:          takerpubkeyhash,
+		Network:         "mainnet",
+		ProtocolVersion: PEERSWAP_PROTOCOL_VERSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []time.Duration{time.Minute}, timeOutD.durations)
+
+	_, err = swapFSM.SendEvent(Event_OnFeeInvoiceReceived, &SwapOutAgreementMessage{
+		Payreq: "fee",
+		Pubkey: peer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = swapFSM.SendEvent(Event_OnTxOpenedMessage, &OpeningTxBroadcastedMessage{
+		Payreq:    "claiminv",
+		ScriptOut: 0,
+		TxId:      getRandom32ByteHexString(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, State_SwapOutSender_AwaitTxConfirmation, swapFSM.Current)
+
+	// The negotiation state times out faster than the confirmation state.
+	assert.Equal(t, []time.Duration{time.Minute, time.Hour}, timeOutD.durations)
+	assert.Less(t, int64(timeOutD.durations[0]), int64(timeOutD.durations[1]))
+
+	// Disabling the timeout of a state does not register a timeout.
+	swapServices.SetStateTimeout(State_SwapOutSender_AwaitAgreement, 0)
+	_, ok := swapServices.stateTimeouts[State_SwapOutSender_AwaitAgreement]
+	assert.False(t, ok)
+}
+
 func Test_Cancel2(t *testing.T) {
 	swapAmount := uint64(100000)
 	initiator, peer, takerpubkeyhash, _, chanId := getTestParams()
@@ -285,7 +388,8 @@ func (d *dummyLightningClient) GetPayreq(msatAmount uint64, preimage string, swa
 	if invoiceType == INVOICE_FEE {
 		return "fee", nil
 	}
-	return "claim", nil
+	// The claim invoice carries its amount, so that it is decoded again.
+	return fmt.Sprintf("claim_%d", msatAmount), nil
 }
 
 func (d *dummyLightningClient) DecodePayreq(payreq string) (string, uint64, error) {
@@ -295,6 +399,13 @@ func (d *dummyLightningClient) DecodePayreq(payreq string) (string, uint64, erro
 	if payreq == "fee" {
 		return "foo", 100 * 1000, nil
 	}
+	if strings.HasPrefix(payreq, "claim_") {
+		msatAmount, err := strconv.ParseUint(strings.TrimPrefix(payreq, "claim_"), 10, 64)
+		if err != nil {
+			return "", 0, err
+		}
+		return "foo", msatAmount, nil
+	}
 	return "foo", 100000 * 1000, nil
 }
 
@@ -375,6 +486,8 @@ func (d *dummyPolicy) ShouldPayFee(swapAmount, feeAmount uint64, peerId, channel
 type dummyChain struct {
 	txConfirmedFunc func(swapId string, txHex string) error
 	csvPassedFunc   func(swapId string) error
+	reorgFunc       func(swapId string) error
+	mempoolFunc     func(swapId string, txId string) error
 	balance         uint64
 
 	calledGetCSVHeight int64
@@ -452,6 +565,10 @@ func (d *dummyChain) AddCsvCallback(f func(swapId string) error) {
 	d.csvPassedFunc = f
 }
 
+func (d *dummyChain) AddReorgCallback(f func(swapId string) error) {
+	d.reorgFunc = f
+}
+
 func (d *dummyChain) NewAddress() (string, error) {
 	return "addr", nil
 }
@@ -464,6 +581,10 @@ func (d *dummyChain) AddConfirmationCallback(f func(swapId string, txHex string)
 	d.txConfirmedFunc = f
 }
 
+func (d *dummyChain) AddMempoolCallback(f func(swapId string, txId string) error) {
+	d.mempoolFunc = f
+}
+
 func (d *dummyChain) ValidateTx(swapParams *OpeningParams, openingTxId string) (bool, error) {
 	return true, nil
 }
@@ -4,33 +4,59 @@ import (
 	"context"
 	"sync"
 	"time"
-
-	"github.com/elementsproject/peerswap/timer"
 )
 
 type callbackFactory func(string) func()
 
 type timeOutService struct {
 	callbackFactory callbackFactory
+	ctx             context.Context
+	done            context.CancelFunc
 }
 
 func newTimeOutService(cbf callbackFactory) *timeOutService {
-	return &timeOutService{callbackFactory: cbf}
+	ctx, done := context.WithCancel(context.Background())
+	return &timeOutService{callbackFactory: cbf, ctx: ctx, done: done}
 }
 
+// addNewTimeOut calls the callback for id after d has passed. The callback is
+// dropped if either ctx is canceled or the service is stopped before.
 func (s *timeOutService) addNewTimeOut(ctx context.Context, d time.Duration, id string) {
-	go timer.TimedCallback(ctx, d, s.callbackFactory(id))
+	callback := s.callbackFactory(id)
+	go func() {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			// The timeout may have been canceled at the same time the
+			// timer fired, select does not prefer the canceled context.
+			if ctx.Err() != nil {
+				return
+			}
+			callback()
+		case <-ctx.Done():
+		case <-s.ctx.Done():
+		}
+	}()
+}
+
+// stop cancels all pending timeouts.
+func (s *timeOutService) stop() {
+	s.done()
 }
 
 type timeOutDummy struct {
 	sync.Mutex
-	called int
+	called    int
+	durations []time.Duration
 }
 
 func (t *timeOutDummy) addNewTimeOut(ctx context.Context, d time.Duration, id string) {
 	t.Lock()
 	defer t.Unlock()
 	t.called++
+	t.durations = append(t.durations, d)
 }
 
 func (t *timeOutDummy) getCalled() int {
@@ -0,0 +1,109 @@
+package swap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultLightningRetryBackoff is the default time we wait before an
+	// event is sent again that was deferred because the lightning client
+	// was unavailable.
+	defaultLightningRetryBackoff = 5 * time.Second
+	// defaultLightningRetryWindow is the default total time we defer the
+	// events of a swap while the lightning client is unavailable.
+	defaultLightningRetryWindow = 2 * time.Minute
+)
+
+// ErrLightningUnavailable can be returned by a lightning client if the
+// lightning node can not be reached, e.g. while it reconnects.
+var ErrLightningUnavailable = errors.New("lightning client unavailable")
+
+// LightningUnavailableChecker is implemented by lightning clients that can
+// tell if an error was caused by the lightning node being unreachable.
+type LightningUnavailableChecker interface {
+	IsUnavailableError(err error) bool
+}
+
+// eventDeferredError is returned by the statemachine if the action of the
+// next state failed because the lightning client is unavailable. The swap was
+// rolled back to the state and the event has to be sent again.
+type eventDeferredError struct {
+	State    StateType
+	Event    EventType
+	EventCtx EventContext
+	Err      error
+}
+
+func (e *eventDeferredError) Error() string {
+	return fmt.Sprintf("event %s deferred in state %s: %v", e.Event, e.State, e.Err)
+}
+
+func (e *eventDeferredError) Unwrap() error {
+	return e.Err
+}
+
+// isLightningUnavailable returns true if the error was caused by the
+// lightning client being unavailable.
+func (s *SwapServices) isLightningUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrLightningUnavailable) {
+		return true
+	}
+	if checker, ok := s.lightning.(LightningUnavailableChecker); ok {
+		return checker.IsUnavailableError(err)
+	}
+	return false
+}
+
+// SetLightningRetry sets the time we wait before an event is sent again that
+// failed because the lightning client was unavailable, and the total time the
+// events of a swap are deferred before the failure is handled as usual, which
+// usually cancels the swap. A window of 0 disables the deferral.
+func (s *SwapServices) SetLightningRetry(backoff, window time.Duration) error {
+	if window < 0 {
+		return fmt.Errorf("lightning retry window must not be negative, got %v", window)
+	}
+	if window > 0 && backoff <= 0 {
+		return fmt.Errorf("lightning retry backoff must be positive, got %v", backoff)
+	}
+	s.lightningRetryBackoff = backoff
+	s.lightningRetryWindow = window
+	return nil
+}
+
+// deferEvent sends the deferred event to the swap again after the lightning
+// retry backoff, unless the swap left the state in the meantime.
+func (s *SwapService) deferEvent(swap *SwapStateMachine, deferred *eventDeferredError) {
+	swapId := swap.SwapId.String()
+	swap.logger().Infof("[SwapService] %v, retrying in %v", deferred, s.swapServices.lightningRetryBackoff)
+
+	time.AfterFunc(s.swapServices.lightningRetryBackoff, func() {
+		if s.isStopped() {
+			return
+		}
+		if _, err := s.GetActiveSwap(swapId); err != nil {
+			return
+		}
+		if deferred.Event == Event_OnTxConfirmed && !swap.hasOpeningTxHex() {
+			// The confirmation was reorged out of the chain.
+			return
+		}
+		done, err := s.runEvent(swap, func() (bool, error) {
+			return swap.sendDeferredEvent(deferred.State, deferred.Event, deferred.EventCtx)
+		})
+		if err == ErrEventRejected {
+			return
+		}
+		if err != nil {
+			swap.logger().Warnf("[SwapService] Could not send deferred event %s: %v", deferred.Event, err)
+			return
+		}
+		if done {
+			s.RemoveActiveSwap(swapId)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package swap
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elementsproject/peerswap/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unavailableLightningClient is a LightningClient that is unavailable for the
+// first calls that create or decode invoices and recovers afterwards.
+type unavailableLightningClient struct {
+	LightningClient
+	sync.Mutex
+	down  int
+	calls int
+}
+
+func (u *unavailableLightningClient) unavailable() error {
+	u.Lock()
+	defer u.Unlock()
+	u.calls++
+	if u.down > 0 {
+		u.down--
+		return fmt.Errorf("could not reach lightning node: %w", ErrLightningUnavailable)
+	}
+	return nil
+}
+
+func (u *unavailableLightningClient) GetPayreq(msatAmount uint64, preimage string, swapId string, memo string, invoiceType InvoiceType, expiry uint64) (string, error) {
+	if err := u.unavailable(); err != nil {
+		return "", err
+	}
+	return u.LightningClient.GetPayreq(msatAmount, preimage, swapId, memo, invoiceType, expiry)
+}
+
+func (u *unavailableLightningClient) DecodePayreq(payreq string) (string, uint64, error) {
+	if err := u.unavailable(); err != nil {
+		return "", 0, err
+	}
+	return u.LightningClient.DecodePayreq(payreq)
+}
+
+func Test_LightningUnavailable_Request(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	alice, bob, aliceMsgChan, bobMsgChan := splitTestSetup(t, initiator, peer)
+	lightning := &unavailableLightningClient{LightningClient: bob.swapServices.lightning, down: 2}
+	bob.swapServices.lightning = lightning
+	require.NoError(t, bob.swapServices.SetLightningRetry(10*time.Millisecond, time.Minute))
+
+	_, err := alice.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	require.Equal(t, messages.MESSAGETYPE_SWAPOUTREQUEST, <-bobMsgChan)
+
+	// Bob creates the fee invoice once the lightning client is available.
+	assert.Equal(t, messages.MESSAGETYPE_SWAPOUTAGREEMENT, <-aliceMsgChan)
+	bobSwaps := bob.GetActiveSwaps()
+	require.Len(t, bobSwaps, 1)
+	bobSwaps[0].mutex.Lock()
+	assert.Equal(t, State_SwapOutReceiver_AwaitFeeInvoicePayment, bobSwaps[0].Current)
+	// The rolled back transitions are not recorded.
+	var created int
+	for _, transition := range bobSwaps[0].Data.Transitions {
+		if transition.To == State_SwapOutReceiver_CreateSwap {
+			created++
+		}
+	}
+	assert.Equal(t, 1, created)
+	bobSwaps[0].mutex.Unlock()
+	lightning.Lock()
+	assert.Equal(t, 3, lightning.calls)
+	lightning.Unlock()
+}
+
+func Test_LightningUnavailable_Agreement(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+	alice, bob, aliceMsgChan, bobMsgChan := splitTestSetup(t, initiator, peer)
+	alice.swapServices.lightning = &unavailableLightningClient{LightningClient: alice.swapServices.lightning, down: 2}
+	require.NoError(t, alice.swapServices.SetLightningRetry(10*time.Millisecond, time.Minute))
+
+	_, err := alice.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+	require.NoError(t, err)
+	aliceSwap, _ := negotiateSwapOut(t, alice, bob, aliceMsgChan, bobMsgChan)
+
+	// Alice pays the fee invoice once the lightning client is available.
+	assert.Eventually(t, func() bool {
+		aliceSwap.mutex.Lock()
+		defer aliceSwap.mutex.Unlock()
+		return aliceSwap.Current == State_SwapOutSender_AwaitTxBroadcastedMessage
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_LightningUnavailable_WindowUsedUp(t *testing.T) {
+	initiator, peer, _, _, channelId := getTestParams()
+
+	for _, tc := range []struct {
+		name   string
+		window time.Duration
+	}{
+		{name: "window used up", window: 50 * time.Millisecond},
+		{name: "disabled", window: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			alice, bob, aliceMsgChan, bobMsgChan := splitTestSetup(t, initiator, peer)
+			bob.swapServices.lightning = &unavailableLightningClient{LightningClient: bob.swapServices.lightning, down: 1000}
+			require.NoError(t, bob.swapServices.SetLightningRetry(10*time.Millisecond, tc.window))
+
+			_, err := alice.SwapOut(peer, btc_chain, channelId, initiator, 100000)
+			require.NoError(t, err)
+			require.Equal(t, messages.MESSAGETYPE_SWAPOUTREQUEST, <-bobMsgChan)
+
+			// Bob gives up and cancels the swap.
+			assert.Equal(t, messages.MESSAGETYPE_CANCELED, <-aliceMsgChan)
+			assert.Empty(t, bob.GetActiveSwaps())
+		})
+	}
+
+	services := getTestSetup(initiator).swapServices
+	assert.Error(t, services.SetLightningRetry(time.Second, -time.Second))
+	assert.Error(t, services.SetLightningRetry(0, time.Second))
+}
@@ -29,6 +29,12 @@ type SwapTxInfo struct {
 	TxVout              uint32
 	StartingBlockHeight uint32
 	Csv                 uint32
+	// SeenInMempool is set once the tx was reported as unconfirmed.
+	SeenInMempool bool
+	// ConfirmedHeight and ConfirmedBlockHash are set to the block that
+	// included the tx once the confirmation was reported.
+	ConfirmedHeight    uint32
+	ConfirmedBlockHash string
 }
 
 // todo zmq notifications
@@ -39,10 +45,15 @@ type BlockchainRpcTxWatcher struct {
 
 	txCallback        func(swapId string, txHex string) error
 	csvPassedCallback func(swapId string) error
+	reorgCallback     func(swapId string) error
+	mempoolCallback   func(swapId string, txId string) error
 
 	txWatchList    map[string]*SwapTxInfo
 	csvtxWatchList map[string]*SwapTxInfo
-	newBlockChan   chan uint64
+	// confirmedList holds the reported confirmations that are watched for
+	// reorgs until they are deep enough.
+	confirmedList map[string]*SwapTxInfo
+	newBlockChan  chan uint64
 
 	requiredConfs uint32
 	csv           uint32
@@ -70,6 +81,7 @@ func NewBlockchainRpcTxWatcher(ctx context.Context, blockchain BlockchainRpc, re
 		blockchain:     blockchain,
 		txWatchList:    make(map[string]*SwapTxInfo),
 		csvtxWatchList: make(map[string]*SwapTxInfo),
+		confirmedList:  make(map[string]*SwapTxInfo),
 		newBlockChan:   make(chan uint64),
 		requiredConfs:  requiredConfs,
 	}
@@ -97,7 +109,11 @@ func (s *BlockchainRpcTxWatcher) StartWatchingTxs() error {
 				// risk of deadlocks.
 				// Todo: How to care about errors?
 				go func() {
-					err := s.HandleConfirmedTx(nb)
+					err := s.HandleReorgedTx(nb)
+					if err != nil {
+						log.Debugf("HandleReorgedTx: %v", err)
+					}
+					err = s.HandleConfirmedTx(nb)
 					if err != nil {
 						log.Debugf("HandleConfirmedTx: %v", err)
 					}
@@ -153,6 +169,9 @@ func (s *BlockchainRpcTxWatcher) HandleConfirmedTx(blockheight uint64) error {
 		if res == nil {
 			continue
 		}
+		if res.Confirmations == 0 {
+			s.notifyMempool(k, v)
+		}
 		if !(res.Confirmations >= s.requiredConfs) {
 			log.Debugf("tx does not have enough confirmations")
 			continue
@@ -170,6 +189,7 @@ func (s *BlockchainRpcTxWatcher) HandleConfirmedTx(blockheight uint64) error {
 			continue
 		}
 
+		s.watchConfirmed(k, v, res)
 		toRemove = append(toRemove, k)
 	}
 	s.Unlock()
@@ -177,6 +197,59 @@ func (s *BlockchainRpcTxWatcher) HandleConfirmedTx(blockheight uint64) error {
 	return nil
 }
 
+// HandleReorgedTx looks for reported confirmations that were reorged out of
+// the chain. A transaction that reached the csv depth is not watched any
+// longer. The output of a transaction that is not found was either spent or
+// the transaction was evicted or double spent after a reorg, which is told
+// apart by the block that included the transaction.
+func (s *BlockchainRpcTxWatcher) HandleReorgedTx(blockheight uint64) error {
+	var reorged []string
+	s.Lock()
+	for k, v := range s.confirmedList {
+		res, err := s.blockchain.GetTxOut(v.TxId, v.TxVout)
+		if err != nil {
+			log.Infof("watchlist fetchtx err: %v", err)
+			continue
+		}
+		if res == nil {
+			blockHash, err := s.blockchain.GetBlockHash(v.ConfirmedHeight)
+			if err != nil {
+				log.Infof("watchlist getblockhash err: %v", err)
+				continue
+			}
+			delete(s.confirmedList, k)
+			if blockHash != v.ConfirmedBlockHash {
+				reorged = append(reorged, k)
+			}
+			continue
+		}
+		if res.Confirmations >= s.csv {
+			delete(s.confirmedList, k)
+			continue
+		}
+		if res.Confirmations >= s.requiredConfs {
+			continue
+		}
+		delete(s.confirmedList, k)
+		reorged = append(reorged, k)
+	}
+	callback := s.reorgCallback
+	s.Unlock()
+
+	if callback == nil {
+		return nil
+	}
+	// The callback is called without the lock as the swap adds the tx
+	// to the watcher again.
+	for _, swapId := range reorged {
+		err := callback(swapId)
+		if err != nil {
+			log.Infof("reorg callback error %v", err)
+		}
+	}
+	return nil
+}
+
 // HandleCsvTx looks for transactions that have enough confirmations to be spend using the csv path
 func (s *BlockchainRpcTxWatcher) HandleCsvTx(blockheight uint64) error {
 	var toRemove []string
@@ -217,17 +290,63 @@ func (l *BlockchainRpcTxWatcher) AddWaitForConfirmationTx(swapId, txId string, v
 				log.Infof("tx callback error %v", err)
 				return
 			}
+			res, err := l.blockchain.GetTxOut(txId, vout)
+			if err != nil || res == nil {
+				log.Infof("watchlist fetchtx err: %v", err)
+				return
+			}
+			l.Lock()
+			defer l.Unlock()
+			l.watchConfirmed(swapId, &SwapTxInfo{
+				TxId:                txId,
+				TxVout:              vout,
+				Csv:                 l.csv,
+				StartingBlockHeight: startingBlockheight,
+			}, res)
 		}()
 		return
 	}
-	l.Lock()
-	defer l.Unlock()
-	l.txWatchList[swapId] = &SwapTxInfo{
+	info := &SwapTxInfo{
 		TxId:                txId,
 		TxVout:              vout,
 		Csv:                 l.csv,
 		StartingBlockHeight: startingBlockheight,
 	}
+	l.Lock()
+	defer l.Unlock()
+	l.txWatchList[swapId] = info
+
+	// The tx was usually just broadcasted, report it without waiting for
+	// the next block. The callback is called without the lock, the caller
+	// may hold the lock of the swap.
+	if callback := l.mempoolCallback; callback != nil {
+		go func() {
+			res, err := l.blockchain.GetTxOut(txId, vout)
+			if err != nil || res == nil || res.Confirmations != 0 {
+				return
+			}
+			if err := callback(swapId, txId); err != nil {
+				log.Infof("mempool callback error %v", err)
+				return
+			}
+			l.Lock()
+			defer l.Unlock()
+			info.SeenInMempool = true
+		}()
+	}
+}
+
+// notifyMempool calls the mempool callback once for the unconfirmed tx of
+// the swap. The caller must hold the lock.
+func (l *BlockchainRpcTxWatcher) notifyMempool(swapId string, info *SwapTxInfo) {
+	if l.mempoolCallback == nil || info.SeenInMempool {
+		return
+	}
+	if err := l.mempoolCallback(swapId, info.TxId); err != nil {
+		log.Infof("mempool callback error %v", err)
+		return
+	}
+	info.SeenInMempool = true
 }
 
 func (s *BlockchainRpcTxWatcher) CheckTxConfirmed(swapId string, txId string, vout uint32) string {
@@ -290,6 +409,14 @@ func (l *BlockchainRpcTxWatcher) TxClaimed(swaps []string) {
 	}
 }
 
+// SetRequiredConfirmations sets the number of confirmations after which a
+// transaction is reported as confirmed.
+func (l *BlockchainRpcTxWatcher) SetRequiredConfirmations(confs uint32) {
+	l.Lock()
+	defer l.Unlock()
+	l.requiredConfs = confs
+}
+
 func (l *BlockchainRpcTxWatcher) AddConfirmationCallback(f func(swapId string, txHex string) error) {
 	l.Lock()
 	defer l.Unlock()
@@ -302,13 +429,51 @@ func (l *BlockchainRpcTxWatcher) AddCsvCallback(f func(swapId string) error) {
 	l.csvPassedCallback = f
 }
 
-func (l *BlockchainRpcTxWatcher) TxHexFromId(resp *TxOutResp, txId string) (string, error) {
+func (l *BlockchainRpcTxWatcher) AddReorgCallback(f func(swapId string) error) {
+	l.Lock()
+	defer l.Unlock()
+	l.reorgCallback = f
+}
+
+// AddMempoolCallback adds a callback that is called once when the tx of a
+// swap that waits for confirmation is first seen unconfirmed.
+func (l *BlockchainRpcTxWatcher) AddMempoolCallback(f func(swapId string, txId string) error) {
+	l.Lock()
+	defer l.Unlock()
+	l.mempoolCallback = f
+}
+
+// watchConfirmed adds the reported confirmation to the list that is watched
+// for reorgs. The caller must hold the lock.
+func (l *BlockchainRpcTxWatcher) watchConfirmed(swapId string, info *SwapTxInfo, resp *TxOutResp) {
+	height, blockhash, err := l.confirmingBlock(resp)
+	if err != nil {
+		log.Infof("could not get the confirming block of tx %s: %v", info.TxId, err)
+		return
+	}
+	info.ConfirmedHeight = height
+	info.ConfirmedBlockHash = blockhash
+	l.confirmedList[swapId] = info
+}
+
+// confirmingBlock returns the height and the hash of the block that included
+// the tx.
+func (l *BlockchainRpcTxWatcher) confirmingBlock(resp *TxOutResp) (uint32, string, error) {
 	blockheight, err := l.blockchain.GetBlockHeightByHash(resp.BestBlockHash)
 	if err != nil {
-		return "", err
+		return 0, "", err
 	}
 
-	blockhash, err := l.blockchain.GetBlockHash(uint32(blockheight) - resp.Confirmations + 1)
+	height := uint32(blockheight) - resp.Confirmations + 1
+	blockhash, err := l.blockchain.GetBlockHash(height)
+	if err != nil {
+		return 0, "", err
+	}
+	return height, blockhash, nil
+}
+
+func (l *BlockchainRpcTxWatcher) TxHexFromId(resp *TxOutResp, txId string) (string, error) {
+	_, blockhash, err := l.confirmingBlock(resp)
 	if err != nil {
 		return "", err
 	}
@@ -36,6 +36,88 @@ func Test_RpcTxWatcherConfirmations(t *testing.T) {
 	assert.Equal(t, swapId, txConfirmedId)
 }
 
+func Test_RpcTxWatcherReorg(t *testing.T) {
+	swapId := "foo"
+	txId := "bar"
+
+	db := &DummyBlockchain{}
+	txWatcherChan := make(chan string)
+	reorgChan := make(chan string)
+
+	txWatcher := NewBlockchainRpcTxWatcher(context.Background(), db, 2, 100)
+
+	err := txWatcher.StartWatchingTxs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txWatcher.AddWaitForConfirmationTx(swapId, txId, 0, 0, nil)
+	txWatcher.AddConfirmationCallback(func(swapId string, txHex string) error {
+		go func() { txWatcherChan <- swapId }()
+		return nil
+	})
+	txWatcher.AddReorgCallback(func(swapId string) error {
+		go func() { reorgChan <- swapId }()
+		return nil
+	})
+
+	db.SetBlockHeight(1)
+	db.SetNextTxOutResp(&TxOutResp{
+		Confirmations: 2,
+	})
+	assert.Equal(t, swapId, <-txWatcherChan)
+
+	// The confirming block is reorged out, the tx is back in the mempool.
+	db.SetNextTxOutResp(&TxOutResp{
+		Confirmations: 0,
+	})
+	db.SetBlockHeight(2)
+	assert.Equal(t, swapId, <-reorgChan)
+
+	txWatcher.Lock()
+	assert.Empty(t, txWatcher.confirmedList)
+	txWatcher.Unlock()
+}
+
+func Test_RpcTxWatcherReorg_TxNotFound(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		blockHash string
+		reorged   bool
+	}{
+		// The confirming block is still in the chain, the output was spent.
+		{name: "spent", blockHash: "blockhash", reorged: false},
+		// The confirming block was reorged out and the tx is gone, it was
+		// evicted or double spent.
+		{name: "missing", blockHash: "otherhash", reorged: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &DummyBlockchain{nextTxOutResp: &TxOutResp{Confirmations: 2}}
+			txWatcher := NewBlockchainRpcTxWatcher(context.Background(), db, 2, 100)
+			var reorged []string
+			txWatcher.AddConfirmationCallback(func(swapId string, txHex string) error { return nil })
+			txWatcher.AddReorgCallback(func(swapId string) error {
+				reorged = append(reorged, swapId)
+				return nil
+			})
+
+			txWatcher.txWatchList["foo"] = &SwapTxInfo{TxId: "bar"}
+			assert.NoError(t, txWatcher.HandleConfirmedTx(1))
+			assert.Equal(t, "blockhash", txWatcher.confirmedList["foo"].ConfirmedBlockHash)
+
+			db.SetNextTxOutResp(nil)
+			db.SetBlockHash(tc.blockHash)
+			assert.NoError(t, txWatcher.HandleReorgedTx(2))
+			assert.Empty(t, txWatcher.confirmedList)
+			if tc.reorged {
+				assert.Equal(t, []string{"foo"}, reorged)
+			} else {
+				assert.Empty(t, reorged)
+			}
+		})
+	}
+}
+
 func Test_RpcTxWatcherCsv(t *testing.T) {
 	csv := uint32(100)
 	swapId := "foo"
@@ -76,6 +158,7 @@ type DummyBlockchain struct {
 	sync.RWMutex
 	nextBlockheight uint64
 	nextTxOutResp   *TxOutResp
+	blockHash       string
 }
 
 func (d *DummyBlockchain) GetBlockHeightByHash(blockhash string) (uint32, error) {
@@ -83,9 +166,20 @@ func (d *DummyBlockchain) GetBlockHeightByHash(blockhash string) (uint32, error)
 }
 
 func (d *DummyBlockchain) GetBlockHash(height uint32) (string, error) {
+	d.RLock()
+	defer d.RUnlock()
+	if d.blockHash != "" {
+		return d.blockHash, nil
+	}
 	return "blockhash", nil
 }
 
+func (d *DummyBlockchain) SetBlockHash(hash string) {
+	d.Lock()
+	defer d.Unlock()
+	d.blockHash = hash
+}
+
 func (d *DummyBlockchain) GetRawtransactionWithBlockHash(txId string, blockHash string) (string, error) {
 	return "txhex", nil
 }
@@ -113,3 +207,42 @@ func (d *DummyBlockchain) GetTxOut(txid string, vout uint32) (*TxOutResp, error)
 	defer d.RUnlock()
 	return d.nextTxOutResp, nil
 }
+
+func Test_RpcTxWatcherMempool(t *testing.T) {
+	swapId := "foo"
+	txId := "bar"
+
+	db := &DummyBlockchain{
+		nextTxOutResp: &TxOutResp{
+			Confirmations: 0,
+		},
+	}
+	seenChan := make(chan string, 2)
+	confirmedChan := make(chan string)
+
+	txWatcher := NewBlockchainRpcTxWatcher(context.Background(), db, 2, 100)
+	txWatcher.AddMempoolCallback(func(swapId string, txId string) error {
+		seenChan <- txId
+		return nil
+	})
+	txWatcher.AddConfirmationCallback(func(swapId string, txHex string) error {
+		go func() { confirmedChan <- swapId }()
+		return nil
+	})
+
+	err := txWatcher.StartWatchingTxs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txWatcher.AddWaitForConfirmationTx(swapId, txId, 0, 0, nil)
+	assert.Equal(t, txId, <-seenChan)
+
+	// The confirmation is still reported, the tx is not seen again.
+	db.SetBlockHeight(1)
+	db.SetNextTxOutResp(&TxOutResp{
+		Confirmations: 2,
+	})
+	assert.Equal(t, swapId, <-confirmedChan)
+	assert.Empty(t, seenChan)
+}